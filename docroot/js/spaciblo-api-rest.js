@@ -9,6 +9,9 @@ var spaciblo = spaciblo || {}
 spaciblo.api = spaciblo.api || {}
 spaciblo.events = spaciblo.events || {}
 
+// Set as a Template's pinnedRevision to load its draft revision
+spaciblo.api.DraftRevision = 'draft'
+
 spaciblo.api.handleSchemaPopulated = function(){
 	/*
 	Once the schema is loaded, we update the models and collections in be.api with custom logic.
@@ -31,9 +34,49 @@ spaciblo.api.handleSchemaPopulated = function(){
 		}
 		return null
 	}
+	be.api.Template.prototype.getRevision = function(){
+		/*
+		Returns the revision number whose data this template loads: the pinnedRevision if it is set, otherwise the current revision
+		Set pinnedRevision to spaciblo.api.DraftRevision to load the draft, if there is one
+		*/
+		if(this.pinnedRevision === spaciblo.api.DraftRevision){
+			return this.get('draftRevision') || this.get('currentRevision') || 0
+		}
+		return this.pinnedRevision || this.get('currentRevision') || 0
+	}
 	be.api.Template.prototype.getBaseURL = function(){
+		// Load every file from the same revision so a publish during loading can't mix old and new data
+		const revision = this.getRevision()
+		if(revision){
+			return `/api/${be.API_VERSION}/template/${this.get('uuid')}/revision/${revision}/data/`
+		}
 		return `/api/${be.API_VERSION}/template/${this.get('uuid')}/data/`
 	}
+	be.api.Template.prototype.publish = function(revision=0){
+		// Publishes the draft, or rolls back to a published revision
+		return new Promise((resolve, reject) => {
+			const headers = new Headers()
+			headers.set('Accept', be.schema.acceptFormat + be.API_VERSION)
+			headers.set('Content-Type', 'application/json')
+			const fetchOptions = {
+				method: 'post',
+				body: JSON.stringify({ revision: revision }),
+				headers: headers,
+				credentials: 'same-origin' // So that cookies are sent and handled when received
+			}
+			fetch(`/api/${be.API_VERSION}/template/${this.get('uuid')}/publish`, fetchOptions).then(response => {
+				if(response.status === 200){
+					return response.json()
+				}
+				throw 'Failed with status: ' + response.status
+			}).then(data => {
+				this.reset(data)
+				resolve(this)
+			}).catch(err => {
+				reject(err)
+			})
+		})
+	}
 	be.api.Template.prototype.getGeometryExtension = function(){
 		const geometry = this.get('geometry')
 		if(geometry == null || geometry == '' || geometry.indexOf('.') == -1){
@@ -43,8 +86,15 @@ spaciblo.api.handleSchemaPopulated = function(){
 	}
 
 	be.api.TemplateData.prototype.getDataURL = function(templateUUID){
+		// Reads come from the record's revision, but writes to the unversioned URL go to the template's draft
 		return `/api/${be.API_VERSION}/template/${templateUUID}/data/` + encodeURIComponent(this.get('name'))
 	}
+	be.api.TemplateData.prototype.getRevisionDataURL = function(templateUUID){
+		if(!this.get('revision')){
+			return this.getDataURL(templateUUID)
+		}
+		return `/api/${be.API_VERSION}/template/${templateUUID}/revision/${this.get('revision')}/data/` + encodeURIComponent(this.get('name'))
+	}
 
 	be.api.TemplateData.prototype.getData = function(templateUUID){
 		return new Promise((resolve, reject) => {
@@ -55,7 +105,7 @@ spaciblo.api.handleSchemaPopulated = function(){
 				headers: headers,
 				credentials: 'same-origin' // So that cookies are sent and handled when received
 			}
-			fetch(this.getDataURL(templateUUID), fetchOptions).then(response => {
+			fetch(this.getRevisionDataURL(templateUUID), fetchOptions).then(response => {
 				if(response.status === 200){
 					resolve(response.text())
 					return
@@ -89,6 +139,17 @@ spaciblo.api.handleSchemaPopulated = function(){
 		})
 	}
 
+	// Pass a revision option of a number or spaciblo.api.DraftRevision to list data other than the current revision's
+	Object.defineProperty(be.api.TemplateDataList.prototype, 'url', {
+		get: function(){
+			const url = be.schema._generateURL(this.schema.get('path'), this.options)
+			if(this.options.revision){
+				return url + '?revision=' + encodeURIComponent(this.options.revision)
+			}
+			return url
+		}
+	})
+
 	be.api.TemplateData.postFile = function(templateUUID, file){
 		return new Promise((resolve, reject) => {
 			const url = `/api/${be.API_VERSION}/template/${templateUUID}/data/`
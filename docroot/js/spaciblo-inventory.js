@@ -1539,7 +1539,8 @@ spaciblo.components.TemplateDetailComponent = class extends k.Component {
 		this.rightCol = k.el.div({ class: 'col-9' }).appendTo(this.row)
 
 		this.templateDataTextEditor = null
-		this.templateRenderer = new spaciblo.three.TemplateRenderer(this.dataObject)
+		// Uploads and edits go into the template's draft, so preview and list the draft until it is published
+		this.templateRenderer = new spaciblo.three.TemplateRenderer(this.dataObject, spaciblo.api.DraftRevision)
 		this.rightCol.appendChild(this.templateRenderer.el)
 
 		this.snapTemplateButton = k.el.button('Snap').appendTo(this.rightCol)
@@ -1556,6 +1557,20 @@ spaciblo.components.TemplateDetailComponent = class extends k.Component {
 			})
 		})
 
+		this.publishButton = k.el.button('Publish').appendTo(this.rightCol)
+		this.listenTo('click', this.publishButton, ev => {
+			this.dataObject.publish().then(() => {
+				this.templateDataList.fetch()
+				this.templateRenderer.reloadTemplate()
+			}).catch((...params) => {
+				console.error('error publishing', ...params)
+			})
+		})
+		this._updatePublishButton()
+		this.dataObject.addListener(() => {
+			this._updatePublishButton()
+		}, 'changed:draftRevision')
+
 		k.el.h3('Name').appendTo(this.leftCol)
 		this.nameInput = new be.ui.TextInputComponent(dataObject, 'name', { autosave: true })
 		this.leftCol.appendChild(this.nameInput.el)
@@ -1587,7 +1602,8 @@ spaciblo.components.TemplateDetailComponent = class extends k.Component {
 		this.leftCol.appendChild(this.dropTarget.el)
 
 		this.templateDataList = new be.api.TemplateDataList([], {
-			uuid: this.dataObject.get('uuid')
+			uuid: this.dataObject.get('uuid'),
+			revision: spaciblo.api.DraftRevision
 		})
 		this.templateDataListComponent = new be.ui.CollectionComponent(this.templateDataList, {
 			itemComponent: spaciblo.components.TemplateDataItemComponent,
@@ -1641,6 +1657,10 @@ spaciblo.components.TemplateDetailComponent = class extends k.Component {
 		this.partInput.cleanup()
 		this.dropTarget.cleanup()
 	}
+	_updatePublishButton(){
+		// There is something to publish only when the template has a draft
+		this.publishButton.disabled = !this.dataObject.get('draftRevision')
+	}
 	_hideTemplateDataForm(){
 		this.addTemplateDataForm.style.display = 'none'
 		this.addTemplateDataLink.style.display = 'block'
@@ -1666,21 +1686,29 @@ spaciblo.components.TemplateDetailComponent = class extends k.Component {
 			this.trigger('deleted', this)
 		})
 	}
+	_handleDataChanged(){
+		// Refetch the template for its new draftRevision before reloading the draft's geometry
+		this.templateDataList.fetch()
+		this.dataObject.fetch().then(() => {
+			this.templateRenderer.reloadTemplate()
+		}).catch((...params) => {
+			console.error('Error', ...params)
+			this.templateRenderer.reloadTemplate()
+		})
+	}
 	_handleFilesDropped(eventName, component, files){
 		let fileCount = files.length
 		for(let file of files){
 			be.api.TemplateData.postFile(this.dataObject.get('uuid'), file).then((...params) => {
 				fileCount -= 1
 				if(fileCount == 0){
-					this.templateDataList.fetch()
-					this.templateRenderer.reloadTemplate()
+					this._handleDataChanged()
 				}
 			}).catch((...params) => {
 				console.error('Error', ...params)
 				fileCount -= 1
 				if(fileCount == 0){
-					this.templateDataList.fetch()
-					this.templateRenderer.reloadTemplate()
+					this._handleDataChanged()
 				}
 			})
 		}
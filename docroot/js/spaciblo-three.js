@@ -32,6 +32,9 @@ spaciblo.three.DEFAULT_LIGHT_INTENSITY = 0.7
 spaciblo.three.DEFAULT_LIGHT_SKY_COLOR  = '#0077FF'
 spaciblo.three.DEFAULT_LIGHT_GROUND_COLOR  = '#FFFFFF'
 
+// Mobile headsets run out of GPU memory with large textures, so they ask TemplateDataResource for a smaller power of two tier
+spaciblo.three.MOBILE_TEXTURE_DERIVATIVE = 'texture-1024'
+
 spaciblo.three.events.TemplateLoaded = 'three-template-loaded'
 spaciblo.three.events.GroupSettingsChanged = 'three-group-settings-changed'
 spaciblo.three.events.RequestedGroupSettingsChange = 'three-requested-group-settings-change'
@@ -1155,17 +1158,19 @@ spaciblo.three.TemplateLoader = k.eventMixin(class {
 		this._loadQueue = []		// Templates who metadata we've fetched and whose geometry we will load
 		this._loadedTemplates = [] 	// Templates whose metadata is fetched or that have failed
 	}
-	getOrAddTemplate(templateUUID){
-		let [index, listName, array] = this._indexAndListForTemplate(templateUUID)
+	getOrAddTemplate(templateUUID, revision=0){
+		// revision is a pinned revision number, spaciblo.api.DraftRevision, or 0 for the current revision
+		let [index, listName, array] = this._indexAndListForTemplate(templateUUID, revision)
 		if(index !== -1){
 			return array[index]
 		}
 		let template = new be.api.Template({ uuid: templateUUID })
+		template.pinnedRevision = revision
 		this._addTemplateToFetchQueue(template)
 		return template
 	}
-	removeTemplate(templateUUID){
-		let [index, listName, array] = this._indexAndListForTemplate(templateUUID)
+	removeTemplate(templateUUID, revision=0){
+		let [index, listName, array] = this._indexAndListForTemplate(templateUUID, revision)
 		if(index == -1) return
 		switch(listName){
 			case 'fetch':
@@ -1229,20 +1234,23 @@ spaciblo.three.TemplateLoader = k.eventMixin(class {
 		this._loadedTemplates.push(template)
 		template.trigger(spaciblo.three.events.TemplateLoaded, template)
 	}
-	_indexAndListForTemplate(templateUUID){
+	_indexAndListForTemplate(templateUUID, revision=0){
 		// returns [index,fetch/load/loaded,array] for a template or [-1, null, null] if it isn't known
+		const matches = template => {
+			return template.get('uuid') === templateUUID && template.pinnedRevision === revision
+		}
 		for(let i = 0; i < this._fetchQueue.length; i++){
-			if(this._fetchQueue[i].get('uuid') === templateUUID){
+			if(matches(this._fetchQueue[i])){
 				return [i, 'fetch', this._fetchQueue]
 			}
 		}
 		for(let i =0; i < this._loadQueue.length; i++){
-			if(this._loadQueue[i].get('uuid') === templateUUID){
+			if(matches(this._loadQueue[i])){
 				return [i, 'loading', this._loadQueue]
 			}
 		}
 		for(let i = 0; i < this._loadedTemplates.length; i++){
-			if(this._loadedTemplates[i].get('uuid') === templateUUID){
+			if(matches(this._loadedTemplates[i])){
 				return [i, 'loaded', this._loadedTemplates]
 			}
 		}
@@ -1313,8 +1321,12 @@ spaciblo.three.Group.prototype = Object.assign(Object.create(THREE.Group.prototy
 		}
 		return results
 	},
-	updateTemplate: function(templateUUID, templateLoader){
+	updateTemplate: function(templateUUID, templateLoader, revision=null){
 		if(typeof templateUUID === 'undefined' || templateUUID.length == 0) return
+		if(revision === null){
+			// Nodes can pin a template revision with the templateRevision setting
+			revision = this.templateRevisionSetting()
+		}
 		if(this.templateGroup){
 			this.remove(this.templateGroup)
 			if(this.workerManager){
@@ -1327,7 +1339,7 @@ spaciblo.three.Group.prototype = Object.assign(Object.create(THREE.Group.prototy
 			return
 		}
 
-		this.template = templateLoader.getOrAddTemplate(templateUUID)
+		this.template = templateLoader.getOrAddTemplate(templateUUID, revision)
 		if(this.workerManager){
 			this.worker = this.workerManager.getOrCreateTemplateWorker(this.template)
 		}
@@ -1353,6 +1365,11 @@ spaciblo.three.Group.prototype = Object.assign(Object.create(THREE.Group.prototy
 			if(this.worker) this.worker.handleTemplateGroupAdded(this)
 		}
 	},
+	templateRevisionSetting: function(){
+		if(typeof this.settings !== 'object') return 0
+		const revision = parseInt(this.settings['templateRevision'])
+		return Number.isNaN(revision) ? 0 : revision
+	},
 	isSetVisible: function(){
 		if(typeof this.settings !== 'object') return true
 		if(typeof this.settings['visible'] !== 'string') return true
@@ -1630,7 +1647,9 @@ spaciblo.three.Group.prototype = Object.assign(Object.create(THREE.Group.prototy
 Renders a single template instead of an entire space
 */
 spaciblo.three.TemplateRenderer = k.eventMixin(class {
-	constructor(dataObject){
+	constructor(dataObject, revision=0){
+		// revision is a revision number, spaciblo.api.DraftRevision, or 0 for the current revision
+		this.revision = revision
 		this.cleanedUp = false
 		this.boundingBox = null
 		this.dataObject = dataObject
@@ -1662,7 +1681,7 @@ spaciblo.three.TemplateRenderer = k.eventMixin(class {
 		this.orbitControls = new THREE.OrbitControls(this.camera, this.renderer.domElement)
 		this.orbitControls.enableZoom = true
 
-		this.rootGroup.updateTemplate(this.dataObject.get('uuid'), this.templateLoader)
+		this.rootGroup.updateTemplate(this.dataObject.get('uuid'), this.templateLoader, this.revision)
 		this.initializePosition()
 
 		this._boundAnimate = this._animate.bind(this) // Since we use this in every frame, bind it once
@@ -1681,8 +1700,8 @@ spaciblo.three.TemplateRenderer = k.eventMixin(class {
 	}
 	reloadTemplate(){
 		this.rootGroup.updateTemplate(spaciblo.api.RemoveKeyIndicator, this.templateLoader)
-		this.templateLoader.removeTemplate(this.dataObject.get('uuid'))
-		this.rootGroup.updateTemplate(this.dataObject.get('uuid'), this.templateLoader)
+		this.templateLoader.removeTemplate(this.dataObject.get('uuid'), this.revision)
+		this.rootGroup.updateTemplate(this.dataObject.get('uuid'), this.templateLoader, this.revision)
 	}
 	initializePosition(){
 		this.findBoundingBox().then(() => {
@@ -1803,6 +1822,16 @@ spaciblo.three.findChildNodeByName = function(name, node, deepSearch=true, resul
 	return results
 }
 
+/*
+textureDerivativeURL returns the URL for a tier of a PNG or JPEG texture, or the URL unchanged for other formats
+*/
+spaciblo.three.textureDerivativeURL = function(url, derivative){
+	if(/\.(png|jpe?g)$/i.test(url.split('?')[0]) === false){
+		return url
+	}
+	return url + (url.indexOf('?') === -1 ? '?' : '&') + 'derivative=' + encodeURIComponent(derivative)
+}
+
 spaciblo.three.OBJLoader = class {
 	static load(baseURL, geometry){
 		return new Promise(function(resolve, reject){
@@ -1810,6 +1839,12 @@ spaciblo.three.OBJLoader = class {
 			mtlLoader.setPath(baseURL)
 			const mtlName = geometry.split('.')[geometry.split(':').length - 1] + '.mtl'
 			mtlLoader.load(mtlName, (materials) => {
+				if(be.isMobile.any()){
+					const loadTexture = materials.loadTexture.bind(materials)
+					materials.loadTexture = (url, ...params) => {
+						return loadTexture(spaciblo.three.textureDerivativeURL(url, spaciblo.three.MOBILE_TEXTURE_DERIVATIVE), ...params)
+					}
+				}
 				materials.preload()
 				let objLoader = new THREE.OBJLoader()
 				objLoader.setMaterials(materials)
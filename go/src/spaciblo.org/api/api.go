@@ -12,11 +12,12 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/goincremental/negroni-sessions"
-	"github.com/goincremental/negroni-sessions/cookiestore"
 	"github.com/urfave/negroni"
 
+	apiDB "spaciblo.org/api/db"
 	"spaciblo.org/be"
 	"spaciblo.org/db"
 )
@@ -26,25 +27,72 @@ var VERSION = "0.1.0"
 
 var logger = log.New(os.Stdout, "[api] ", 0)
 
+// simHost is the hostname:port of the sim host's RPC service, or "" if the api should not contact the sims
+var simHost = ""
+
 func StartAPI() error {
 	// Get the required environment variables
 	port, err := strconv.ParseInt(os.Getenv("API_PORT"), 10, 64)
 	if err != nil {
 		return err
 	}
-	fsDir := os.Getenv("FILE_STORAGE_DIR")
-	if fsDir == "" {
-		return errors.New("No FILE_STORAGE_DIR env variable")
+	fs, err := be.NewFileStorageFromEnv()
+	if err != nil {
+		return err
 	}
 	sessionSecret := os.Getenv("SESSION_SECRET")
 	if sessionSecret == "" {
 		return errors.New("No SESSION_SECRET env variable")
 	}
+	// Optional, like "6h", to collect unreferenced files in the background
+	fileGCInterval, err := durationFromEnv("FILE_GC_INTERVAL", 0)
+	if err != nil {
+		return err
+	}
+	fileGCGracePeriod, err := durationFromEnv("FILE_GC_GRACE_PERIOD", apiDB.DefaultFileGCGracePeriod)
+	if err != nil {
+		return err
+	}
+	// Optional, like "2160h", to delete audit events older than this, which are otherwise kept forever
+	auditRetention, err := durationFromEnv("AUDIT_RETENTION", 0)
+	if err != nil {
+		return err
+	}
+	// Optional, UPLOAD_MAX_REQUEST_BYTES, UPLOAD_MAX_FILE_BYTES, and STORAGE_QUOTA_BYTES override the defaults
+	uploadLimits, err := be.UploadLimitsFromEnv()
+	if err != nil {
+		return err
+	}
+	// Optional, REGISTRATION_MODE of open or invite allows people to create accounts
+	registration, err := be.RegistrationConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	// Optional, SMTP_HOST and friends send mail instead of logging it
+	mailer, err := be.NewMailerFromEnv()
+	if err != nil {
+		return err
+	}
+	// Optional, OIDC_PROVIDERS and OIDC_<NAME>_ISSUER and friends allow logging in with OpenID Connect
+	oidc, err := be.OIDCConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	// Optional, REQUIRE_STAFF_TOTP=true makes staff and people with roles turn on two-factor authentication before sensitive actions
+	requireStaffTOTP := false
+	if value := os.Getenv("REQUIRE_STAFF_TOTP"); value != "" {
+		requireStaffTOTP, err = strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("REQUIRE_STAFF_TOTP must be true or false: " + value)
+		}
+	}
 	docrootDir := os.Getenv("DOCROOT_DIR") // Optional
 	if docrootDir == "" {
 		return errors.New("No DOCROOT_DIR env variable")
 	}
 
+	simHost = os.Getenv("SIM_HOST") // Optional
+
 	certPath := os.Getenv("TLS_CERT")
 	if certPath == "" {
 		return errors.New("No TLS_CERT env variable")
@@ -56,7 +104,23 @@ func StartAPI() error {
 
 	logger.Print("API_PORT:\t\t", port)
 	logger.Print("DOCROOT_DIR:\t", docrootDir)
-	logger.Print("FILE_STORAGE_DIR:\t", fsDir)
+	if bucket := os.Getenv("FILE_STORAGE_S3_BUCKET"); bucket != "" {
+		logger.Print("FILE_STORAGE_S3_BUCKET:\t", bucket)
+	} else {
+		logger.Print("FILE_STORAGE_DIR:\t", os.Getenv("FILE_STORAGE_DIR"))
+	}
+	if fileGCInterval > 0 {
+		logger.Print("FILE_GC_INTERVAL:\t", fileGCInterval)
+	}
+	if auditRetention > 0 {
+		logger.Print("AUDIT_RETENTION:\t", auditRetention)
+	}
+	logger.Print("REGISTRATION_MODE:\t", registration.Mode)
+	logger.Print("REQUIRE_STAFF_TOTP:\t", requireStaffTOTP)
+	for _, provider := range oidc.Providers {
+		logger.Print("OIDC PROVIDER:\t", provider.Name, " ", provider.Issuer)
+	}
+	logger.Print("SIM_HOST:\t\t", simHost)
 	logger.Print("DB HOST:\t\t", be.DBHost, ":", be.DBPort)
 	logger.Print("TLS_CERT:\t\t", certPath)
 	logger.Print("TLS_KEY:\t\t", keyPath)
@@ -69,13 +133,15 @@ func StartAPI() error {
 		dbInfo.Connection.Close()
 	}()
 
-	fs, err := be.NewLocalFileStorage(fsDir)
-	if err != nil {
-		return errors.New("Could not open file storage directory: " + fsDir)
+	if fileGCInterval > 0 {
+		go collectFilesPeriodically(fs, fileGCInterval, fileGCGracePeriod, dbInfo)
+	}
+	if auditRetention > 0 {
+		go deleteAuditEventsPeriodically(auditRetention, dbInfo)
 	}
 
 	server := negroni.New()
-	store := cookiestore.New([]byte(sessionSecret))
+	store := be.NewDBSessionStore(dbInfo, []byte(sessionSecret))
 	server.Use(sessions.Sessions(be.AuthCookieName, store))
 
 	feStatic := negroni.NewStatic(http.Dir(docrootDir))
@@ -83,6 +149,11 @@ func StartAPI() error {
 	server.Use(feStatic)
 
 	api := be.NewAPI("/api/"+VERSION, VERSION, fs, dbInfo)
+	api.UploadLimits = uploadLimits
+	api.Registration = registration
+	api.Mailer = mailer
+	api.OIDC = oidc
+	api.RequireStaffSecondFactor = requireStaffTOTP
 	addApiResources(api)
 
 	server.UseHandler(api.Mux)
@@ -98,15 +169,64 @@ func StartAPI() error {
 	return nil
 }
 
+/*
+collectFilesPeriodically deletes unreferenced files every interval, logging what it reclaims
+*/
+func collectFilesPeriodically(fs be.FileStorage, interval time.Duration, gracePeriod time.Duration, dbInfo *be.DBInfo) {
+	for range time.Tick(interval) {
+		report, err := apiDB.CollectFileGarbage(fs, gracePeriod, dbInfo)
+		if err != nil {
+			logger.Println("Could not collect files", err)
+			continue
+		}
+		logger.Println("Collected files:", report)
+	}
+}
+
+/*
+deleteAuditEventsPeriodically removes audit events once they are older than retention, checking hourly
+*/
+func deleteAuditEventsPeriodically(retention time.Duration, dbInfo *be.DBInfo) {
+	for range time.Tick(time.Hour) {
+		count, err := be.DeleteAuditEventsBefore(time.Now().Add(-retention), dbInfo)
+		if err != nil {
+			logger.Println("Could not delete old audit events", err)
+			continue
+		}
+		if count > 0 {
+			logger.Println("Deleted old audit events:", count)
+		}
+	}
+}
+
+func durationFromEnv(name string, defaultDuration time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultDuration, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New(name + " must be a duration like 6h: " + value)
+	}
+	return duration, nil
+}
+
 func addApiResources(api *be.API) {
 	api.AddResource(NewSpaceResource(), true)
 	api.AddResource(NewSpacesResource(), true)
 	api.AddResource(NewSpaceStateResource(), true)
+	api.AddResource(NewSpaceCloneResource(), true)
+	api.AddResource(NewSpaceBundleResource(), true)
+	api.AddResource(NewSpaceBundlesResource(), true)
 	api.AddResource(NewTemplatesResource(), true)
 	api.AddResource(NewTemplateResource(), true)
 	api.AddResource(NewTemplateImageResource(), false)
+	api.AddResource(NewTemplateRenderResource(), true)
 	api.AddResource(NewTemplateDataResource(), false)
 	api.AddResource(NewTemplateDataListResource(), true)
+	api.AddResource(NewTemplateRevisionsResource(), true)
+	api.AddResource(NewTemplateRevisionDataResource(), false)
+	api.AddResource(NewTemplatePublishResource(), true)
 	api.AddResource(NewAvatarsResource(), true)
 	api.AddResource(NewAvatarResource(), true)
 	api.AddResource(NewAvatarPartsResource(), true)
@@ -115,4 +235,6 @@ func addApiResources(api *be.API) {
 	api.AddResource(NewFlocksResource(), true)
 	api.AddResource(NewFlockMemberResource(), true)
 	api.AddResource(NewFlockMembersResource(), true)
+	// Account export and deletion in the be package include the flocks and spaces
+	api.AddAccountDataHandler(apiDB.AccountData{})
 }
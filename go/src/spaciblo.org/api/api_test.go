@@ -1,9 +1,18 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"testing"
 
 	apiDB "spaciblo.org/api/db"
@@ -326,6 +335,31 @@ func TestSpaceAPI(t *testing.T) {
 	AssertEqual(t, "Sub Space 0", spaceState0.Nodes[0].Settings["name"])
 	AssertEqual(t, stateNode.Nodes[0].TemplateUUID, spaceState0.Nodes[0].TemplateUUID)
 	AssertEqual(t, templateRecord0.Name, spaceState0.Nodes[0].TemplateName) // the API should fill this in
+
+	spaceRecord1 := &apiDB.SpaceRecord{}
+	err = client.PostAndReceiveJSON("/space/bogus/clone", &SpaceCloneData{}, spaceRecord1)
+	AssertNotNil(t, err)
+	err = client.PostAndReceiveJSON("/space/"+spaceRecord0.UUID+"/clone", &SpaceCloneData{}, spaceRecord1)
+	AssertNil(t, err)
+	AssertNotEqual(t, spaceRecord0.UUID, spaceRecord1.UUID)
+	AssertEqual(t, spaceRecord0.Name+SpaceCloneSuffix, spaceRecord1.Name)
+	AssertEqual(t, spaceRecord0.Avatar, spaceRecord1.Avatar)
+	AssertEqual(t, user.UUID, spaceRecord1.Owner)
+	spaceState1 := new(apiDB.SpaceStateNode)
+	err = client.GetJSON("/space-state/"+spaceRecord1.UUID, spaceState1)
+	AssertNil(t, err)
+	AssertEqual(t, "Sub Space 0", spaceState1.Nodes[0].Settings["name"])
+	AssertEqual(t, templateRecord0.UUID, spaceState1.Nodes[0].TemplateUUID) // templates are shared unless copyTemplates is set
+
+	spaceRecord2 := &apiDB.SpaceRecord{}
+	err = client.PostAndReceiveJSON("/space/"+spaceRecord0.UUID+"/clone", &SpaceCloneData{Name: "Space 2", CopyTemplates: true}, spaceRecord2)
+	AssertNil(t, err)
+	AssertEqual(t, "Space 2", spaceRecord2.Name)
+	spaceState2 := new(apiDB.SpaceStateNode)
+	err = client.GetJSON("/space-state/"+spaceRecord2.UUID, spaceState2)
+	AssertNil(t, err)
+	AssertNotEqual(t, templateRecord0.UUID, spaceState2.Nodes[0].TemplateUUID)
+	AssertEqual(t, templateRecord0.Name, spaceState2.Nodes[0].TemplateName)
 }
 
 func TestTemplateAPI(t *testing.T) {
@@ -384,8 +418,10 @@ func TestTemplateAPI(t *testing.T) {
 	AssertNil(t, err)
 	key, err := testApi.API.FileStorage.Put(record0.Geometry, file0)
 	AssertNil(t, err)
-	_, err = apiDB.CreateTemplateDataRecord(record0.Id, record0.Geometry, key, dbInfo)
+	geometryData, err := apiDB.CreateTemplateDataRecord(record0.Id, record0.Geometry, key, dbInfo)
 	AssertNil(t, err)
+	AssertEqual(t, []string{`"template-data-` + be.TestVersion + `-` + key + `"`}, etagForTemplateData(geometryData, "", be.TestVersion))
+	AssertEqual(t, []string{`"template-data-` + be.TestVersion + `-` + key + `/texture-1024"`}, etagForTemplateData(geometryData, "texture-1024", be.TestVersion))
 
 	reader, err := client.GetFile("/template/" + record0.UUID + "/data/" + record0.Geometry)
 	AssertNil(t, err)
@@ -414,4 +450,812 @@ func TestTemplateAPI(t *testing.T) {
 	reader, err = client.GetFile("/template/" + record0.UUID + "/image")
 	AssertNil(t, err)
 	AssertNotNil(t, reader)
+
+	// Changes are recorded in the audit log
+	err = client.Delete("/template/" + record0.UUID)
+	AssertNil(t, err)
+	events, err := be.FindAuditEvents(be.AuditFilter{TargetType: "template", TargetUUID: record0.UUID}, 0, 10, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(events))
+	AssertEqual(t, be.AuditDelete, events[0].Action)
+	AssertEqual(t, user1.UUID, events[0].ActorUUID)
+	AssertTrue(t, strings.Contains(events[0].Before, record0.UUID))
+	AssertEqual(t, "", events[0].After)
+	AssertEqual(t, be.AuditUpdate, events[1].Action)
+}
+
+func TestSpaceBundleAPI(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+	fs := testApi.API.FileStorage
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+
+	headTemplate, err := apiDB.CreateTemplateRecord("Head", "head.obj", "", "", "head", "", dbInfo)
+	AssertNil(t, err)
+	boxTemplate, err := apiDB.CreateTemplateRecord("Box", "box.obj", "client.js", "", "", "", dbInfo)
+	AssertNil(t, err)
+	boxKey, err := fs.Put("box.obj", bytes.NewBufferString("v 0 0 0"))
+	AssertNil(t, err)
+	_, err = apiDB.CreateTemplateDataRecord(boxTemplate.Id, "box.obj", boxKey, dbInfo)
+	AssertNil(t, err)
+	avatarRecord, err := apiDB.CreateAvatarRecord("Default Avatar", dbInfo)
+	AssertNil(t, err)
+	_, err = apiDB.CreateAvatarPartRecord(avatarRecord.Id, headTemplate.UUID, "Head", "head", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+
+	stateNode := apiDB.NewEmptySpaceStateNode()
+	stateNode.Nodes = append(stateNode.Nodes, apiDB.NewEmptySpaceStateNode())
+	stateNode.Nodes[0].Settings["name"] = "Box 0"
+	stateNode.Nodes[0].TemplateName = boxTemplate.Name // Referenced by name, like the demo spaces
+	spaceRecord0, err := apiDB.CreateSpaceRecord("Space 0", stateNode.ToString(), avatarRecord.UUID, "", dbInfo)
+	AssertNil(t, err)
+
+	_, err = client.GetFile("/space/" + spaceRecord0.UUID + "/bundle")
+	AssertNotNil(t, err) // Not logged in
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+	reader, err := client.GetFile("/space/" + spaceRecord0.UUID + "/bundle")
+	AssertNil(t, err)
+	bundleFile, err := os.Create(path.Join(tempDir, "bundle.zip"))
+	AssertNil(t, err)
+	_, err = io.Copy(bundleFile, reader)
+	AssertNil(t, err)
+
+	// Importing into the same server reuses the templates and avatar
+	spaceRecord1 := postSpaceBundle(t, client, bundleFile)
+	AssertNotEqual(t, spaceRecord0.UUID, spaceRecord1.UUID)
+	AssertEqual(t, spaceRecord0.Name, spaceRecord1.Name)
+	AssertEqual(t, avatarRecord.UUID, spaceRecord1.Avatar)
+	AssertEqual(t, user.UUID, spaceRecord1.Owner)
+	state1, err := spaceRecord1.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Box 0", state1.Nodes[0].Settings["name"])
+	AssertEqual(t, boxTemplate.UUID, state1.Nodes[0].TemplateUUID)
+	templateRecords, err := apiDB.FindAllTemplateRecords(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(templateRecords))
+
+	// Once the local template differs, importing creates a new template with the bundled data
+	changedKey, err := fs.Put("box.obj", bytes.NewBufferString("v 1 1 1"))
+	AssertNil(t, err)
+	boxData, err := apiDB.FindTemplateDataRecord(boxTemplate.Id, "box.obj", dbInfo)
+	AssertNil(t, err)
+	boxData.Key = changedKey
+	err = apiDB.UpdateTemplateDataRecord(boxData, dbInfo)
+	AssertNil(t, err)
+	spaceRecord2 := postSpaceBundle(t, client, bundleFile)
+	AssertEqual(t, avatarRecord.UUID, spaceRecord2.Avatar)
+	state2, err := spaceRecord2.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertNotEqual(t, boxTemplate.UUID, state2.Nodes[0].TemplateUUID)
+	importedTemplate, err := apiDB.FindTemplateRecord(state2.Nodes[0].TemplateUUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, boxTemplate.Name, importedTemplate.Name)
+	AssertEqual(t, boxTemplate.ClientScript, importedTemplate.ClientScript)
+	reader, err = client.GetFile("/template/" + importedTemplate.UUID + "/data/box.obj")
+	AssertNil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	AssertNil(t, err)
+	AssertEqual(t, "v 0 0 0", string(data))
+
+	// Version 1 bundles carried only vertex and triangle counts instead of stats
+	legacyFile, err := os.Create(path.Join(tempDir, "legacy.zip"))
+	AssertNil(t, err)
+	writeLegacySpaceBundle(t, bundleFile, legacyFile)
+	spaceRecord3 := postSpaceBundle(t, client, legacyFile)
+	state3, err := spaceRecord3.LoadState(dbInfo)
+	AssertNil(t, err)
+	legacyTemplate, err := apiDB.FindTemplateRecord(state3.Nodes[0].TemplateUUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Legacy Box", legacyTemplate.Name)
+	AssertEqual(t, int64(8), legacyTemplate.VertexCount)
+	AssertEqual(t, int64(12), legacyTemplate.TriangleCount)
+
+	// Stored files count against the importer's quota, once for each key
+	used, err := be.FindStorageUsage(user.Id, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(len("v 0 0 0")), used)
+	testApi.API.UploadLimits.DefaultQuotaBytes = used + 1
+	AssertEqual(t, 413, sendSpaceBundle(t, client, bundleFile).StatusCode)
+	testApi.API.UploadLimits = be.DefaultUploadLimits
+
+	// Manifests with missing entries are rejected
+	for index, manifest := range []string{
+		`{"version": 2, "state": {"nodes": [null]}, "templates": []}`,
+		`{"version": 2, "state": {}, "templates": [null]}`,
+		`{"version": 2, "state": {}, "templates": [], "avatar": {"parts": [null]}}`,
+	} {
+		nullFile, err := os.Create(path.Join(tempDir, "null-"+strconv.Itoa(index)+".zip"))
+		AssertNil(t, err)
+		zipWriter := zip.NewWriter(nullFile)
+		writer, err := zipWriter.Create(apiDB.SpaceBundleManifestName)
+		AssertNil(t, err)
+		_, err = writer.Write([]byte(manifest))
+		AssertNil(t, err)
+		AssertNil(t, zipWriter.Close())
+		AssertEqual(t, 400, sendSpaceBundle(t, client, nullFile).StatusCode, manifest)
+		nullFile.Close()
+	}
+}
+
+/*
+writeLegacySpaceBundle copies bundleFile into legacyFile with a version 1 manifest
+*/
+func writeLegacySpaceBundle(t *testing.T, bundleFile *os.File, legacyFile *os.File) {
+	info, err := bundleFile.Stat()
+	AssertNil(t, err)
+	zipReader, err := zip.NewReader(bundleFile, info.Size())
+	AssertNil(t, err)
+	zipWriter := zip.NewWriter(legacyFile)
+	for _, file := range zipReader.File {
+		reader, err := file.Open()
+		AssertNil(t, err)
+		writer, err := zipWriter.Create(file.Name)
+		AssertNil(t, err)
+		if file.Name != apiDB.SpaceBundleManifestName {
+			_, err = io.Copy(writer, reader)
+			AssertNil(t, err)
+			reader.Close()
+			continue
+		}
+		manifest := map[string]interface{}{}
+		AssertNil(t, json.NewDecoder(reader).Decode(&manifest))
+		reader.Close()
+		manifest["version"] = 1
+		for _, template := range manifest["templates"].([]interface{}) {
+			templateMap := template.(map[string]interface{})
+			delete(templateMap, "stats")
+			templateMap["vertexCount"] = 8
+			templateMap["triangleCount"] = 12
+			templateMap["name"] = "Legacy " + templateMap["name"].(string) // Keeps the import from reusing an existing template
+		}
+		AssertNil(t, json.NewEncoder(writer).Encode(manifest))
+	}
+	AssertNil(t, zipWriter.Close())
+}
+
+func postSpaceBundle(t *testing.T, client *be.Client, bundleFile *os.File) *apiDB.SpaceRecord {
+	resp := sendSpaceBundle(t, client, bundleFile)
+	AssertEqual(t, 200, resp.StatusCode)
+	spaceRecord := new(apiDB.SpaceRecord)
+	err := json.NewDecoder(resp.Body).Decode(spaceRecord)
+	AssertNil(t, err)
+	return spaceRecord
+}
+
+/*
+sendSpaceBundle posts bundleFile to the space bundle API and returns the response with its body read into memory
+*/
+func sendSpaceBundle(t *testing.T, client *be.Client, bundleFile *os.File) *http.Response {
+	_, err := bundleFile.Seek(0, io.SeekStart)
+	AssertNil(t, err)
+	resp, err := client.SendFile("POST", "/space-bundle/", "file", bundleFile)
+	AssertNil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	AssertNil(t, err)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp
+}
+
+func TestTemplateGLTFValidation(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+
+	template, err := apiDB.CreateTemplateRecord("Triangle", "triangle.gltf", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	gltfFile, err := os.Create(path.Join(tempDir, "triangle.gltf"))
+	AssertNil(t, err)
+	_, err = gltfFile.WriteString(`{
+		"asset": {"version": "2.0"},
+		"buffers": [{"uri": "triangle.bin", "byteLength": 36}],
+		"bufferViews": [{"buffer": 0, "byteLength": 36}],
+		"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+		"meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}]
+	}`)
+	AssertNil(t, err)
+	binFile, err := os.Create(path.Join(tempDir, "triangle.bin"))
+	AssertNil(t, err)
+	_, err = binFile.Write(make([]byte, 36))
+	AssertNil(t, err)
+
+	// The glTF is rejected until its buffer is uploaded
+	_, err = gltfFile.Seek(0, io.SeekStart)
+	AssertNil(t, err)
+	resp, err := client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", gltfFile)
+	AssertNil(t, err)
+	AssertEqual(t, 400, resp.StatusCode)
+	apiError := new(be.APIError)
+	err = json.NewDecoder(resp.Body).Decode(apiError)
+	resp.Body.Close()
+	AssertNil(t, err)
+	AssertEqual(t, "invalid_gltf", apiError.Id)
+	AssertEqual(t, `buffers[0]: "triangle.bin" is not in the template data`, apiError.Error)
+	rejected, err := apiDB.FindTemplateRecord(template.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(0), rejected.DraftRevision, "A rejected upload should not create a draft")
+
+	_, err = binFile.Seek(0, io.SeekStart)
+	AssertNil(t, err)
+	resp, err = client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", binFile)
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+	_, err = gltfFile.Seek(0, io.SeekStart)
+	AssertNil(t, err)
+	resp, err = client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", gltfFile)
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+
+	// The stats belong to the draft until it is published
+	record := new(apiDB.TemplateRecord)
+	err = client.GetJSON("/template/"+template.UUID, record)
+	AssertNil(t, err)
+	AssertEqual(t, int64(0), record.VertexCount)
+	err = client.PostAndReceiveJSON("/template/"+template.UUID+"/publish", nil, record)
+	AssertNil(t, err)
+	AssertEqual(t, int64(3), record.VertexCount)
+	AssertEqual(t, int64(1), record.TriangleCount)
+}
+
+func TestTemplateOBJStats(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+
+	template, err := apiDB.CreateTemplateRecord("Quad", "quad.obj", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	files := map[string]string{
+		"quad.obj": "mtllib quad.mtl\nv -1 0 -2\nv 1 0 -2\nv 1 3 2\nv -1 3 2\nusemtl Wood\nf 1 2 3 4\n",
+		"quad.mtl": "newmtl Wood\nmap_Kd wood.png\n",
+		"wood.png": "not really a png",
+	}
+	sendFile := func(name string) *http.Response {
+		file, err := os.Create(path.Join(tempDir, name))
+		AssertNil(t, err)
+		defer file.Close()
+		_, err = file.WriteString(files[name])
+		AssertNil(t, err)
+		_, err = file.Seek(0, io.SeekStart)
+		AssertNil(t, err)
+		resp, err := client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", file)
+		AssertNil(t, err)
+		return resp
+	}
+
+	// The MTL is rejected until its texture is uploaded
+	resp := sendFile("quad.mtl")
+	AssertEqual(t, 400, resp.StatusCode)
+	apiError := new(be.APIError)
+	err = json.NewDecoder(resp.Body).Decode(apiError)
+	resp.Body.Close()
+	AssertNil(t, err)
+	AssertEqual(t, "invalid_obj", apiError.Id)
+
+	for _, name := range []string{"wood.png", "quad.mtl", "quad.obj"} {
+		resp = sendFile(name)
+		resp.Body.Close()
+		AssertEqual(t, 200, resp.StatusCode, name)
+	}
+
+	record := new(apiDB.TemplateRecord)
+	err = client.PostAndReceiveJSON("/template/"+template.UUID+"/publish", nil, record)
+	AssertNil(t, err)
+	AssertEqual(t, int64(4), record.VertexCount)
+	AssertEqual(t, int64(1), record.FaceCount)
+	AssertEqual(t, int64(2), record.TriangleCount)
+	AssertEqual(t, int64(1), record.MaterialCount)
+	AssertEqual(t, "-1,0,-2", record.BoundsMin)
+	AssertEqual(t, "1,3,2", record.BoundsMax)
+	AssertEqual(t, "wood.png", record.Textures)
+
+	// Publishing the geometry rendered an image because the template had none
+	AssertNotEqual(t, "", record.Image)
+	_, err = client.GetFile("/template/" + template.UUID + "/image")
+	AssertNil(t, err)
+	rendered := new(apiDB.TemplateRecord)
+	err = client.PostAndReceiveJSON("/template/"+template.UUID+"/render", nil, rendered)
+	AssertNil(t, err)
+	// Rendering is deterministic, so the content addressed image key is unchanged and still served
+	AssertEqual(t, record.Image, rendered.Image)
+	_, err = client.GetFile("/template/" + template.UUID + "/image")
+	AssertNil(t, err)
+}
+
+func TestTemplateRevisions(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+
+	template, err := apiDB.CreateTemplateRecord("Scripted", "", "client.js", "", "", "", dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(1), template.CurrentRevision)
+	AssertEqual(t, int64(0), template.DraftRevision)
+	sendScript := func(text string) {
+		file, err := os.Create(path.Join(tempDir, "client.js"))
+		AssertNil(t, err)
+		defer file.Close()
+		_, err = file.WriteString(text)
+		AssertNil(t, err)
+		_, err = file.Seek(0, io.SeekStart)
+		AssertNil(t, err)
+		resp, err := client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", file)
+		AssertNil(t, err)
+		resp.Body.Close()
+		AssertEqual(t, 200, resp.StatusCode)
+	}
+	readScript := func(url string) string {
+		reader, err := client.GetFile(url)
+		AssertNil(t, err)
+		data, err := ioutil.ReadAll(reader)
+		AssertNil(t, err)
+		return string(data)
+	}
+	publish := func(revision int64) *apiDB.TemplateRecord {
+		record := new(apiDB.TemplateRecord)
+		err := client.PostAndReceiveJSON("/template/"+template.UUID+"/publish", &TemplatePublishPost{Revision: revision}, record)
+		AssertNil(t, err)
+		return record
+	}
+
+	// Nothing to publish without a draft
+	resp, err := client.PostJSON("/template/"+template.UUID+"/publish", nil)
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 400, resp.StatusCode)
+
+	// Uploads go into a draft that clients don't see until it is published
+	sendScript("one")
+	record := new(apiDB.TemplateRecord)
+	err = client.GetJSON("/template/"+template.UUID, record)
+	AssertNil(t, err)
+	AssertEqual(t, int64(1), record.CurrentRevision)
+	AssertEqual(t, int64(2), record.DraftRevision)
+	_, err = client.GetFile("/template/" + template.UUID + "/data/client.js")
+	AssertNotNil(t, err)
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/revision/2/data/client.js"))
+	list, err := client.GetList("/template/" + template.UUID + "/data/?revision=draft")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+
+	record = publish(0)
+	AssertEqual(t, int64(2), record.CurrentRevision)
+	AssertEqual(t, int64(0), record.DraftRevision)
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/data/client.js"))
+
+	// Replacing the file in a new draft leaves the published revision untouched
+	sendScript("two")
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/data/client.js"))
+	record = publish(0)
+	AssertEqual(t, int64(3), record.CurrentRevision)
+	AssertEqual(t, "two", readScript("/template/"+template.UUID+"/data/client.js"))
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/revision/2/data/client.js"))
+
+	list, err = client.GetList("/template/" + template.UUID + "/revision/")
+	AssertNil(t, err)
+	AssertEqual(t, 3, len(list.Objects.([]interface{})))
+
+	// Publishing an old revision rolls back to it
+	record = publish(2)
+	AssertEqual(t, int64(2), record.CurrentRevision)
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/data/client.js"))
+	resp, err = client.PostJSON("/template/"+template.UUID+"/publish", &TemplatePublishPost{Revision: 10})
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 400, resp.StatusCode)
+
+	// Deleting from a draft does not delete the file that published revisions share
+	err = client.Delete("/template/" + template.UUID + "/data/client.js")
+	AssertNil(t, err)
+	AssertEqual(t, "one", readScript("/template/"+template.UUID+"/data/client.js"))
+	list, err = client.GetList("/template/" + template.UUID + "/data/?revision=draft")
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(list.Objects.([]interface{})))
+}
+
+func TestTemplateTextureTiers(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+
+	template, err := apiDB.CreateTemplateRecord("Textured", "", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	file, err := os.Create(path.Join(tempDir, "big.jpg"))
+	AssertNil(t, err)
+	AssertNil(t, jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 3000, 1500)), nil))
+	_, err = file.Seek(0, io.SeekStart)
+	AssertNil(t, err)
+	resp, err := client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", file)
+	file.Close()
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+
+	readSize := func(url string) (int, int) {
+		reader, err := client.GetFile(url)
+		AssertNil(t, err)
+		config, format, err := image.DecodeConfig(reader)
+		AssertNil(t, err)
+		AssertEqual(t, "jpeg", format)
+		return config.Width, config.Height
+	}
+	dataURL := "/template/" + template.UUID + "/revision/2/data/big.jpg"
+	width, height := readSize(dataURL)
+	AssertEqual(t, 3000, width)
+	AssertEqual(t, 1500, height)
+	width, height = readSize(dataURL + "?derivative=texture-1024")
+	AssertEqual(t, 1024, width)
+	AssertEqual(t, 512, height)
+	width, height = readSize(dataURL + "?derivative=texture-placeholder")
+	AssertEqual(t, 32, width)
+	AssertEqual(t, 16, height)
+
+	_, err = client.GetFile(dataURL + "?derivative=texture-1000")
+	AssertNotNil(t, err, "Unknown derivatives should be a 400")
+}
+
+func TestUploadLimits(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+	testApi.API.UploadLimits = be.UploadLimits{
+		MaxRequestBytes:   2048,
+		MaxFileBytes:      100,
+		DefaultQuotaBytes: 150,
+	}
+
+	tempDir, err := ioutil.TempDir(os.TempDir(), "be-temp")
+	AssertNil(t, err, "Could not create tempDir: "+tempDir)
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		AssertNil(t, err, "Could not clean up tempDir: "+tempDir)
+	}()
+
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	err = client.Authenticate("alice@example.com", "1234")
+	AssertNil(t, err)
+
+	template, err := apiDB.CreateTemplateRecord("Limited", "", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	upload := func(name string, size int) (int, be.APIError) {
+		filePath := path.Join(tempDir, name)
+		AssertNil(t, ioutil.WriteFile(filePath, bytes.Repeat([]byte(name[:1]), size), 0664))
+		file, err := os.Open(filePath)
+		AssertNil(t, err)
+		defer file.Close()
+		resp, err := client.SendFile("POST", "/template/"+template.UUID+"/data/", "file", file)
+		AssertNil(t, err)
+		defer resp.Body.Close()
+		var apiError be.APIError
+		if resp.StatusCode != 200 {
+			AssertNil(t, json.NewDecoder(resp.Body).Decode(&apiError))
+		}
+		return resp.StatusCode, apiError
+	}
+
+	status, _ := upload("a.bin", 60)
+	AssertEqual(t, 200, status)
+	status, apiError := upload("big.bin", 101)
+	AssertEqual(t, 413, status)
+	AssertEqual(t, be.FileTooLargeError.Id, apiError.Id)
+	status, apiError = upload("huge.bin", 4096)
+	AssertEqual(t, 413, status)
+	AssertEqual(t, be.RequestTooLargeError.Id, apiError.Id)
+	status, _ = upload("b.bin", 60)
+	AssertEqual(t, 200, status)
+	status, apiError = upload("c.bin", 60)
+	AssertEqual(t, 413, status)
+	AssertEqual(t, be.StorageQuotaExceededError.Id, apiError.Id)
+
+	storageURL := "/user/" + user.UUID + "/storage"
+	storage := be.UserStorage{}
+	AssertNil(t, client.GetJSON(storageURL, &storage))
+	AssertEqual(t, int64(120), storage.Used)
+	AssertEqual(t, int64(0), storage.Quota)
+	AssertEqual(t, int64(150), storage.EffectiveQuota)
+
+	// Deleting a file frees its share of the quota without waiting for file garbage collection
+	AssertNil(t, client.Delete("/template/"+template.UUID+"/data/b.bin"))
+	AssertNil(t, client.GetJSON(storageURL, &storage))
+	AssertEqual(t, int64(60), storage.Used)
+
+	AssertNil(t, client.PutAndReceiveJSON(storageURL, be.UserStorage{Quota: -1}, &storage))
+	AssertEqual(t, int64(-1), storage.Quota)
+	AssertEqual(t, int64(0), storage.EffectiveQuota)
+	status, _ = upload("c.bin", 60)
+	AssertEqual(t, 200, status)
+
+	AssertNil(t, client.PutAndReceiveJSON(storageURL, be.UserStorage{Quota: 1000, Reset: true}, &storage))
+	AssertEqual(t, int64(0), storage.Used)
+	AssertEqual(t, int64(1000), storage.EffectiveQuota)
+
+	// Only staff may view or adjust storage
+	user.Staff = false
+	AssertNil(t, be.UpdateUser(user, dbInfo))
+	AssertNotNil(t, client.GetJSON(storageURL, &storage))
+}
+
+func TestRolePermissions(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", false, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, client.Authenticate("alice@example.com", "1234"))
+
+	avatar := &apiDB.AvatarRecord{}
+	template := &apiDB.TemplateRecord{}
+	AssertNotNil(t, client.PostAndReceiveJSON("/avatar/", &apiDB.AvatarRecord{Name: "Avatar"}, avatar))
+	AssertNotNil(t, client.PostAndReceiveJSON("/template/", &apiDB.TemplateRecord{Name: "Template"}, template))
+
+	// Content managers change templates and avatars but not spaces
+	AssertNil(t, be.AddUserRole(user.Id, be.RoleContentManager, dbInfo))
+	AssertNil(t, client.PostAndReceiveJSON("/avatar/", &apiDB.AvatarRecord{Name: "Avatar"}, avatar))
+	AssertNil(t, client.PostAndReceiveJSON("/template/", &apiDB.TemplateRecord{Name: "Template"}, template))
+	space := &apiDB.SpaceRecord{}
+	AssertNotNil(t, client.PostAndReceiveJSON("/space/", &apiDB.SpaceRecord{Name: "Space"}, space))
+
+	AssertNil(t, be.RemoveUserRole(user.Id, be.RoleContentManager, dbInfo))
+	AssertNotNil(t, client.Delete("/avatar/"+avatar.UUID))
+
+	// Replacing template data requires templates:write
+	_, err = apiDB.CreateTemplateDataRecord(template.Id, "client.js", "", dbInfo)
+	AssertNil(t, err)
+	dataURL := "/template/" + template.UUID + "/data/client.js"
+	be.AssertStatus(t, 401, "PUT", testApi.URL()+dataURL)
+	resp, _ := client.PutJSON(dataURL, "changed")
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 403, resp.StatusCode)
+	draftCheck, err := apiDB.FindTemplateRecord(template.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(0), draftCheck.DraftRevision)
+}
+
+func TestAccountData(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := be.NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	addApiResources(testApi.API)
+	apiDB.MigrateDB(testApi.DBInfo)
+
+	user, err := be.CreateUser("alice@example.com", "Alice", "Example", false, "", dbInfo)
+	AssertNil(t, err)
+	_, err = be.CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	heir, err := be.CreateUser("bob@example.com", "Bob", "Example", false, "", dbInfo)
+	AssertNil(t, err)
+	client, err := be.NewClient(testApi.URL())
+	AssertNil(t, err)
+	err = client.Authenticate(user.Email, "1234")
+	AssertNil(t, err)
+
+	flock, err := apiDB.CreateFlockRecord("Flock 0", user.UUID, dbInfo)
+	AssertNil(t, err)
+	template, err := apiDB.CreateTemplateRecord("Template 0", "test.gltf", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	member, err := apiDB.CreateFlockMemberRecord(flock.UUID, template.UUID, dbInfo)
+	AssertNil(t, err)
+	space, err := apiDB.CreateSpaceRecord("Space 0", apiDB.NewEmptySpaceStateNode().ToString(), "", user.UUID, dbInfo)
+	AssertNil(t, err)
+	otherSpace, err := apiDB.CreateSpaceRecord("Space 1", apiDB.NewEmptySpaceStateNode().ToString(), "", "", dbInfo)
+	AssertNil(t, err)
+
+	// The export includes the flocks and a bundle of each owned space
+	reader, err := client.GetFile("/user/current/export")
+	AssertNil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	AssertNil(t, err)
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	AssertNil(t, err)
+	zipFiles := map[string]*zip.File{}
+	for _, zipFile := range zipReader.File {
+		zipFiles[zipFile.Name] = zipFile
+	}
+	_, ok := zipFiles["spaces/"+space.UUID+".zip"]
+	AssertTrue(t, ok)
+	_, ok = zipFiles["spaces/"+otherSpace.UUID+".zip"]
+	AssertFalse(t, ok)
+	flocksFile, ok := zipFiles["flocks.json"]
+	AssertTrue(t, ok)
+	flocksReader, err := flocksFile.Open()
+	AssertNil(t, err)
+	flocks := []map[string]interface{}{}
+	AssertNil(t, json.NewDecoder(flocksReader).Decode(&flocks))
+	flocksReader.Close()
+	AssertEqual(t, 1, len(flocks))
+	AssertEqual(t, flock.UUID, flocks[0]["uuid"])
+	AssertEqual(t, 1, len(flocks[0]["members"].([]interface{})))
+
+	// Deletion removes the flocks and hands the spaces to the heir
+	_, err = client.SendJSON("DELETE", "/user/current/account", be.AccountDeletionData{Password: "1234", SpaceHeir: heir.Email})
+	AssertNil(t, err)
+	_, err = apiDB.FindFlockRecord(flock.UUID, dbInfo)
+	AssertNotNil(t, err)
+	_, err = apiDB.FindFlockMemberRecord(member.UUID, dbInfo)
+	AssertNotNil(t, err)
+	space, err = apiDB.FindSpaceRecord(space.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, heir.UUID, space.Owner)
+	otherSpace, err = apiDB.FindSpaceRecord(otherSpace.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "", otherSpace.Owner)
+	_, err = apiDB.FindTemplateRecord(template.UUID, dbInfo)
+	AssertNil(t, err, "Shared content is kept")
 }
@@ -63,11 +63,9 @@ func (resource AvatarsResource) Get(request *be.APIRequest) (int, interface{}, h
 
 func (resource AvatarsResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	var data apiDB.AvatarRecord
@@ -83,6 +81,7 @@ func (resource AvatarsResource) Post(request *be.APIRequest) (int, interface{},
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "avatar", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -120,11 +119,9 @@ func (resource AvatarResource) Get(request *be.APIRequest) (int, interface{}, ht
 
 func (resource AvatarResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -144,6 +141,7 @@ func (resource AvatarResource) Put(request *be.APIRequest) (int, interface{}, ht
 	}
 
 	// Only some attributes can be updated
+	before := *avatar
 	avatar.Name = updatedAvatar.Name
 	err = apiDB.UpdateAvatarRecord(avatar, request.DBInfo)
 	if err != nil {
@@ -153,17 +151,19 @@ func (resource AvatarResource) Put(request *be.APIRequest) (int, interface{}, ht
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "avatar", avatar.UUID, before, avatar)
 	return 200, avatar, responseHeader
 }
 
 func (resource AvatarResource) Delete(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	avatarUUID, _ := request.PathValues["uuid"]
@@ -184,5 +184,6 @@ func (resource AvatarResource) Delete(request *be.APIRequest) (int, interface{},
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditDelete, "avatar", avatar.UUID, avatar, nil)
 	return 200, "{}", responseHeader
 }
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	apiDB "spaciblo.org/api/db"
 	"spaciblo.org/be"
@@ -20,6 +21,11 @@ var AvatarPartProperties = []be.Property{
 		Description: "name",
 		DataType:    "string",
 	},
+	be.Property{
+		Name:        "templateRevision",
+		Description: "The published template revision to load, or 0 for the template's current revision",
+		DataType:    "int",
+	},
 }
 
 var AvatarPartsProperties = be.NewAPIListProperties("avatar-part")
@@ -74,11 +80,9 @@ func (resource AvatarPartsResource) Get(request *be.APIRequest) (int, interface{
 
 func (resource AvatarPartsResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	avatarUUID, _ := request.PathValues["avatar-uuid"]
@@ -111,6 +115,7 @@ func (resource AvatarPartsResource) Post(request *be.APIRequest) (int, interface
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "avatar-part", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -161,11 +166,9 @@ func (resource AvatarPartResource) Get(request *be.APIRequest) (int, interface{}
 
 func (resource AvatarPartResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	avatarUUID, _ := request.PathValues["avatar-uuid"]
@@ -195,7 +198,7 @@ func (resource AvatarPartResource) Put(request *be.APIRequest) (int, interface{}
 	}
 
 	if updatedAvatarPart.TemplateUUID != "" {
-		_, err = apiDB.FindTemplateRecord(updatedAvatarPart.TemplateUUID, request.DBInfo)
+		template, err := apiDB.FindTemplateRecord(updatedAvatarPart.TemplateUUID, request.DBInfo)
 		if err != nil {
 			return 400, be.APIError{
 				Id:      "no_such_template_id",
@@ -203,9 +206,22 @@ func (resource AvatarPartResource) Put(request *be.APIRequest) (int, interface{}
 				Error:   err.Error(),
 			}, responseHeader
 		}
+		if updatedAvatarPart.TemplateRevision != 0 {
+			// Parts can only pin published revisions because drafts change
+			revision, err := apiDB.FindTemplateRevisionRecord(template.Id, updatedAvatarPart.TemplateRevision, request.DBInfo)
+			if err != nil || revision.Published == false {
+				return 400, be.APIError{
+					Id:      "no_such_template_revision",
+					Message: "No such published template revision: " + strconv.FormatInt(updatedAvatarPart.TemplateRevision, 10),
+				}, responseHeader
+			}
+		}
+	} else {
+		updatedAvatarPart.TemplateRevision = 0
 	}
 
 	// Only some attributes can be updated
+	before := *avatarPart
 	avatarPart.Name = updatedAvatarPart.Name
 	avatarPart.Part = updatedAvatarPart.Part
 	avatarPart.Parent = updatedAvatarPart.Parent
@@ -213,6 +229,7 @@ func (resource AvatarPartResource) Put(request *be.APIRequest) (int, interface{}
 	avatarPart.Orientation = updatedAvatarPart.Orientation
 	avatarPart.Scale = updatedAvatarPart.Scale
 	avatarPart.TemplateUUID = updatedAvatarPart.TemplateUUID
+	avatarPart.TemplateRevision = updatedAvatarPart.TemplateRevision
 	err = apiDB.UpdateAvatarPartRecord(avatarPart, request.DBInfo)
 	if err != nil {
 		return 400, be.APIError{
@@ -221,17 +238,19 @@ func (resource AvatarPartResource) Put(request *be.APIRequest) (int, interface{}
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "avatar-part", avatarPart.UUID, before, avatarPart)
 	return 200, avatarPart, responseHeader
 }
 
 func (resource AvatarPartResource) Delete(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionAvatarsWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	avatarUUID, _ := request.PathValues["avatar-uuid"]
@@ -262,5 +281,6 @@ func (resource AvatarPartResource) Delete(request *be.APIRequest) (int, interfac
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditDelete, "avatar-part", avatarPart.UUID, avatarPart, nil)
 	return 200, "{}", responseHeader
 }
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"path"
+
+	"spaciblo.org/be"
+)
+
+/*
+AccountData exports and removes the flocks and spaces of Users who download or delete their accounts
+*/
+type AccountData struct{}
+
+type accountFlock struct {
+	*FlockRecord
+	Members []*FlockMemberRecord `json:"members"`
+}
+
+func (AccountData) ExportAccountData(user *be.User, export *be.AccountExport, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	var flockRecords []*FlockRecord
+	_, err := dbInfo.Map.Select(&flockRecords, "select * from "+FlockTable+" where user_uuid=$1 order by id", user.UUID)
+	if err != nil {
+		return err
+	}
+	flocks := []*accountFlock{}
+	for _, flockRecord := range flockRecords {
+		flock := &accountFlock{FlockRecord: flockRecord}
+		_, err = dbInfo.Map.Select(&flock.Members, "select * from "+FlockMemberTable+" where flock_uuid=$1 order by id", flockRecord.UUID)
+		if err != nil {
+			return err
+		}
+		flocks = append(flocks, flock)
+	}
+	err = export.WriteJSON("flocks.json", flocks)
+	if err != nil {
+		return err
+	}
+
+	spaceRecords, err := FindOwnedSpaceRecords(user.UUID, dbInfo)
+	if err != nil {
+		return err
+	}
+	for _, spaceRecord := range spaceRecords {
+		writer, err := export.Create(path.Join("spaces", spaceRecord.UUID+".zip"))
+		if err != nil {
+			return err
+		}
+		err = WriteSpaceBundle(spaceRecord, writer, fileStorage, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	if spaceRecords == nil {
+		spaceRecords = []*SpaceRecord{}
+	}
+	return export.WriteJSON("spaces.json", spaceRecords)
+}
+
+/*
+DeleteAccountData removes the User's flocks and gives their spaces to heir, since other people may use them
+*/
+func (AccountData) DeleteAccountData(user *be.User, heir *be.User, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+FlockMemberTable+" where flock_uuid in (select u_u_i_d from "+FlockTable+" where user_uuid=$1)", user.UUID)
+	if err != nil {
+		return err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+FlockTable+" where user_uuid=$1", user.UUID)
+	if err != nil {
+		return err
+	}
+	heirUUID := ""
+	if heir != nil {
+		heirUUID = heir.UUID
+	}
+	return TransferSpaceRecords(user.UUID, heirUUID, dbInfo)
+}
@@ -14,16 +14,17 @@ const DefaultAvatarName = "Default Avatar"
 AvatarPartRecord associates an AvatarRecord with a TemplateRecord that represents a head, hand, torso, etc
 */
 type AvatarPartRecord struct {
-	Id           int64  `json:"id" db:"id, primarykey, autoincrement"`
-	UUID         string `json:"uuid" db:"u_u_i_d"`
-	Avatar       int64  `json:"-" db:"avatar"`                   // TODO make this a foreign key
-	TemplateUUID string `json:"templateUUID" db:"template_uuid"` // TODO make this a foreign key
-	Name         string `json:"name" db:"name"`                  // A human readable name, like "Left Lobster Claw"
-	Part         string `json:"part" db:"part"`                  // System short name like "head", "torso", "left_hand", or "right_hand"
-	Parent       string `json:"parent" db:"parent"`              // The AvatarPartRecord.Part name of the scene graph parent of this part, empty if the parent is the root of the avatar
-	Position     string `json:"position" db:"position"`          // "x,y,z" vector3 relative to avatar origin TODO figure out how to use PostgreSQL array types
-	Orientation  string `json:"orientation" db:"orientation"`    // "x,y,z,w" quaternion relative to avatar origin
-	Scale        string `json:"scale" db:"scale"`                // "x,y,z" scale of the part
+	Id               int64  `json:"id" db:"id, primarykey, autoincrement"`
+	UUID             string `json:"uuid" db:"u_u_i_d"`
+	Avatar           int64  `json:"-" db:"avatar"`                           // TODO make this a foreign key
+	TemplateUUID     string `json:"templateUUID" db:"template_uuid"`         // TODO make this a foreign key
+	TemplateRevision int64  `json:"templateRevision" db:"template_revision"` // The pinned TemplateRevisionRecord.Number, or 0 to use the template's current revision
+	Name             string `json:"name" db:"name"`                          // A human readable name, like "Left Lobster Claw"
+	Part             string `json:"part" db:"part"`                          // System short name like "head", "torso", "left_hand", or "right_hand"
+	Parent           string `json:"parent" db:"parent"`                      // The AvatarPartRecord.Part name of the scene graph parent of this part, empty if the parent is the root of the avatar
+	Position         string `json:"position" db:"position"`                  // "x,y,z" vector3 relative to avatar origin TODO figure out how to use PostgreSQL array types
+	Orientation      string `json:"orientation" db:"orientation"`            // "x,y,z,w" quaternion relative to avatar origin
+	Scale            string `json:"scale" db:"scale"`                        // "x,y,z" scale of the part
 	// TODO offer avatar part customization of textures, colors, morphs, positioning, etc
 }
 
@@ -249,6 +250,12 @@ func FindAvatarRecordByField(fieldName string, value string, dbInfo *be.DBInfo)
 	return record, nil
 }
 
+func FindAvatarRecordsByField(fieldName string, value string, dbInfo *be.DBInfo) ([]*AvatarRecord, error) {
+	var records []*AvatarRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+AvatarTable+" where "+fieldName+"=$1 order by id asc", value)
+	return records, err
+}
+
 /*
 AvatarPartDescriptor is a JSON serializable description of a part to load into an AvatarRecord described by AvatarDescriptor
 It's used when loading avatars from demo_data/avatars/
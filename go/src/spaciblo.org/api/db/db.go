@@ -22,8 +22,10 @@ const TEMPLATES_DATA_DIR = "templates"
 
 func MigrateDB(dbInfo *be.DBInfo) error {
 	dbInfo.Map.AddTableWithName(SpaceRecord{}, SpaceTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(SpaceNodeRecord{}, SpaceNodeTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(TemplateRecord{}, TemplateTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(TemplateDataRecord{}, TemplateDataTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(TemplateRevisionRecord{}, TemplateRevisionTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(AvatarRecord{}, AvatarTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(AvatarPartRecord{}, AvatarPartTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(FlockRecord{}, FlockTable).SetKeys(true, "Id")
@@ -32,7 +34,68 @@ func MigrateDB(dbInfo *be.DBInfo) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// CreateTablesIfNotExists does not add columns to existing tables, so add columns that were introduced later
+	err = addColumnIfMissing(SpaceTable, "owner", "text not null default ''", dbInfo)
+	if err != nil {
+		return err
+	}
+	templateColumns := [][]string{
+		{"vertex_count", "bigint not null default 0"},
+		{"face_count", "bigint not null default 0"},
+		{"triangle_count", "bigint not null default 0"},
+		{"material_count", "bigint not null default 0"},
+		{"bounds_min", "text not null default ''"},
+		{"bounds_max", "text not null default ''"},
+		{"textures", "text not null default ''"},
+		{"current_revision", "bigint not null default 1"},
+		{"draft_revision", "bigint not null default 0"},
+	}
+	for _, column := range templateColumns {
+		err = addColumnIfMissing(TemplateTable, column[0], column[1], dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	err = addColumnIfMissing(TemplateDataTable, "revision", "bigint not null default 1", dbInfo)
+	if err != nil {
+		return err
+	}
+	err = addColumnIfMissing(AvatarPartTable, "template_revision", "bigint not null default 0", dbInfo)
+	if err != nil {
+		return err
+	}
+	// Templates from before revisions have all of their data in an implicit first revision
+	_, err = dbInfo.Map.Exec("insert into " + TemplateRevisionTable + " (template, number, published, stats) select id, 1, true, '' from " + TemplateTable + " where not exists (select 1 from " + TemplateRevisionTable + " where template=" + TemplateTable + ".id)")
+	if err != nil {
+		return err
+	}
+	err = addUniqueIndex(TemplateRevisionTable, []string{"template", "number"}, dbInfo)
+	if err != nil {
+		return err
+	}
+	return addUniqueIndex(TemplateDataTable, []string{"template", "revision", "name"}, dbInfo)
+}
+
+/*
+addUniqueIndex makes columns unique together
+Rows that already share the columns are never deleted, instead the migration fails so that someone can decide which to keep.
+*/
+func addUniqueIndex(table string, columns []string, dbInfo *be.DBInfo) error {
+	joined := strings.Join(columns, ", ")
+	duplicates, err := dbInfo.Map.SelectInt("select count(*) from (select 1 from " + table + " group by " + joined + " having count(*) > 1) as duplicates")
+	if err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		return errors.New(table + " has " + strconv.FormatInt(duplicates, 10) + " sets of rows with the same " + joined + ", remove the duplicates before migrating")
+	}
+	_, err = dbInfo.Map.Exec("create unique index if not exists " + table + "_" + strings.Join(columns, "_") + " on " + table + " (" + joined + ")")
+	return err
+}
+
+func addColumnIfMissing(table string, column string, definition string, dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Exec("alter table " + table + " add column if not exists " + column + " " + definition)
+	return err
 }
 
 /*
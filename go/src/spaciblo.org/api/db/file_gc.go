@@ -0,0 +1,62 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"spaciblo.org/be"
+)
+
+/*
+DefaultFileGCGracePeriod is how long files may go unreferenced before CollectFileGarbage deletes them.
+It should be longer than any upload takes to store its files and then reference them in the DB.
+*/
+const DefaultFileGCGracePeriod = 24 * time.Hour
+
+/*
+FindLiveFileKeys returns the FileStorage keys referenced by users, templates, and the template data of every revision
+*/
+func FindLiveFileKeys(dbInfo *be.DBInfo) (map[string]bool, error) {
+	liveKeys := map[string]bool{}
+	for _, query := range []string{
+		"select image from " + be.UserTable + " where image != ''",
+		"select image from " + TemplateTable + " where image != ''",
+		"select distinct key from " + TemplateDataTable,
+	} {
+		var keys []string
+		_, err := dbInfo.Map.Select(&keys, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			liveKeys[key] = true
+		}
+	}
+	return liveKeys, nil
+}
+
+/*
+CollectFileGarbage deletes the files and derivatives that have not been referenced in the DB for gracePeriod
+It also deletes the FileUploads of those files so that they stop counting against storage quotas.
+*/
+func CollectFileGarbage(fileStorage be.FileStorage, gracePeriod time.Duration, dbInfo *be.DBInfo) (*be.FileGCReport, error) {
+	collector, ok := fileStorage.(be.GarbageCollectedFileStorage)
+	if ok == false {
+		return nil, errors.New("The file storage does not support garbage collection")
+	}
+	// Find the cut off first so that files stored while the DB is read are always in the grace period
+	before := time.Now().Add(-gracePeriod)
+	liveKeys, err := FindLiveFileKeys(dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	report, err := collector.CollectGarbage(liveKeys, before)
+	if err != nil {
+		return nil, err
+	}
+	_, err = be.DeleteDeadFileUploads(liveKeys, before, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,694 @@
+package db
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"path"
+	"strconv"
+
+	"spaciblo.org/api/geometry"
+	"spaciblo.org/be"
+)
+
+/*
+SpaceBundleVersion is written into exported bundles
+Version 1 bundles held only vertexCount and triangleCount for each template, version 2 bundles hold the full stats.
+*/
+const SpaceBundleVersion = 2
+const SpaceBundleMimeType = "application/zip"
+const SpaceBundleManifestName = "bundle.json"
+const spaceBundleFilesDir = "files"
+
+/*
+SpaceBundle is the manifest of a space bundle, a zip archive that holds everything needed to recreate a space on another server.
+The archive holds the manifest in SpaceBundleManifestName and the template files under spaceBundleFilesDir.
+UUIDs in the manifest are those of the exporting server and are remapped on import.
+*/
+type SpaceBundle struct {
+	Version   int                    `json:"version"`
+	Name      string                 `json:"name"`
+	State     *SpaceStateNode        `json:"state"`
+	Avatar    *SpaceBundleAvatar     `json:"avatar,omitempty"` // The default avatar of the space, if it has one
+	Templates []*SpaceBundleTemplate `json:"templates"`        // Every template referenced by the state or the avatar's parts
+}
+
+type SpaceBundleTemplate struct {
+	UUID         string             `json:"uuid"`
+	Name         string             `json:"name"`
+	Geometry     string             `json:"geometry"`
+	ClientScript string             `json:"clientScript"`
+	SimScript    string             `json:"simScript"`
+	Part         string             `json:"part"`
+	Parent       string             `json:"parent"`
+	Stats        *geometry.Stats    `json:"stats"`
+	Image        *SpaceBundleFile   `json:"image,omitempty"`
+	Data         []*SpaceBundleFile `json:"data"`
+
+	// Only read from version 1 bundles, which predate Stats
+	VertexCount   int64 `json:"vertexCount,omitempty"`
+	TriangleCount int64 `json:"triangleCount,omitempty"`
+}
+
+/*
+SpaceBundleFile describes a file in the bundle archive
+*/
+type SpaceBundleFile struct {
+	Name   string `json:"name"`   // The TemplateDataRecord name or the FileStorage name of an image
+	Path   string `json:"path"`   // The path of the file in the archive
+	SHA256 string `json:"sha256"` // Hex encoded, used to recognize templates that already exist on import
+}
+
+type SpaceBundleAvatar struct {
+	UUID  string                   `json:"uuid"`
+	Name  string                   `json:"name"`
+	Parts []*SpaceBundleAvatarPart `json:"parts"`
+}
+
+type SpaceBundleAvatarPart struct {
+	TemplateUUID string `json:"templateUUID"`
+	Name         string `json:"name"`
+	Part         string `json:"part"`
+	Parent       string `json:"parent"`
+	Position     string `json:"position"`
+	Orientation  string `json:"orientation"`
+	Scale        string `json:"scale"`
+}
+
+/*
+WriteSpaceBundle writes a zip archive to writer holding the space's state, its templates and their files, and its default avatar
+*/
+func WriteSpaceBundle(spaceRecord *SpaceRecord, writer io.Writer, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	state, err := spaceRecord.LoadState(dbInfo)
+	if err != nil {
+		return err
+	}
+	bundle := &SpaceBundle{
+		Version:   SpaceBundleVersion,
+		Name:      spaceRecord.Name,
+		State:     state,
+		Templates: []*SpaceBundleTemplate{},
+	}
+	templateRecords := []*TemplateRecord{}
+	bundled := make(map[string]bool) // <template uuid, true>
+	addTemplate := func(templateRecord *TemplateRecord) {
+		if bundled[templateRecord.UUID] {
+			return
+		}
+		bundled[templateRecord.UUID] = true
+		templateRecords = append(templateRecords, templateRecord)
+	}
+
+	err = collectBundleTemplates(state, addTemplate, dbInfo)
+	if err != nil {
+		return err
+	}
+
+	if spaceRecord.Avatar != "" {
+		avatarRecord, err := FindAvatarRecord(spaceRecord.Avatar, dbInfo)
+		if err != nil {
+			return err
+		}
+		partRecords, err := FindAvatarPartRecordsForAvatar(avatarRecord.UUID, dbInfo)
+		if err != nil {
+			return err
+		}
+		bundle.Avatar = &SpaceBundleAvatar{
+			UUID:  avatarRecord.UUID,
+			Name:  avatarRecord.Name,
+			Parts: []*SpaceBundleAvatarPart{},
+		}
+		for _, partRecord := range partRecords {
+			templateRecord, err := FindTemplateRecord(partRecord.TemplateUUID, dbInfo)
+			if err != nil {
+				return errors.New("Could not find avatar part template " + partRecord.TemplateUUID + ": " + err.Error())
+			}
+			addTemplate(templateRecord)
+			bundle.Avatar.Parts = append(bundle.Avatar.Parts, &SpaceBundleAvatarPart{
+				TemplateUUID: partRecord.TemplateUUID,
+				Name:         partRecord.Name,
+				Part:         partRecord.Part,
+				Parent:       partRecord.Parent,
+				Position:     partRecord.Position,
+				Orientation:  partRecord.Orientation,
+				Scale:        partRecord.Scale,
+			})
+		}
+	}
+
+	zipWriter := zip.NewWriter(writer)
+	for _, templateRecord := range templateRecords {
+		bundleTemplate := &SpaceBundleTemplate{
+			UUID:         templateRecord.UUID,
+			Name:         templateRecord.Name,
+			Geometry:     templateRecord.Geometry,
+			ClientScript: templateRecord.ClientScript,
+			SimScript:    templateRecord.SimScript,
+			Part:         templateRecord.Part,
+			Parent:       templateRecord.Parent,
+			Stats:        templateRecord.GeometryStats(),
+			Data:         []*SpaceBundleFile{},
+		}
+		templateDir := path.Join(spaceBundleFilesDir, templateRecord.UUID)
+		if templateRecord.Image != "" {
+			bundleTemplate.Image, err = writeSpaceBundleFile(zipWriter, path.Join(templateDir, "image"), "", templateRecord.Image, fileStorage)
+			if err != nil {
+				return err
+			}
+		}
+		dataRecords, err := FindTemplateDataRecords(templateRecord.Id, 0, -1, dbInfo)
+		if err != nil {
+			return err
+		}
+		for index, dataRecord := range dataRecords {
+			// Data names can be anything, so the archive path uses the index and the manifest holds the name
+			bundleFile, err := writeSpaceBundleFile(zipWriter, path.Join(templateDir, "data"), strconv.Itoa(index), dataRecord.Key, fileStorage)
+			if err != nil {
+				return err
+			}
+			bundleFile.Name = dataRecord.Name
+			bundleTemplate.Data = append(bundleTemplate.Data, bundleFile)
+		}
+		bundle.Templates = append(bundle.Templates, bundleTemplate)
+	}
+
+	manifestWriter, err := zipWriter.Create(SpaceBundleManifestName)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(manifestWriter).Encode(bundle)
+	if err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+/*
+collectBundleTemplates passes the template of each node to addTemplate and sets each node's TemplateUUID so that references by name survive the export
+*/
+func collectBundleTemplates(stateNode *SpaceStateNode, addTemplate func(*TemplateRecord), dbInfo *be.DBInfo) error {
+	var templateRecord *TemplateRecord
+	var err error
+	if stateNode.TemplateUUID != "" {
+		templateRecord, err = FindTemplateRecord(stateNode.TemplateUUID, dbInfo)
+	} else if stateNode.TemplateName != "" {
+		templateRecord, err = FindTemplateRecordByField("name", stateNode.TemplateName, dbInfo)
+	}
+	if err != nil {
+		return errors.New("Could not find template " + stateNode.TemplateUUID + stateNode.TemplateName + ": " + err.Error())
+	}
+	if templateRecord != nil {
+		stateNode.TemplateUUID = templateRecord.UUID
+		stateNode.TemplateName = ""
+		addTemplate(templateRecord)
+	}
+	for _, childNode := range stateNode.Nodes {
+		err = collectBundleTemplates(childNode, addTemplate, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+writeSpaceBundleFile copies a stored file into the archive under dir
+If fileName is "" then the file's FileStorage name is used
+*/
+func writeSpaceBundleFile(zipWriter *zip.Writer, dir string, fileName string, key string, fileStorage be.FileStorage) (*SpaceBundleFile, error) {
+	file, err := fileStorage.Get(key, "")
+	if err != nil {
+		return nil, err
+	}
+	name, err := file.Name()
+	if err != nil {
+		return nil, err
+	}
+	if fileName == "" {
+		fileName = name
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	bundleFile := &SpaceBundleFile{
+		Name: name,
+		Path: path.Join(dir, fileName),
+	}
+	entryWriter, err := zipWriter.Create(bundleFile.Path)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(entryWriter, hash), reader)
+	if err != nil {
+		return nil, err
+	}
+	bundleFile.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return bundleFile, nil
+}
+
+/*
+SpaceBundleFileSizes returns the uncompressed sizes of the files in a bundle, which are the most that importing it can store
+*/
+func SpaceBundleFileSizes(readerAt io.ReaderAt, size int64) ([]int64, error) {
+	zipReader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, err
+	}
+	sizes := []int64{}
+	for _, zipFile := range zipReader.File {
+		if zipFile.Name == SpaceBundleManifestName {
+			continue
+		}
+		// Reading a file past its declared size fails, so the declared sizes can be trusted
+		if zipFile.UncompressedSize64 > math.MaxInt64 {
+			return nil, errors.New("The bundle has a file that is too large: " + zipFile.Name)
+		}
+		sizes = append(sizes, int64(zipFile.UncompressedSize64))
+	}
+	return sizes, nil
+}
+
+/*
+ImportSpaceBundle creates a new space from a bundle written by WriteSpaceBundle and returns it with the FileStorage keys of the files it stored
+Templates and the avatar are reused if identical ones already exist, otherwise they are created with new UUIDs.
+If the import fails then the templates and avatar it created are deleted.
+*/
+func ImportSpaceBundle(readerAt io.ReaderAt, size int64, ownerUUID string, fileStorage be.FileStorage, dbInfo *be.DBInfo) (*SpaceRecord, []string, error) {
+	spaceRecord, createdTemplates, err := importSpaceBundle(readerAt, size, ownerUUID, fileStorage, dbInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	storedKeys := []string{}
+	for _, templateRecord := range createdTemplates {
+		if templateRecord.Image != "" {
+			storedKeys = append(storedKeys, templateRecord.Image)
+		}
+		dataRecords, err := FindTemplateRevisionDataRecords(templateRecord.Id, templateRecord.CurrentRevision, 0, -1, dbInfo)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, dataRecord := range dataRecords {
+			storedKeys = append(storedKeys, dataRecord.Key)
+		}
+	}
+	return spaceRecord, storedKeys, nil
+}
+
+func importSpaceBundle(readerAt io.ReaderAt, size int64, ownerUUID string, fileStorage be.FileStorage, dbInfo *be.DBInfo) (*SpaceRecord, []*TemplateRecord, error) {
+	zipReader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	zipFiles := make(map[string]*zip.File) // <archive path, file>
+	for _, zipFile := range zipReader.File {
+		zipFiles[zipFile.Name] = zipFile
+	}
+	manifestFile, ok := zipFiles[SpaceBundleManifestName]
+	if ok == false {
+		return nil, nil, errors.New("The bundle has no " + SpaceBundleManifestName)
+	}
+	bundle, err := readSpaceBundleManifest(manifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bundle.Version < 1 || bundle.Version > SpaceBundleVersion {
+		return nil, nil, errors.New("Unsupported bundle version: " + strconv.Itoa(bundle.Version))
+	}
+	err = validateSpaceBundle(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	createdTemplates := []*TemplateRecord{}
+	var createdAvatar *AvatarRecord
+	rollback := func() {
+		if createdAvatar != nil {
+			DeleteAvatarRecord(createdAvatar, dbInfo)
+		}
+		for _, templateRecord := range createdTemplates {
+			DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+		}
+	}
+
+	templateUUIDs := make(map[string]string) // <bundle template uuid, local template uuid>
+	for _, bundleTemplate := range bundle.Templates {
+		templateRecord, err := findBundleTemplate(bundleTemplate, fileStorage, dbInfo)
+		if err != nil {
+			rollback()
+			return nil, nil, err
+		}
+		if templateRecord == nil {
+			templateRecord, err = createBundleTemplate(bundleTemplate, zipFiles, fileStorage, dbInfo)
+			if err != nil {
+				rollback()
+				return nil, nil, err
+			}
+			createdTemplates = append(createdTemplates, templateRecord)
+		}
+		templateUUIDs[bundleTemplate.UUID] = templateRecord.UUID
+	}
+
+	avatarUUID := ""
+	if bundle.Avatar != nil {
+		for _, part := range bundle.Avatar.Parts {
+			localUUID, ok := templateUUIDs[part.TemplateUUID]
+			if ok == false {
+				rollback()
+				return nil, nil, errors.New("The bundle has no template for avatar part " + part.Name)
+			}
+			part.TemplateUUID = localUUID
+		}
+		avatarRecord, err := findBundleAvatar(bundle.Avatar, dbInfo)
+		if err != nil {
+			rollback()
+			return nil, nil, err
+		}
+		if avatarRecord == nil {
+			avatarRecord, err = createBundleAvatar(bundle.Avatar, dbInfo)
+			if err != nil {
+				rollback()
+				return nil, nil, err
+			}
+			createdAvatar = avatarRecord
+		}
+		avatarUUID = avatarRecord.UUID
+	}
+
+	err = remapTemplateUUIDs(bundle.State, templateUUIDs)
+	if err != nil {
+		rollback()
+		return nil, nil, err
+	}
+	spaceRecord, err := CreateSpaceRecord(bundle.Name, bundle.State.ToString(), avatarUUID, ownerUUID, dbInfo)
+	if err != nil {
+		rollback()
+		return nil, nil, err
+	}
+	return spaceRecord, createdTemplates, nil
+}
+
+func readSpaceBundleManifest(manifestFile *zip.File) (*SpaceBundle, error) {
+	reader, err := manifestFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	bundle := new(SpaceBundle)
+	err = json.NewDecoder(reader).Decode(bundle)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundleTemplate := range bundle.Templates {
+		if bundleTemplate != nil && bundleTemplate.Stats == nil && (bundleTemplate.VertexCount != 0 || bundleTemplate.TriangleCount != 0) {
+			bundleTemplate.Stats = &geometry.Stats{
+				VertexCount:   bundleTemplate.VertexCount,
+				TriangleCount: bundleTemplate.TriangleCount,
+			}
+		}
+	}
+	return bundle, nil
+}
+
+/*
+validateSpaceBundle checks that a manifest has no missing entries, since the manifest comes from whoever uploaded the bundle
+*/
+func validateSpaceBundle(bundle *SpaceBundle) error {
+	if bundle.State == nil {
+		return errors.New("The bundle has no state")
+	}
+	err := validateSpaceBundleState(bundle.State)
+	if err != nil {
+		return err
+	}
+	for index, bundleTemplate := range bundle.Templates {
+		if bundleTemplate == nil {
+			return errors.New("The bundle has an empty template at " + strconv.Itoa(index))
+		}
+		for _, bundleFile := range bundleTemplate.Data {
+			if bundleFile == nil {
+				return errors.New("The bundle has empty data in template " + bundleTemplate.UUID)
+			}
+		}
+	}
+	if bundle.Avatar != nil {
+		for index, part := range bundle.Avatar.Parts {
+			if part == nil {
+				return errors.New("The bundle has an empty avatar part at " + strconv.Itoa(index))
+			}
+		}
+	}
+	return nil
+}
+
+func validateSpaceBundleState(stateNode *SpaceStateNode) error {
+	for _, childNode := range stateNode.Nodes {
+		if childNode == nil {
+			return errors.New("The bundle's state has an empty node")
+		}
+		err := validateSpaceBundleState(childNode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remapTemplateUUIDs(stateNode *SpaceStateNode, templateUUIDs map[string]string) error {
+	if stateNode.TemplateUUID != "" {
+		localUUID, ok := templateUUIDs[stateNode.TemplateUUID]
+		if ok == false {
+			return errors.New("The bundle has no template " + stateNode.TemplateUUID)
+		}
+		stateNode.TemplateUUID = localUUID
+	}
+	stateNode.TemplateName = ""
+	// Bundles hold only the current revision of each template, so revision pins from the exporting service are meaningless
+	delete(stateNode.Settings, TemplateRevisionSetting)
+	for _, childNode := range stateNode.Nodes {
+		err := remapTemplateUUIDs(childNode, templateUUIDs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+findBundleTemplate returns an existing template with the same UUID or name whose fields and files match the bundled template, or nil
+*/
+func findBundleTemplate(bundleTemplate *SpaceBundleTemplate, fileStorage be.FileStorage, dbInfo *be.DBInfo) (*TemplateRecord, error) {
+	candidates, err := FindTemplateRecordsByField("name", bundleTemplate.Name, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	sameUUID, err := FindTemplateRecord(bundleTemplate.UUID, dbInfo)
+	if err == nil {
+		// Prefer the template with the same UUID, which is likely when importing into the server that exported the bundle
+		candidates = append([]*TemplateRecord{sameUUID}, candidates...)
+	}
+	for _, candidate := range candidates {
+		matches, err := templateMatchesBundle(candidate, bundleTemplate, fileStorage, dbInfo)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+func templateMatchesBundle(templateRecord *TemplateRecord, bundleTemplate *SpaceBundleTemplate, fileStorage be.FileStorage, dbInfo *be.DBInfo) (bool, error) {
+	if templateRecord.Name != bundleTemplate.Name ||
+		templateRecord.Geometry != bundleTemplate.Geometry ||
+		templateRecord.ClientScript != bundleTemplate.ClientScript ||
+		templateRecord.SimScript != bundleTemplate.SimScript ||
+		templateRecord.Part != bundleTemplate.Part ||
+		templateRecord.Parent != bundleTemplate.Parent {
+		return false, nil
+	}
+	if (templateRecord.Image == "") != (bundleTemplate.Image == nil) {
+		return false, nil
+	}
+	if templateRecord.Image != "" {
+		imageHash, err := hashStoredFile(templateRecord.Image, fileStorage)
+		if err != nil || imageHash != bundleTemplate.Image.SHA256 {
+			return false, nil
+		}
+	}
+	dataRecords, err := FindTemplateDataRecords(templateRecord.Id, 0, -1, dbInfo)
+	if err != nil {
+		return false, err
+	}
+	if len(dataRecords) != len(bundleTemplate.Data) {
+		return false, nil
+	}
+	dataHashes := make(map[string]string) // <data name, sha256>
+	for _, bundleFile := range bundleTemplate.Data {
+		dataHashes[bundleFile.Name] = bundleFile.SHA256
+	}
+	for _, dataRecord := range dataRecords {
+		bundleHash, ok := dataHashes[dataRecord.Name]
+		if ok == false {
+			return false, nil
+		}
+		dataHash, err := hashStoredFile(dataRecord.Key, fileStorage)
+		if err != nil || dataHash != bundleHash {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func hashStoredFile(key string, fileStorage be.FileStorage) (string, error) {
+	file, err := fileStorage.Get(key, "")
+	if err != nil {
+		return "", err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func createBundleTemplate(bundleTemplate *SpaceBundleTemplate, zipFiles map[string]*zip.File, fileStorage be.FileStorage, dbInfo *be.DBInfo) (*TemplateRecord, error) {
+	templateRecord, err := CreateTemplateRecord(bundleTemplate.Name, bundleTemplate.Geometry, bundleTemplate.ClientScript, bundleTemplate.SimScript, bundleTemplate.Part, bundleTemplate.Parent, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	err = SetCurrentGeometryStats(templateRecord, bundleTemplate.Stats, dbInfo)
+	if err != nil {
+		DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+		return nil, err
+	}
+	if bundleTemplate.Image != nil {
+		templateRecord.Image, err = storeSpaceBundleFile(bundleTemplate.Image, zipFiles, fileStorage)
+		if err != nil {
+			DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+			return nil, err
+		}
+	}
+	err = UpdateTemplateRecord(templateRecord, dbInfo)
+	if err != nil {
+		DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+		return nil, err
+	}
+	for _, bundleFile := range bundleTemplate.Data {
+		key, err := storeSpaceBundleFile(bundleFile, zipFiles, fileStorage)
+		if err != nil {
+			DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+			return nil, err
+		}
+		err = shareTemplateDataFile(key, fileStorage, dbInfo)
+		if err != nil {
+			DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+			return nil, err
+		}
+		_, err = CreateTemplateDataRecord(templateRecord.Id, bundleFile.Name, key, dbInfo)
+		if err != nil {
+			DeleteTemplateDataFileIfUnused(key, fileStorage, dbInfo)
+			DeleteTemplateRecord(templateRecord, fileStorage, dbInfo)
+			return nil, err
+		}
+	}
+	return templateRecord, nil
+}
+
+/*
+storeSpaceBundleFile copies a file from the archive into the file storage and returns its key
+*/
+func storeSpaceBundleFile(bundleFile *SpaceBundleFile, zipFiles map[string]*zip.File, fileStorage be.FileStorage) (string, error) {
+	zipFile, ok := zipFiles[bundleFile.Path]
+	if ok == false {
+		return "", errors.New("The bundle is missing a file: " + bundleFile.Path)
+	}
+	reader, err := zipFile.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	return fileStorage.Put(bundleFile.Name, reader)
+}
+
+/*
+findBundleAvatar returns an existing avatar with the same name and parts as the bundled avatar, or nil
+The bundled parts must already refer to local template UUIDs.
+*/
+func findBundleAvatar(bundleAvatar *SpaceBundleAvatar, dbInfo *be.DBInfo) (*AvatarRecord, error) {
+	candidates, err := FindAvatarRecordsByField("name", bundleAvatar.Name, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		partRecords, err := FindAvatarPartRecordsForAvatar(candidate.UUID, dbInfo)
+		if err != nil {
+			return nil, err
+		}
+		if avatarPartsMatchBundle(partRecords, bundleAvatar.Parts) {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+func avatarPartsMatchBundle(partRecords []*AvatarPartRecord, bundleParts []*SpaceBundleAvatarPart) bool {
+	if len(partRecords) != len(bundleParts) {
+		return false
+	}
+	unmatched := make([]*SpaceBundleAvatarPart, len(bundleParts))
+	copy(unmatched, bundleParts)
+	for _, partRecord := range partRecords {
+		found := false
+		for index, bundlePart := range unmatched {
+			if bundlePart != nil &&
+				bundlePart.TemplateUUID == partRecord.TemplateUUID &&
+				bundlePart.Name == partRecord.Name &&
+				bundlePart.Part == partRecord.Part &&
+				bundlePart.Parent == partRecord.Parent &&
+				bundlePart.Position == partRecord.Position &&
+				bundlePart.Orientation == partRecord.Orientation &&
+				bundlePart.Scale == partRecord.Scale {
+				unmatched[index] = nil
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return false
+		}
+	}
+	return true
+}
+
+func createBundleAvatar(bundleAvatar *SpaceBundleAvatar, dbInfo *be.DBInfo) (*AvatarRecord, error) {
+	avatarRecord, err := CreateAvatarRecord(bundleAvatar.Name, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range bundleAvatar.Parts {
+		_, err = CreateAvatarPartRecord(avatarRecord.Id, part.TemplateUUID, part.Name, part.Part, part.Parent, part.Position, part.Orientation, part.Scale, dbInfo)
+		if err != nil {
+			DeleteAvatarRecord(avatarRecord, dbInfo)
+			return nil, err
+		}
+	}
+	return avatarRecord, nil
+}
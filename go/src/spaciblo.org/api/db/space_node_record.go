@@ -0,0 +1,221 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"gopkg.in/gorp.v2"
+
+	"spaciblo.org/be"
+)
+
+const SpaceNodeTable = "space_nodes"
+
+/*
+SpaceNodeRecord stores one node of a space's scene graph so that the sim can save only the nodes that changed.
+Once a space has SpaceNodeRecords they replace SpaceRecord.State as the stored state of the space.
+*/
+type SpaceNodeRecord struct {
+	Id           int64  `json:"id" db:"id, primarykey, autoincrement"`
+	SpaceUUID    string `json:"spaceUUID" db:"space_u_u_i_d"`
+	Parent       int64  `json:"parent" db:"parent"`              // The Id of the parent SpaceNodeRecord, or 0 for the root node of the space
+	Settings     string `json:"settings" db:"settings"`          // A JSON object like {"name":"Chair"}
+	Position     string `json:"position" db:"position"`          // "x,y,z"
+	Orientation  string `json:"orientation" db:"orientation"`    // "x,y,z,w"
+	Translation  string `json:"translation" db:"translation"`    // "x,y,z"
+	Rotation     string `json:"rotation" db:"rotation"`          // "x,y,z"
+	Scale        string `json:"scale" db:"scale"`                // "x,y,z"
+	TemplateUUID string `json:"templateUUID" db:"template_uuid"` // TODO make this a foreign key
+}
+
+func NewSpaceNodeRecord(spaceUUID string, parent int64, stateNode *SpaceStateNode) *SpaceNodeRecord {
+	record := &SpaceNodeRecord{
+		Id:        stateNode.RecordId,
+		SpaceUUID: spaceUUID,
+		Parent:    parent,
+	}
+	record.SetState(stateNode)
+	return record
+}
+
+/*
+SetState copies the values (but not the children) of a SpaceStateNode into the record
+*/
+func (record *SpaceNodeRecord) SetState(stateNode *SpaceStateNode) {
+	settings := stateNode.Settings
+	if settings == nil {
+		settings = make(map[string]string)
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		logger.Println("Could not encode node settings", err)
+		settingsJSON = []byte("{}")
+	}
+	record.Settings = string(settingsJSON)
+	record.Position = EncodeFloatArrayString(stateNode.Position)
+	record.Orientation = EncodeFloatArrayString(stateNode.Orientation)
+	record.Translation = EncodeFloatArrayString(stateNode.Translation)
+	record.Rotation = EncodeFloatArrayString(stateNode.Rotation)
+	record.Scale = EncodeFloatArrayString(stateNode.Scale)
+	record.TemplateUUID = stateNode.TemplateUUID
+}
+
+/*
+ToSpaceStateNode returns a SpaceStateNode with the record's values and no children
+*/
+func (record *SpaceNodeRecord) ToSpaceStateNode() (*SpaceStateNode, error) {
+	stateNode := NewEmptySpaceStateNode()
+	stateNode.RecordId = record.Id
+	stateNode.TemplateUUID = record.TemplateUUID
+	if record.Settings != "" {
+		err := json.Unmarshal([]byte(record.Settings), &stateNode.Settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if stateNode.Position, err = DecodeFloatArrayString(record.Position, 3, []float64{0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if stateNode.Orientation, err = DecodeFloatArrayString(record.Orientation, 4, []float64{0, 0, 0, 1}); err != nil {
+		return nil, err
+	}
+	if stateNode.Translation, err = DecodeFloatArrayString(record.Translation, 3, []float64{0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if stateNode.Rotation, err = DecodeFloatArrayString(record.Rotation, 3, []float64{0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if stateNode.Scale, err = DecodeFloatArrayString(record.Scale, 3, []float64{1, 1, 1}); err != nil {
+		return nil, err
+	}
+	return stateNode, nil
+}
+
+/*
+FindSpaceNodeRecords returns every node record for a space, ordered so that parents come before their children and siblings keep their order
+*/
+func FindSpaceNodeRecords(spaceUUID string, dbInfo *be.DBInfo) ([]*SpaceNodeRecord, error) {
+	var records []*SpaceNodeRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+SpaceNodeTable+" where space_u_u_i_d=$1 order by id asc", spaceUUID)
+	return records, err
+}
+
+/*
+DecodeSpaceNodeRecords assembles a SpaceStateNode tree from the node records of a space
+Returns nil if there are no records
+*/
+func DecodeSpaceNodeRecords(records []*SpaceNodeRecord) (*SpaceStateNode, error) {
+	var root *SpaceStateNode
+	stateNodes := make(map[int64]*SpaceStateNode) // <record id, state node>
+	for _, record := range records {
+		stateNode, err := record.ToSpaceStateNode()
+		if err != nil {
+			return nil, err
+		}
+		stateNodes[record.Id] = stateNode
+		if record.Parent == 0 {
+			if root != nil {
+				logger.Println("Found a second root node record, ignoring", record.Id)
+				continue
+			}
+			root = stateNode
+			continue
+		}
+		parent, ok := stateNodes[record.Parent]
+		if ok == false {
+			logger.Println("Found a node record with an unknown parent, ignoring", record.Id, record.Parent)
+			continue
+		}
+		parent.Nodes = append(parent.Nodes, stateNode)
+	}
+	return root, nil
+}
+
+func DeleteAllSpaceNodeRecords(dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from " + SpaceNodeTable)
+	return err
+}
+
+/*
+ErrSpaceStateReplaced means that the space's stored state was replaced while a sim was saving changes to it
+*/
+var ErrSpaceStateReplaced = errors.New("The space's state was replaced since it was loaded")
+
+/*
+SpaceNodeChanges writes inserts, updates, and deletions of a space's node records in a single transaction
+The transaction locks the space's row, so UpdateSpaceState can not replace the state between checking for ErrSpaceStateReplaced and Commit.
+Call Commit when all of the changes are made or Rollback to abandon them.
+*/
+type SpaceNodeChanges struct {
+	SpaceUUID   string
+	transaction *gorp.Transaction
+}
+
+func BeginSpaceNodeChanges(spaceUUID string, dbInfo *be.DBInfo) (*SpaceNodeChanges, error) {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = transaction.Exec("select id from "+SpaceTable+" where u_u_i_d=$1 for update", spaceUUID)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	return &SpaceNodeChanges{
+		SpaceUUID:   spaceUUID,
+		transaction: transaction,
+	}, nil
+}
+
+/*
+Insert writes a new record and sets its Id
+*/
+func (changes *SpaceNodeChanges) Insert(record *SpaceNodeRecord) error {
+	record.SpaceUUID = changes.SpaceUUID
+	record.Id = 0
+	return changes.transaction.Insert(record)
+}
+
+/*
+Update returns ErrSpaceStateReplaced if the record is gone, which happens when UpdateSpaceState replaced the space's state
+*/
+func (changes *SpaceNodeChanges) Update(record *SpaceNodeRecord) error {
+	record.SpaceUUID = changes.SpaceUUID
+	count, err := changes.transaction.Update(record)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSpaceStateReplaced
+	}
+	return nil
+}
+
+/*
+HasRecord returns true if the space still has the SpaceNodeRecord with Id id
+*/
+func (changes *SpaceNodeChanges) HasRecord(id int64) (bool, error) {
+	count, err := changes.transaction.SelectInt("select count(*) from "+SpaceNodeTable+" where id=$1 and space_u_u_i_d=$2", id, changes.SpaceUUID)
+	return count > 0, err
+}
+
+/*
+StateBlob returns the space's SpaceRecord.State, which is only used until the space has SpaceNodeRecords
+*/
+func (changes *SpaceNodeChanges) StateBlob() (string, error) {
+	return changes.transaction.SelectStr(`select "State" from `+SpaceTable+` where u_u_i_d=$1`, changes.SpaceUUID)
+}
+
+func (changes *SpaceNodeChanges) Delete(id int64) error {
+	_, err := changes.transaction.Exec("delete from "+SpaceNodeTable+" where id=$1 and space_u_u_i_d=$2", id, changes.SpaceUUID)
+	return err
+}
+
+func (changes *SpaceNodeChanges) Commit() error {
+	return changes.transaction.Commit()
+}
+
+func (changes *SpaceNodeChanges) Rollback() error {
+	return changes.transaction.Rollback()
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"io"
 	"spaciblo.org/be"
@@ -13,20 +14,43 @@ type SpaceRecord struct {
 	Id     int64  `json:"id" db:"id, primarykey, autoincrement"`
 	UUID   string `json:"uuid" db:"u_u_i_d"`
 	Name   string `json:"name" db:"name"`
-	State  string `json:"-"`      // A JSON blob that stores a serialized SpaceStateNode scene graph and settings to initialize a space in a sim
-	Avatar string `json:"avatar"` // The UUID of the default AvatarRecord for the space
+	State  string `json:"-"`                // A JSON blob that stores a serialized SpaceStateNode scene graph and settings to initialize a space in a sim, unused once the space has SpaceNodeRecords
+	Avatar string `json:"avatar"`           // The UUID of the default AvatarRecord for the space
+	Owner  string `json:"owner" db:"owner"` // The UUID of the User who created the space, or "" if it was installed
 }
 
 func (record *SpaceRecord) DecodeState() (*SpaceStateNode, error) {
 	return DecodeSpaceStateNode(bytes.NewBufferString(record.State))
 }
 
-func CreateSpaceRecord(name string, state string, avatarUUID string, dbInfo *be.DBInfo) (*SpaceRecord, error) {
+/*
+LoadState returns the space's scene graph from its SpaceNodeRecords or, if the sim has never saved any, from the State blob
+*/
+func (record *SpaceRecord) LoadState(dbInfo *be.DBInfo) (*SpaceStateNode, error) {
+	nodeRecords, err := FindSpaceNodeRecords(record.UUID, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeRecords) > 0 {
+		state, err := DecodeSpaceNodeRecords(nodeRecords)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			return state, nil
+		}
+		logger.Println("Space has node records but no root node, using the state blob", record.UUID)
+	}
+	return record.DecodeState()
+}
+
+func CreateSpaceRecord(name string, state string, avatarUUID string, ownerUUID string, dbInfo *be.DBInfo) (*SpaceRecord, error) {
 	record := &SpaceRecord{
 		Name:   name,
 		UUID:   be.UUID(),
 		State:  state,
 		Avatar: avatarUUID,
+		Owner:  ownerUUID,
 	}
 	err := dbInfo.Map.Insert(record)
 	if err != nil {
@@ -35,14 +59,32 @@ func CreateSpaceRecord(name string, state string, avatarUUID string, dbInfo *be.
 	return record, nil
 }
 
+/*
+UpdateSpaceState replaces the entire stored state of a space, removing any SpaceNodeRecords so that the new State blob is used
+The sim saves incrementally using SpaceNodeChanges instead of this. A sim that is running the space finds out on its next save, which
+fails with ErrSpaceStateReplaced, and then reloads the new state.
+*/
 func UpdateSpaceState(uuid string, state string, dbInfo *be.DBInfo) error {
-	// TODO update just the state column
-	record, err := FindSpaceRecord(uuid, dbInfo)
+	transaction, err := dbInfo.Map.Begin()
 	if err != nil {
 		return err
 	}
-	record.State = state
-	return UpdateSpaceRecord(record, dbInfo)
+	result, err := transaction.Exec(`update `+SpaceTable+` set "State"=$1 where u_u_i_d=$2`, state, uuid)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err == nil && count == 0 {
+		transaction.Rollback()
+		return sql.ErrNoRows
+	}
+	_, err = transaction.Exec("delete from "+SpaceNodeTable+" where space_u_u_i_d=$1", uuid)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
 }
 
 func UpdateSpaceRecord(record *SpaceRecord, dbInfo *be.DBInfo) error {
@@ -51,6 +93,10 @@ func UpdateSpaceRecord(record *SpaceRecord, dbInfo *be.DBInfo) error {
 }
 
 func DeleteAllSpaceRecords(dbInfo *be.DBInfo) error {
+	err := DeleteAllSpaceNodeRecords(dbInfo)
+	if err != nil {
+		return err
+	}
 	records, err := FindAllSpaceRecords(dbInfo)
 	if err != nil {
 		return err
@@ -80,6 +126,23 @@ func FindAllSpaceRecords(dbInfo *be.DBInfo) ([]*SpaceRecord, error) {
 	return records, err
 }
 
+/*
+FindOwnedSpaceRecords returns the spaces created by the User with ownerUUID
+*/
+func FindOwnedSpaceRecords(ownerUUID string, dbInfo *be.DBInfo) ([]*SpaceRecord, error) {
+	var records []*SpaceRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+SpaceTable+" where owner=$1 order by id desc", ownerUUID)
+	return records, err
+}
+
+/*
+TransferSpaceRecords gives the spaces owned by fromUUID to toUUID, or leaves them without an owner like installed spaces if toUUID is ""
+*/
+func TransferSpaceRecords(fromUUID string, toUUID string, dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Exec("update "+SpaceTable+" set owner=$1 where owner=$2", toUUID, fromUUID)
+	return err
+}
+
 func findSpaceByField(fieldName string, value string, dbInfo *be.DBInfo) (*SpaceRecord, error) {
 	record := new(SpaceRecord)
 	err := dbInfo.Map.SelectOne(record, "select * from "+SpaceTable+" where "+fieldName+"=$1", value)
@@ -89,6 +152,11 @@ func findSpaceByField(fieldName string, value string, dbInfo *be.DBInfo) (*Space
 	return record, nil
 }
 
+/*
+TemplateRevisionSetting is the node setting that pins a node to a TemplateRevisionRecord.Number instead of the template's current revision
+*/
+const TemplateRevisionSetting = "templateRevision"
+
 /*
 SpaceStateNode is used to serialize and parse JSON that holds a space's initialization state.
 Use SpaceStateNode when reading space.json files into the DB or passing around initialization state.
@@ -103,6 +171,7 @@ type SpaceStateNode struct {
 	TemplateName string            `json:"template-name,omitempty"` // Templates can be referenced by names (which are not unique) or by UUID (which are)
 	TemplateUUID string            `json:"template-uuid,omitempty"`
 	Nodes        []*SpaceStateNode `json:"nodes,omitempty"`
+	RecordId     int64             `json:"-"` // The Id of the SpaceNodeRecord this was loaded from, or 0
 }
 
 func NewEmptySpaceStateNode() *SpaceStateNode {
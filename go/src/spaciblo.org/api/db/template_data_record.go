@@ -2,6 +2,8 @@ package db
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
 	"strconv"
 
 	"spaciblo.org/be"
@@ -12,18 +14,31 @@ const TemplateDataTable = "template_data"
 type TemplateDataRecord struct {
 	Id       int64  `json:"id" db:"id, primarykey, autoincrement"`
 	Template int64  `json:"template" db:"template"` // TODO make Template a foreign key
-	Name     string `json:"name" db:"name"`         // TODO make Template and Name unique together
-	Key      string `json:"-" db:"key"`             // The FileStorage key for this data
+	Revision int64  `json:"revision" db:"revision"` // The TemplateRevisionRecord.Number that this data is part of
+	Name     string `json:"name" db:"name"`         // Unique for each Template and Revision
+	Key      string `json:"-" db:"key"`             // The FileStorage key for this data, which may be shared with other revisions
 }
 
+/*
+CreateTemplateDataRecord adds data to the template's current revision
+*/
 func CreateTemplateDataRecord(template int64, name string, key string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
-	dr, err := FindTemplateDataRecordByTemplateId(template, name, dbInfo)
+	revision, err := findCurrentRevision(template, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return CreateTemplateRevisionDataRecord(template, revision, name, key, dbInfo)
+}
+
+func CreateTemplateRevisionDataRecord(template int64, revision int64, name string, key string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
+	dr, err := FindTemplateRevisionDataRecord(template, revision, name, dbInfo)
 	if err == nil {
 		logger.Println(dr, err)
-		return nil, errors.New("A template already exists with that template ID and name: " + strconv.FormatInt(template, 10) + "/" + name)
+		return nil, errors.New("A template already exists with that template ID, revision, and name: " + strconv.FormatInt(template, 10) + "/" + strconv.FormatInt(revision, 10) + "/" + name)
 	}
 	record := &TemplateDataRecord{
 		Template: template,
+		Revision: revision,
 		Name:     name,
 		Key:      key,
 	}
@@ -65,35 +80,134 @@ func DeleteAllTemplateDataRecords(dbInfo *be.DBInfo) error {
 	return nil
 }
 
+/*
+FindTemplateDataRecord returns the named data in the template's current revision
+*/
 func FindTemplateDataRecord(template int64, name string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
-	record := new(TemplateDataRecord)
-	err := dbInfo.Map.SelectOne(record, "select * from "+TemplateDataTable+" where template=$1 and name=$2", template, name)
+	revision, err := findCurrentRevision(template, dbInfo)
 	if err != nil {
 		return nil, err
 	}
-	return record, nil
+	return FindTemplateRevisionDataRecord(template, revision, name, dbInfo)
 }
 
-func FindTemplateDataRecordByTemplateId(templateId int64, name string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
+func FindTemplateRevisionDataRecord(template int64, revision int64, name string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
 	record := new(TemplateDataRecord)
-	err := dbInfo.Map.SelectOne(record, "select * from "+TemplateDataTable+" where template=$1 and name=$2", templateId, name)
+	err := dbInfo.Map.SelectOne(record, "select * from "+TemplateDataTable+" where template=$1 and revision=$2 and name=$3", template, revision, name)
 	if err != nil {
 		return nil, err
 	}
 	return record, nil
 }
 
+func findCurrentRevision(template int64, dbInfo *be.DBInfo) (int64, error) {
+	return dbInfo.Map.SelectInt("select current_revision from "+TemplateTable+" where id=$1", template)
+}
+
+/*
+ReadTemplateData returns the contents of the named data file of a template revision
+*/
+func ReadTemplateData(template int64, revision int64, name string, fileStorage be.FileStorage, dbInfo *be.DBInfo) ([]byte, error) {
+	record, err := FindTemplateRevisionDataRecord(template, revision, name, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fileStorage.Get(record.Key, "")
+	if err != nil {
+		return nil, err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func FindTemplateDataRecordByTemplateId(templateId int64, name string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
+	return FindTemplateDataRecord(templateId, name, dbInfo)
+}
+
+/*
+FindTemplateDataRecords returns the data in the template's current revision
+*/
 func FindTemplateDataRecords(template int64, offset int, limit int, dbInfo *be.DBInfo) ([]*TemplateDataRecord, error) {
+	revision, err := findCurrentRevision(template, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return FindTemplateRevisionDataRecords(template, revision, offset, limit, dbInfo)
+}
+
+func FindTemplateRevisionDataRecords(template int64, revision int64, offset int, limit int, dbInfo *be.DBInfo) ([]*TemplateDataRecord, error) {
 	var records []*TemplateDataRecord
 	if limit > -1 {
-		_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" where template=$1 order by id desc limit $2 offset $3", template, limit, offset)
+		_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" where template=$1 and revision=$2 order by id desc limit $3 offset $4", template, revision, limit, offset)
 		return records, err
 	} else {
-		_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" where template=$1 order by id desc offset $2", template, offset)
+		_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" where template=$1 and revision=$2 order by id desc offset $3", template, revision, offset)
 		return records, err
 	}
 }
 
+/*
+findTemplateDataRecordsForAllRevisions returns the template's data in every revision
+*/
+func findTemplateDataRecordsForAllRevisions(template int64, dbInfo *be.DBInfo) ([]*TemplateDataRecord, error) {
+	var records []*TemplateDataRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" where template=$1 order by id desc", template)
+	return records, err
+}
+
+/*
+StoreTemplateDataFile puts data for a new or updated TemplateDataRecord into fileStorage and returns its key
+*/
+func StoreTemplateDataFile(name string, reader io.Reader, fileStorage be.FileStorage, dbInfo *be.DBInfo) (string, error) {
+	key, err := fileStorage.Put(name, reader)
+	if err != nil {
+		return "", err
+	}
+	err = shareTemplateDataFile(key, fileStorage, dbInfo)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+/*
+shareTemplateDataFile keeps the rule that all of the TemplateDataRecords with a key hold one FileStorage reference, which DeleteTemplateDataFileIfUnused releases
+Call it after putting a file for a TemplateDataRecord, because a content addressed FileStorage returns an existing key with an added reference for identical data.
+*/
+func shareTemplateDataFile(key string, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+TemplateDataTable+" where key=$1", key)
+	if err != nil || count > 0 {
+		fileStorage.Delete(key, "")
+	}
+	return err
+}
+
+/*
+DeleteTemplateDataFileIfUnused deletes the file for key unless another TemplateDataRecord, in any revision or template, still uses it
+Deleting the file also stops it counting against the storage quota of whoever uploaded it.
+Use it instead of FileStorage.Delete for files stored by StoreTemplateDataFile.
+*/
+func DeleteTemplateDataFileIfUnused(key string, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+TemplateDataTable+" where key=$1", key)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	err = fileStorage.Delete(key, "")
+	if err != nil {
+		return err
+	}
+	return be.DeleteKeyFileUploads(key, dbInfo)
+}
+
 func FindAllTemplateDataRecords(dbInfo *be.DBInfo) ([]*TemplateDataRecord, error) {
 	var records []*TemplateDataRecord
 	_, err := dbInfo.Map.Select(&records, "select * from "+TemplateDataTable+" order by id desc")
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+
+	"spaciblo.org/api/geometry"
+	"spaciblo.org/api/render"
+	"spaciblo.org/be"
+)
+
+/*
+The size of rendered template images, which are fit-cropped to smaller sizes when they are served
+*/
+const RenderedTemplateImageSize = 512
+
+/*
+RenderTemplateImage draws the geometry file of the template's current revision, stores it as the template's image, and deletes the previous image
+*/
+func RenderTemplateImage(templateRecord *TemplateRecord, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	if templateRecord.Geometry == "" {
+		return errors.New("The template has no geometry")
+	}
+	load := func(name string) ([]byte, error) {
+		return ReadTemplateData(templateRecord.Id, templateRecord.CurrentRevision, name, fileStorage, dbInfo)
+	}
+	data, err := load(templateRecord.Geometry)
+	if err != nil {
+		return errors.New("Could not read the geometry " + templateRecord.Geometry + ": " + err.Error())
+	}
+	jpegData, err := RenderGeometryImage(templateRecord.Geometry, data, load)
+	if err != nil {
+		return err
+	}
+	key, err := fileStorage.Put("template_image.jpg", bytes.NewReader(jpegData))
+	if err != nil {
+		return err
+	}
+	oldKey := templateRecord.Image
+	templateRecord.Image = key
+	err = UpdateTemplateRecord(templateRecord, dbInfo)
+	if err != nil {
+		templateRecord.Image = oldKey
+		fileStorage.Delete(key, "")
+		return err
+	}
+	if oldKey != "" {
+		err = fileStorage.Delete(oldKey, "")
+		if err != nil {
+			logger.Print("Could not delete old template image: " + err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+RenderGeometryImage returns a JPEG of a glTF, glb, or OBJ file, reading the files it references with load
+*/
+func RenderGeometryImage(name string, data []byte, load geometry.Loader) ([]byte, error) {
+	mesh, err := geometry.LoadMesh(name, data, load)
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := render.Render(mesh, RenderedTemplateImageSize, RenderedTemplateImageSize)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0))
+	err = jpeg.Encode(buffer, rendered, &jpeg.Options{Quality: jpeg.DefaultQuality})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"strings"
+
+	"spaciblo.org/api/geometry"
 	"spaciblo.org/be"
 )
 
@@ -10,12 +13,58 @@ type TemplateRecord struct {
 	Id           int64  `json:"id" db:"id, primarykey, autoincrement"`
 	UUID         string `json:"uuid" db:"u_u_i_d"`               // TODO make unique
 	Name         string `json:"name" db:"name"`                  // A human readable name like "Top Hat" or "Mountain"
-	Geometry     string `json:"geometry" db:"geometry"`          // The name of the graphics file to load with this template, like Something.obj or Something.gltf
+	Geometry     string `json:"geometry" db:"geometry"`          // The name of the graphics file to load with this template, like Something.obj, Something.gltf, or Something.glb
 	ClientScript string `json:"clientScript" db:"client_script"` // A script to run as a WebWorker in the browser
 	SimScript    string `json:"simScript" db:"sim_script"`       // A script to run in the sim TODO add scripting to the sim
 	Part         string `json:"part" db:"part"`                  // The default AvatarPartRecord.Part name (if any)
 	Parent       string `json:"parent" db:"parent"`              // The default AvatarPartRecord.Parent name (if any)
 	Image        string `json:"image" db:"image"`                // The FS key for a representative image depicting this template
+
+	CurrentRevision int64 `json:"currentRevision" db:"current_revision"` // The TemplateRevisionRecord.Number that clients load
+	DraftRevision   int64 `json:"draftRevision" db:"draft_revision"`     // The unpublished TemplateRevisionRecord.Number that receives uploads, or 0 if there is no draft
+
+	// The geometry stats of the current revision, which are zero if unknown
+	VertexCount   int64  `json:"vertexCount" db:"vertex_count"`
+	FaceCount     int64  `json:"faceCount" db:"face_count"`
+	TriangleCount int64  `json:"triangleCount" db:"triangle_count"`
+	MaterialCount int64  `json:"materialCount" db:"material_count"`
+	BoundsMin     string `json:"boundsMin" db:"bounds_min"` // "x,y,z" of the axis-aligned bounding box, empty if unknown
+	BoundsMax     string `json:"boundsMax" db:"bounds_max"` // "x,y,z"
+	Textures      string `json:"textures" db:"textures"`    // Comma separated names of the texture files used by the geometry
+}
+
+/*
+SetGeometryStats records the stats of the template's geometry file, or clears them if stats is nil
+*/
+func (record *TemplateRecord) SetGeometryStats(stats *geometry.Stats) {
+	if stats == nil {
+		stats = &geometry.Stats{}
+	}
+	record.VertexCount = stats.VertexCount
+	record.FaceCount = stats.FaceCount
+	record.TriangleCount = stats.TriangleCount
+	record.MaterialCount = stats.MaterialCount
+	record.BoundsMin = EncodeFloatArrayString(stats.BoundsMin)
+	record.BoundsMax = EncodeFloatArrayString(stats.BoundsMax)
+	record.Textures = strings.Join(stats.Textures, ",")
+}
+
+/*
+GeometryStats returns the stats recorded by SetGeometryStats, without material names which are not stored
+*/
+func (record *TemplateRecord) GeometryStats() *geometry.Stats {
+	stats := &geometry.Stats{
+		VertexCount:   record.VertexCount,
+		FaceCount:     record.FaceCount,
+		TriangleCount: record.TriangleCount,
+		MaterialCount: record.MaterialCount,
+	}
+	stats.BoundsMin, _ = DecodeFloatArrayString(record.BoundsMin, 3, nil)
+	stats.BoundsMax, _ = DecodeFloatArrayString(record.BoundsMax, 3, nil)
+	if record.Textures != "" {
+		stats.Textures = strings.Split(record.Textures, ",")
+	}
+	return stats
 }
 
 func CreateTemplateRecord(name string, geometry string, clientScript string, simScript string, part string, parent string, dbInfo *be.DBInfo) (*TemplateRecord, error) {
@@ -27,19 +76,26 @@ func CreateTemplateRecord(name string, geometry string, clientScript string, sim
 		SimScript:    simScript,
 		Part:         part,
 		Parent:       parent,
+
+		CurrentRevision: 1,
 	}
 	err := dbInfo.Map.Insert(record)
 	if err != nil {
 		return nil, err
 	}
+	_, err = CreateTemplateRevisionRecord(record.Id, record.CurrentRevision, true, dbInfo)
+	if err != nil {
+		dbInfo.Map.Delete(record)
+		return nil, err
+	}
 	return record, nil
 }
 
 /*
-DeleteTemplateRecord deletes the TemplateRecord and
+DeleteTemplateRecord deletes the TemplateRecord and its revisions, data records, data files, and image
 */
 func DeleteTemplateRecord(templateRecord *TemplateRecord, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
-	dataRecords, err := FindTemplateDataRecords(templateRecord.Id, 0, -1, dbInfo)
+	dataRecords, err := findTemplateDataRecordsForAllRevisions(templateRecord.Id, dbInfo)
 	if err != nil {
 		return err
 	}
@@ -48,7 +104,11 @@ func DeleteTemplateRecord(templateRecord *TemplateRecord, fileStorage be.FileSto
 		if err != nil {
 			return err
 		}
-		fileStorage.Delete(dataRecord.Key, "")
+		DeleteTemplateDataFileIfUnused(dataRecord.Key, fileStorage, dbInfo)
+	}
+	err = DeleteTemplateRevisionRecords(templateRecord.Id, dbInfo)
+	if err != nil {
+		return err
 	}
 	imageKey := templateRecord.Image
 	_, err = dbInfo.Map.Delete(templateRecord)
@@ -64,6 +124,58 @@ func DeleteTemplateRecord(templateRecord *TemplateRecord, fileStorage be.FileSto
 	return nil
 }
 
+/*
+CopyTemplateRecord creates a new TemplateRecord with a new UUID and copies of the original's image and current revision's data files
+Content addressed FileStorage stores the copies once, with another reference to the original's files.
+The copy's history starts over with the copied data as its first revision.
+*/
+func CopyTemplateRecord(templateRecord *TemplateRecord, fileStorage be.FileStorage, dbInfo *be.DBInfo) (*TemplateRecord, error) {
+	dataRecords, err := FindTemplateDataRecords(templateRecord.Id, 0, -1, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	record, err := CreateTemplateRecord(templateRecord.Name, templateRecord.Geometry, templateRecord.ClientScript, templateRecord.SimScript, templateRecord.Part, templateRecord.Parent, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	err = SetCurrentGeometryStats(record, templateRecord.GeometryStats(), dbInfo)
+	if err != nil {
+		DeleteTemplateRecord(record, fileStorage, dbInfo)
+		return nil, err
+	}
+	if templateRecord.Image != "" {
+		record.Image, err = be.CopyFile(fileStorage, templateRecord.Image)
+		if err != nil {
+			DeleteTemplateRecord(record, fileStorage, dbInfo)
+			return nil, err
+		}
+	}
+	err = UpdateTemplateRecord(record, dbInfo)
+	if err != nil {
+		DeleteTemplateRecord(record, fileStorage, dbInfo)
+		return nil, err
+	}
+	for _, dataRecord := range dataRecords {
+		key, err := be.CopyFile(fileStorage, dataRecord.Key)
+		if err != nil {
+			DeleteTemplateRecord(record, fileStorage, dbInfo)
+			return nil, err
+		}
+		err = shareTemplateDataFile(key, fileStorage, dbInfo)
+		if err != nil {
+			DeleteTemplateRecord(record, fileStorage, dbInfo)
+			return nil, err
+		}
+		_, err = CreateTemplateDataRecord(record.Id, dataRecord.Name, key, dbInfo)
+		if err != nil {
+			DeleteTemplateDataFileIfUnused(key, fileStorage, dbInfo)
+			DeleteTemplateRecord(record, fileStorage, dbInfo)
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
 func DeleteAllTemplateRecords(fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
 	records, err := FindAllTemplateRecords(dbInfo)
 	if err != nil {
@@ -116,3 +228,9 @@ func FindTemplateRecordByField(fieldName string, value string, dbInfo *be.DBInfo
 	}
 	return record, nil
 }
+
+func FindTemplateRecordsByField(fieldName string, value string, dbInfo *be.DBInfo) ([]*TemplateRecord, error) {
+	var records []*TemplateRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+TemplateTable+" where "+fieldName+"=$1 order by id asc", value)
+	return records, err
+}
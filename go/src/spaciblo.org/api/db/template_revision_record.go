@@ -0,0 +1,334 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"gopkg.in/gorp.v2"
+
+	"spaciblo.org/api/geometry"
+	"spaciblo.org/be"
+)
+
+const TemplateRevisionTable = "template_revisions"
+
+/*
+ErrRevisionPublished is returned when writing to a revision that is no longer the template's draft, usually because it was published during the write
+*/
+var ErrRevisionPublished = errors.New("The revision has been published and can not be changed")
+
+/*
+TemplateRevisionRecord is a numbered set of a template's TemplateDataRecords
+Uploads go into a draft revision, which becomes the template's current revision when it is published.
+Published revisions are never changed so clients can load every file of a revision without seeing a partial update.
+*/
+type TemplateRevisionRecord struct {
+	Id        int64  `json:"-" db:"id, primarykey, autoincrement"`
+	Template  int64  `json:"-" db:"template"`
+	Number    int64  `json:"number" db:"number"`       // Counts up from 1 for each template
+	Published bool   `json:"published" db:"published"` // False while the revision is a draft
+	Stats     string `json:"-" db:"stats"`             // The JSON encoded geometry.Stats of the revision's geometry, empty if unknown
+}
+
+/*
+GeometryStats returns the decoded Stats or nil if they are unknown
+*/
+func (record *TemplateRevisionRecord) GeometryStats() *geometry.Stats {
+	if record.Stats == "" {
+		return nil
+	}
+	stats := new(geometry.Stats)
+	err := json.Unmarshal([]byte(record.Stats), stats)
+	if err != nil {
+		return nil
+	}
+	return stats
+}
+
+func (record *TemplateRevisionRecord) SetGeometryStats(stats *geometry.Stats) {
+	if stats == nil {
+		record.Stats = ""
+		return
+	}
+	data, _ := json.Marshal(stats)
+	record.Stats = string(data)
+}
+
+func CreateTemplateRevisionRecord(template int64, number int64, published bool, dbInfo *be.DBInfo) (*TemplateRevisionRecord, error) {
+	record := &TemplateRevisionRecord{
+		Template:  template,
+		Number:    number,
+		Published: published,
+	}
+	err := dbInfo.Map.Insert(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func UpdateTemplateRevisionRecord(record *TemplateRevisionRecord, dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Update(record)
+	return err
+}
+
+func FindTemplateRevisionRecord(template int64, number int64, dbInfo *be.DBInfo) (*TemplateRevisionRecord, error) {
+	record := new(TemplateRevisionRecord)
+	err := dbInfo.Map.SelectOne(record, "select * from "+TemplateRevisionTable+" where template=$1 and number=$2", template, number)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func FindTemplateRevisionRecords(template int64, offset int, limit int, dbInfo *be.DBInfo) ([]*TemplateRevisionRecord, error) {
+	var records []*TemplateRevisionRecord
+	_, err := dbInfo.Map.Select(&records, "select * from "+TemplateRevisionTable+" where template=$1 order by number desc limit $2 offset $3", template, limit, offset)
+	return records, err
+}
+
+func DeleteTemplateRevisionRecords(template int64, dbInfo *be.DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+TemplateRevisionTable+" where template=$1", template)
+	return err
+}
+
+/*
+SetCurrentGeometryStats records stats on the template's current revision and sets them on the TemplateRecord, which the caller then updates
+Use it when data is stored directly into the current revision, as when installing demo templates or importing bundles.
+*/
+func SetCurrentGeometryStats(templateRecord *TemplateRecord, stats *geometry.Stats, dbInfo *be.DBInfo) error {
+	revision, err := FindTemplateRevisionRecord(templateRecord.Id, templateRecord.CurrentRevision, dbInfo)
+	if err != nil {
+		return err
+	}
+	revision.SetGeometryStats(stats)
+	err = UpdateTemplateRevisionRecord(revision, dbInfo)
+	if err != nil {
+		return err
+	}
+	templateRecord.SetGeometryStats(stats)
+	return nil
+}
+
+/*
+FindOrCreateDraftRevision returns the template's draft revision, creating one from the current revision if there is no draft
+The draft's data records share the current revision's files until they are replaced.
+The template's row is locked while the draft is created so concurrent uploads share one draft.
+*/
+func FindOrCreateDraftRevision(templateRecord *TemplateRecord, dbInfo *be.DBInfo) (*TemplateRevisionRecord, error) {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return nil, err
+	}
+	draftNumber, err := transaction.SelectInt("select draft_revision from "+TemplateTable+" where id=$1 for update", templateRecord.Id)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if draftNumber != 0 {
+		// Another request may have created the draft since templateRecord was read
+		err = transaction.Commit()
+		if err != nil {
+			return nil, err
+		}
+		templateRecord.DraftRevision = draftNumber
+		return FindTemplateRevisionRecord(templateRecord.Id, draftNumber, dbInfo)
+	}
+	latest, err := transaction.SelectInt("select coalesce(max(number), 0) from "+TemplateRevisionTable+" where template=$1", templateRecord.Id)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	draft := &TemplateRevisionRecord{
+		Template:  templateRecord.Id,
+		Number:    latest + 1,
+		Published: false,
+	}
+	current := new(TemplateRevisionRecord)
+	err = transaction.SelectOne(current, "select * from "+TemplateRevisionTable+" where template=$1 and number=$2", templateRecord.Id, templateRecord.CurrentRevision)
+	if err == nil {
+		draft.Stats = current.Stats
+	}
+	err = transaction.Insert(draft)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	_, err = transaction.Exec("insert into "+TemplateDataTable+" (template, revision, name, key) select template, $1, name, key from "+TemplateDataTable+" where template=$2 and revision=$3", draft.Number, templateRecord.Id, templateRecord.CurrentRevision)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	_, err = transaction.Exec("update "+TemplateTable+" set draft_revision=$1 where id=$2", draft.Number, templateRecord.Id)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return nil, err
+	}
+	templateRecord.DraftRevision = draft.Number
+	return draft, nil
+}
+
+/*
+PublishTemplateRevision makes a revision the template's current revision and copies its geometry stats to the template
+Publishing the draft ends it, and publishing an older revision rolls the template back to it.
+The template's row is locked while publishing, so writes to the draft either finish first or fail with ErrRevisionPublished.
+*/
+func PublishTemplateRevision(templateRecord *TemplateRecord, number int64, dbInfo *be.DBInfo) (*TemplateRevisionRecord, error) {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return nil, err
+	}
+	locked := new(TemplateRecord)
+	err = transaction.SelectOne(locked, "select * from "+TemplateTable+" where id=$1 for update", templateRecord.Id)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	revision := new(TemplateRevisionRecord)
+	err = transaction.SelectOne(revision, "select * from "+TemplateRevisionTable+" where template=$1 and number=$2", templateRecord.Id, number)
+	if err != nil {
+		transaction.Rollback()
+		return nil, errors.New("No such revision: " + strconv.FormatInt(number, 10))
+	}
+	if revision.Published == false {
+		revision.Published = true
+		_, err = transaction.Update(revision)
+		if err != nil {
+			transaction.Rollback()
+			return nil, err
+		}
+	}
+	locked.CurrentRevision = revision.Number
+	if locked.DraftRevision == revision.Number {
+		locked.DraftRevision = 0
+	}
+	locked.SetGeometryStats(revision.GeometryStats())
+	_, err = transaction.Update(locked)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return nil, err
+	}
+	*templateRecord = *locked
+	return revision, nil
+}
+
+/*
+lockDraftRevision locks the template's row until transaction ends and returns ErrRevisionPublished unless number is still its unpublished draft
+*/
+func lockDraftRevision(transaction *gorp.Transaction, template int64, number int64) (*TemplateRevisionRecord, error) {
+	draftNumber, err := transaction.SelectInt("select draft_revision from "+TemplateTable+" where id=$1 for update", template)
+	if err != nil {
+		return nil, err
+	}
+	revision := new(TemplateRevisionRecord)
+	err = transaction.SelectOne(revision, "select * from "+TemplateRevisionTable+" where template=$1 and number=$2", template, number)
+	if err != nil {
+		return nil, err
+	}
+	if draftNumber != number || revision.Published {
+		return nil, ErrRevisionPublished
+	}
+	return revision, nil
+}
+
+/*
+StoreDraftTemplateData points the named data of a draft revision at key, creating the TemplateDataRecord if necessary
+Returns the record and the key it used to have, which is "" for new records.
+*/
+func StoreDraftTemplateData(template int64, draft int64, name string, key string, dbInfo *be.DBInfo) (*TemplateDataRecord, string, error) {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = lockDraftRevision(transaction, template, draft)
+	if err != nil {
+		transaction.Rollback()
+		return nil, "", err
+	}
+	oldKey := ""
+	record := new(TemplateDataRecord)
+	err = transaction.SelectOne(record, "select * from "+TemplateDataTable+" where template=$1 and revision=$2 and name=$3", template, draft, name)
+	if err == nil {
+		oldKey = record.Key
+		record.Key = key
+		_, err = transaction.Update(record)
+	} else if err == sql.ErrNoRows {
+		record = &TemplateDataRecord{
+			Template: template,
+			Revision: draft,
+			Name:     name,
+			Key:      key,
+		}
+		err = transaction.Insert(record)
+	}
+	if err != nil {
+		transaction.Rollback()
+		return nil, "", err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+	return record, oldKey, nil
+}
+
+/*
+DeleteDraftTemplateData deletes the named data of a draft revision and returns the deleted record, or sql.ErrNoRows if there is none
+*/
+func DeleteDraftTemplateData(template int64, draft int64, name string, dbInfo *be.DBInfo) (*TemplateDataRecord, error) {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = lockDraftRevision(transaction, template, draft)
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	record := new(TemplateDataRecord)
+	err = transaction.SelectOne(record, "select * from "+TemplateDataTable+" where template=$1 and revision=$2 and name=$3", template, draft, name)
+	if err == nil {
+		_, err = transaction.Delete(record)
+	}
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	err = transaction.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+/*
+SetDraftGeometryStats records stats on a draft revision
+*/
+func SetDraftGeometryStats(template int64, draft int64, stats *geometry.Stats, dbInfo *be.DBInfo) error {
+	transaction, err := dbInfo.Map.Begin()
+	if err != nil {
+		return err
+	}
+	revision, err := lockDraftRevision(transaction, template, draft)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	revision.SetGeometryStats(stats)
+	_, err = transaction.Update(revision)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
@@ -3,9 +3,12 @@ package api
 import (
 	"bytes"
 	"flag"
+	"io/ioutil"
 	"os"
 	"path"
+	"sync"
 	"testing"
+	"time"
 
 	apiDB "spaciblo.org/api/db"
 	"spaciblo.org/be"
@@ -158,7 +161,7 @@ func TestSpaceRecords(t *testing.T) {
 	AssertNil(t, err)
 	AssertEqual(t, 0, len(spaceRecords))
 
-	spaceRecord, err := apiDB.CreateSpaceRecord("Space 0", "{}", "bogus-avatar-uuid", dbInfo)
+	spaceRecord, err := apiDB.CreateSpaceRecord("Space 0", "{}", "bogus-avatar-uuid", "", dbInfo)
 	AssertNil(t, err)
 	spaceRecord2, err := apiDB.FindSpaceRecord(spaceRecord.UUID, dbInfo)
 	AssertNil(t, err)
@@ -172,7 +175,7 @@ func TestSpaceRecords(t *testing.T) {
 	AssertNil(t, err)
 	AssertEqual(t, 1, len(spaceRecords))
 	AssertEqual(t, spaceRecords[0].UUID, spaceRecord.UUID)
-	spaceRecord3, err := apiDB.CreateSpaceRecord("Space 3", "{}", "bogus-avatar-uuid", dbInfo)
+	spaceRecord3, err := apiDB.CreateSpaceRecord("Space 3", "{}", "bogus-avatar-uuid", "", dbInfo)
 	AssertNil(t, err)
 	spaceRecords, err = apiDB.FindAllSpaceRecords(dbInfo)
 	AssertNil(t, err)
@@ -181,6 +184,115 @@ func TestSpaceRecords(t *testing.T) {
 	AssertEqual(t, spaceRecords[1].UUID, spaceRecord.UUID)
 }
 
+func TestSpaceNodeRecords(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	stateNode := apiDB.NewEmptySpaceStateNode()
+	stateNode.Settings["name"] = "Blob Space"
+	spaceRecord, err := apiDB.CreateSpaceRecord("Space 0", stateNode.ToString(), "bogus-avatar-uuid", "", dbInfo)
+	AssertNil(t, err)
+
+	// With no node records the state blob is used
+	loadedState, err := spaceRecord.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Blob Space", loadedState.Settings["name"])
+
+	changes, err := apiDB.BeginSpaceNodeChanges(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	rootRecord := apiDB.NewSpaceNodeRecord(spaceRecord.UUID, 0, apiDB.NewEmptySpaceStateNode())
+	rootRecord.Settings = `{"name":"Node Space"}`
+	AssertNil(t, changes.Insert(rootRecord))
+	AssertTrue(t, rootRecord.Id != 0)
+	childState := apiDB.NewSpaceStateNode([]float64{1, 2, 3}, []float64{0, 0, 0, 1}, []float64{0, 0, 0}, []float64{0, 0, 0}, []float64{2, 2, 2}, "template-uuid")
+	childState.Settings["name"] = "Child 0"
+	childRecord0 := apiDB.NewSpaceNodeRecord(spaceRecord.UUID, rootRecord.Id, childState)
+	AssertNil(t, changes.Insert(childRecord0))
+	childState.Settings["name"] = "Child 1"
+	childRecord1 := apiDB.NewSpaceNodeRecord(spaceRecord.UUID, rootRecord.Id, childState)
+	AssertNil(t, changes.Insert(childRecord1))
+	grandchildRecord := apiDB.NewSpaceNodeRecord(spaceRecord.UUID, childRecord0.Id, apiDB.NewEmptySpaceStateNode())
+	AssertNil(t, changes.Insert(grandchildRecord))
+	AssertNil(t, changes.Commit())
+
+	loadedState, err = spaceRecord.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Node Space", loadedState.Settings["name"])
+	AssertEqual(t, rootRecord.Id, loadedState.RecordId)
+	AssertEqual(t, 2, len(loadedState.Nodes))
+	AssertEqual(t, "Child 0", loadedState.Nodes[0].Settings["name"])
+	AssertEqual(t, "Child 1", loadedState.Nodes[1].Settings["name"])
+	AssertEqual(t, []float64{1, 2, 3}, loadedState.Nodes[0].Position)
+	AssertEqual(t, []float64{2, 2, 2}, loadedState.Nodes[0].Scale)
+	AssertEqual(t, "template-uuid", loadedState.Nodes[0].TemplateUUID)
+	AssertEqual(t, 1, len(loadedState.Nodes[0].Nodes))
+	AssertEqual(t, grandchildRecord.Id, loadedState.Nodes[0].Nodes[0].RecordId)
+
+	// Update and delete only some of the records
+	changes, err = apiDB.BeginSpaceNodeChanges(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	childState.Settings["name"] = "Child 1 Renamed"
+	childState.RecordId = childRecord1.Id
+	AssertNil(t, changes.Update(apiDB.NewSpaceNodeRecord(spaceRecord.UUID, rootRecord.Id, childState)))
+	AssertNil(t, changes.Delete(grandchildRecord.Id))
+	AssertNil(t, changes.Commit())
+
+	loadedState, err = spaceRecord.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Child 1 Renamed", loadedState.Nodes[1].Settings["name"])
+	AssertEqual(t, 0, len(loadedState.Nodes[0].Nodes))
+
+	// Rolled back changes are not saved
+	changes, err = apiDB.BeginSpaceNodeChanges(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertNil(t, changes.Delete(childRecord0.Id))
+	AssertNil(t, changes.Rollback())
+	nodeRecords, err := apiDB.FindSpaceNodeRecords(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 3, len(nodeRecords))
+
+	// Replacing the state waits for changes that are being saved, so a sim can not save over a replaced state
+	changes, err = apiDB.BeginSpaceNodeChanges(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- apiDB.UpdateSpaceState(spaceRecord.UUID, stateNode.ToString(), dbInfo)
+	}()
+	select {
+	case <-replaced:
+		t.Fatal("The state was replaced while changes were being saved")
+	case <-time.After(100 * time.Millisecond):
+	}
+	AssertNil(t, changes.Delete(childRecord0.Id))
+	AssertNil(t, changes.Commit())
+	AssertNil(t, <-replaced)
+	nodeRecords, err = apiDB.FindSpaceNodeRecords(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(nodeRecords))
+
+	// Replacing the whole state removes the node records
+	stateNode.Settings["name"] = "Replaced Space"
+	err = apiDB.UpdateSpaceState(spaceRecord.UUID, stateNode.ToString(), dbInfo)
+	AssertNil(t, err)
+	nodeRecords, err = apiDB.FindSpaceNodeRecords(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(nodeRecords))
+	spaceRecord, err = apiDB.FindSpaceRecord(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	loadedState, err = spaceRecord.LoadState(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "Replaced Space", loadedState.Settings["name"])
+	AssertEqual(t, "Space 0", spaceRecord.Name)
+	err = apiDB.UpdateSpaceState("bogus-uuid", stateNode.ToString(), dbInfo)
+	AssertNotNil(t, err)
+}
+
 func TestTemplateRecords(t *testing.T) {
 	err := be.CreateDB()
 	AssertNil(t, err)
@@ -230,4 +342,96 @@ func TestTemplateRecords(t *testing.T) {
 	data2, err = apiDB.FindTemplateDataRecord(data1.Template, "test.gltf", dbInfo)
 	AssertNil(t, err)
 	AssertEqual(t, data1.Id, data2.Id)
+
+	// Concurrent requests that each read the template before there was a draft share one draft
+	drafts := make(chan int64, 8)
+	var group sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			templateRecord, err := apiDB.FindTemplateRecord(record.UUID, dbInfo)
+			AssertNil(t, err)
+			draft, err := apiDB.FindOrCreateDraftRevision(templateRecord, dbInfo)
+			AssertNil(t, err)
+			AssertEqual(t, draft.Number, templateRecord.DraftRevision)
+			drafts <- draft.Number
+		}()
+	}
+	group.Wait()
+	close(drafts)
+	for number := range drafts {
+		AssertEqual(t, int64(2), number)
+	}
+	draftData, err := apiDB.FindTemplateRevisionDataRecords(record.Id, 2, 0, -1, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(draftData))
+	err = dbInfo.Map.Insert(&apiDB.TemplateDataRecord{Template: record.Id, Revision: 2, Name: "test.gltf", Key: "key5678"})
+	AssertNotNil(t, err, "Template, revision, and name are unique together")
+
+	// Once published, the draft refuses writes from requests that found it earlier
+	stored, oldKey, err := apiDB.StoreDraftTemplateData(record.Id, 2, "test.gltf", "key5678", dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, "key1234", oldKey)
+	AssertEqual(t, "key5678", stored.Key)
+	_, err = apiDB.PublishTemplateRevision(record, 2, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(2), record.CurrentRevision)
+	AssertEqual(t, int64(0), record.DraftRevision)
+	_, _, err = apiDB.StoreDraftTemplateData(record.Id, 2, "test.gltf", "key9012", dbInfo)
+	AssertEqual(t, apiDB.ErrRevisionPublished, err)
+	_, err = apiDB.DeleteDraftTemplateData(record.Id, 2, "test.gltf", dbInfo)
+	AssertEqual(t, apiDB.ErrRevisionPublished, err)
+	draftData, err = apiDB.FindTemplateRevisionDataRecords(record.Id, 2, 0, -1, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(draftData))
+	AssertEqual(t, "key5678", draftData[0].Key)
+}
+
+func TestFileGarbageCollection(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+	fsDir, err := ioutil.TempDir(os.TempDir(), "api-test-fs")
+	AssertNil(t, err)
+	defer os.RemoveAll(fsDir)
+	fs, err := be.NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+
+	userKey, err := fs.Put("user.png", bytes.NewReader([]byte("user")))
+	AssertNil(t, err)
+	user, err := be.CreateUser("gc@example.com", "Garbage", "Collector", false, "", dbInfo)
+	AssertNil(t, err)
+	user.Image = userKey
+	AssertNil(t, be.UpdateUser(user, dbInfo))
+	template, err := apiDB.CreateTemplateRecord("Template GC", "gc.obj", "", "", "", "", dbInfo)
+	AssertNil(t, err)
+	dataKey, err := apiDB.StoreTemplateDataFile("gc.obj", bytes.NewReader([]byte("o gc")), fs, dbInfo)
+	AssertNil(t, err)
+	_, err = apiDB.CreateTemplateDataRecord(template.Id, "gc.obj", dataKey, dbInfo)
+	AssertNil(t, err)
+	orphanKey, err := fs.Put("orphan.png", bytes.NewReader([]byte("orphan")))
+	AssertNil(t, err)
+
+	liveKeys, err := apiDB.FindLiveFileKeys(dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, map[string]bool{userKey: true, dataKey: true}, liveKeys)
+
+	report, err := apiDB.CollectFileGarbage(fs, apiDB.DefaultFileGCGracePeriod, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, report.Originals, "Recent files should be kept")
+	report, err = apiDB.CollectFileGarbage(fs, -time.Second, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 1, report.Originals)
+	AssertEqual(t, int64(6), report.Bytes)
+	for key, expected := range map[string]bool{userKey: true, dataKey: true, orphanKey: false} {
+		exists, err := fs.Exists(key, "")
+		AssertNil(t, err)
+		AssertEqual(t, expected, exists, key)
+	}
 }
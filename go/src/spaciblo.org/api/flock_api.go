@@ -95,6 +95,7 @@ func (resource FlocksResource) Post(request *be.APIRequest) (int, interface{}, h
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "flock", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -161,6 +162,7 @@ func (resource FlockResource) Put(request *be.APIRequest) (int, interface{}, htt
 	}
 
 	// Only some attributes can be updated
+	before := *flock
 	flock.Name = updatedFlock.Name
 	flock.Active = updatedFlock.Active
 	err = apiDB.UpdateFlockRecord(flock, request.DBInfo)
@@ -171,7 +173,7 @@ func (resource FlockResource) Put(request *be.APIRequest) (int, interface{}, htt
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "flock", flock.UUID, before, flock)
 	return 200, flock, responseHeader
 }
 
@@ -200,5 +202,6 @@ func (resource FlockResource) Delete(request *be.APIRequest) (int, interface{},
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditDelete, "flock", flock.UUID, flock, nil)
 	return 200, "{}", responseHeader
 }
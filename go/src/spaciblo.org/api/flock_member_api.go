@@ -110,6 +110,7 @@ func (resource FlockMembersResource) Post(request *be.APIRequest) (int, interfac
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "flock-member", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -188,6 +189,7 @@ func (resource FlockMemberResource) Put(request *be.APIRequest) (int, interface{
 	}
 
 	// Only some attributes can be updated
+	before := *flockMember
 	flockMember.Position = updatedFlockMember.Position
 	flockMember.Orientation = updatedFlockMember.Orientation
 	flockMember.Translation = updatedFlockMember.Translation
@@ -201,7 +203,7 @@ func (resource FlockMemberResource) Put(request *be.APIRequest) (int, interface{
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "flock-member", flockMember.UUID, before, flockMember)
 	return 200, flockMember, responseHeader
 }
 
@@ -236,5 +238,6 @@ func (resource FlockMemberResource) Delete(request *be.APIRequest) (int, interfa
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditDelete, "flock-member", flockMember.UUID, flockMember, nil)
 	return 200, "{}", responseHeader
 }
@@ -0,0 +1,686 @@
+/*
+Package api/geometry parses and validates the geometry files that are uploaded as template data.
+*/
+package geometry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	glbMagic         = 0x46546C67 // "glTF" read as a little endian uint32
+	glbJSONChunkType = 0x4E4F534A // "JSON"
+	glbBINChunkType  = 0x004E4942 // "BIN\0"
+	glbHeaderLength  = 12
+	glb1HeaderLength = 20
+
+	// The buffer id used by glTF 1.0 binary files (KHR_binary_glTF) for the binary body
+	binaryGLTFBufferId = "binary_glTF"
+
+	// The most elements an accessor without a bufferView may have, since no data bounds its count
+	maxUnbackedAccessorCount = 1 << 20
+)
+
+/*
+Stats describes the size and contents of a geometry file
+*/
+type Stats struct {
+	VertexCount   int64     `json:"vertexCount"`
+	FaceCount     int64     `json:"faceCount"`     // Polygons in an OBJ, triangles in a glTF
+	TriangleCount int64     `json:"triangleCount"` // The number of triangles once polygons are triangulated
+	BoundsMin     []float64 `json:"boundsMin"`     // x,y,z of the axis-aligned bounding box, nil if unknown
+	BoundsMax     []float64 `json:"boundsMax"`     // x,y,z
+	MaterialCount int64     `json:"materialCount"`
+	Materials     []string  `json:"materials"` // Material names
+	Textures      []string  `json:"textures"`  // The file names of referenced textures
+}
+
+/*
+CanValidate returns true if Validate knows the file type of name
+*/
+func CanValidate(name string) bool {
+	return IsGLTF(name) || IsOBJ(name) || IsMTL(name)
+}
+
+/*
+Validate checks a glTF, glb, OBJ, or MTL file based on the extension of its name
+resolve and load look up the other files that it references
+*/
+func Validate(name string, data []byte, resolve Resolver, load Loader) (*Stats, error) {
+	switch {
+	case IsGLTF(name):
+		return ValidateGLTF(data, resolve)
+	case IsOBJ(name):
+		return ValidateOBJ(data, resolve, load)
+	case IsMTL(name):
+		return ValidateMTL(data, resolve)
+	}
+	return nil, errors.New("Unknown geometry file type: " + name)
+}
+
+/*
+ValidationError holds every problem found while validating a geometry file
+*/
+type ValidationError struct {
+	Problems []string
+}
+
+func (validationError *ValidationError) Error() string {
+	return strings.Join(validationError.Problems, "; ")
+}
+
+func (validationError *ValidationError) add(format string, args ...interface{}) {
+	validationError.Problems = append(validationError.Problems, fmt.Sprintf(format, args...))
+}
+
+/*
+Resolver returns the size in bytes of a file referenced by a relative URI in a glTF, or an error if the file is missing
+*/
+type Resolver func(uri string) (int64, error)
+
+/*
+IsGLTF returns true if the file name has a .gltf or .glb extension
+*/
+func IsGLTF(name string) bool {
+	extension := strings.ToLower(path.Ext(name))
+	return extension == ".gltf" || extension == ".glb"
+}
+
+/*
+ValidateGLTF parses a glTF JSON or binary glTF (.glb) file, checks that every buffer, image, and shader it references is available and large enough, and returns the stats of its meshes
+Node transforms are ignored, so the bounds are those of the meshes in their own coordinates.
+Both glTF 1.0 (objects keyed by id) and 2.0 (arrays) are supported.
+Problems with the asset are returned as a *ValidationError
+*/
+func ValidateGLTF(data []byte, resolve Resolver) (*Stats, error) {
+	validationError := &ValidationError{}
+	jsonData := data
+	var binData []byte
+	isBinary := len(data) >= 4 && binary.LittleEndian.Uint32(data[0:4]) == glbMagic
+	if isBinary {
+		var err error
+		jsonData, binData, err = parseGLB(data)
+		if err != nil {
+			validationError.add("%s", err.Error())
+			return nil, validationError
+		}
+	}
+
+	document := new(gltfDocument)
+	err := json.Unmarshal(jsonData, document)
+	if err != nil {
+		validationError.add("could not parse the glTF JSON: %s", err.Error())
+		return nil, validationError
+	}
+	version := document.Asset.Version
+	if version == "" {
+		version = "1.0" // Early 1.0 files do not always set the version
+	}
+	if strings.HasPrefix(version, "1.") == false && strings.HasPrefix(version, "2.") == false {
+		validationError.add("asset: unsupported glTF version %q", version)
+		return nil, validationError
+	}
+
+	validator := &gltfValidator{
+		document:        document,
+		version1:        strings.HasPrefix(version, "1."),
+		binary:          isBinary,
+		binData:         binData,
+		resolve:         resolve,
+		validationError: validationError,
+		bufferLengths:   make(map[string]int64),
+		viewLengths:     make(map[string]int64),
+		viewStrides:     make(map[string]int64),
+		accessorCounts:  make(map[string]int64),
+		accessorBounds:  make(map[string][2][]float64),
+	}
+	validator.validateBuffers()
+	validator.validateBufferViews()
+	validator.validateAccessors()
+	validator.validateImages()
+	validator.validateShaders()
+	stats := validator.validateMeshes()
+	if len(validationError.Problems) > 0 {
+		return nil, validationError
+	}
+	return stats, nil
+}
+
+/*
+parseGLB returns the JSON and binary chunks of a binary glTF 1.0 or 2.0 file
+*/
+func parseGLB(data []byte) ([]byte, []byte, error) {
+	if len(data) < glbHeaderLength {
+		return nil, nil, errors.New("glb: file is too short for a header")
+	}
+	version := binary.LittleEndian.Uint32(data[4:8])
+	length := binary.LittleEndian.Uint32(data[8:12])
+	if int64(length) != int64(len(data)) {
+		return nil, nil, fmt.Errorf("glb: header length %d does not match the file length %d", length, len(data))
+	}
+	switch version {
+	case 1:
+		if len(data) < glb1HeaderLength {
+			return nil, nil, errors.New("glb: file is too short for a version 1 header")
+		}
+		contentLength := int64(binary.LittleEndian.Uint32(data[12:16]))
+		contentFormat := binary.LittleEndian.Uint32(data[16:20])
+		if contentFormat != 0 {
+			return nil, nil, fmt.Errorf("glb: unsupported content format %d", contentFormat)
+		}
+		if glb1HeaderLength+contentLength > int64(len(data)) {
+			return nil, nil, fmt.Errorf("glb: content length %d runs past the end of the file", contentLength)
+		}
+		return data[glb1HeaderLength : glb1HeaderLength+contentLength], data[glb1HeaderLength+contentLength:], nil
+	case 2:
+		var jsonChunk []byte
+		var binChunk []byte
+		offset := int64(glbHeaderLength)
+		for index := 0; offset < int64(len(data)); index++ {
+			if offset+8 > int64(len(data)) {
+				return nil, nil, fmt.Errorf("glb: chunk %d header runs past the end of the file", index)
+			}
+			chunkLength := int64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+			chunkType := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+			if chunkLength%4 != 0 {
+				return nil, nil, fmt.Errorf("glb: chunk %d length %d is not 4 byte aligned", index, chunkLength)
+			}
+			if offset+8+chunkLength > int64(len(data)) {
+				return nil, nil, fmt.Errorf("glb: chunk %d length %d runs past the end of the file", index, chunkLength)
+			}
+			chunk := data[offset+8 : offset+8+chunkLength]
+			switch {
+			case index == 0 && chunkType != glbJSONChunkType:
+				return nil, nil, errors.New("glb: the first chunk is not JSON")
+			case index == 0:
+				jsonChunk = chunk
+			case index == 1 && chunkType == glbBINChunkType:
+				binChunk = chunk
+			case chunkType == glbJSONChunkType || chunkType == glbBINChunkType:
+				return nil, nil, fmt.Errorf("glb: chunk %d is an unexpected JSON or BIN chunk", index)
+			}
+			// Chunks of unknown types are allowed and ignored
+			offset += 8 + chunkLength
+		}
+		if jsonChunk == nil {
+			return nil, nil, errors.New("glb: file has no JSON chunk")
+		}
+		return jsonChunk, binChunk, nil
+	default:
+		return nil, nil, fmt.Errorf("glb: unsupported version %d", version)
+	}
+}
+
+/*
+gltfCollection holds a top level glTF property like "buffers", which is an object keyed by id in glTF 1.0 and an array in 2.0
+Array items are keyed by their index.
+*/
+type gltfCollection struct {
+	keys  []string
+	items map[string]json.RawMessage
+}
+
+func (collection *gltfCollection) UnmarshalJSON(data []byte) error {
+	collection.items = make(map[string]json.RawMessage)
+	var array []json.RawMessage
+	if err := json.Unmarshal(data, &array); err == nil {
+		for index, item := range array {
+			key := strconv.Itoa(index)
+			collection.keys = append(collection.keys, key)
+			collection.items[key] = item
+		}
+		return nil
+	}
+	err := json.Unmarshal(data, &collection.items)
+	if err != nil {
+		return err
+	}
+	for key := range collection.items {
+		collection.keys = append(collection.keys, key)
+	}
+	sort.Strings(collection.keys)
+	return nil
+}
+
+/*
+gltfRef is a reference to an item in a gltfCollection, an id string in glTF 1.0 and an index in 2.0
+*/
+type gltfRef string
+
+func (ref *gltfRef) UnmarshalJSON(data []byte) error {
+	var index int64
+	if err := json.Unmarshal(data, &index); err == nil {
+		*ref = gltfRef(strconv.FormatInt(index, 10))
+		return nil
+	}
+	var id string
+	err := json.Unmarshal(data, &id)
+	if err != nil {
+		return errors.New("a reference must be an id or an index")
+	}
+	*ref = gltfRef(id)
+	return nil
+}
+
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Buffers     gltfCollection `json:"buffers"`
+	BufferViews gltfCollection `json:"bufferViews"`
+	Accessors   gltfCollection `json:"accessors"`
+	Meshes      gltfCollection `json:"meshes"`
+	Materials   gltfCollection `json:"materials"`
+	Images      gltfCollection `json:"images"`
+	Shaders     gltfCollection `json:"shaders"` // glTF 1.0 only
+}
+
+type gltfBuffer struct {
+	URI        *string `json:"uri"`
+	ByteLength int64   `json:"byteLength"`
+}
+
+type gltfBufferView struct {
+	Buffer     *gltfRef `json:"buffer"`
+	ByteOffset int64    `json:"byteOffset"`
+	ByteLength int64    `json:"byteLength"`
+	ByteStride int64    `json:"byteStride"` // glTF 2.0
+}
+
+type gltfAccessor struct {
+	BufferView    *gltfRef  `json:"bufferView"`
+	ByteOffset    int64     `json:"byteOffset"`
+	ByteStride    int64     `json:"byteStride"` // glTF 1.0
+	ComponentType int       `json:"componentType"`
+	Count         int64     `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min"`
+	Max           []float64 `json:"max"`
+	Sparse        *struct {
+		Count int64 `json:"count"`
+	} `json:"sparse"` // glTF 2.0
+}
+
+type gltfMesh struct {
+	Primitives []struct {
+		Attributes map[string]gltfRef `json:"attributes"`
+		Indices    *gltfRef           `json:"indices"`
+		Mode       *int               `json:"mode"`
+	} `json:"primitives"`
+}
+
+/*
+gltfExternal is an image or shader, which is either in a file referenced by URI or in a buffer view
+*/
+type gltfExternal struct {
+	URI        *string  `json:"uri"`
+	BufferView *gltfRef `json:"bufferView"` // glTF 2.0
+	Extensions struct {
+		BinaryGLTF *struct {
+			BufferView *gltfRef `json:"bufferView"`
+		} `json:"KHR_binary_glTF"` // glTF 1.0 binary
+	} `json:"extensions"`
+}
+
+var componentTypeSizes = map[int]int64{
+	5120: 1, // BYTE
+	5121: 1, // UNSIGNED_BYTE
+	5122: 2, // SHORT
+	5123: 2, // UNSIGNED_SHORT
+	5125: 4, // UNSIGNED_INT
+	5126: 4, // FLOAT
+}
+
+var accessorTypeComponents = map[string]int64{
+	"SCALAR": 1,
+	"VEC2":   2,
+	"VEC3":   3,
+	"VEC4":   4,
+	"MAT2":   4,
+	"MAT3":   9,
+	"MAT4":   16,
+}
+
+const (
+	modeTriangles     = 4
+	modeTriangleStrip = 5
+	modeTriangleFan   = 6
+)
+
+type gltfValidator struct {
+	document        *gltfDocument
+	version1        bool
+	binary          bool
+	binData         []byte
+	resolve         Resolver
+	validationError *ValidationError
+
+	bufferLengths  map[string]int64        // <buffer key, available bytes>
+	viewLengths    map[string]int64        // <buffer view key, byte length>
+	viewStrides    map[string]int64        // <buffer view key, byte stride>
+	accessorCounts map[string]int64        // <accessor key, count>
+	accessorBounds map[string][2][]float64 // <accessor key, [min, max]> for accessors that declare them
+	textures       []string                // The URIs of image files
+}
+
+func (validator *gltfValidator) problem(format string, args ...interface{}) {
+	validator.validationError.add(format, args...)
+}
+
+/*
+resolveURI returns the size of the data referenced by a data: URI or a relative URI
+*/
+func (validator *gltfValidator) resolveURI(uri string) (int64, error) {
+	if strings.HasPrefix(uri, "data:") {
+		return dataURILength(uri)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uri %q", uri)
+	}
+	if parsed.Scheme != "" || parsed.Host != "" {
+		return 0, fmt.Errorf("uri %q is not relative, external files must be template data", uri)
+	}
+	if validator.resolve == nil {
+		return 0, fmt.Errorf("%q is not available", parsed.Path)
+	}
+	size, err := validator.resolve(parsed.Path)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not in the template data", parsed.Path)
+	}
+	return size, nil
+}
+
+func (validator *gltfValidator) validateBuffers() {
+	for _, key := range validator.document.Buffers.keys {
+		buffer := new(gltfBuffer)
+		err := json.Unmarshal(validator.document.Buffers.items[key], buffer)
+		if err != nil {
+			validator.problem("buffers[%s]: %s", key, err.Error())
+			continue
+		}
+		var available int64
+		var source string
+		if validator.binary && ((validator.version1 && key == binaryGLTFBufferId) || (!validator.version1 && key == "0" && buffer.URI == nil)) {
+			if validator.binData == nil {
+				validator.problem("buffers[%s]: refers to the binary chunk but the glb has none", key)
+				continue
+			}
+			available = int64(len(validator.binData))
+			source = "the binary chunk"
+		} else {
+			if buffer.URI == nil {
+				validator.problem("buffers[%s]: has no uri", key)
+				continue
+			}
+			available, err = validator.resolveURI(*buffer.URI)
+			if err != nil {
+				validator.problem("buffers[%s]: %s", key, err.Error())
+				continue
+			}
+			source = strconv.Quote(*buffer.URI)
+			if strings.HasPrefix(*buffer.URI, "data:") {
+				source = "the data uri"
+			}
+		}
+		if buffer.ByteLength > available {
+			validator.problem("buffers[%s]: byteLength %d is larger than the %d bytes of %s", key, buffer.ByteLength, available, source)
+			continue
+		}
+		if buffer.ByteLength > 0 {
+			available = buffer.ByteLength
+		}
+		validator.bufferLengths[key] = available
+	}
+}
+
+func (validator *gltfValidator) validateBufferViews() {
+	for _, key := range validator.document.BufferViews.keys {
+		view := new(gltfBufferView)
+		err := json.Unmarshal(validator.document.BufferViews.items[key], view)
+		if err != nil {
+			validator.problem("bufferViews[%s]: %s", key, err.Error())
+			continue
+		}
+		if view.Buffer == nil {
+			validator.problem("bufferViews[%s]: has no buffer", key)
+			continue
+		}
+		bufferLength, ok := validator.bufferLengths[string(*view.Buffer)]
+		if ok == false {
+			if _, exists := validator.document.Buffers.items[string(*view.Buffer)]; exists == false {
+				validator.problem("bufferViews[%s]: buffer %s does not exist", key, *view.Buffer)
+			}
+			continue // The buffer's own problem has already been reported
+		}
+		if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > bufferLength {
+			validator.problem("bufferViews[%s]: byteOffset %d + byteLength %d is outside of the %d bytes of buffer %s", key, view.ByteOffset, view.ByteLength, bufferLength, *view.Buffer)
+			continue
+		}
+		validator.viewLengths[key] = view.ByteLength
+		validator.viewStrides[key] = view.ByteStride
+	}
+}
+
+func (validator *gltfValidator) validateAccessors() {
+	for _, key := range validator.document.Accessors.keys {
+		accessor := new(gltfAccessor)
+		err := json.Unmarshal(validator.document.Accessors.items[key], accessor)
+		if err != nil {
+			validator.problem("accessors[%s]: %s", key, err.Error())
+			continue
+		}
+		componentSize, ok := componentTypeSizes[accessor.ComponentType]
+		if ok == false {
+			validator.problem("accessors[%s]: unknown componentType %d", key, accessor.ComponentType)
+			continue
+		}
+		components, ok := accessorTypeComponents[accessor.Type]
+		if ok == false {
+			validator.problem("accessors[%s]: unknown type %q", key, accessor.Type)
+			continue
+		}
+		if accessor.Count < 0 {
+			validator.problem("accessors[%s]: negative count %d", key, accessor.Count)
+			continue
+		}
+		if accessor.ByteOffset < 0 {
+			validator.problem("accessors[%s]: negative byteOffset %d", key, accessor.ByteOffset)
+			continue
+		}
+		if accessor.Sparse != nil && (accessor.Sparse.Count < 1 || accessor.Sparse.Count > accessor.Count) {
+			validator.problem("accessors[%s]: sparse count %d is not between 1 and count %d", key, accessor.Sparse.Count, accessor.Count)
+			continue
+		}
+		if accessor.BufferView == nil && accessor.Count > maxUnbackedAccessorCount {
+			validator.problem("accessors[%s]: count %d is more than the %d allowed without a bufferView", key, accessor.Count, maxUnbackedAccessorCount)
+			continue
+		}
+		validator.accessorCounts[key] = accessor.Count
+		if len(accessor.Min) >= 3 && len(accessor.Max) >= 3 {
+			validator.accessorBounds[key] = [2][]float64{accessor.Min[:3], accessor.Max[:3]}
+		}
+		if accessor.BufferView == nil {
+			if validator.version1 {
+				validator.problem("accessors[%s]: has no bufferView", key)
+			}
+			continue // glTF 2.0 accessors without a buffer view are all zeros
+		}
+		viewKey := string(*accessor.BufferView)
+		viewLength, ok := validator.viewLengths[viewKey]
+		if ok == false {
+			if _, exists := validator.document.BufferViews.items[viewKey]; exists == false {
+				validator.problem("accessors[%s]: bufferView %s does not exist", key, viewKey)
+			}
+			continue
+		}
+		if accessor.Count == 0 {
+			continue
+		}
+		elementSize := componentSize * components
+		stride := accessor.ByteStride
+		if validator.version1 == false {
+			stride = validator.viewStrides[viewKey]
+		}
+		if stride == 0 {
+			stride = elementSize
+		}
+		if stride < 0 {
+			validator.problem("accessors[%s]: negative byteStride %d", key, stride)
+			continue
+		}
+		if accessor.Count-1 > (math.MaxInt64-accessor.ByteOffset-elementSize)/stride {
+			validator.problem("accessors[%s]: count %d is too large for bufferView %s", key, accessor.Count, viewKey)
+			continue
+		}
+		required := accessor.ByteOffset + stride*(accessor.Count-1) + elementSize
+		if required > viewLength {
+			validator.problem("accessors[%s]: needs %d bytes but bufferView %s has %d", key, required, viewKey, viewLength)
+		}
+	}
+}
+
+func (validator *gltfValidator) validateImages() {
+	validator.validateExternals("images", validator.document.Images)
+}
+
+func (validator *gltfValidator) validateShaders() {
+	validator.validateExternals("shaders", validator.document.Shaders)
+}
+
+func (validator *gltfValidator) validateExternals(name string, collection gltfCollection) {
+	for _, key := range collection.keys {
+		external := new(gltfExternal)
+		err := json.Unmarshal(collection.items[key], external)
+		if err != nil {
+			validator.problem("%s[%s]: %s", name, key, err.Error())
+			continue
+		}
+		viewRef := external.BufferView
+		if external.Extensions.BinaryGLTF != nil {
+			viewRef = external.Extensions.BinaryGLTF.BufferView
+		}
+		if viewRef != nil {
+			if _, exists := validator.document.BufferViews.items[string(*viewRef)]; exists == false {
+				validator.problem("%s[%s]: bufferView %s does not exist", name, key, *viewRef)
+			}
+			continue
+		}
+		if external.URI == nil {
+			validator.problem("%s[%s]: has neither a uri nor a bufferView", name, key)
+			continue
+		}
+		_, err = validator.resolveURI(*external.URI)
+		if err != nil {
+			validator.problem("%s[%s]: %s", name, key, err.Error())
+			continue
+		}
+		if name == "images" && strings.HasPrefix(*external.URI, "data:") == false {
+			validator.textures = append(validator.textures, *external.URI)
+		}
+	}
+}
+
+func (validator *gltfValidator) validateMeshes() *Stats {
+	stats := &Stats{
+		MaterialCount: int64(len(validator.document.Materials.keys)),
+		Materials:     []string{},
+		Textures:      validator.textures,
+	}
+	for _, key := range validator.document.Materials.keys {
+		material := struct {
+			Name string `json:"name"`
+		}{}
+		json.Unmarshal(validator.document.Materials.items[key], &material)
+		if material.Name == "" {
+			material.Name = key
+		}
+		stats.Materials = append(stats.Materials, material.Name)
+	}
+	countedPositions := make(map[string]bool) // <accessor key, true>
+	boundsKnown := true
+	for _, key := range validator.document.Meshes.keys {
+		mesh := new(gltfMesh)
+		err := json.Unmarshal(validator.document.Meshes.items[key], mesh)
+		if err != nil {
+			validator.problem("meshes[%s]: %s", key, err.Error())
+			continue
+		}
+		for index, primitive := range mesh.Primitives {
+			for attribute, ref := range primitive.Attributes {
+				if _, exists := validator.document.Accessors.items[string(ref)]; exists == false {
+					validator.problem("meshes[%s].primitives[%d]: %s accessor %s does not exist", key, index, attribute, ref)
+				}
+			}
+			positionRef, ok := primitive.Attributes["POSITION"]
+			if ok == false {
+				validator.problem("meshes[%s].primitives[%d]: has no POSITION attribute", key, index)
+				continue
+			}
+			vertexCount := validator.accessorCounts[string(positionRef)]
+			if countedPositions[string(positionRef)] == false {
+				countedPositions[string(positionRef)] = true
+				stats.VertexCount += vertexCount
+				bounds, ok := validator.accessorBounds[string(positionRef)]
+				if ok == false {
+					boundsKnown = false
+				} else if stats.BoundsMin == nil {
+					stats.BoundsMin = append([]float64{}, bounds[0]...)
+					stats.BoundsMax = append([]float64{}, bounds[1]...)
+				} else {
+					for axis := 0; axis < 3; axis++ {
+						stats.BoundsMin[axis] = math.Min(stats.BoundsMin[axis], bounds[0][axis])
+						stats.BoundsMax[axis] = math.Max(stats.BoundsMax[axis], bounds[1][axis])
+					}
+				}
+			}
+			elementCount := vertexCount
+			if primitive.Indices != nil {
+				if _, exists := validator.document.Accessors.items[string(*primitive.Indices)]; exists == false {
+					validator.problem("meshes[%s].primitives[%d]: indices accessor %s does not exist", key, index, *primitive.Indices)
+					continue
+				}
+				elementCount = validator.accessorCounts[string(*primitive.Indices)]
+			}
+			mode := modeTriangles
+			if primitive.Mode != nil {
+				mode = *primitive.Mode
+			}
+			switch mode {
+			case modeTriangles:
+				stats.TriangleCount += elementCount / 3
+			case modeTriangleStrip, modeTriangleFan:
+				if elementCount > 2 {
+					stats.TriangleCount += elementCount - 2
+				}
+			}
+		}
+	}
+	stats.FaceCount = stats.TriangleCount
+	if boundsKnown == false {
+		// Some positions do not declare their min and max
+		stats.BoundsMin = nil
+		stats.BoundsMax = nil
+	}
+	return stats
+}
+
+/*
+dataURILength returns the decoded length of a data: URI like data:application/octet-stream;base64,AAAA
+*/
+func dataURILength(uri string) (int64, error) {
+	decoded, err := decodeDataURI(uri)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(decoded)), nil
+}
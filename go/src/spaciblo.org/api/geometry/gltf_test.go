@@ -0,0 +1,159 @@
+package geometry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+// A single triangle with indices, in glTF 2.0 with an external buffer
+const triangleGLTF = `{
+	"asset": {"version": "2.0"},
+	"buffers": [{"uri": "triangle.bin", "byteLength": 44}],
+	"bufferViews": [
+		{"buffer": 0, "byteOffset": 0, "byteLength": 36},
+		{"buffer": 0, "byteOffset": 36, "byteLength": 6}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"},
+		{"bufferView": 1, "componentType": 5123, "count": 3, "type": "SCALAR"}
+	],
+	"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1}]}],
+	"images": [{"uri": "texture%20one.png"}]
+}`
+
+// A quad drawn as a triangle strip, in glTF 1.0 with objects keyed by id
+const quadGLTF1 = `{
+	"asset": {"version": "1.0"},
+	"buffers": {"quad": {"uri": "data:application/octet-stream;base64,AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "byteLength": 48}},
+	"bufferViews": {"quadView": {"buffer": "quad", "byteOffset": 0, "byteLength": 48}},
+	"accessors": {"positions": {"bufferView": "quadView", "byteOffset": 0, "byteStride": 12, "componentType": 5126, "count": 4, "type": "VEC3"}},
+	"meshes": {"quadMesh": {"primitives": [{"attributes": {"POSITION": "positions"}, "mode": 5}]}},
+	"shaders": {"vs": {"uri": "quad.glsl"}}
+}`
+
+func mapResolver(sizes map[string]int64) Resolver {
+	return func(uri string) (int64, error) {
+		size, ok := sizes[uri]
+		if ok == false {
+			return 0, errors.New("no such file")
+		}
+		return size, nil
+	}
+}
+
+func TestIsGLTF(t *testing.T) {
+	AssertTrue(t, IsGLTF("Thing.gltf"))
+	AssertTrue(t, IsGLTF("Thing.GLB"))
+	AssertFalse(t, IsGLTF("Thing.obj"))
+	AssertFalse(t, IsGLTF("gltf"))
+}
+
+func TestValidateGLTF(t *testing.T) {
+	resolver := mapResolver(map[string]int64{"triangle.bin": 44, "texture one.png": 100})
+	stats, err := ValidateGLTF([]byte(triangleGLTF), resolver)
+	AssertNil(t, err)
+	AssertEqual(t, int64(3), stats.VertexCount)
+	AssertEqual(t, int64(1), stats.TriangleCount)
+
+	// Missing buffer and image files are reported by URI
+	stats, err = ValidateGLTF([]byte(triangleGLTF), mapResolver(map[string]int64{}))
+	AssertTrue(t, stats == nil)
+	validationError, ok := err.(*ValidationError)
+	AssertTrue(t, ok)
+	AssertEqual(t, 2, len(validationError.Problems), validationError.Error())
+	AssertEqual(t, `buffers[0]: "triangle.bin" is not in the template data`, validationError.Problems[0])
+	AssertEqual(t, `images[0]: "texture one.png" is not in the template data`, validationError.Problems[1])
+
+	// A buffer file that is too short
+	_, err = ValidateGLTF([]byte(triangleGLTF), mapResolver(map[string]int64{"triangle.bin": 20, "texture one.png": 100}))
+	AssertNotNil(t, err)
+	AssertTrue(t, strings.Contains(err.Error(), "byteLength 44 is larger than the 20 bytes"), err.Error())
+
+	// An accessor that runs past its buffer view
+	overrun := strings.Replace(triangleGLTF, `"count": 3, "type": "VEC3"`, `"count": 4, "type": "VEC3"`, 1)
+	_, err = ValidateGLTF([]byte(overrun), resolver)
+	AssertNotNil(t, err)
+	AssertEqual(t, "accessors[0]: needs 48 bytes but bufferView 0 has 36", err.Error())
+
+	// Offsets and counts that would read outside of the bufferView or overflow
+	negative := strings.Replace(triangleGLTF, `{"bufferView": 0, "componentType"`, `{"bufferView": 0, "byteOffset": -8, "componentType"`, 1)
+	_, err = ValidateGLTF([]byte(negative), resolver)
+	AssertNotNil(t, err)
+	AssertEqual(t, "accessors[0]: negative byteOffset -8", err.Error())
+	overflow := strings.Replace(triangleGLTF, `"count": 3, "type": "VEC3"`, `"count": 1000000000000000000, "type": "VEC3"`, 1)
+	_, err = ValidateGLTF([]byte(overflow), resolver)
+	AssertNotNil(t, err)
+	AssertEqual(t, "accessors[0]: count 1000000000000000000 is too large for bufferView 0", err.Error())
+	unbacked := strings.Replace(triangleGLTF, `{"bufferView": 0, "componentType": 5126, "count": 3`, `{"componentType": 5126, "count": 100000000000`, 1)
+	_, err = ValidateGLTF([]byte(unbacked), resolver)
+	AssertNotNil(t, err)
+	AssertTrue(t, strings.Contains(err.Error(), "accessors[0]: count 100000000000 is more than the"), err.Error())
+	sparse := strings.Replace(triangleGLTF, `"count": 3, "type": "VEC3"`, `"count": 3, "type": "VEC3", "sparse": {"count": 4}`, 1)
+	_, err = ValidateGLTF([]byte(sparse), resolver)
+	AssertNotNil(t, err)
+	AssertEqual(t, "accessors[0]: sparse count 4 is not between 1 and count 3", err.Error())
+
+	// A primitive referring to a missing accessor
+	missing := strings.Replace(triangleGLTF, `"indices": 1`, `"indices": 7`, 1)
+	_, err = ValidateGLTF([]byte(missing), resolver)
+	AssertNotNil(t, err)
+	AssertEqual(t, "meshes[0].primitives[0]: indices accessor 7 does not exist", err.Error())
+
+	_, err = ValidateGLTF([]byte("{not json"), resolver)
+	AssertNotNil(t, err)
+	_, err = ValidateGLTF([]byte(`{"asset": {"version": "3.0"}}`), resolver)
+	AssertNotNil(t, err)
+}
+
+func TestValidateGLTF1(t *testing.T) {
+	stats, err := ValidateGLTF([]byte(quadGLTF1), mapResolver(map[string]int64{"quad.glsl": 10}))
+	AssertNil(t, err)
+	AssertEqual(t, int64(4), stats.VertexCount)
+	AssertEqual(t, int64(2), stats.TriangleCount)
+
+	_, err = ValidateGLTF([]byte(quadGLTF1), mapResolver(map[string]int64{}))
+	AssertNotNil(t, err)
+	AssertEqual(t, `shaders[vs]: "quad.glsl" is not in the template data`, err.Error())
+}
+
+func TestValidateGLB(t *testing.T) {
+	json := strings.Replace(triangleGLTF, `{"uri": "triangle.bin", "byteLength": 44}`, `{"byteLength": 44}`, 1)
+	json = strings.Replace(json, `{"uri": "texture%20one.png"}`, `{"bufferView": 1, "mimeType": "image/png"}`, 1)
+	glb := makeGLB([]byte(json), make([]byte, 44))
+	stats, err := ValidateGLTF(glb, nil)
+	AssertNil(t, err)
+	AssertEqual(t, int64(3), stats.VertexCount)
+	AssertEqual(t, int64(1), stats.TriangleCount)
+
+	// The binary chunk is too short for the buffer
+	_, err = ValidateGLTF(makeGLB([]byte(json), make([]byte, 12)), nil)
+	AssertNotNil(t, err)
+	AssertTrue(t, strings.Contains(err.Error(), "buffers[0]: byteLength 44 is larger than the 12 bytes of the binary chunk"), err.Error())
+
+	// The header length does not match
+	_, err = ValidateGLTF(glb[:len(glb)-4], nil)
+	AssertNotNil(t, err)
+	AssertTrue(t, strings.HasPrefix(err.Error(), "glb: header length"), err.Error())
+}
+
+func makeGLB(jsonChunk []byte, binChunk []byte) []byte {
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+	for len(binChunk)%4 != 0 {
+		binChunk = append(binChunk, 0)
+	}
+	buff := &bytes.Buffer{}
+	length := glbHeaderLength + 8 + len(jsonChunk) + 8 + len(binChunk)
+	binary.Write(buff, binary.LittleEndian, []uint32{glbMagic, 2, uint32(length)})
+	binary.Write(buff, binary.LittleEndian, []uint32{uint32(len(jsonChunk)), glbJSONChunkType})
+	buff.Write(jsonChunk)
+	binary.Write(buff, binary.LittleEndian, []uint32{uint32(len(binChunk)), glbBINChunkType})
+	buff.Write(binChunk)
+	return buff.Bytes()
+}
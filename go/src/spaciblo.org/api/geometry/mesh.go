@@ -0,0 +1,780 @@
+package geometry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+/*
+DefaultColor is the RGB color of triangles that have no material
+*/
+var DefaultColor = [3]float64{0.8, 0.8, 0.8}
+
+/*
+Triangle is three positions and the RGB color (0 to 1) of its material
+*/
+type Triangle struct {
+	Vertices [3][3]float64
+	Color    [3]float64
+}
+
+/*
+Mesh is the triangles of a geometry file in the coordinates of the file's root, ready for rendering
+*/
+type Mesh struct {
+	Triangles []Triangle
+}
+
+/*
+Bounds returns the x,y,z min and max of the mesh's axis-aligned bounding box, or nil if it has no triangles
+*/
+func (mesh *Mesh) Bounds() ([]float64, []float64) {
+	if len(mesh.Triangles) == 0 {
+		return nil, nil
+	}
+	min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, triangle := range mesh.Triangles {
+		for _, vertex := range triangle.Vertices {
+			for axis := 0; axis < 3; axis++ {
+				min[axis] = math.Min(min[axis], vertex[axis])
+				max[axis] = math.Max(max[axis], vertex[axis])
+			}
+		}
+	}
+	return min, max
+}
+
+/*
+LoadMesh reads the triangles and material colors of a glTF, glb, or OBJ file
+load reads the files it references, like buffers, MTL files, and textures. Textured materials are colored by their texture's average color.
+The file should have already passed Validate.
+*/
+func LoadMesh(name string, data []byte, load Loader) (*Mesh, error) {
+	switch {
+	case IsGLTF(name):
+		return loadGLTFMesh(data, load)
+	case IsOBJ(name):
+		return loadOBJMesh(data, load)
+	}
+	return nil, errors.New("Unknown geometry file type: " + name)
+}
+
+/*
+objMaterial is the part of an MTL material used for rendering
+*/
+type objMaterial struct {
+	diffuse *[3]float64
+	texture string
+}
+
+func loadOBJMesh(data []byte, load Loader) (*Mesh, error) {
+	mesh := &Mesh{}
+	positions := [][3]float64{}
+	materials := make(map[string]*objMaterial)
+	colors := make(map[string][3]float64) // <material name, color> cached as materials are used
+	color := DefaultColor
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		keyword, rest := splitStatement(scanner.Text())
+		switch keyword {
+		case "v":
+			fields := strings.Fields(rest)
+			if len(fields) < 3 {
+				continue
+			}
+			var position [3]float64
+			for axis := 0; axis < 3; axis++ {
+				position[axis], _ = strconv.ParseFloat(fields[axis], 64)
+			}
+			positions = append(positions, position)
+		case "f":
+			indices := []int{}
+			for _, field := range strings.Fields(rest) {
+				value, err := strconv.Atoi(strings.Split(field, "/")[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid face index %q", field)
+				}
+				if value < 0 {
+					value = len(positions) + value + 1
+				}
+				if value < 1 || value > len(positions) {
+					return nil, fmt.Errorf("face index %d is out of range", value)
+				}
+				indices = append(indices, value-1)
+			}
+			// Triangulate the polygon as a fan
+			for index := 2; index < len(indices); index++ {
+				mesh.Triangles = append(mesh.Triangles, Triangle{
+					Vertices: [3][3]float64{positions[indices[0]], positions[indices[index-1]], positions[indices[index]]},
+					Color:    color,
+				})
+			}
+		case "mtllib":
+			if load == nil {
+				continue
+			}
+			for _, mtlName := range splitFileNames(rest, func(name string) (int64, error) {
+				mtlData, err := load(name)
+				return int64(len(mtlData)), err
+			}) {
+				mtlData, err := load(mtlName)
+				if err != nil {
+					continue // Missing MTL files are reported by Validate, so render without them
+				}
+				readMTLMaterials(mtlData, materials)
+			}
+		case "usemtl":
+			var ok bool
+			color, ok = colors[rest]
+			if ok == false {
+				color = materialColor(materials[rest], load)
+				colors[rest] = color
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mesh, nil
+}
+
+/*
+readMTLMaterials adds the diffuse colors and textures of an MTL file's materials to materials
+*/
+func readMTLMaterials(data []byte, materials map[string]*objMaterial) {
+	var material *objMaterial
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		keyword, rest := splitStatement(scanner.Text())
+		switch strings.ToLower(keyword) {
+		case "newmtl":
+			material = &objMaterial{}
+			materials[rest] = material
+		case "kd":
+			fields := strings.Fields(rest)
+			if material == nil || len(fields) < 3 {
+				continue
+			}
+			diffuse := [3]float64{}
+			for channel := 0; channel < 3; channel++ {
+				diffuse[channel], _ = strconv.ParseFloat(fields[channel], 64)
+			}
+			material.diffuse = &diffuse
+		case "map_kd":
+			if material != nil {
+				material.texture = textureFileName(rest)
+			}
+		}
+	}
+}
+
+/*
+materialColor returns the diffuse color multiplied by the average color of the texture, if any
+*/
+func materialColor(material *objMaterial, load Loader) [3]float64 {
+	if material == nil {
+		return DefaultColor
+	}
+	color := DefaultColor
+	if material.diffuse != nil {
+		color = *material.diffuse
+	} else if material.texture != "" {
+		color = [3]float64{1, 1, 1}
+	}
+	if material.texture != "" && load != nil {
+		if textureData, err := load(material.texture); err == nil {
+			color = multiplyColor(color, averageImageColor(textureData))
+		}
+	}
+	return color
+}
+
+/*
+averageImageColor returns the average RGB color of a PNG, JPEG, or GIF, or white if it can not be decoded
+The image is sampled on a grid so that large textures are quick to average.
+*/
+func averageImageColor(data []byte) [3]float64 {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return [3]float64{1, 1, 1}
+	}
+	bounds := decoded.Bounds()
+	if bounds.Empty() {
+		return [3]float64{1, 1, 1}
+	}
+	const samples = 64
+	var sum [3]float64
+	var count float64
+	for row := 0; row < samples; row++ {
+		y := bounds.Min.Y + (2*row+1)*bounds.Dy()/(2*samples)
+		for column := 0; column < samples; column++ {
+			x := bounds.Min.X + (2*column+1)*bounds.Dx()/(2*samples)
+			r, g, b, _ := decoded.At(x, y).RGBA()
+			sum[0] += float64(r) / 0xffff
+			sum[1] += float64(g) / 0xffff
+			sum[2] += float64(b) / 0xffff
+			count++
+		}
+	}
+	return [3]float64{sum[0] / count, sum[1] / count, sum[2] / count}
+}
+
+func multiplyColor(color1 [3]float64, color2 [3]float64) [3]float64 {
+	return [3]float64{color1[0] * color2[0], color1[1] * color2[1], color1[2] * color2[2]}
+}
+
+/*
+matrix4 is a column-major 4x4 transform, like glTF node matrices
+*/
+type matrix4 [16]float64
+
+var identityMatrix = matrix4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+
+func (m matrix4) multiply(other matrix4) matrix4 {
+	var result matrix4
+	for column := 0; column < 4; column++ {
+		for row := 0; row < 4; row++ {
+			var sum float64
+			for index := 0; index < 4; index++ {
+				sum += m[index*4+row] * other[column*4+index]
+			}
+			result[column*4+row] = sum
+		}
+	}
+	return result
+}
+
+func (m matrix4) transformPoint(point [3]float64) [3]float64 {
+	return [3]float64{
+		m[0]*point[0] + m[4]*point[1] + m[8]*point[2] + m[12],
+		m[1]*point[0] + m[5]*point[1] + m[9]*point[2] + m[13],
+		m[2]*point[0] + m[6]*point[1] + m[10]*point[2] + m[14],
+	}
+}
+
+/*
+composeMatrix returns the matrix of a translation, an x,y,z,w rotation quaternion, and a scale
+*/
+func composeMatrix(translation []float64, rotation []float64, scale []float64) matrix4 {
+	t := []float64{0, 0, 0}
+	q := []float64{0, 0, 0, 1}
+	s := []float64{1, 1, 1}
+	if len(translation) == 3 {
+		t = translation
+	}
+	if len(rotation) == 4 {
+		q = rotation
+	}
+	if len(scale) == 3 {
+		s = scale
+	}
+	x, y, z, w := q[0], q[1], q[2], q[3]
+	return matrix4{
+		(1 - 2*(y*y+z*z)) * s[0], 2 * (x*y + z*w) * s[0], 2 * (x*z - y*w) * s[0], 0,
+		2 * (x*y - z*w) * s[1], (1 - 2*(x*x+z*z)) * s[1], 2 * (y*z + x*w) * s[1], 0,
+		2 * (x*z + y*w) * s[2], 2 * (y*z - x*w) * s[2], (1 - 2*(x*x+y*y)) * s[2], 0,
+		t[0], t[1], t[2], 1,
+	}
+}
+
+type gltfSceneDocument struct {
+	Scene    *gltfRef       `json:"scene"`
+	Scenes   gltfCollection `json:"scenes"`
+	Nodes    gltfCollection `json:"nodes"`
+	Textures gltfCollection `json:"textures"`
+}
+
+type gltfNode struct {
+	Children    []gltfRef `json:"children"`
+	Matrix      []float64 `json:"matrix"`
+	Translation []float64 `json:"translation"`
+	Rotation    []float64 `json:"rotation"`
+	Scale       []float64 `json:"scale"`
+	Mesh        *gltfRef  `json:"mesh"`   // glTF 2.0
+	Meshes      []gltfRef `json:"meshes"` // glTF 1.0
+}
+
+type gltfMaterial struct {
+	PBRMetallicRoughness *struct {
+		BaseColorFactor  []float64 `json:"baseColorFactor"`
+		BaseColorTexture *struct {
+			Index gltfRef `json:"index"`
+		} `json:"baseColorTexture"`
+	} `json:"pbrMetallicRoughness"` // glTF 2.0
+	Values struct {
+		Diffuse json.RawMessage `json:"diffuse"` // glTF 1.0, a color or a texture id
+	} `json:"values"`
+}
+
+/*
+gltfMeshLoader reads the buffer data of a glTF file that has passed ValidateGLTF
+*/
+type gltfMeshLoader struct {
+	document *gltfDocument
+	scene    *gltfSceneDocument
+	version1 bool
+	binary   bool
+	binData  []byte
+	load     Loader
+	buffers  map[string][]byte     // <buffer key, data>
+	colors   map[string][3]float64 // <material key, color>
+	mesh     *Mesh
+}
+
+func loadGLTFMesh(data []byte, load Loader) (*Mesh, error) {
+	jsonData := data
+	var binData []byte
+	isBinary := len(data) >= 4 && binary.LittleEndian.Uint32(data[0:4]) == glbMagic
+	if isBinary {
+		var err error
+		jsonData, binData, err = parseGLB(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	document := new(gltfDocument)
+	err := json.Unmarshal(jsonData, document)
+	if err != nil {
+		return nil, err
+	}
+	sceneDocument := new(gltfSceneDocument)
+	err = json.Unmarshal(jsonData, sceneDocument)
+	if err != nil {
+		return nil, err
+	}
+	loader := &gltfMeshLoader{
+		document: document,
+		scene:    sceneDocument,
+		version1: document.Asset.Version == "" || strings.HasPrefix(document.Asset.Version, "1."),
+		binary:   isBinary,
+		binData:  binData,
+		load:     load,
+		buffers:  make(map[string][]byte),
+		colors:   make(map[string][3]float64),
+		mesh:     &Mesh{},
+	}
+
+	roots := loader.rootNodes()
+	if len(roots) == 0 {
+		// Without a node hierarchy every mesh is drawn untransformed
+		for _, key := range document.Meshes.keys {
+			err = loader.addMesh(key, identityMatrix)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return loader.mesh, nil
+	}
+	for _, key := range roots {
+		err = loader.addNode(key, identityMatrix, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return loader.mesh, nil
+}
+
+/*
+rootNodes returns the node keys of the default scene, or of the first scene, or of nodes that are not children
+*/
+func (loader *gltfMeshLoader) rootNodes() []string {
+	sceneKey := ""
+	if loader.scene.Scene != nil {
+		sceneKey = string(*loader.scene.Scene)
+	} else if len(loader.scene.Scenes.keys) > 0 {
+		sceneKey = loader.scene.Scenes.keys[0]
+	}
+	if sceneData, ok := loader.scene.Scenes.items[sceneKey]; ok {
+		scene := struct {
+			Nodes []gltfRef `json:"nodes"`
+		}{}
+		if err := json.Unmarshal(sceneData, &scene); err == nil {
+			roots := []string{}
+			for _, ref := range scene.Nodes {
+				roots = append(roots, string(ref))
+			}
+			return roots
+		}
+	}
+	children := make(map[string]bool)
+	for _, key := range loader.scene.Nodes.keys {
+		node := new(gltfNode)
+		if err := json.Unmarshal(loader.scene.Nodes.items[key], node); err == nil {
+			for _, child := range node.Children {
+				children[string(child)] = true
+			}
+		}
+	}
+	roots := []string{}
+	for _, key := range loader.scene.Nodes.keys {
+		if children[key] == false {
+			roots = append(roots, key)
+		}
+	}
+	return roots
+}
+
+func (loader *gltfMeshLoader) addNode(key string, parent matrix4, depth int) error {
+	if depth > 64 {
+		return errors.New("nodes are nested too deeply, is there a cycle?")
+	}
+	nodeData, ok := loader.scene.Nodes.items[key]
+	if ok == false {
+		return fmt.Errorf("node %s does not exist", key)
+	}
+	node := new(gltfNode)
+	err := json.Unmarshal(nodeData, node)
+	if err != nil {
+		return fmt.Errorf("nodes[%s]: %s", key, err.Error())
+	}
+	transform := composeMatrix(node.Translation, node.Rotation, node.Scale)
+	if len(node.Matrix) == 16 {
+		copy(transform[:], node.Matrix)
+	}
+	transform = parent.multiply(transform)
+	meshes := node.Meshes
+	if node.Mesh != nil {
+		meshes = append(meshes, *node.Mesh)
+	}
+	for _, meshRef := range meshes {
+		err = loader.addMesh(string(meshRef), transform)
+		if err != nil {
+			return err
+		}
+	}
+	for _, child := range node.Children {
+		err = loader.addNode(string(child), transform, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (loader *gltfMeshLoader) addMesh(key string, transform matrix4) error {
+	meshData, ok := loader.document.Meshes.items[key]
+	if ok == false {
+		return fmt.Errorf("mesh %s does not exist", key)
+	}
+	mesh := struct {
+		Primitives []struct {
+			Attributes map[string]gltfRef `json:"attributes"`
+			Indices    *gltfRef           `json:"indices"`
+			Mode       *int               `json:"mode"`
+			Material   *gltfRef           `json:"material"`
+		} `json:"primitives"`
+	}{}
+	err := json.Unmarshal(meshData, &mesh)
+	if err != nil {
+		return fmt.Errorf("meshes[%s]: %s", key, err.Error())
+	}
+	for _, primitive := range mesh.Primitives {
+		mode := modeTriangles
+		if primitive.Mode != nil {
+			mode = *primitive.Mode
+		}
+		if mode != modeTriangles && mode != modeTriangleStrip && mode != modeTriangleFan {
+			continue // Points and lines are not drawn
+		}
+		positionRef, ok := primitive.Attributes["POSITION"]
+		if ok == false {
+			continue
+		}
+		positions, err := loader.readAccessor(string(positionRef))
+		if err != nil {
+			return err
+		}
+		var indices []int
+		if primitive.Indices != nil {
+			values, err := loader.readAccessor(string(*primitive.Indices))
+			if err != nil {
+				return err
+			}
+			for _, value := range values {
+				indices = append(indices, int(value[0]))
+			}
+		} else {
+			for index := range positions {
+				indices = append(indices, index)
+			}
+		}
+		color := DefaultColor
+		if primitive.Material != nil {
+			color = loader.materialColor(string(*primitive.Material))
+		}
+		vertex := func(index int) ([3]float64, bool) {
+			if index < 0 || index >= len(positions) || len(positions[index]) < 3 {
+				return [3]float64{}, false
+			}
+			return transform.transformPoint([3]float64{positions[index][0], positions[index][1], positions[index][2]}), true
+		}
+		addTriangle := func(a int, b int, c int) {
+			va, okA := vertex(a)
+			vb, okB := vertex(b)
+			vc, okC := vertex(c)
+			if okA && okB && okC {
+				loader.mesh.Triangles = append(loader.mesh.Triangles, Triangle{Vertices: [3][3]float64{va, vb, vc}, Color: color})
+			}
+		}
+		switch mode {
+		case modeTriangles:
+			for index := 0; index+2 < len(indices); index += 3 {
+				addTriangle(indices[index], indices[index+1], indices[index+2])
+			}
+		case modeTriangleStrip:
+			for index := 0; index+2 < len(indices); index++ {
+				if index%2 == 0 {
+					addTriangle(indices[index], indices[index+1], indices[index+2])
+				} else {
+					addTriangle(indices[index+1], indices[index], indices[index+2])
+				}
+			}
+		case modeTriangleFan:
+			for index := 1; index+1 < len(indices); index++ {
+				addTriangle(indices[0], indices[index], indices[index+1])
+			}
+		}
+	}
+	return nil
+}
+
+/*
+materialColor returns the base color of a glTF 2.0 material or the diffuse color of a glTF 1.0 material, multiplied by its texture's average color
+*/
+func (loader *gltfMeshLoader) materialColor(key string) [3]float64 {
+	if color, ok := loader.colors[key]; ok {
+		return color
+	}
+	color := DefaultColor
+	material := new(gltfMaterial)
+	if err := json.Unmarshal(loader.document.Materials.items[key], material); err == nil {
+		textureKey := ""
+		if material.PBRMetallicRoughness != nil {
+			color = [3]float64{1, 1, 1}
+			if factor := material.PBRMetallicRoughness.BaseColorFactor; len(factor) >= 3 {
+				color = [3]float64{factor[0], factor[1], factor[2]}
+			}
+			if material.PBRMetallicRoughness.BaseColorTexture != nil {
+				textureKey = string(material.PBRMetallicRoughness.BaseColorTexture.Index)
+			}
+		} else if len(material.Values.Diffuse) > 0 {
+			var diffuse []float64
+			if json.Unmarshal(material.Values.Diffuse, &diffuse) == nil && len(diffuse) >= 3 {
+				color = [3]float64{diffuse[0], diffuse[1], diffuse[2]}
+			} else if json.Unmarshal(material.Values.Diffuse, &textureKey) == nil {
+				color = [3]float64{1, 1, 1}
+			}
+		}
+		if textureKey != "" {
+			if imageData, err := loader.textureImage(textureKey); err == nil {
+				color = multiplyColor(color, averageImageColor(imageData))
+			}
+		}
+	}
+	loader.colors[key] = color
+	return color
+}
+
+/*
+textureImage returns the encoded image data of a texture, from a file, a data URI, or a buffer view
+*/
+func (loader *gltfMeshLoader) textureImage(textureKey string) ([]byte, error) {
+	texture := struct {
+		Source gltfRef `json:"source"`
+	}{}
+	err := json.Unmarshal(loader.scene.Textures.items[textureKey], &texture)
+	if err != nil {
+		return nil, err
+	}
+	external := new(gltfExternal)
+	err = json.Unmarshal(loader.document.Images.items[string(texture.Source)], external)
+	if err != nil {
+		return nil, err
+	}
+	viewRef := external.BufferView
+	if external.Extensions.BinaryGLTF != nil {
+		viewRef = external.Extensions.BinaryGLTF.BufferView
+	}
+	if viewRef != nil {
+		return loader.readBufferView(string(*viewRef))
+	}
+	if external.URI == nil {
+		return nil, errors.New("image has no uri")
+	}
+	return loader.readURI(*external.URI)
+}
+
+func (loader *gltfMeshLoader) readURI(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		return decodeDataURI(uri)
+	}
+	if loader.load == nil {
+		return nil, fmt.Errorf("%q is not available", uri)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	return loader.load(parsed.Path)
+}
+
+func (loader *gltfMeshLoader) readBuffer(key string) ([]byte, error) {
+	if data, ok := loader.buffers[key]; ok {
+		return data, nil
+	}
+	buffer := new(gltfBuffer)
+	err := json.Unmarshal(loader.document.Buffers.items[key], buffer)
+	if err != nil {
+		return nil, fmt.Errorf("buffers[%s]: %s", key, err.Error())
+	}
+	var data []byte
+	if loader.binary && ((loader.version1 && key == binaryGLTFBufferId) || (!loader.version1 && key == "0" && buffer.URI == nil)) {
+		data = loader.binData
+	} else if buffer.URI != nil {
+		data, err = loader.readURI(*buffer.URI)
+		if err != nil {
+			return nil, fmt.Errorf("buffers[%s]: %s", key, err.Error())
+		}
+	}
+	loader.buffers[key] = data
+	return data, nil
+}
+
+func (loader *gltfMeshLoader) bufferView(key string) (*gltfBufferView, []byte, error) {
+	view := new(gltfBufferView)
+	err := json.Unmarshal(loader.document.BufferViews.items[key], view)
+	if err != nil || view.Buffer == nil {
+		return nil, nil, fmt.Errorf("bufferViews[%s] is invalid", key)
+	}
+	buffer, err := loader.readBuffer(string(*view.Buffer))
+	if err != nil {
+		return nil, nil, err
+	}
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > int64(len(buffer)) {
+		return nil, nil, fmt.Errorf("bufferViews[%s] is outside of its buffer", key)
+	}
+	return view, buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], nil
+}
+
+func (loader *gltfMeshLoader) readBufferView(key string) ([]byte, error) {
+	_, data, err := loader.bufferView(key)
+	return data, err
+}
+
+/*
+readAccessor returns the elements of an accessor as float64 components
+*/
+func (loader *gltfMeshLoader) readAccessor(key string) ([][]float64, error) {
+	accessor := new(gltfAccessor)
+	err := json.Unmarshal(loader.document.Accessors.items[key], accessor)
+	if err != nil {
+		return nil, fmt.Errorf("accessors[%s]: %s", key, err.Error())
+	}
+	componentSize := componentTypeSizes[accessor.ComponentType]
+	components := accessorTypeComponents[accessor.Type]
+	if componentSize == 0 || components == 0 || accessor.Count < 0 || accessor.ByteOffset < 0 {
+		return nil, fmt.Errorf("accessors[%s] is invalid", key)
+	}
+	if accessor.BufferView == nil {
+		if accessor.Count > maxUnbackedAccessorCount {
+			return nil, fmt.Errorf("accessors[%s] has too many elements without a bufferView", key)
+		}
+		elements := make([][]float64, accessor.Count)
+		for index := range elements {
+			elements[index] = make([]float64, components)
+		}
+		return elements, nil
+	}
+	view, data, err := loader.bufferView(string(*accessor.BufferView))
+	if err != nil {
+		return nil, err
+	}
+	stride := accessor.ByteStride
+	if loader.version1 == false {
+		stride = view.ByteStride
+	}
+	if stride == 0 {
+		stride = componentSize * components
+	}
+	if stride < 0 {
+		return nil, fmt.Errorf("accessors[%s] is invalid", key)
+	}
+	// Check the count against the data before allocating, since it comes from the upload
+	if accessor.Count > 0 {
+		available := int64(len(data)) - accessor.ByteOffset - componentSize*components
+		if available < 0 || accessor.Count-1 > available/stride {
+			return nil, fmt.Errorf("accessors[%s] runs past the end of its bufferView", key)
+		}
+	}
+	elements := make([][]float64, accessor.Count)
+	for index := range elements {
+		elements[index] = make([]float64, components)
+		for component := int64(0); component < components; component++ {
+			offset := accessor.ByteOffset + stride*int64(index) + componentSize*component
+			if offset+componentSize > int64(len(data)) {
+				return nil, fmt.Errorf("accessors[%s] runs past the end of its bufferView", key)
+			}
+			elements[index][component] = readComponent(data[offset:offset+componentSize], accessor.ComponentType)
+		}
+	}
+	return elements, nil
+}
+
+func readComponent(data []byte, componentType int) float64 {
+	switch componentType {
+	case 5120:
+		return float64(int8(data[0]))
+	case 5121:
+		return float64(data[0])
+	case 5122:
+		return float64(int16(binary.LittleEndian.Uint16(data)))
+	case 5123:
+		return float64(binary.LittleEndian.Uint16(data))
+	case 5125:
+		return float64(binary.LittleEndian.Uint32(data))
+	case 5126:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	}
+	return 0
+}
+
+/*
+decodeDataURI returns the data of a data: URI like data:application/octet-stream;base64,AAAA
+*/
+func decodeDataURI(uri string) ([]byte, error) {
+	commaIndex := strings.Index(uri, ",")
+	if commaIndex == -1 {
+		return nil, errors.New("data uri has no comma")
+	}
+	header := uri[len("data:"):commaIndex]
+	payload := uri[commaIndex+1:]
+	if strings.HasSuffix(header, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, errors.New("data uri has invalid base64: " + err.Error())
+		}
+		return decoded, nil
+	}
+	unescaped, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, errors.New("data uri has invalid escapes: " + err.Error())
+	}
+	return []byte(unescaped), nil
+}
@@ -0,0 +1,109 @@
+package geometry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestLoadOBJMesh(t *testing.T) {
+	files := map[string]string{
+		"Two Colors.mtl": twoColorsMTL,
+	}
+	mesh, err := LoadMesh("quad.obj", []byte(quadOBJ), loaderFor(files))
+	AssertNil(t, err)
+	AssertEqual(t, 3, len(mesh.Triangles)) // The quad is split into two triangles
+	AssertEqual(t, [3]float64{1, 0, 0}, mesh.Triangles[0].Color)
+	AssertEqual(t, [3]float64{1, 0, 0}, mesh.Triangles[1].Color)
+	AssertEqual(t, [3]float64{0, 0, 1}, mesh.Triangles[2].Color)
+	AssertEqual(t, [3]float64{-1, 0.5, 1}, mesh.Triangles[2].Vertices[0])
+	min, max := mesh.Bounds()
+	AssertEqual(t, []float64{-1, 0, -1}, min)
+	AssertEqual(t, []float64{1, 0.5, 1}, max)
+
+	// Materials are the texture's average color when there is no Kd
+	texture := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			texture.Set(x, y, color.RGBA{0, 255, 0, 255})
+		}
+	}
+	textureData := bytes.NewBuffer(nil)
+	AssertNil(t, png.Encode(textureData, texture))
+	files = map[string]string{
+		"green.mtl": "newmtl Green\nmap_Kd green.png\n",
+		"green.png": textureData.String(),
+	}
+	mesh, err = LoadMesh("green.obj", []byte("mtllib green.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nusemtl Green\nf 1 2 3\n"), loaderFor(files))
+	AssertNil(t, err)
+	AssertEqual(t, [3]float64{0, 1, 0}, mesh.Triangles[0].Color)
+
+	// Missing MTL files leave the default color
+	mesh, err = LoadMesh("quad.obj", []byte(quadOBJ), loaderFor(map[string]string{}))
+	AssertNil(t, err)
+	AssertEqual(t, DefaultColor, mesh.Triangles[0].Color)
+}
+
+func TestLoadGLTFMesh(t *testing.T) {
+	positions := []float32{0, 0, 0, 1, 0, 0, 0, 1, 0}
+	buffer := bytes.NewBuffer(nil)
+	AssertNil(t, binary.Write(buffer, binary.LittleEndian, positions))
+	dataURI := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+	gltf := `{
+		"asset": {"version": "2.0"},
+		"scene": 0,
+		"scenes": [{"nodes": [0]}],
+		"nodes": [
+			{"translation": [10, 0, 0], "children": [1]},
+			{"scale": [2, 2, 2], "mesh": 0}
+		],
+		"buffers": [{"uri": "` + dataURI + `", "byteLength": 36}],
+		"bufferViews": [{"buffer": 0, "byteLength": 36}],
+		"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+		"materials": [{"pbrMetallicRoughness": {"baseColorFactor": [0.5, 0.25, 1, 1]}}],
+		"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "material": 0}]}]
+	}`
+	mesh, err := LoadMesh("triangle.gltf", []byte(gltf), nil)
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(mesh.Triangles))
+	AssertEqual(t, [3]float64{0.5, 0.25, 1}, mesh.Triangles[0].Color)
+	AssertEqual(t, [3]float64{10, 0, 0}, mesh.Triangles[0].Vertices[0])
+	AssertEqual(t, [3]float64{12, 0, 0}, mesh.Triangles[0].Vertices[1])
+	AssertEqual(t, [3]float64{10, 2, 0}, mesh.Triangles[0].Vertices[2])
+
+	// Meshes are drawn untransformed when there are no nodes, and buffers are read with the loader
+	gltf = `{
+		"asset": {"version": "2.0"},
+		"buffers": [{"uri": "triangle.bin", "byteLength": 36}],
+		"bufferViews": [{"buffer": 0, "byteLength": 36}],
+		"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+		"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "mode": 6}]}]
+	}`
+	mesh, err = LoadMesh("triangle.gltf", []byte(gltf), loaderFor(map[string]string{"triangle.bin": buffer.String()}))
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(mesh.Triangles))
+	AssertEqual(t, DefaultColor, mesh.Triangles[0].Color)
+	AssertEqual(t, [3]float64{0, 1, 0}, mesh.Triangles[0].Vertices[2])
+
+	_, err = LoadMesh("triangle.gltf", []byte(gltf), loaderFor(map[string]string{}))
+	AssertNotNil(t, err)
+
+	// Offsets and counts from the upload are checked before they are used
+	files := loaderFor(map[string]string{"triangle.bin": buffer.String()})
+	for _, accessor := range []string{
+		`{"bufferView": 0, "byteOffset": -8, "componentType": 5126, "count": 3, "type": "VEC3"}`,
+		`{"bufferView": 0, "componentType": 5126, "count": 100000000000, "type": "VEC3"}`,
+		`{"componentType": 5126, "count": 100000000000, "type": "VEC3"}`,
+	} {
+		bad := strings.Replace(gltf, `{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}`, accessor, 1)
+		_, err = LoadMesh("triangle.gltf", []byte(bad), files)
+		AssertNotNil(t, err, accessor)
+	}
+}
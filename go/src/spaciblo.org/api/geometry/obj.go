@@ -0,0 +1,292 @@
+package geometry
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+Loader returns the contents of a file referenced by name from another file, like an MTL file named by an OBJ file's mtllib statement
+*/
+type Loader func(name string) ([]byte, error)
+
+/*
+IsOBJ returns true if the file name has a .obj extension
+*/
+func IsOBJ(name string) bool {
+	return strings.ToLower(path.Ext(name)) == ".obj"
+}
+
+/*
+IsMTL returns true if the file name has a .mtl extension
+*/
+func IsMTL(name string) bool {
+	return strings.ToLower(path.Ext(name)) == ".mtl"
+}
+
+/*
+ValidateOBJ parses a Wavefront OBJ file and the MTL files it uses, checks that the MTL and texture files exist, and returns its stats
+Problems with the file are returned as a *ValidationError
+*/
+func ValidateOBJ(data []byte, resolve Resolver, load Loader) (*Stats, error) {
+	validationError := &ValidationError{}
+	stats := &Stats{}
+	min := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	max := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	var textureCoordinateCount int64
+	var normalCount int64
+	mtlNames := []string{}
+	usedMaterials := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		keyword, rest := splitStatement(scanner.Text())
+		switch keyword {
+		case "v":
+			fields := strings.Fields(rest)
+			if len(fields) < 3 {
+				validationError.add("line %d: a vertex needs at least 3 coordinates", lineNumber)
+				continue
+			}
+			for axis := 0; axis < 3; axis++ {
+				value, err := strconv.ParseFloat(fields[axis], 64)
+				if err != nil {
+					validationError.add("line %d: invalid vertex coordinate %q", lineNumber, fields[axis])
+					break
+				}
+				min[axis] = math.Min(min[axis], value)
+				max[axis] = math.Max(max[axis], value)
+			}
+			stats.VertexCount++
+		case "vt":
+			textureCoordinateCount++
+		case "vn":
+			normalCount++
+		case "f":
+			fields := strings.Fields(rest)
+			if len(fields) < 3 {
+				validationError.add("line %d: a face needs at least 3 vertices", lineNumber)
+				continue
+			}
+			for _, field := range fields {
+				indices := strings.Split(field, "/")
+				counts := []int64{stats.VertexCount, textureCoordinateCount, normalCount}
+				names := []string{"vertex", "texture coordinate", "normal"}
+				for position, index := range indices {
+					if position > 2 || (index == "" && position > 0) {
+						continue
+					}
+					err := checkOBJIndex(index, counts[position])
+					if err != "" {
+						validationError.add("line %d: face %s %s", lineNumber, names[position], err)
+					}
+				}
+			}
+			stats.FaceCount++
+			stats.TriangleCount += int64(len(fields) - 2)
+		case "mtllib":
+			mtlNames = append(mtlNames, splitFileNames(rest, resolve)...)
+		case "usemtl":
+			if rest != "" && containsString(usedMaterials, rest) == false {
+				usedMaterials = append(usedMaterials, rest)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		validationError.add("could not read the OBJ: %s", err.Error())
+		return nil, validationError
+	}
+	if stats.VertexCount > 0 {
+		stats.BoundsMin = min
+		stats.BoundsMax = max
+	}
+
+	materials := []string{}
+	mtlProblems := len(validationError.Problems)
+	for _, mtlName := range mtlNames {
+		if load == nil {
+			validationError.add("mtllib %q is not available", mtlName)
+			continue
+		}
+		mtlData, err := load(mtlName)
+		if err != nil {
+			validationError.add("mtllib %q is not in the template data", mtlName)
+			continue
+		}
+		mtlStats, err := ValidateMTL(mtlData, resolve)
+		if err != nil {
+			if mtlError, ok := err.(*ValidationError); ok {
+				for _, problem := range mtlError.Problems {
+					validationError.add("%s: %s", mtlName, problem)
+				}
+			} else {
+				validationError.add("%s: %s", mtlName, err.Error())
+			}
+			continue
+		}
+		materials = append(materials, mtlStats.Materials...)
+		for _, texture := range mtlStats.Textures {
+			if containsString(stats.Textures, texture) == false {
+				stats.Textures = append(stats.Textures, texture)
+			}
+		}
+	}
+	// Only check material names if every MTL file could be read
+	if len(mtlNames) > 0 && len(validationError.Problems) == mtlProblems {
+		for _, material := range usedMaterials {
+			if containsString(materials, material) == false {
+				validationError.add("usemtl %q is not defined by the mtllib files", material)
+			}
+		}
+	}
+	stats.Materials = materials
+	stats.MaterialCount = int64(len(materials))
+
+	if len(validationError.Problems) > 0 {
+		return nil, validationError
+	}
+	return stats, nil
+}
+
+/*
+ValidateMTL parses a Wavefront MTL file, checks that the texture files exist, and returns its material count and textures
+*/
+func ValidateMTL(data []byte, resolve Resolver) (*Stats, error) {
+	validationError := &ValidationError{}
+	stats := &Stats{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		keyword, rest := splitStatement(scanner.Text())
+		switch strings.ToLower(keyword) {
+		case "newmtl":
+			if rest == "" {
+				validationError.add("line %d: newmtl has no name", lineNumber)
+				continue
+			}
+			stats.Materials = append(stats.Materials, rest)
+		case "map_ka", "map_kd", "map_ks", "map_ke", "map_ns", "map_d", "map_bump", "bump", "disp", "decal", "refl", "norm":
+			texture := textureFileName(rest)
+			if texture == "" {
+				validationError.add("line %d: %s has no file name", lineNumber, keyword)
+				continue
+			}
+			if resolve == nil {
+				validationError.add("line %d: %q is not available", lineNumber, texture)
+				continue
+			}
+			if _, err := resolve(texture); err != nil {
+				validationError.add("line %d: %q is not in the template data", lineNumber, texture)
+				continue
+			}
+			if containsString(stats.Textures, texture) == false {
+				stats.Textures = append(stats.Textures, texture)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		validationError.add("could not read the MTL: %s", err.Error())
+	}
+	if len(validationError.Problems) > 0 {
+		return nil, validationError
+	}
+	stats.MaterialCount = int64(len(stats.Materials))
+	return stats, nil
+}
+
+/*
+splitStatement returns the keyword and the trimmed remainder of an OBJ or MTL line, ignoring comments
+*/
+func splitStatement(line string) (string, string) {
+	if commentIndex := strings.Index(line, "#"); commentIndex != -1 {
+		line = line[:commentIndex]
+	}
+	line = strings.TrimSpace(line)
+	spaceIndex := strings.IndexAny(line, " \t")
+	if spaceIndex == -1 {
+		return line, ""
+	}
+	return line[:spaceIndex], strings.TrimSpace(line[spaceIndex+1:])
+}
+
+/*
+splitFileNames splits an mtllib statement into file names
+The format separates names with spaces, but exporters often write a single name that contains spaces, so the whole statement is used if it resolves
+*/
+func splitFileNames(rest string, resolve Resolver) []string {
+	if rest == "" {
+		return []string{}
+	}
+	if resolve != nil {
+		if _, err := resolve(rest); err == nil {
+			return []string{rest}
+		}
+		fields := strings.Fields(rest)
+		for _, field := range fields {
+			if _, err := resolve(field); err != nil {
+				return []string{rest} // Report the whole statement as missing
+			}
+		}
+		return fields
+	}
+	return []string{rest}
+}
+
+/*
+textureFileName returns the file name of a texture map statement like "-s 1 1 1 -clamp on wood.jpg"
+*/
+func textureFileName(rest string) string {
+	fields := strings.Fields(rest)
+	index := 0
+	for index < len(fields) && strings.HasPrefix(fields[index], "-") {
+		option := fields[index]
+		index++
+		if option == "-imfchan" || option == "-type" {
+			index++
+			continue
+		}
+		// Skip the option's numeric or on/off arguments
+		for index < len(fields) {
+			if _, err := strconv.ParseFloat(fields[index], 64); err == nil || fields[index] == "on" || fields[index] == "off" {
+				index++
+				continue
+			}
+			break
+		}
+	}
+	if index >= len(fields) {
+		return ""
+	}
+	return strings.Join(fields[index:], " ")
+}
+
+/*
+checkOBJIndex returns a description of the problem with a 1 based or negative relative OBJ index, or "" if it is valid
+*/
+func checkOBJIndex(index string, count int64) string {
+	value, err := strconv.ParseInt(index, 10, 64)
+	if err != nil {
+		return "has an invalid index " + strconv.Quote(index)
+	}
+	if value == 0 || value > count || -value > count {
+		return "index " + index + " is out of range, there are " + strconv.FormatInt(count, 10)
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
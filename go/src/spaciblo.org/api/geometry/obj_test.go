@@ -0,0 +1,103 @@
+package geometry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+const quadOBJ = `# A quad and a triangle
+mtllib Two Colors.mtl
+o Quad
+v -1 0 -1
+v 1 0 -1
+v 1 0 1
+v -1 0.5 1
+vt 0 0
+vn 0 1 0
+usemtl Red
+f 1/1/1 2/1/1 3/1/1 4/1/1
+usemtl Blue
+f -1 -2 -3
+`
+
+const twoColorsMTL = `newmtl Red
+Kd 1 0 0
+map_Kd -s 1 1 1 -clamp on red wood.jpg
+
+newmtl Blue
+Kd 0 0 1
+bump blue.png
+`
+
+func loaderFor(files map[string]string) Loader {
+	return func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if ok == false {
+			return nil, errors.New("no such file")
+		}
+		return []byte(data), nil
+	}
+}
+
+func TestValidateOBJ(t *testing.T) {
+	resolver := mapResolver(map[string]int64{"Two Colors.mtl": int64(len(twoColorsMTL)), "red wood.jpg": 10, "blue.png": 10})
+	loader := loaderFor(map[string]string{"Two Colors.mtl": twoColorsMTL})
+	stats, err := ValidateOBJ([]byte(quadOBJ), resolver, loader)
+	AssertNil(t, err)
+	AssertEqual(t, int64(4), stats.VertexCount)
+	AssertEqual(t, int64(2), stats.FaceCount)
+	AssertEqual(t, int64(3), stats.TriangleCount)
+	AssertEqual(t, []float64{-1, 0, -1}, stats.BoundsMin)
+	AssertEqual(t, []float64{1, 0.5, 1}, stats.BoundsMax)
+	AssertEqual(t, int64(2), stats.MaterialCount)
+	AssertEqual(t, []string{"Red", "Blue"}, stats.Materials)
+	AssertEqual(t, []string{"red wood.jpg", "blue.png"}, stats.Textures)
+
+	// Missing MTL
+	_, err = ValidateOBJ([]byte(quadOBJ), mapResolver(map[string]int64{}), loaderFor(map[string]string{}))
+	AssertNotNil(t, err)
+	AssertEqual(t, `mtllib "Two Colors.mtl" is not in the template data`, err.Error())
+
+	// Missing texture
+	_, err = ValidateOBJ([]byte(quadOBJ), mapResolver(map[string]int64{"Two Colors.mtl": 10, "blue.png": 10}), loader)
+	AssertNotNil(t, err)
+	AssertEqual(t, `Two Colors.mtl: line 3: "red wood.jpg" is not in the template data`, err.Error())
+
+	// Bad indices and undefined materials
+	broken := strings.Replace(quadOBJ, "f -1 -2 -3", "f 1 2 9", 1)
+	broken = strings.Replace(broken, "usemtl Blue", "usemtl Green", 1)
+	_, err = ValidateOBJ([]byte(broken), resolver, loader)
+	AssertNotNil(t, err)
+	validationError := err.(*ValidationError)
+	AssertEqual(t, 2, len(validationError.Problems), err.Error())
+	AssertEqual(t, "line 13: face vertex index 9 is out of range, there are 4", validationError.Problems[0])
+	AssertEqual(t, `usemtl "Green" is not defined by the mtllib files`, validationError.Problems[1])
+
+	// Geometry without materials
+	stats, err = ValidateOBJ([]byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"), nil, nil)
+	AssertNil(t, err)
+	AssertEqual(t, int64(1), stats.TriangleCount)
+	AssertEqual(t, int64(0), stats.MaterialCount)
+}
+
+func TestTextureFileName(t *testing.T) {
+	AssertEqual(t, "wood.jpg", textureFileName("wood.jpg"))
+	AssertEqual(t, "red wood.jpg", textureFileName("-o 0.5 0.5 -blendu off red wood.jpg"))
+	AssertEqual(t, "bump.png", textureFileName("-imfchan l -bm 0.2 bump.png"))
+	AssertEqual(t, "", textureFileName("-clamp on"))
+}
+
+func TestValidate(t *testing.T) {
+	AssertTrue(t, CanValidate("Thing.obj"))
+	AssertTrue(t, CanValidate("Thing.mtl"))
+	AssertTrue(t, CanValidate("Thing.glb"))
+	AssertFalse(t, CanValidate("Thing.jpg"))
+	stats, err := Validate("Two Colors.mtl", []byte(twoColorsMTL), mapResolver(map[string]int64{"red wood.jpg": 10, "blue.png": 10}), nil)
+	AssertNil(t, err)
+	AssertEqual(t, int64(2), stats.MaterialCount)
+	_, err = Validate("Thing.jpg", []byte{}, nil, nil)
+	AssertNotNil(t, err)
+}
@@ -0,0 +1,253 @@
+/*
+Package api/render draws template geometry into thumbnail images on the CPU, with no GPU or browser.
+*/
+package render
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"spaciblo.org/api/geometry"
+)
+
+const (
+	// The camera looks at the geometry from the front right and above
+	CameraAzimuth     = 35.0 // Degrees around the y axis from +z toward +x
+	CameraElevation   = 25.0 // Degrees above the horizon
+	CameraFieldOfView = 35.0 // Vertical degrees
+
+	// Pixels are rendered at this multiple of the image size and averaged, to smooth edges
+	supersampling = 3
+
+	ambientLight = 0.35
+	keyLight     = 0.6
+	fillLight    = 0.15
+
+	// The fraction of the geometry's size to leave around it
+	margin = 0.1
+)
+
+/*
+BackgroundColor fills the pixels that no triangle covers
+*/
+var BackgroundColor = color.RGBA{238, 238, 238, 255}
+
+/*
+Render draws the mesh with flat shading and a directional light from a three-quarter camera that fits its bounds
+*/
+func Render(mesh *geometry.Mesh, width int, height int) (*image.RGBA, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("Bogus width or height")
+	}
+	min, max := mesh.Bounds()
+	if min == nil {
+		return nil, errors.New("The geometry has no triangles")
+	}
+	center := vector{(min[0] + max[0]) / 2, (min[1] + max[1]) / 2, (min[2] + max[2]) / 2}
+	radius := vector{max[0] - min[0], max[1] - min[1], max[2] - min[2]}.length() / 2
+	if radius == 0 || math.IsInf(radius, 0) || math.IsNaN(radius) {
+		return nil, errors.New("The geometry has no size")
+	}
+
+	azimuth := radians(CameraAzimuth)
+	elevation := radians(CameraElevation)
+	toCamera := vector{math.Cos(elevation) * math.Sin(azimuth), math.Sin(elevation), math.Cos(elevation) * math.Cos(azimuth)}
+	forward := toCamera.scale(-1)
+	right := forward.cross(vector{0, 1, 0}).normalize()
+	up := right.cross(forward)
+	light := toCamera.add(vector{0, 1, 0}).add(right.scale(-0.5)).normalize()
+
+	// Place the camera just far enough away that every vertex, plus a margin, is in the field of view
+	aspect := float64(width) / float64(height)
+	tanHalfFOV := math.Tan(radians(CameraFieldOfView) / 2)
+	distance := radius * 0.01
+	for _, triangle := range mesh.Triangles {
+		for _, vertex := range triangle.Vertices {
+			relative := vector{vertex[0], vertex[1], vertex[2]}.subtract(center)
+			towardCamera := relative.dot(toCamera)
+			horizontal := math.Abs(relative.dot(right)) * (1 + margin) / (tanHalfFOV * aspect)
+			vertical := math.Abs(relative.dot(up)) * (1 + margin) / tanHalfFOV
+			distance = math.Max(distance, towardCamera+math.Max(horizontal, vertical))
+		}
+	}
+	distance = math.Max(distance, radius*(1+margin)) // Keep the near side of the geometry in front of the camera
+	eye := center.add(toCamera.scale(distance))
+
+	canvas := newCanvas(width*supersampling, height*supersampling)
+	focal := 1 / tanHalfFOV
+	for _, triangle := range mesh.Triangles {
+		var world [3]vector
+		var screen [3]vector // x, y in pixels and z as 1/depth
+		visible := true
+		for index, vertex := range triangle.Vertices {
+			world[index] = vector{vertex[0], vertex[1], vertex[2]}
+			relative := world[index].subtract(eye)
+			depth := relative.dot(forward)
+			if depth <= distance*0.001 {
+				visible = false // Behind the camera, which only happens with bogus bounds
+				break
+			}
+			screen[index] = vector{
+				(1 + focal*relative.dot(right)/(depth*aspect)) / 2 * float64(canvas.width),
+				(1 - focal*relative.dot(up)/depth) / 2 * float64(canvas.height),
+				1 / depth,
+			}
+		}
+		if visible == false {
+			continue
+		}
+		normal := world[1].subtract(world[0]).cross(world[2].subtract(world[0])).normalize()
+		if normal.dot(eye.subtract(world[0])) < 0 {
+			normal = normal.scale(-1) // Light both sides of the triangle
+		}
+		shade := ambientLight + keyLight*math.Max(0, normal.dot(light)) + fillLight*math.Max(0, normal.dot(toCamera))
+		canvas.fillTriangle(screen, [3]float64{
+			triangle.Color[0] * shade,
+			triangle.Color[1] * shade,
+			triangle.Color[2] * shade,
+		})
+	}
+	return canvas.downsample(supersampling), nil
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+/*
+canvas is a color and depth buffer
+*/
+type canvas struct {
+	width  int
+	height int
+	colors [][3]float64
+	depths []float64 // 1/depth, so 0 is infinitely far away
+	filled []bool
+}
+
+func newCanvas(width int, height int) *canvas {
+	return &canvas{
+		width:  width,
+		height: height,
+		colors: make([][3]float64, width*height),
+		depths: make([]float64, width*height),
+		filled: make([]bool, width*height),
+	}
+}
+
+/*
+fillTriangle draws the pixels whose centers are inside the screen space triangle and nearer than what is already drawn
+*/
+func (canvas *canvas) fillTriangle(points [3]vector, fill [3]float64) {
+	area := edge(points[0], points[1], points[2])
+	if area == 0 || math.IsNaN(area) {
+		return
+	}
+	minX := int(math.Max(0, math.Floor(math.Min(points[0].x, math.Min(points[1].x, points[2].x)))))
+	maxX := int(math.Min(float64(canvas.width-1), math.Ceil(math.Max(points[0].x, math.Max(points[1].x, points[2].x)))))
+	minY := int(math.Max(0, math.Floor(math.Min(points[0].y, math.Min(points[1].y, points[2].y)))))
+	maxY := int(math.Min(float64(canvas.height-1), math.Ceil(math.Max(points[0].y, math.Max(points[1].y, points[2].y)))))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			pixel := vector{float64(x) + 0.5, float64(y) + 0.5, 0}
+			weight0 := edge(points[1], points[2], pixel) / area
+			weight1 := edge(points[2], points[0], pixel) / area
+			weight2 := edge(points[0], points[1], pixel) / area
+			if weight0 < 0 || weight1 < 0 || weight2 < 0 {
+				continue
+			}
+			// 1/depth is linear in screen space
+			inverseDepth := weight0*points[0].z + weight1*points[1].z + weight2*points[2].z
+			index := y*canvas.width + x
+			if canvas.filled[index] && inverseDepth <= canvas.depths[index] {
+				continue
+			}
+			canvas.filled[index] = true
+			canvas.depths[index] = inverseDepth
+			canvas.colors[index] = fill
+		}
+	}
+}
+
+/*
+downsample averages factor x factor blocks of pixels into an image, with the background behind unfilled pixels
+*/
+func (canvas *canvas) downsample(factor int) *image.RGBA {
+	background := [3]float64{float64(BackgroundColor.R) / 255, float64(BackgroundColor.G) / 255, float64(BackgroundColor.B) / 255}
+	result := image.NewRGBA(image.Rect(0, 0, canvas.width/factor, canvas.height/factor))
+	samples := float64(factor * factor)
+	for y := 0; y < canvas.height/factor; y++ {
+		for x := 0; x < canvas.width/factor; x++ {
+			var sum [3]float64
+			for sampleY := y * factor; sampleY < (y+1)*factor; sampleY++ {
+				for sampleX := x * factor; sampleX < (x+1)*factor; sampleX++ {
+					index := sampleY*canvas.width + sampleX
+					sample := background
+					if canvas.filled[index] {
+						sample = canvas.colors[index]
+					}
+					for channel := 0; channel < 3; channel++ {
+						sum[channel] += sample[channel]
+					}
+				}
+			}
+			result.SetRGBA(x, y, color.RGBA{toByte(sum[0] / samples), toByte(sum[1] / samples), toByte(sum[2] / samples), 255})
+		}
+	}
+	return result
+}
+
+func toByte(value float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Floor(value*255+0.5))))
+}
+
+/*
+edge returns twice the signed area of the triangle a, b, c in screen space
+*/
+func edge(a vector, b vector, c vector) float64 {
+	return (c.x-a.x)*(b.y-a.y) - (c.y-a.y)*(b.x-a.x)
+}
+
+type vector struct {
+	x float64
+	y float64
+	z float64
+}
+
+func (v vector) add(other vector) vector {
+	return vector{v.x + other.x, v.y + other.y, v.z + other.z}
+}
+
+func (v vector) subtract(other vector) vector {
+	return vector{v.x - other.x, v.y - other.y, v.z - other.z}
+}
+
+func (v vector) scale(factor float64) vector {
+	return vector{v.x * factor, v.y * factor, v.z * factor}
+}
+
+func (v vector) dot(other vector) float64 {
+	return v.x*other.x + v.y*other.y + v.z*other.z
+}
+
+func (v vector) cross(other vector) vector {
+	return vector{
+		v.y*other.z - v.z*other.y,
+		v.z*other.x - v.x*other.z,
+		v.x*other.y - v.y*other.x,
+	}
+}
+
+func (v vector) length() float64 {
+	return math.Sqrt(v.dot(v))
+}
+
+func (v vector) normalize() vector {
+	length := v.length()
+	if length == 0 {
+		return v
+	}
+	return v.scale(1 / length)
+}
@@ -0,0 +1,49 @@
+package render
+
+import (
+	"testing"
+
+	"spaciblo.org/api/geometry"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestRender(t *testing.T) {
+	red := [3]float64{1, 0, 0}
+	// A unit cube, with two triangles per side
+	corners := [8][3]float64{
+		{-1, -1, -1}, {1, -1, -1}, {1, 1, -1}, {-1, 1, -1},
+		{-1, -1, 1}, {1, -1, 1}, {1, 1, 1}, {-1, 1, 1},
+	}
+	sides := [6][4]int{
+		{0, 1, 2, 3}, {4, 5, 6, 7}, {0, 1, 5, 4},
+		{2, 3, 7, 6}, {0, 3, 7, 4}, {1, 2, 6, 5},
+	}
+	mesh := &geometry.Mesh{}
+	for _, side := range sides {
+		mesh.Triangles = append(mesh.Triangles,
+			geometry.Triangle{Vertices: [3][3]float64{corners[side[0]], corners[side[1]], corners[side[2]]}, Color: red},
+			geometry.Triangle{Vertices: [3][3]float64{corners[side[0]], corners[side[2]], corners[side[3]]}, Color: red},
+		)
+	}
+
+	rendered, err := Render(mesh, 64, 48)
+	AssertNil(t, err)
+	AssertEqual(t, 64, rendered.Bounds().Dx())
+	AssertEqual(t, 48, rendered.Bounds().Dy())
+	AssertEqual(t, BackgroundColor, rendered.RGBAAt(0, 0))
+	center := rendered.RGBAAt(32, 24)
+	AssertTrue(t, center.R > 0)
+	AssertEqual(t, uint8(0), center.G)
+	AssertEqual(t, uint8(0), center.B)
+
+	// The lit top of the cube is brighter than its sides
+	top := rendered.RGBAAt(32, 10)
+	side := rendered.RGBAAt(24, 32)
+	AssertTrue(t, top.R != side.R)
+
+	_, err = Render(&geometry.Mesh{}, 64, 64)
+	AssertNotNil(t, err)
+	_, err = Render(mesh, 0, 64)
+	AssertNotNil(t, err)
+}
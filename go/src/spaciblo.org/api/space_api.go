@@ -2,10 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
 	apiDB "spaciblo.org/api/db"
 	"spaciblo.org/be"
+	"spaciblo.org/sim"
 )
 
 var SpaceProperties = []be.Property{
@@ -20,6 +22,12 @@ var SpaceProperties = []be.Property{
 		Description: "name",
 		DataType:    "string",
 	},
+	be.Property{
+		Name:        "owner",
+		Description: "The UUID of the user who created the space",
+		DataType:    "string",
+		Protected:   true,
+	},
 }
 
 // THIS IS WHERE I STOPPED. MAKE THESE PORTABLE AND WRITE TESTS.
@@ -65,11 +73,9 @@ func (resource SpacesResource) Get(request *be.APIRequest) (int, interface{}, ht
 
 func (resource SpacesResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionSpacesCreate)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	var data apiDB.SpaceRecord
@@ -88,7 +94,7 @@ func (resource SpacesResource) Post(request *be.APIRequest) (int, interface{}, h
 		}, responseHeader
 	}
 
-	record, err := apiDB.CreateSpaceRecord(data.Name, "{}", avatarRecord.UUID, request.DBInfo)
+	record, err := apiDB.CreateSpaceRecord(data.Name, "{}", avatarRecord.UUID, request.User.UUID, request.DBInfo)
 	if err != nil {
 		logger.Println("Error creating a space record", err)
 		return 500, be.APIError{
@@ -97,6 +103,7 @@ func (resource SpacesResource) Post(request *be.APIRequest) (int, interface{}, h
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "space", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -134,11 +141,9 @@ func (resource SpaceResource) Get(request *be.APIRequest) (int, interface{}, htt
 
 func (resource SpaceResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionSpacesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -158,6 +163,7 @@ func (resource SpaceResource) Put(request *be.APIRequest) (int, interface{}, htt
 	}
 
 	// Only some attributes can be updated
+	before := *record
 	record.Name = updatedRecord.Name
 	err = apiDB.UpdateSpaceRecord(record, request.DBInfo)
 	if err != nil {
@@ -167,6 +173,144 @@ func (resource SpaceResource) Put(request *be.APIRequest) (int, interface{}, htt
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "space", record.UUID, before, record)
 	return 200, record, responseHeader
 }
+
+/*
+SpaceCloneData is the optional body of a POST to SpaceCloneResource
+*/
+type SpaceCloneData struct {
+	Name          string `json:"name"`          // The name of the new space, which defaults to the original name plus SpaceCloneSuffix
+	CopyTemplates bool   `json:"copyTemplates"` // If true, the new space refers to copies of the original's templates instead of sharing them
+}
+
+const SpaceCloneSuffix = " (copy)"
+
+type SpaceCloneResource struct {
+}
+
+func NewSpaceCloneResource() *SpaceCloneResource {
+	return &SpaceCloneResource{}
+}
+
+func (SpaceCloneResource) Name() string  { return "space-clone" }
+func (SpaceCloneResource) Path() string  { return "/space/{uuid:[0-9,a-z,-]+}/clone" }
+func (SpaceCloneResource) Title() string { return "Space Clone" }
+func (SpaceCloneResource) Description() string {
+	return "POST to create a copy of a space, including the unsaved state of its simulator if it is running."
+}
+
+func (resource SpaceCloneResource) Properties() []be.Property {
+	return SpaceProperties
+}
+
+func (resource SpaceCloneResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionSpacesCreate)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	uuid, _ := request.PathValues["uuid"]
+	record, err := apiDB.FindSpaceRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_space",
+			Message: "No such space: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	var data SpaceCloneData
+	err = json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil && err != io.EOF {
+		return 400, be.BadRequestError, responseHeader
+	}
+	if data.Name == "" {
+		data.Name = record.Name + SpaceCloneSuffix
+	}
+
+	// Ask a running simulator to save so that the clone includes changes made since its last save
+	if simHost != "" {
+		simClient := sim.NewSimRPCClient(simHost)
+		err = simClient.Connect()
+		if err == nil {
+			_, err = simClient.SaveSpace(record.UUID)
+			simClient.Close()
+		}
+		if err != nil {
+			logger.Println("Could not save the space's simulator, cloning the stored state", record.UUID, err)
+		}
+	}
+
+	state, err := record.LoadState(request.DBInfo)
+	if err != nil {
+		return 500, be.APIError{
+			Id:      "could_not_decode",
+			Message: "Could not decode: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	if data.CopyTemplates {
+		err = copySpaceStateTemplates(state, make(map[string]string), request.FS, request.DBInfo)
+		if err != nil {
+			return 500, be.APIError{
+				Id:      "could_not_copy_templates",
+				Message: "Could not copy templates: " + uuid,
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+
+	clonedRecord, err := apiDB.CreateSpaceRecord(data.Name, state.ToString(), record.Avatar, request.User.UUID, request.DBInfo)
+	if err != nil {
+		logger.Println("Error creating a space record", err)
+		return 500, be.APIError{
+			Id:      "db_error",
+			Message: "Database error",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	request.Audit("clone", "space", clonedRecord.UUID, record, clonedRecord)
+	return 200, clonedRecord, responseHeader
+}
+
+/*
+copySpaceStateTemplates replaces the templates referenced by the state nodes with copies
+copies maps original template UUIDs to the UUIDs of their copies so that each template is copied only once
+*/
+func copySpaceStateTemplates(stateNode *apiDB.SpaceStateNode, copies map[string]string, fileStorage be.FileStorage, dbInfo *be.DBInfo) error {
+	var templateRecord *apiDB.TemplateRecord
+	var err error
+	if stateNode.TemplateUUID != "" {
+		templateRecord, err = apiDB.FindTemplateRecord(stateNode.TemplateUUID, dbInfo)
+	} else if stateNode.TemplateName != "" {
+		templateRecord, err = apiDB.FindTemplateRecordByField("name", stateNode.TemplateName, dbInfo)
+	}
+	if err != nil {
+		return err
+	}
+	if templateRecord != nil {
+		copyUUID, ok := copies[templateRecord.UUID]
+		if ok == false {
+			copyRecord, err := apiDB.CopyTemplateRecord(templateRecord, fileStorage, dbInfo)
+			if err != nil {
+				return err
+			}
+			copyUUID = copyRecord.UUID
+			copies[templateRecord.UUID] = copyUUID
+		}
+		stateNode.TemplateUUID = copyUUID
+		stateNode.TemplateName = ""
+		// The copy starts with the current revision as its only revision
+		delete(stateNode.Settings, apiDB.TemplateRevisionSetting)
+	}
+	for _, childNode := range stateNode.Nodes {
+		err = copySpaceStateTemplates(childNode, copies, fileStorage, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
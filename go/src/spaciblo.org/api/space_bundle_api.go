@@ -0,0 +1,161 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/be"
+)
+
+type SpaceBundleResource struct {
+}
+
+func NewSpaceBundleResource() *SpaceBundleResource {
+	return &SpaceBundleResource{}
+}
+
+func (SpaceBundleResource) Name() string  { return "space-bundle" }
+func (SpaceBundleResource) Path() string  { return "/space/{uuid:[0-9,a-z,-]+}/bundle" }
+func (SpaceBundleResource) Title() string { return "Space Bundle" }
+func (SpaceBundleResource) Description() string {
+	return "A zip archive holding a space's state, templates, and default avatar that can be imported into another server using Space Bundles."
+}
+
+func (resource SpaceBundleResource) Properties() []be.Property {
+	return []be.Property{}
+}
+
+func (resource SpaceBundleResource) Get(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionSpacesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	uuid, _ := request.PathValues["uuid"]
+	record, err := apiDB.FindSpaceRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_space",
+			Message: "No such space: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	// Write the bundle to a temp file so that errors can be returned before the response starts
+	bundleFile, err := ioutil.TempFile("", "space-bundle")
+	if err != nil {
+		return 500, be.APIError{
+			Id:      be.InternalServerError.Id,
+			Message: "Could not create a temp file",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	defer func() {
+		bundleFile.Close()
+		os.Remove(bundleFile.Name())
+	}()
+	err = apiDB.WriteSpaceBundle(record, bundleFile, request.FS, request.DBInfo)
+	if err != nil {
+		return 500, be.APIError{
+			Id:      "could_not_bundle",
+			Message: "Could not bundle: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	size, err := bundleFile.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = bundleFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return 500, be.APIError{
+			Id:      be.InternalServerError.Id,
+			Message: "Could not read the bundle",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	request.Writer.Header().Set("Content-Type", apiDB.SpaceBundleMimeType)
+	request.Writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	request.Writer.Header().Set("Content-Disposition", "attachment; filename=\"space-"+record.UUID+".zip\"")
+	_, err = io.Copy(request.Writer, bundleFile)
+	if err != nil {
+		logger.Printf("Error serving a space bundle but too late to recover %v", err)
+	}
+	return be.StatusInternallyHandled, nil, nil
+}
+
+type SpaceBundlesResource struct {
+}
+
+func NewSpaceBundlesResource() *SpaceBundlesResource {
+	return &SpaceBundlesResource{}
+}
+
+func (SpaceBundlesResource) Name() string  { return "space-bundles" }
+func (SpaceBundlesResource) Path() string  { return "/space-bundle/" }
+func (SpaceBundlesResource) Title() string { return "Space Bundles" }
+func (SpaceBundlesResource) Description() string {
+	return "POST a Space Bundle zip archive in a `file` form field to create a new space. Templates and avatars that already exist are reused, and the files of new templates count against the storage quota."
+}
+
+func (resource SpaceBundlesResource) Properties() []be.Property {
+	return SpaceProperties
+}
+
+func (resource SpaceBundlesResource) PostForm(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionSpacesCreate)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	file, _, err := request.Raw.FormFile("file")
+	if err != nil {
+		return http.StatusBadRequest, be.APIError{
+			Id:      "bad_request",
+			Message: "A `file` field is required",
+		}, responseHeader
+	}
+	defer file.Close()
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return http.StatusBadRequest, be.APIError{
+			Id:      "bad_request",
+			Message: "Could not read the `file` field",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	// Count the bundled files against the quota as if each were uploaded, though reused templates store nothing
+	fileSizes, err := apiDB.SpaceBundleFileSizes(file, size)
+	if err != nil {
+		return http.StatusBadRequest, be.APIError{
+			Id:      "could_not_import",
+			Message: "Could not import the space bundle",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	status, apiError = request.CheckUploads(fileSizes)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	record, storedKeys, err := apiDB.ImportSpaceBundle(file, size, request.User.UUID, request.FS, request.DBInfo)
+	if err != nil {
+		return http.StatusBadRequest, be.APIError{
+			Id:      "could_not_import",
+			Message: "Could not import the space bundle",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	for _, key := range storedKeys {
+		request.RecordUpload(key)
+	}
+	request.Audit("import", "space", record.UUID, nil, record)
+	return 200, record, responseHeader
+}
@@ -50,7 +50,7 @@ func (resource SpaceStateResource) Get(request *be.APIRequest) (int, interface{}
 			Error:   err.Error(),
 		}, responseHeader
 	}
-	state, err := space.DecodeState()
+	state, err := space.LoadState(request.DBInfo)
 	if err != nil {
 		return 500, be.APIError{
 			Id:      "could_not_decode",
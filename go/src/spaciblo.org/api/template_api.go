@@ -28,6 +28,60 @@ var TemplateProperties = []be.Property{
 		Description: "image",
 		DataType:    "string",
 	},
+	be.Property{
+		Name:        "currentRevision",
+		Description: "The revision number that clients load, changed by publishing",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "draftRevision",
+		Description: "The unpublished revision number that receives data uploads, 0 if there is no draft",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "vertexCount",
+		Description: "The number of vertices in the current revision's geometry",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "faceCount",
+		Description: "The number of faces in the geometry, polygons in an OBJ and triangles in a glTF",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "triangleCount",
+		Description: "The number of triangles in the current revision's geometry",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "materialCount",
+		Description: "The number of materials defined for the geometry",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "boundsMin",
+		Description: "The x,y,z minimum of the geometry's axis-aligned bounding box, empty if unknown",
+		DataType:    "string",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "boundsMax",
+		Description: "The x,y,z maximum of the geometry's axis-aligned bounding box, empty if unknown",
+		DataType:    "string",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "textures",
+		Description: "Comma separated names of the texture files used by the geometry",
+		DataType:    "string",
+		Protected:   true,
+	},
 }
 
 var TemplatesProperties = be.NewAPIListProperties("template")
@@ -71,11 +125,9 @@ func (resource TemplatesResource) Get(request *be.APIRequest) (int, interface{},
 
 func (resource TemplatesResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	var data apiDB.TemplateRecord
@@ -91,6 +143,7 @@ func (resource TemplatesResource) Post(request *be.APIRequest) (int, interface{}
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditCreate, "template", record.UUID, nil, record)
 	return 200, record, responseHeader
 }
 
@@ -128,11 +181,9 @@ func (resource TemplateResource) Get(request *be.APIRequest) (int, interface{},
 
 func (resource TemplateResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff == false {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -152,6 +203,11 @@ func (resource TemplateResource) Put(request *be.APIRequest) (int, interface{},
 	}
 
 	// Only some attributes can be updated
+	before := *template
+	if template.Geometry != updatedTemplate.Geometry {
+		// The stats are unknown until the new geometry file is uploaded
+		template.SetGeometryStats(nil)
+	}
 	template.Name = updatedTemplate.Name
 	template.Geometry = updatedTemplate.Geometry
 	template.ClientScript = updatedTemplate.ClientScript
@@ -166,17 +222,19 @@ func (resource TemplateResource) Put(request *be.APIRequest) (int, interface{},
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
+	request.Audit(be.AuditUpdate, "template", template.UUID, before, template)
 	return 200, template, responseHeader
 }
 
 func (resource TemplateResource) Delete(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -198,6 +256,7 @@ func (resource TemplateResource) Delete(request *be.APIRequest) (int, interface{
 			Error:   err.Error(),
 		}, responseHeader
 	}
+	request.Audit(be.AuditDelete, "template", template.UUID, template, nil)
 	return 200, "{}", responseHeader
 }
 
@@ -227,11 +286,9 @@ func (resource TemplateImageResource) Properties() []be.Property { return Templa
 
 func (resource TemplateImageResource) Get(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -256,6 +313,12 @@ func (resource TemplateImageResource) Get(request *be.APIRequest) (int, interfac
 			Message: "Error reading template image: " + template.Image + ": " + err.Error(),
 		}, responseHeader
 	}
+	responseHeader["Etag"] = []string{be.FileETag(imageFile)}
+	responseHeader["Cache-Control"] = []string{be.CacheControlPrivate}
+	if request.NotModified(responseHeader["Etag"][0]) {
+		return 200, nil, responseHeader
+	}
+
 	err = request.ServeFile(imageFile, responseHeader)
 	if err != nil {
 		return 500, &be.APIError{
@@ -286,11 +349,9 @@ func (templateImagePost *TemplateImagePost) ImageData() string {
 func (resource TemplateImageResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	// Accepts a JSON encoded TemplateImagePost where Image is a base64 encoded image
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -346,6 +407,54 @@ func (resource TemplateImageResource) Put(request *be.APIRequest) (int, interfac
 			logger.Print("Could not delete old image: " + err.Error())
 		}
 	}
+	request.Audit(be.AuditUpdate, "template", template.UUID, map[string]string{"image": oldFileKey}, map[string]string{"image": fileKey})
 	templateImagePost.Image = fileKey
 	return 200, templateImagePost, responseHeader
 }
+
+/*
+TemplateRenderResource replaces a template's image with one rendered from its geometry
+*/
+type TemplateRenderResource struct{}
+
+func NewTemplateRenderResource() *TemplateRenderResource {
+	return &TemplateRenderResource{}
+}
+
+func (TemplateRenderResource) Name() string  { return "template-render" }
+func (TemplateRenderResource) Path() string  { return "/template/{uuid:[0-9,a-z,-]+}/render" }
+func (TemplateRenderResource) Title() string { return "Template render" }
+func (TemplateRenderResource) Description() string {
+	return "POST to re-render the template's image from its geometry, replacing any uploaded image."
+}
+func (resource TemplateRenderResource) Properties() []be.Property { return TemplateProperties }
+
+func (resource TemplateRenderResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	uuid, _ := request.PathValues["uuid"]
+	template, err := apiDB.FindTemplateRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_template",
+			Message: "No such template: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	oldImage := template.Image
+	err = apiDB.RenderTemplateImage(template, request.FS, request.DBInfo)
+	if err != nil {
+		return 400, be.APIError{
+			Id:      "could_not_render",
+			Message: "Could not render the template's geometry: " + template.Geometry,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	request.Audit("render", "template", template.UUID, map[string]string{"image": oldImage}, map[string]string{"image": template.Image})
+	return 200, template, responseHeader
+}
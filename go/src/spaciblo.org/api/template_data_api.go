@@ -2,10 +2,16 @@ package api
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/api/geometry"
 	"spaciblo.org/be"
 )
 
@@ -45,11 +51,9 @@ func (resource TemplateDataListResource) Properties() []be.Property {
 
 func (resource TemplateDataListResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -69,46 +73,30 @@ func (resource TemplateDataListResource) Post(request *be.APIRequest) (int, inte
 	}
 	var name = data.(map[string]interface{})["name"].(string)
 
-	fileKey, err := request.FS.Put(name, bytes.NewBufferString(""))
+	draft, apiError := findOrCreateDraftRevision(template, request)
+	if apiError != nil {
+		return http.StatusInternalServerError, apiError, responseHeader
+	}
+	fileKey, err := apiDB.StoreTemplateDataFile(name, bytes.NewBufferString(""), request.FS, request.DBInfo)
 	if err != nil {
 		return http.StatusInternalServerError, be.APIError{
 			Id:      "storage_error",
 			Message: "Could not store the file: " + err.Error(),
 		}, responseHeader
 	}
-
-	templateData, err := apiDB.FindTemplateDataRecord(template.Id, name, request.DBInfo)
-	if err == nil {
-		// TemplateDataRecord exists, update it
-		request.FS.Delete(templateData.Key, "")
-		templateData.Key = fileKey
-		err = apiDB.UpdateTemplateDataRecord(templateData, request.DBInfo)
-		if err != nil {
-			return http.StatusInternalServerError, be.APIError{
-				Id:      "update_error",
-				Message: "Could not update the record: " + err.Error(),
-			}, responseHeader
-		}
-	} else {
-		// TemplateDataRecord does not exist, create one
-		templateData, err = apiDB.CreateTemplateDataRecord(template.Id, name, fileKey, request.DBInfo)
-		if err != nil {
-			return http.StatusInternalServerError, be.APIError{
-				Id:      "creation_error",
-				Message: "Could not create the record: " + err.Error(),
-			}, responseHeader
-		}
+	templateData, status, apiError := storeDraftTemplateData(template, draft, name, fileKey, request)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
+	request.Audit(be.AuditCreate, "template-data", template.UUID, nil, templateData)
 	return 200, templateData, responseHeader
 }
 
 func (resource TemplateDataListResource) PostForm(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -128,36 +116,34 @@ func (resource TemplateDataListResource) PostForm(request *be.APIRequest) (int,
 			Message: "A `file` field is required",
 		}, responseHeader
 	}
-	fileKey, err := request.FS.Put(fileHeader.Filename, file)
+	status, apiError = request.CheckUpload(fileHeader.Size)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	// Validate before creating a draft so a rejected upload leaves the template unchanged
+	reader, stats, apiError := validateTemplateData(template, editableRevision(template), fileHeader.Filename, file, request)
+	if apiError != nil {
+		return http.StatusBadRequest, apiError, responseHeader
+	}
+	draft, apiError := findOrCreateDraftRevision(template, request)
+	if apiError != nil {
+		return http.StatusInternalServerError, apiError, responseHeader
+	}
+	fileKey, err := apiDB.StoreTemplateDataFile(fileHeader.Filename, reader, request.FS, request.DBInfo)
 	if err != nil {
 		return http.StatusInternalServerError, be.APIError{
 			Id:      "storage_error",
 			Message: "Could not store the file: " + err.Error(),
 		}, responseHeader
 	}
-
-	templateData, err := apiDB.FindTemplateDataRecord(template.Id, fileHeader.Filename, request.DBInfo)
-	if err == nil {
-		// TemplateDataRecord exists, update it
-		request.FS.Delete(templateData.Key, "")
-		templateData.Key = fileKey
-		err = apiDB.UpdateTemplateDataRecord(templateData, request.DBInfo)
-		if err != nil {
-			return http.StatusInternalServerError, be.APIError{
-				Id:      "update_error",
-				Message: "Could not update the record: " + err.Error(),
-			}, responseHeader
-		}
-	} else {
-		// TemplateDataRecord does not exist, create one
-		templateData, err = apiDB.CreateTemplateDataRecord(template.Id, fileHeader.Filename, fileKey, request.DBInfo)
-		if err != nil {
-			return http.StatusInternalServerError, be.APIError{
-				Id:      "creation_error",
-				Message: "Could not create the record: " + err.Error(),
-			}, responseHeader
-		}
+	request.RecordUpload(fileKey)
+	templateData, status, apiError := storeDraftTemplateData(template, draft, fileHeader.Filename, fileKey, request)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
+	recordGeometryStats(template, draft, templateData.Name, stats, request)
+	go generateTextureTiers(templateData, request.FS)
+	request.Audit(be.AuditCreate, "template-data", template.UUID, nil, templateData)
 	return 200, templateData, responseHeader
 }
 
@@ -175,7 +161,23 @@ func (resource TemplateDataListResource) Get(request *be.APIRequest) (int, inter
 		}, responseHeader
 	}
 
-	records, err := apiDB.FindTemplateDataRecords(template.Id, offset, limit, request.DBInfo)
+	// By default list the current revision, or ?revision=N for a specific revision
+	// ?revision=draft lists the revision that uploads change, which is the current revision until there is a draft
+	revision := template.CurrentRevision
+	if revisionParam := request.Raw.Form.Get("revision"); revisionParam == "draft" {
+		revision = editableRevision(template)
+	} else if revisionParam != "" {
+		revision, err = strconv.ParseInt(revisionParam, 10, 64)
+		if err != nil {
+			return 400, be.APIError{
+				Id:      "bad_request",
+				Message: "The revision parameter must be a number or draft",
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+
+	records, err := apiDB.FindTemplateRevisionDataRecords(template.Id, revision, offset, limit, request.DBInfo)
 	if err != nil {
 		return 500, be.APIError{
 			Id:      "db_error",
@@ -204,7 +206,7 @@ func (TemplateDataResource) Path() string {
 }
 func (TemplateDataResource) Title() string { return "TemplateData" }
 func (TemplateDataResource) Description() string {
-	return "The data blobs (gltf, textures, vertices, etc) required to load a 3D thing into a space."
+	return "The data blobs (gltf, textures, vertices, etc) required to load a 3D thing into a space. GET textures with ?derivative=texture-256 (or 512, 1024, 2048) for power of two sizes, or ?derivative=texture-placeholder for a tiny preview."
 }
 
 func (resource TemplateDataResource) Properties() []be.Property {
@@ -225,42 +227,20 @@ func (resource TemplateDataResource) Get(request *be.APIRequest) (int, interface
 		}, responseHeader
 	}
 
-	templateData, err := apiDB.FindTemplateDataRecord(template.Id, name, request.DBInfo)
-	if err != nil {
-		return 404, be.APIError{
-			Id:      "no_such_template_data",
-			Message: "No such template data: " + uuid + ", " + name,
-			Error:   err.Error(),
-		}, responseHeader
-	}
-
-	file, err := request.FS.Get(templateData.Key, "")
-	if err != nil {
-		return 500, be.APIError{
-			Id:      "no_such_template_data_file",
-			Message: "No such template data file: " + uuid + ", " + name + ", " + templateData.Key,
-			Error:   err.Error(),
-		}, responseHeader
-	}
-
-	responseHeader["Etag"] = etagForTemplateData(templateData, request.Version)
-	// Check whether the client's If-None-Match and the response header's ETag match
-	if responseHeader["Etag"][0] == request.Raw.Header.Get("If-None-Match") {
-		return 200, nil, responseHeader
-	}
-
-	err = request.ServeFile(file, responseHeader)
-	if err != nil {
-		return 500, be.APIError{
-			Id:      be.InternalServerError.Id,
-			Message: "Error serving template data: " + err.Error(),
-		}, responseHeader
-	}
-	return be.StatusInternallyHandled, nil, nil
+	// The current revision changes when a draft is published, so clients must revalidate
+	return serveTemplateData(template, template.CurrentRevision, name, be.CacheControlRevalidate, request)
 }
 
 func (resource TemplateDataResource) Put(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
 	uuid, _ := request.PathValues["uuid"]
 	name, _ := request.PathValues["name"]
 
@@ -273,7 +253,7 @@ func (resource TemplateDataResource) Put(request *be.APIRequest) (int, interface
 		}, responseHeader
 	}
 
-	templateData, err := apiDB.FindTemplateDataRecord(template.Id, name, request.DBInfo)
+	templateData, err := apiDB.FindTemplateRevisionDataRecord(template.Id, editableRevision(template), name, request.DBInfo)
 	if err != nil {
 		return 404, be.APIError{
 			Id:      "no_such_template_data",
@@ -281,34 +261,47 @@ func (resource TemplateDataResource) Put(request *be.APIRequest) (int, interface
 			Error:   err.Error(),
 		}, responseHeader
 	}
-	fileKey, err := request.FS.Put(templateData.Name, request.Raw.Body)
+	status, apiError = request.CheckUpload(request.Raw.ContentLength)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	// Validate before creating a draft so a rejected upload leaves the template unchanged
+	reader, stats, apiError := validateTemplateData(template, editableRevision(template), templateData.Name, request.Raw.Body, request)
+	if apiError != nil {
+		return http.StatusBadRequest, apiError, responseHeader
+	}
+	draft, apiError := findOrCreateDraftRevision(template, request)
+	if apiError != nil {
+		return http.StatusInternalServerError, apiError, responseHeader
+	}
+	fileKey, err := apiDB.StoreTemplateDataFile(templateData.Name, reader, request.FS, request.DBInfo)
 	if err != nil {
 		return http.StatusInternalServerError, be.APIError{
 			Id:      "storage_error",
 			Message: "Could not store the file: " + err.Error(),
 		}, responseHeader
 	}
-
-	request.FS.Delete(templateData.Key, "")
-	templateData.Key = fileKey
-	err = apiDB.UpdateTemplateDataRecord(templateData, request.DBInfo)
-	if err != nil {
-		return 500, be.APIError{
-			Id:      be.InternalServerError.Id,
-			Message: "Error updating template data: " + err.Error(),
-		}, responseHeader
+	request.RecordUpload(fileKey)
+	before := *templateData
+	templateData, status, apiError = storeDraftTemplateData(template, draft, templateData.Name, fileKey, request)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
-
+	recordGeometryStats(template, draft, templateData.Name, stats, request)
+	go generateTextureTiers(templateData, request.FS)
+	request.Audit(be.AuditUpdate, "template-data", template.UUID, before, templateData)
 	return 200, "", responseHeader
 }
 
 func (resource TemplateDataResource) Delete(request *be.APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, be.NotLoggedInError, responseHeader
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
-	if request.User.Staff != true {
-		return 401, be.StaffOnlyError, responseHeader
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -323,29 +316,225 @@ func (resource TemplateDataResource) Delete(request *be.APIRequest) (int, interf
 		}, responseHeader
 	}
 
-	templateData, err := apiDB.FindTemplateDataRecord(template.Id, name, request.DBInfo)
-	if err != nil {
+	draft, apiError := findOrCreateDraftRevision(template, request)
+	if apiError != nil {
+		return http.StatusInternalServerError, apiError, responseHeader
+	}
+	templateData, err := apiDB.DeleteDraftTemplateData(template.Id, draft.Number, name, request.DBInfo)
+	if err == sql.ErrNoRows {
 		return 404, be.APIError{
 			Id:      "no_such_template_data",
 			Message: "No such template data: " + uuid + ", " + name,
 			Error:   err.Error(),
 		}, responseHeader
 	}
-
-	err = apiDB.DeleteTemplateDataRecord(templateData.Id, request.DBInfo)
+	if err == apiDB.ErrRevisionPublished {
+		return http.StatusConflict, be.RevisionPublishedError, responseHeader
+	}
 	if err != nil {
 		return http.StatusInternalServerError, be.APIError{
 			Id:      "deletion_error",
 			Message: "Could not delete the record: " + err.Error(),
 		}, responseHeader
 	}
-	err = request.FS.Delete(templateData.Key, "")
+	err = apiDB.DeleteTemplateDataFileIfUnused(templateData.Key, request.FS, request.DBInfo)
 	if err != nil {
 		logger.Println("Ignored error deleting template data file: " + templateData.Key + ": " + err.Error())
 	}
+	request.Audit(be.AuditDelete, "template-data", template.UUID, templateData, nil)
 	return 200, "{}", responseHeader
 }
 
-func etagForTemplateData(templateData *apiDB.TemplateDataRecord, version string) []string {
-	return []string{"template-data-" + version + "-" + templateData.Key}
+/*
+validateTemplateData checks glTF, glb, OBJ, and MTL data against the template's other data before it is stored
+References are resolved in revision, the editable revision whose data the upload's draft starts from.
+Returns a reader for the data to store and its stats (nil if it is not geometry), or an APIError if the data is invalid
+*/
+func validateTemplateData(template *apiDB.TemplateRecord, revision int64, name string, reader io.Reader, request *be.APIRequest) (io.Reader, *geometry.Stats, *be.APIError) {
+	if geometry.CanValidate(name) == false {
+		return reader, nil, nil
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, nil, &be.APIError{
+			Id:      "bad_request",
+			Message: "Could not read the data: " + name,
+			Error:   err.Error(),
+		}
+	}
+	resolve := func(uri string) (int64, error) {
+		dataRecord, err := apiDB.FindTemplateRevisionDataRecord(template.Id, revision, uri, request.DBInfo)
+		if err != nil {
+			return 0, err
+		}
+		file, err := request.FS.Get(dataRecord.Key, "")
+		if err != nil {
+			return 0, err
+		}
+		return file.Size()
+	}
+	load := func(dataName string) ([]byte, error) {
+		return apiDB.ReadTemplateData(template.Id, revision, dataName, request.FS, request.DBInfo)
+	}
+	stats, err := geometry.Validate(name, data, resolve, load)
+	if err != nil {
+		if geometry.IsGLTF(name) {
+			return nil, nil, &be.APIError{
+				Id:      "invalid_gltf",
+				Message: "Invalid glTF, upload the buffers and images it references before the glTF: " + name,
+				Error:   err.Error(),
+			}
+		}
+		return nil, nil, &be.APIError{
+			Id:      "invalid_obj",
+			Message: "Invalid OBJ or MTL, upload the textures and MTL files it references before it: " + name,
+			Error:   err.Error(),
+		}
+	}
+	return bytes.NewReader(data), stats, nil
+}
+
+/*
+recordGeometryStats sets the draft revision's geometry stats if the stored data is the template's geometry
+The template's stats and image are updated when the draft is published.
+*/
+func recordGeometryStats(template *apiDB.TemplateRecord, draft *apiDB.TemplateRevisionRecord, name string, stats *geometry.Stats, request *be.APIRequest) {
+	if stats == nil || name != template.Geometry {
+		return
+	}
+	err := apiDB.SetDraftGeometryStats(template.Id, draft.Number, stats, request.DBInfo)
+	if err != nil {
+		logger.Println("Could not record the geometry stats", template.UUID, draft.Number, err)
+	}
+}
+
+/*
+generateTextureTiers creates the texture tier derivatives for uploaded textures so the first clients to ask for them do not wait
+It runs after the upload responds, so callers should start it in a goroutine.
+Textures that can not be decoded are stored anyway and their tiers fail when requested.
+*/
+func generateTextureTiers(templateData *apiDB.TemplateDataRecord, fileStorage be.FileStorage) {
+	if be.IsTextureFileName(templateData.Name) == false {
+		return
+	}
+	err := be.GenerateTextureTiers(templateData.Key, fileStorage)
+	if err != nil {
+		logger.Println("Could not generate texture tiers", templateData.Name, err)
+	}
+}
+
+/*
+editableRevision returns the number of the template's draft revision, or its current revision if there is no draft
+*/
+func editableRevision(template *apiDB.TemplateRecord) int64 {
+	if template.DraftRevision != 0 {
+		return template.DraftRevision
+	}
+	return template.CurrentRevision
+}
+
+func findOrCreateDraftRevision(template *apiDB.TemplateRecord, request *be.APIRequest) (*apiDB.TemplateRevisionRecord, *be.APIError) {
+	draft, err := apiDB.FindOrCreateDraftRevision(template, request.DBInfo)
+	if err != nil {
+		return nil, &be.APIError{
+			Id:      "draft_error",
+			Message: "Could not create a draft revision: " + err.Error(),
+		}
+	}
+	return draft, nil
+}
+
+/*
+storeDraftTemplateData points the draft revision's named data at fileKey, creating the TemplateDataRecord if necessary
+The replaced file is deleted only if no published revision still uses it.
+Returns a 409 status if the draft was published before the data could be stored.
+*/
+func storeDraftTemplateData(template *apiDB.TemplateRecord, draft *apiDB.TemplateRevisionRecord, name string, fileKey string, request *be.APIRequest) (*apiDB.TemplateDataRecord, int, *be.APIError) {
+	templateData, oldKey, err := apiDB.StoreDraftTemplateData(template.Id, draft.Number, name, fileKey, request.DBInfo)
+	if err != nil {
+		apiDB.DeleteTemplateDataFileIfUnused(fileKey, request.FS, request.DBInfo)
+		if err == apiDB.ErrRevisionPublished {
+			return nil, http.StatusConflict, &be.RevisionPublishedError
+		}
+		return nil, http.StatusInternalServerError, &be.APIError{
+			Id:      "update_error",
+			Message: "Could not store the record: " + err.Error(),
+		}
+	}
+	if oldKey != "" {
+		err = apiDB.DeleteTemplateDataFileIfUnused(oldKey, request.FS, request.DBInfo)
+		if err != nil {
+			logger.Println("Ignored error deleting template data file: " + oldKey + ": " + err.Error())
+		}
+	}
+	return templateData, 0, nil
+}
+
+/*
+serveTemplateData writes the named data file of a template revision to the response
+*/
+func serveTemplateData(template *apiDB.TemplateRecord, revision int64, name string, cacheControl string, request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	templateData, err := apiDB.FindTemplateRevisionDataRecord(template.Id, revision, name, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_template_data",
+			Message: "No such template data: " + template.UUID + ", " + strconv.FormatInt(revision, 10) + ", " + name,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	file, err := request.FS.Get(templateData.Key, "")
+	if err != nil {
+		return 500, be.APIError{
+			Id:      "no_such_template_data_file",
+			Message: "No such template data file: " + template.UUID + ", " + name + ", " + templateData.Key,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	// Textures may be requested at a smaller size with ?derivative=texture-1024 or ?derivative=texture-placeholder
+	if derivative := request.Raw.FormValue("derivative"); derivative != "" {
+		if be.IsTextureDerivative(derivative) == false || be.IsTextureFileName(name) == false {
+			return 400, be.APIError{
+				Id:      "unknown_derivative",
+				Message: "No such derivative for " + name + ": " + derivative,
+			}, responseHeader
+		}
+		file, err = be.TextureTier(templateData.Key, derivative, request.FS)
+		if err != nil {
+			return 500, be.APIError{
+				Id:      "texture_error",
+				Message: "Could not resize the texture: " + name,
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+
+	responseHeader["Etag"] = etagForTemplateData(templateData, file.Derivative(), request.Version)
+	responseHeader["Cache-Control"] = []string{cacheControl}
+	if request.NotModified(responseHeader["Etag"][0]) {
+		return 200, nil, responseHeader
+	}
+
+	err = request.ServeFile(file, responseHeader)
+	if err != nil {
+		return 500, be.APIError{
+			Id:      be.InternalServerError.Id,
+			Message: "Error serving template data: " + err.Error(),
+		}, responseHeader
+	}
+	return be.StatusInternallyHandled, nil, nil
+}
+
+/*
+etagForTemplateData returns the ETag of a template data file or of its derivative
+The quotes make it a strong ETag, which Range requests with If-Range need.
+*/
+func etagForTemplateData(templateData *apiDB.TemplateDataRecord, derivative string, version string) []string {
+	tag := "template-data-" + version + "-" + templateData.Key
+	if derivative != "" {
+		tag += "/" + derivative
+	}
+	return []string{`"` + strings.Replace(tag, `"`, "", -1) + `"`}
 }
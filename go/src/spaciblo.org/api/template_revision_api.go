@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/be"
+)
+
+var TemplateRevisionProperties = []be.Property{
+	be.Property{
+		Name:        "number",
+		Description: "The revision number, counting up from 1",
+		DataType:    "int",
+		Protected:   true,
+	},
+	be.Property{
+		Name:        "published",
+		Description: "False while the revision is the template's draft",
+		DataType:    "bool",
+		Protected:   true,
+	},
+}
+
+var TemplateRevisionsProperties = be.NewAPIListProperties("template-revision")
+
+type TemplateRevisionsResource struct {
+}
+
+func NewTemplateRevisionsResource() *TemplateRevisionsResource {
+	return &TemplateRevisionsResource{}
+}
+
+func (TemplateRevisionsResource) Name() string  { return "template-revisions" }
+func (TemplateRevisionsResource) Path() string  { return "/template/{uuid:[0-9,a-z,-]+}/revision/" }
+func (TemplateRevisionsResource) Title() string { return "Template revisions" }
+func (TemplateRevisionsResource) Description() string {
+	return "The published revisions and draft of a template, newest first."
+}
+
+func (resource TemplateRevisionsResource) Properties() []be.Property {
+	return TemplateRevisionsProperties
+}
+
+func (resource TemplateRevisionsResource) Get(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	offset, limit := be.GetOffsetAndLimit(request.Raw.Form)
+
+	uuid, _ := request.PathValues["uuid"]
+	template, err := apiDB.FindTemplateRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_template",
+			Message: "No such template: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	records, err := apiDB.FindTemplateRevisionRecords(template.Id, offset, limit, request.DBInfo)
+	if err != nil {
+		return 500, be.APIError{
+			Id:      "db_error",
+			Message: "Database error",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	list := &be.APIList{
+		Offset:  offset,
+		Limit:   limit,
+		Objects: records,
+	}
+	return 200, list, responseHeader
+}
+
+/*
+TemplateRevisionDataResource serves the data of a specific revision so that clients can load every file of a model from the same revision
+*/
+type TemplateRevisionDataResource struct {
+}
+
+func NewTemplateRevisionDataResource() *TemplateRevisionDataResource {
+	return &TemplateRevisionDataResource{}
+}
+
+func (TemplateRevisionDataResource) Name() string { return "template-revision-data" }
+func (TemplateRevisionDataResource) Path() string {
+	return "/template/{uuid:[0-9,a-z,-]+}/revision/{revision:[0-9]+}/data/{name:[^/]+}"
+}
+func (TemplateRevisionDataResource) Title() string { return "Template revision data" }
+func (TemplateRevisionDataResource) Description() string {
+	return "The data blobs of a template revision, which never change once the revision is published."
+}
+
+func (resource TemplateRevisionDataResource) Properties() []be.Property {
+	return TemplateDataProperties
+}
+
+func (resource TemplateRevisionDataResource) Get(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	uuid, _ := request.PathValues["uuid"]
+	name, _ := request.PathValues["name"]
+	revision, err := strconv.ParseInt(request.PathValues["revision"], 10, 64)
+	if err != nil {
+		return 400, be.BadRequestError, responseHeader
+	}
+
+	template, err := apiDB.FindTemplateRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_template",
+			Message: "No such template: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	// Published revisions never change but the draft does
+	cacheControl := be.CacheControlImmutable
+	if revision == template.DraftRevision {
+		cacheControl = be.CacheControlRevalidate
+	}
+	return serveTemplateData(template, revision, name, cacheControl, request)
+}
+
+type TemplatePublishPost struct {
+	Revision int64 `json:"revision"` // The revision to publish, or 0 to publish the draft
+}
+
+/*
+TemplatePublishResource makes the draft, or an older revision for a roll back, the template's current revision
+*/
+type TemplatePublishResource struct{}
+
+func NewTemplatePublishResource() *TemplatePublishResource {
+	return &TemplatePublishResource{}
+}
+
+func (TemplatePublishResource) Name() string  { return "template-publish" }
+func (TemplatePublishResource) Path() string  { return "/template/{uuid:[0-9,a-z,-]+}/publish" }
+func (TemplatePublishResource) Title() string { return "Template publish" }
+func (TemplatePublishResource) Description() string {
+	return "POST to make the draft the current revision, or POST {\"revision\": N} to roll back to a published revision."
+}
+func (resource TemplatePublishResource) Properties() []be.Property { return TemplateProperties }
+
+func (resource TemplatePublishResource) Post(request *be.APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(be.PermissionTemplatesWrite)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+
+	uuid, _ := request.PathValues["uuid"]
+	template, err := apiDB.FindTemplateRecord(uuid, request.DBInfo)
+	if err != nil {
+		return 404, be.APIError{
+			Id:      "no_such_template",
+			Message: "No such template: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	publishPost := TemplatePublishPost{}
+	if request.Raw.ContentLength != 0 {
+		err = json.NewDecoder(request.Raw.Body).Decode(&publishPost)
+		if err != nil {
+			return 400, be.JSONParseError, responseHeader
+		}
+	}
+	if publishPost.Revision == 0 {
+		if template.DraftRevision == 0 {
+			return 400, be.APIError{
+				Id:      "no_draft_revision",
+				Message: "The template has no draft revision to publish: " + uuid,
+			}, responseHeader
+		}
+		publishPost.Revision = template.DraftRevision
+	}
+
+	before := map[string]int64{"currentRevision": template.CurrentRevision, "draftRevision": template.DraftRevision}
+	_, err = apiDB.PublishTemplateRevision(template, publishPost.Revision, request.DBInfo)
+	if err != nil {
+		return 400, be.APIError{
+			Id:      "could_not_publish",
+			Message: "Could not publish revision " + strconv.FormatInt(publishPost.Revision, 10) + " of " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	if template.Image == "" {
+		err = apiDB.RenderTemplateImage(template, request.FS, request.DBInfo)
+		if err != nil {
+			logger.Println("Could not render the template image", template.UUID, err)
+		}
+	}
+	request.Audit("publish", "template", template.UUID, before, map[string]int64{"currentRevision": template.CurrentRevision, "draftRevision": template.DraftRevision})
+	return 200, template, responseHeader
+}
@@ -0,0 +1,254 @@
+package be
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"path"
+	"time"
+)
+
+const AccountExportMimeType = "application/zip"
+
+// AccountExportManifestName is the archive path of the JSON holding the User's own records
+const AccountExportManifestName = "account.json"
+
+/*
+AccountDataHandler exports and removes the data that other packages tie to a User, like the api package's flocks and spaces
+Handlers are added with API.AddAccountDataHandler.
+*/
+type AccountDataHandler interface {
+	ExportAccountData(user *User, export *AccountExport, fileStorage FileStorage, dbInfo *DBInfo) error
+	// heir is the User who takes over anything shared that the User owned, or nil to leave it without an owner
+	DeleteAccountData(user *User, heir *User, fileStorage FileStorage, dbInfo *DBInfo) error
+}
+
+/*
+AccountExport is a zip archive of everything tied to a User, with JSON for records and copies of their stored files
+*/
+type AccountExport struct {
+	zipWriter   *zip.Writer
+	fileStorage FileStorage
+}
+
+/*
+Create starts a new file in the archive, which is finished by the next call to Create, WriteJSON, or WriteStoredFile
+*/
+func (export *AccountExport) Create(name string) (io.Writer, error) {
+	return export.zipWriter.Create(name)
+}
+
+/*
+WriteJSON writes value to the archive as indented JSON
+*/
+func (export *AccountExport) WriteJSON(name string, value interface{}) error {
+	writer, err := export.zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+/*
+WriteStoredFile copies the File with Key key into dir in the archive and returns its path there
+*/
+func (export *AccountExport) WriteStoredFile(dir string, key string) (string, error) {
+	file, err := export.fileStorage.Get(key, "")
+	if err != nil {
+		return "", err
+	}
+	name, err := file.Name()
+	if err != nil {
+		return "", err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	filePath := path.Join(dir, key+"-"+name)
+	writer, err := export.zipWriter.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		return "", err
+	}
+	return filePath, nil
+}
+
+/*
+AccountExportData holds the User's own records in AccountExportManifestName
+Secrets like password hashes, token hashes, and TOTP secrets are left out.
+*/
+type AccountExportData struct {
+	Exported       time.Time               `json:"exported"`
+	User           *User                   `json:"user"`
+	Image          string                  `json:"image,omitempty"` // The archive path of the profile image
+	Roles          []string                `json:"roles"`
+	TOTPEnabled    bool                    `json:"totp-enabled"`
+	Sessions       []*UserSession          `json:"sessions"`
+	APITokens      []*APIToken             `json:"api-tokens"`
+	OIDCIdentities []*AccountExportOIDC    `json:"oidc-identities"`
+	Invites        []RegistrationInvite    `json:"invites"` // Invites that the User created
+	FileUploads    []*AccountExportUpload  `json:"file-uploads"`
+	AuditEvents    []*AccountExportedEvent `json:"audit-events"` // Changes that the User made
+}
+
+type AccountExportOIDC struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Created  time.Time `json:"created"`
+}
+
+type AccountExportUpload struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+type AccountExportedEvent struct {
+	Action     string    `json:"action"`
+	TargetType string    `json:"target-type"`
+	TargetUUID string    `json:"target-uuid"`
+	IP         string    `json:"ip"`
+	Created    time.Time `json:"created"`
+}
+
+/*
+WriteAccountExport writes a zip archive to writer holding the User's records, their profile image, and what the handlers export
+*/
+func WriteAccountExport(user *User, writer io.Writer, handlers []AccountDataHandler, fileStorage FileStorage, dbInfo *DBInfo) error {
+	data := &AccountExportData{
+		Exported:       time.Now(),
+		User:           user,
+		OIDCIdentities: []*AccountExportOIDC{},
+		FileUploads:    []*AccountExportUpload{},
+		AuditEvents:    []*AccountExportedEvent{},
+	}
+	var err error
+	data.Roles, err = FindUserRoles(user.Id, dbInfo)
+	if err != nil {
+		return err
+	}
+	data.TOTPEnabled, err = HasTOTP(user.Id, dbInfo)
+	if err != nil {
+		return err
+	}
+	data.Sessions, err = FindUserSessions(user.UUID, dbInfo)
+	if err != nil {
+		return err
+	}
+	data.APITokens, err = FindAPITokens(user.Id, dbInfo)
+	if err != nil {
+		return err
+	}
+	var identities []*OIDCIdentity
+	_, err = dbInfo.Map.Select(&identities, "select * from "+OIDCIdentityTable+" where user_id=$1 order by id", user.Id)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		data.OIDCIdentities = append(data.OIDCIdentities, &AccountExportOIDC{identity.Provider, identity.Subject, identity.Created})
+	}
+	_, err = dbInfo.Map.Select(&data.Invites, "select * from "+RegistrationInviteTable+" where created_by=$1 order by id", user.Id)
+	if err != nil {
+		return err
+	}
+	var uploads []*FileUpload
+	_, err = dbInfo.Map.Select(&uploads, "select * from "+FileUploadTable+" where user_id=$1 order by id", user.Id)
+	if err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		data.FileUploads = append(data.FileUploads, &AccountExportUpload{upload.Key, upload.Size, upload.Created})
+	}
+	var events []*AuditEvent
+	_, err = dbInfo.Map.Select(&events, "select * from "+AuditEventTable+" where actor_uuid=$1 order by id", user.UUID)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		data.AuditEvents = append(data.AuditEvents, &AccountExportedEvent{event.Action, event.TargetType, event.TargetUUID, event.IP, event.Created})
+	}
+
+	export := &AccountExport{
+		zipWriter:   zip.NewWriter(writer),
+		fileStorage: fileStorage,
+	}
+	if user.Image != "" {
+		data.Image, err = export.WriteStoredFile("files/image", user.Image)
+		if err != nil {
+			return err
+		}
+	}
+	for _, handler := range handlers {
+		err = handler.ExportAccountData(user, export, fileStorage, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	err = export.WriteJSON(AccountExportManifestName, data)
+	if err != nil {
+		return err
+	}
+	return export.zipWriter.Close()
+}
+
+/*
+DeleteAccount removes the User and everything tied to them, running the handlers first
+The User is removed last so that a failure part way leaves an account that can be deleted again.
+Registration invites the User created and AuditEvents they caused are kept, since they are records of what staff did,
+but the events are anonymized with AnonymizeAuditEvents.
+*/
+func DeleteAccount(user *User, heir *User, handlers []AccountDataHandler, fileStorage FileStorage, dbInfo *DBInfo) error {
+	for _, handler := range handlers {
+		err := handler.DeleteAccountData(user, heir, fileStorage, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	err := RevokeUserSessions(user.UUID, "", dbInfo)
+	if err != nil {
+		return err
+	}
+	err = DeleteTOTP(user.Id, dbInfo)
+	if err != nil {
+		return err
+	}
+	err = DeleteUserFileUploads(user.Id, dbInfo)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{PasswordTable, EmailVerificationTable, PasswordResetTable, APITokenTable, OIDCIdentityTable, UserRoleTable} {
+		_, err = dbInfo.Map.Exec("delete from "+table+" where user_id=$1", user.Id)
+		if err != nil {
+			return err
+		}
+	}
+	err = AnonymizeAuditEvents(user, dbInfo)
+	if err != nil {
+		return err
+	}
+	// The invite that the User registered with holds their email
+	_, err = dbInfo.Map.Exec("delete from "+RegistrationInviteTable+" where used_by=$1", user.Id)
+	if err != nil {
+		return err
+	}
+	if user.Image != "" {
+		err = fileStorage.Delete(user.Image, "")
+		if err != nil {
+			logger.Print("Could not delete the user's image: " + err.Error())
+		}
+	}
+	_, err = dbInfo.Map.Delete(user)
+	return err
+}
@@ -0,0 +1,202 @@
+package be
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var AccountDeletionProperties = []Property{
+	Property{
+		Name:        "password",
+		Description: "The password the user logged in with, unless they only log in with a provider",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "space-heir",
+		Description: "The email of a user who takes over the user's spaces, which otherwise are left without an owner",
+		DataType:    "string",
+		Optional:    true,
+	},
+}
+
+type AccountDeletionData struct {
+	Password  string `json:"password"`
+	SpaceHeir string `json:"space-heir"`
+}
+
+/*
+AddAccountDataHandler adds a handler that exports and removes another package's data when people download or delete their accounts
+*/
+func (api *API) AddAccountDataHandler(handler AccountDataHandler) {
+	api.accountDataHandlers = append(api.accountDataHandlers, handler)
+}
+
+/*
+AccountResource deletes the authenticated User's account
+*/
+type AccountResource struct {
+	api *API
+}
+
+func NewAccountResource(api *API) *AccountResource {
+	return &AccountResource{api: api}
+}
+
+func (AccountResource) Name() string  { return "account" }
+func (AccountResource) Path() string  { return "/user/current/account" }
+func (AccountResource) Title() string { return "Account" }
+func (AccountResource) Description() string {
+	return "DELETE with the password to remove the account, its flocks, sessions, and profile image. Spaces go to the space-heir or are left without an owner. Requires a session and a recent second factor."
+}
+
+func (resource AccountResource) Properties() []Property {
+	return AccountDeletionProperties
+}
+
+func (resource AccountResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	// A leaked token should not be enough to remove an account
+	if request.Token != nil {
+		return 403, TokenScopeError, responseHeader
+	}
+	var data AccountDeletionData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil && err != io.EOF {
+		return 400, JSONParseError, responseHeader
+	}
+	if _, err := FindPasswordByUserId(request.User.Id, request.DBInfo); err == nil {
+		if PasswordMatches(request.User.Id, data.Password, request.DBInfo) == false {
+			return 400, APIError{
+				Id:      "incorrect_password",
+				Message: "Incorrect password",
+			}, responseHeader
+		}
+	}
+	status, apiError := request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	var heir *User
+	if email := strings.TrimSpace(data.SpaceHeir); email != "" {
+		heir, err = FindUserByEmail(email, request.DBInfo)
+		if err != nil || heir.Id == request.User.Id {
+			return 400, APIError{
+				Id:      "unknown_heir",
+				Message: "The space-heir must be the email of another account",
+			}, responseHeader
+		}
+	}
+	err = DeleteAccount(request.User, heir, resource.api.accountDataHandlers, request.FS, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not delete the account: " + err.Error(),
+		}, responseHeader
+	}
+	after := map[string]string{}
+	if heir != nil {
+		after["space-heir"] = heir.UUID
+	}
+	// Only the UUID is recorded, since the point of deletion is to forget who the User was
+	_, err = RecordAuditEvent(&User{UUID: request.User.UUID}, RequestIP(request.Raw), AuditDelete, "user", request.User.UUID, nil, after, request.DBInfo)
+	if err != nil {
+		logger.Print("Could not record the audit event: " + err.Error())
+	}
+	deleteAuthCookie(request)
+	return 200, "Ok", responseHeader
+}
+
+/*
+AccountExportResource lets people download everything tied to their account
+*/
+type AccountExportResource struct {
+	api   *API
+	limit *RateLimit
+}
+
+func NewAccountExportResource(api *API) *AccountExportResource {
+	return &AccountExportResource{
+		api:   api,
+		limit: NewRateLimit(5, time.Hour),
+	}
+}
+
+func (AccountExportResource) Name() string  { return "account-export" }
+func (AccountExportResource) Path() string  { return "/user/current/export" }
+func (AccountExportResource) Title() string { return "Account export" }
+func (AccountExportResource) Description() string {
+	return "A zip archive of the account's records as JSON, its profile image, and the bundles of its spaces. Requires a session."
+}
+
+func (resource AccountExportResource) Properties() []Property {
+	return []Property{}
+}
+
+func (resource AccountExportResource) RateLimit(method string) *RateLimit {
+	if method == GET {
+		return resource.limit
+	}
+	return nil
+}
+
+func (resource AccountExportResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	if request.Token != nil {
+		return 403, TokenScopeError, responseHeader
+	}
+
+	// Write the export to a temp file so that errors can be returned before the response starts
+	exportFile, err := ioutil.TempFile("", "account-export")
+	if err != nil {
+		return 500, APIError{
+			Id:      InternalServerError.Id,
+			Message: "Could not create a temp file",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	defer func() {
+		exportFile.Close()
+		os.Remove(exportFile.Name())
+	}()
+	err = WriteAccountExport(request.User, exportFile, resource.api.accountDataHandlers, request.FS, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "could_not_export",
+			Message: "Could not export the account",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	size, err := exportFile.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = exportFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return 500, APIError{
+			Id:      InternalServerError.Id,
+			Message: "Could not read the export",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+
+	request.Writer.Header().Set("Content-Type", AccountExportMimeType)
+	request.Writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	request.Writer.Header().Set("Content-Disposition", "attachment; filename=\"account-"+request.User.UUID+".zip\"")
+	_, err = io.Copy(request.Writer, exportFile)
+	if err != nil {
+		logger.Printf("Error serving an account export but too late to recover %v", err)
+	}
+	return StatusInternallyHandled, nil, nil
+}
@@ -0,0 +1,109 @@
+package be
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestAccountAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	userClient, staffClient, err := CreateTestUserAndStaffWithClients(testApi, dbInfo)
+	AssertNil(t, err)
+	user, err := FindUser(userClient.User.UUID, dbInfo)
+	AssertNil(t, err)
+
+	imageKey, err := testApi.API.FileStorage.Put("face.jpg", bytes.NewBufferString("not really a jpeg"))
+	AssertNil(t, err)
+	user.Image = imageKey
+	AssertNil(t, UpdateUser(user, dbInfo))
+	AssertNil(t, AddUserRole(user.Id, RoleModerator, dbInfo))
+	_, err = CreateAPIToken(user.Id, "Script", []string{ScopeReadOnly}, dbInfo)
+	AssertNil(t, err)
+
+	// The export holds the account's records and files, without secrets
+	reader, err := userClient.GetFile("/user/current/export")
+	AssertNil(t, err)
+	data, err := ioutil.ReadAll(reader)
+	AssertNil(t, err)
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	AssertNil(t, err)
+	zipFiles := map[string]*zip.File{}
+	for _, zipFile := range zipReader.File {
+		zipFiles[zipFile.Name] = zipFile
+	}
+	manifestFile, ok := zipFiles[AccountExportManifestName]
+	AssertTrue(t, ok)
+	manifestReader, err := manifestFile.Open()
+	AssertNil(t, err)
+	manifest := new(AccountExportData)
+	AssertNil(t, json.NewDecoder(manifestReader).Decode(manifest))
+	manifestReader.Close()
+	AssertEqual(t, user.UUID, manifest.User.UUID)
+	AssertEqual(t, []string{RoleModerator}, manifest.Roles)
+	AssertEqual(t, 1, len(manifest.APITokens))
+	AssertEqual(t, "", manifest.APITokens[0].TokenHash)
+	AssertEqual(t, 1, len(manifest.Sessions))
+	_, ok = zipFiles[manifest.Image]
+	AssertTrue(t, ok, "The profile image is in the export")
+
+	// Deletion needs the password and an heir that is someone else
+	deletionURL := "/user/current/account"
+	_, err = userClient.SendJSON("DELETE", deletionURL, AccountDeletionData{Password: "4321"})
+	AssertNotNil(t, err)
+	_, err = userClient.SendJSON("DELETE", deletionURL, AccountDeletionData{Password: "1234", SpaceHeir: user.Email})
+	AssertNotNil(t, err)
+	_, err = userClient.SendJSON("DELETE", deletionURL, AccountDeletionData{Password: "1234", SpaceHeir: "nobody@example.com"})
+	AssertNotNil(t, err)
+	_, err = userClient.SendJSON("DELETE", deletionURL, AccountDeletionData{Password: "1234", SpaceHeir: staffClient.User.Email})
+	AssertNil(t, err)
+
+	_, err = FindUser(user.UUID, dbInfo)
+	AssertNotNil(t, err)
+	_, err = FindPasswordByUserId(user.Id, dbInfo)
+	AssertNotNil(t, err)
+	roles, err := FindUserRoles(user.Id, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(roles))
+	tokens, err := FindAPITokens(user.Id, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(tokens))
+	sessions, err := FindUserSessions(user.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(sessions))
+	_, err = testApi.API.FileStorage.Get(imageKey, "")
+	AssertNotNil(t, err, "The profile image is deleted")
+	_, err = userClient.GetFile("/user/current/export")
+	AssertNotNil(t, err, "The session is logged out")
+
+	events, err := FindAuditEvents(AuditFilter{TargetType: "user", TargetUUID: user.UUID, Action: AuditDelete}, 0, 10, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(events))
+	AssertEqual(t, "", events[0].Before)
+
+	// Nothing in the audit log still names the deleted User
+	events, err = FindAuditEvents(AuditFilter{}, 0, 100, dbInfo)
+	AssertNil(t, err)
+	for _, event := range events {
+		if event.ActorUUID == user.UUID {
+			AssertEqual(t, "", event.ActorEmail)
+		}
+		AssertFalse(t, strings.Contains(event.Before+event.After, user.Email))
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/goincremental/negroni-sessions"
 	"github.com/gorilla/mux"
@@ -26,6 +27,13 @@ const (
 	PATCH  = "PATCH"
 )
 
+// Cache-Control policies for served files
+const (
+	CacheControlRevalidate = "no-cache"                            // Cache but check the ETag before every use
+	CacheControlPrivate    = "private, no-cache"                   // Like CacheControlRevalidate but only in the user's browser
+	CacheControlImmutable  = "public, max-age=31536000, immutable" // For URLs whose content never changes
+)
+
 // AuthCookieName and UserUUID are used by the session mechanism
 const (
 	AuthCookieName string = "be_auth"
@@ -122,6 +130,13 @@ type APIRequest struct {
 	Version    string
 	Raw        *http.Request
 	Writer     http.ResponseWriter
+
+	UploadLimits UploadLimits
+	Token        *APIToken // Set when the User authenticated with an API token instead of a session
+
+	RequireStaffSecondFactor bool
+
+	permissions []string // Loaded by Permissions when first needed
 }
 
 /*
@@ -175,23 +190,62 @@ type API struct {
 	Version     string
 	FileStorage FileStorage
 	DBInfo      *DBInfo
-	resources   []Resource
+	// Set UploadLimits before serving requests to override DefaultUploadLimits
+	UploadLimits UploadLimits
+	// Registration is closed and mail is logged unless these are set before serving requests
+	Registration RegistrationConfig
+	Mailer       Mailer
+	OIDC         OIDCConfig
+	// If true, staff and people with roles must turn on TOTP before using sensitive endpoints
+	RequireStaffSecondFactor bool
+	LoginThrottle            *LoginThrottle
+	resources                []Resource
+	accountDataHandlers      []AccountDataHandler
 }
 
 func NewAPI(path string, version string, fileStorage FileStorage, dbInfo *DBInfo) *API {
 	api := &API{
-		Mux:         mux.NewRouter(),
-		Path:        path,
-		Version:     version,
-		FileStorage: fileStorage,
-		DBInfo:      dbInfo,
-		resources:   make([]Resource, 0),
+		Mux:          mux.NewRouter(),
+		Path:         path,
+		Version:      version,
+		FileStorage:  fileStorage,
+		DBInfo:       dbInfo,
+		UploadLimits: DefaultUploadLimits,
+		Registration: RegistrationConfig{Mode: RegistrationClosed, VerifiedURL: "/", ResetURL: "/"},
+		Mailer:       &LogMailer{},
+		OIDC:         OIDCConfig{LoggedInURL: "/"},
+		resources:    make([]Resource, 0),
+
+		LoginThrottle: NewLoginThrottle(),
 	}
 	api.AddResource(NewSchemaResource(api), false)
-	api.AddResource(NewCurrentUserResource(), true)
+	api.AddResource(NewCurrentUserResource(api), true)
 	api.AddResource(NewCurrentUserImage(), false)
+	// These must be added before UserResource, whose path would also match them
+	api.AddResource(NewRegistrationResource(api), true)
+	api.AddResource(NewEmailVerificationResource(api), false)
+	api.AddResource(NewRegistrationInvitesResource(), true)
+	api.AddResource(NewPasswordResetResource(api), true)
+	api.AddResource(NewCurrentUserPasswordResource(), true)
+	api.AddResource(NewSecondFactorResource(api), true)
+	api.AddResource(NewTOTPResource(), true)
+	api.AddResource(NewRecoveryCodesResource(), true)
+	api.AddResource(NewAPITokensResource(), true)
+	api.AddResource(NewAPITokenResource(), true)
+	api.AddResource(NewCurrentUserSessionsResource(), true)
+	api.AddResource(NewCurrentUserSessionResource(), true)
+	api.AddResource(NewAccountResource(api), true)
+	api.AddResource(NewAccountExportResource(api), true)
+	api.AddResource(NewOIDCProvidersResource(api), true)
+	api.AddResource(NewOIDCLoginResource(api), false)
+	api.AddResource(NewOIDCCallbackResource(api), false)
 	api.AddResource(NewUsersResource(), true)
 	api.AddResource(NewUserResource(), true)
+	api.AddResource(NewUserStorageResource(), true)
+	api.AddResource(NewUserSessionsResource(), true)
+	api.AddResource(NewUserRolesResource(), true)
+	api.AddResource(NewRolesResource(), true)
+	api.AddResource(NewAuditEventsResource(), true)
 	return api
 }
 
@@ -290,25 +344,91 @@ func (api *API) createHandlerFunc(resource Resource, versioned bool, dbInfo *DBI
 			Version:    api.Version,
 			Raw:        request,
 			Writer:     rw,
+
+			UploadLimits: api.UploadLimits,
+
+			RequireStaffSecondFactor: api.RequireStaffSecondFactor,
 		}
 
 		// Fetch the User from the session
 		if session != nil {
-			sUUID := session.Get(UserUUIDKey)
-			if sUUID != nil {
-				uuid, _ := sUUID.(string)
-				user, err := FindUser(uuid, dbInfo)
-				if err == nil {
-					apiRequest.User = user
+			user, err := FindSessionUser(session, dbInfo)
+			if err == nil {
+				apiRequest.User = user
+			}
+		}
+
+		// Scripts authenticate with an API token instead of a session
+		if bearer := BearerToken(request.Header); bearer != "" {
+			apiToken, user, err := FindAPITokenUser(bearer, dbInfo)
+			if err != nil {
+				rw.WriteHeader(http.StatusUnauthorized)
+				errorString, _ := json.Marshal(InvalidTokenError)
+				rw.Write(errorString)
+				return
+			}
+			if apiToken.Allows(request.Method, resource.Path()) == false {
+				rw.WriteHeader(http.StatusForbidden)
+				errorString, _ := json.Marshal(TokenScopeError)
+				rw.Write(errorString)
+				return
+			}
+			permissions, err := FindUserPermissions(user, dbInfo)
+			if err != nil {
+				rw.WriteHeader(http.StatusInternalServerError)
+				errorString, _ := json.Marshal(APIError{
+					Id:      "database_error",
+					Message: "Could not find permissions: " + err.Error(),
+				})
+				rw.Write(errorString)
+				return
+			}
+			apiRequest.permissions = apiToken.LimitPermissions(permissions)
+			// Staff powers need the admin scope. Only admin tokens can reach the resources that save request.User.
+			if apiToken.HasScope(ScopeAdmin) == false {
+				user.Staff = false
+			}
+			apiRequest.User = user
+			apiRequest.Token = apiToken
+		}
+
+		if resource, ok := resource.(RateLimited); ok {
+			if limit := resource.RateLimit(request.Method); limit != nil {
+				allowed, wait := limit.Allow(rateLimitKey(request, apiRequest.User), time.Now())
+				if allowed == false {
+					rw.Header().Set("Retry-After", RetryAfter(wait))
+					rw.WriteHeader(http.StatusTooManyRequests)
+					errorString, _ := json.Marshal(RateLimitedError)
+					rw.Write(errorString)
+					return
 				}
 			}
 		}
 
-		if isMultipart(request.Header) && request.ParseMultipartForm(1024) != nil {
-			rw.WriteHeader(http.StatusBadRequest)
-			errorString, _ := json.Marshal(FormParseError)
-			rw.Write(errorString)
-			return
+		if maxBytes := api.UploadLimits.MaxRequestBytes; maxBytes > 0 {
+			if request.ContentLength > maxBytes {
+				rw.WriteHeader(http.StatusRequestEntityTooLarge)
+				errorString, _ := json.Marshal(RequestTooLargeError)
+				rw.Write(errorString)
+				return
+			}
+			// Chunked requests do not declare their length, so stop reading at the limit
+			request.Body = http.MaxBytesReader(rw, request.Body, maxBytes)
+		}
+
+		if isMultipart(request.Header) {
+			err := request.ParseMultipartForm(1024)
+			if err != nil && isRequestTooLarge(err) {
+				rw.WriteHeader(http.StatusRequestEntityTooLarge)
+				errorString, _ := json.Marshal(RequestTooLargeError)
+				rw.Write(errorString)
+				return
+			} else if err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				errorString, _ := json.Marshal(FormParseError)
+				rw.Write(errorString)
+				return
+			}
 		}
 
 		rw.Header().Add("API-Version", api.Version)
@@ -342,7 +462,7 @@ func (api *API) createHandlerFunc(resource Resource, versioned bool, dbInfo *DBI
 		rw.Header().Add("Content-Type", "application/json")
 
 		// Check whether the client's If-None-Match and the response header's ETag match
-		if rw.Header().Get("Etag") != "" && rw.Header().Get("Etag") == request.Header.Get("If-None-Match") {
+		if ETagMatches(request.Header.Get("If-None-Match"), rw.Header().Get("Etag")) {
 			rw.WriteHeader(http.StatusNotModified)
 			return
 		}
@@ -353,21 +473,30 @@ func (api *API) createHandlerFunc(resource Resource, versioned bool, dbInfo *DBI
 }
 
 /*
-ServeFile responds to the request with the data in file
+ServeFile responds to the request with the data in file, or with a redirect if file is a RedirectableFile with a URL
+It answers Range and If-Range requests for files in local storage and gzips text formats for clients that accept it.
+Set Etag and Cache-Control in header to make the response cacheable.
 
 Callers within API resource method funcs (e.g. Get) should return an internally handled status:
 	return StatusInternallyHandled, nil, nil
 */
 func (request *APIRequest) ServeFile(file File, header map[string][]string) error {
-	name, err := file.Name()
-	if err != nil {
-		return err
-	}
-	size, err := file.Size()
-	if err != nil {
-		return err
+	if redirectable, ok := file.(RedirectableFile); ok {
+		redirectURL, err := redirectable.RedirectURL()
+		if err != nil {
+			return err
+		}
+		if redirectURL != "" {
+			for name, values := range header {
+				for _, value := range values {
+					request.Writer.Header().Set(name, value)
+				}
+			}
+			http.Redirect(request.Writer, request.Raw, redirectURL, http.StatusTemporaryRedirect)
+			return nil
+		}
 	}
-	reader, err := file.Reader()
+	name, err := file.Name()
 	if err != nil {
 		return err
 	}
@@ -377,6 +506,40 @@ func (request *APIRequest) ServeFile(file File, header map[string][]string) erro
 		}
 	}
 	request.Writer.Header().Set("Content-Type", MimeTypeFromFileName(name))
+
+	// Text formats are sent gzipped, except for range requests which are for the uncompressed bytes
+	if file.Derivative() == "" && IsCompressibleFileName(name) {
+		request.Writer.Header().Add("Vary", "Accept-Encoding")
+		if request.Raw.Header.Get("Range") == "" && AcceptsGzip(request.Raw.Header) {
+			gzipFile, err := GzipFile(file.Key(), request.FS)
+			if err != nil {
+				logger.Println("Could not gzip a file", file.Key(), err)
+			} else {
+				file = gzipFile
+				request.Writer.Header().Set("Content-Encoding", "gzip")
+				if etag := request.Writer.Header().Get("Etag"); etag != "" && strings.HasPrefix(etag, "W/") == false {
+					request.Writer.Header().Set("Etag", "W/"+etag)
+				}
+			}
+		}
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		// ServeContent handles Range, If-Range, and If-None-Match using the Etag header
+		http.ServeContent(request.Writer, request.Raw, name, time.Time{}, seeker)
+		return nil
+	}
+	size, err := file.Size()
+	if err != nil {
+		return err
+	}
 	request.Writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	_, err = io.Copy(request.Writer, reader)
 	if err != nil {
@@ -384,3 +547,28 @@ func (request *APIRequest) ServeFile(file File, header map[string][]string) erro
 	}
 	return nil
 }
+
+/*
+NotModified returns true if the request's If-None-Match header matches the etag, so the response can be a 304
+*/
+func (request *APIRequest) NotModified(etag string) bool {
+	return ETagMatches(request.Raw.Header.Get("If-None-Match"), etag)
+}
+
+/*
+ETagMatches uses the weak comparison of If-None-Match to check whether the etag is in the list of ETags from an If-None-Match header
+Weak comparison ignores the W/ prefix, so the weak ETags of gzipped files match their strong ETags.
+*/
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -20,6 +20,10 @@ var (
 		Id:      "forbidden",
 		Message: "Forbidden for this user",
 	}
+	PermissionDeniedError = APIError{
+		Id:      "permission_denied",
+		Message: "Forbidden without a role that grants the permission",
+	}
 	FileNotFoundError = APIError{
 		Id:      "file_not_found",
 		Message: "File not found",
@@ -48,6 +52,86 @@ var (
 		Id:      "unprocessable_error",
 		Message: "Unprocessable",
 	}
+	RequestTooLargeError = APIError{
+		Id:      "request_too_large",
+		Message: "Request body too large",
+	}
+	FileTooLargeError = APIError{
+		Id:      "file_too_large",
+		Message: "File too large",
+	}
+	StorageQuotaExceededError = APIError{
+		Id:      "storage_quota_exceeded",
+		Message: "Storage quota exceeded",
+	}
+	LengthRequiredError = APIError{
+		Id:      "length_required",
+		Message: "A Content-Length header is required",
+	}
+	IncorrectLoginError = APIError{
+		Id:      "incorrect_login",
+		Message: "Incorrect email or password",
+	}
+	LoginThrottledError = APIError{
+		Id:      "login_throttled",
+		Message: "Too many incorrect passwords, wait before trying again",
+	}
+	RateLimitedError = APIError{
+		Id:      "rate_limited",
+		Message: "Too many requests, wait before trying again",
+	}
+	UnverifiedEmailError = APIError{
+		Id:      "unverified_email",
+		Message: "Follow the link in the verification email before logging in",
+	}
+	RegistrationClosedError = APIError{
+		Id:      "registration_closed",
+		Message: "Registration is closed",
+	}
+	ShortPasswordError = APIError{
+		Id:      "short_password",
+		Message: "Passwords must be at least 8 characters",
+	}
+	InvalidTokenError = APIError{
+		Id:      "invalid_token",
+		Message: "The API token is invalid or has been revoked",
+	}
+	TokenScopeError = APIError{
+		Id:      "token_scope",
+		Message: "The API token's scopes do not allow this request",
+	}
+	SecondFactorRequiredError = APIError{
+		Id:      "second_factor_required",
+		Message: "Enter a code from your authenticator app",
+	}
+	SecondFactorEnrollmentError = APIError{
+		Id:      "second_factor_enrollment_required",
+		Message: "Staff must turn on two-factor authentication",
+	}
+	IncorrectSecondFactorError = APIError{
+		Id:      "incorrect_second_factor",
+		Message: "Incorrect code",
+	}
+	SecondFactorThrottledError = APIError{
+		Id:      "second_factor_throttled",
+		Message: "Too many incorrect codes, wait before trying again",
+	}
+	PublicURLRequiredError = APIError{
+		Id:      "public_url_required",
+		Message: "PUBLIC_URL must be set to send links",
+	}
+	UnknownOIDCProviderError = APIError{
+		Id:      "unknown_provider",
+		Message: "No such login provider",
+	}
+	OIDCStateError = APIError{
+		Id:      "invalid_login_state",
+		Message: "The login expired or was started in another browser, please try again",
+	}
+	RevisionPublishedError = APIError{
+		Id:      "revision_published",
+		Message: "The revision was published, it can no longer be changed",
+	}
 	InternalServerError = APIError{
 		Id:      "internal_server_error",
 		Message: "Internal server error",
@@ -0,0 +1,238 @@
+package be
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const APITokenTable = "api_tokens"
+
+// APITokenPrefix starts every token so that they are easy to recognize in scripts and leaked logs
+const APITokenPrefix = "sbt_"
+
+// APIToken scopes. Every scope can read, and admin can do anything the user can, including staff actions.
+const (
+	ScopeReadOnly  = "read-only"
+	ScopeTemplates = "templates"
+	ScopeSpaces    = "spaces"
+	ScopeAdmin     = "admin"
+)
+
+var APITokenScopes = []string{ScopeReadOnly, ScopeTemplates, ScopeSpaces, ScopeAdmin}
+
+/*
+scopePathPrefixes are the resource paths that each scope may change
+*/
+var scopePathPrefixes = map[string][]string{
+	ScopeTemplates: []string{"/template", "/avatar"},
+	ScopeSpaces:    []string{"/space", "/flock"},
+}
+
+/*
+scopePermissions are the Permissions that each scope keeps from the User's Roles
+*/
+var scopePermissions = map[string][]string{
+	ScopeTemplates: []string{PermissionTemplatesWrite, PermissionAvatarsWrite},
+	ScopeSpaces:    []string{PermissionSpacesCreate, PermissionSpacesWrite},
+	ScopeAdmin:     Permissions,
+}
+
+/*
+APIToken lets scripts act as a User with an "Authorization: Bearer <token>" header instead of a password and session
+Only the hash of the token is stored, so the token itself is only available when it is created.
+*/
+type APIToken struct {
+	Id        int64     `json:"-" db:"id, primarykey, autoincrement"`
+	UUID      string    `json:"uuid" db:"u_u_i_d"`
+	UserId    int64     `json:"-" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	Scopes    []string  `json:"scopes" db:"-"`
+	ScopeList string    `json:"-" db:"scopes"` // Comma separated Scopes
+	TokenHash string    `json:"-" db:"token_hash"`
+	Hint      string    `json:"hint" db:"hint"` // The first characters of the token, to help people tell them apart
+	Created   time.Time `json:"created" db:"created"`
+	LastUsed  time.Time `json:"last-used" db:"last_used"`
+
+	Token string `json:"token,omitempty" db:"-"` // Only set in the response that creates the APIToken
+}
+
+/*
+HasScope returns true if the token was created with scope
+*/
+func (token *APIToken) HasScope(scope string) bool {
+	for _, tokenScope := range token.Scopes {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ReadOnly returns true if the token can not be used to change anything
+*/
+func (token *APIToken) ReadOnly() bool {
+	for _, scope := range token.Scopes {
+		if scope != ScopeReadOnly {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Allows returns true if the token's scopes permit a request with method to the Resource with path, as returned by Resource.Path()
+*/
+func (token *APIToken) Allows(method string, path string) bool {
+	if method == GET || method == HEAD || token.HasScope(ScopeAdmin) {
+		return true
+	}
+	for _, scope := range token.Scopes {
+		for _, prefix := range scopePathPrefixes[scope] {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+LimitPermissions returns the User's permissions that the token's scopes keep
+*/
+func (token *APIToken) LimitPermissions(permissions []string) []string {
+	limited := []string{}
+	for _, permission := range permissions {
+		for _, scope := range token.Scopes {
+			if HasPermission(scopePermissions[scope], permission) {
+				limited = append(limited, permission)
+				break
+			}
+		}
+	}
+	return limited
+}
+
+/*
+ValidateAPITokenScopes returns an error if scopes is empty or has an unknown scope
+*/
+func ValidateAPITokenScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("At least one scope is required")
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, knownScope := range APITokenScopes {
+			if scope == knownScope {
+				known = true
+			}
+		}
+		if known == false {
+			return errors.New("Unknown scope: " + scope)
+		}
+	}
+	return nil
+}
+
+/*
+CreateAPIToken stores a new APIToken for a User and returns it with the Token field set
+*/
+func CreateAPIToken(userId int64, name string, scopes []string, dbInfo *DBInfo) (*APIToken, error) {
+	err := ValidateAPITokenScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	random, err := RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := APITokenPrefix + random
+	apiToken := &APIToken{
+		UUID:      UUID(),
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		ScopeList: strings.Join(scopes, ","),
+		TokenHash: HashToken(token),
+		Hint:      token[:len(APITokenPrefix)+6],
+		Created:   time.Now(),
+	}
+	err = dbInfo.Map.Insert(apiToken)
+	if err != nil {
+		return nil, err
+	}
+	apiToken.Token = token
+	return apiToken, nil
+}
+
+func FindAPITokens(userId int64, dbInfo *DBInfo) ([]*APIToken, error) {
+	var apiTokens []*APIToken
+	_, err := dbInfo.Map.Select(&apiTokens, "select * from "+APITokenTable+" where user_id=$1 order by id desc", userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, apiToken := range apiTokens {
+		apiToken.Scopes = strings.Split(apiToken.ScopeList, ",")
+	}
+	return apiTokens, nil
+}
+
+/*
+FindAPITokenUser returns the APIToken for token and its User, and notes when the token was used
+*/
+func FindAPITokenUser(token string, dbInfo *DBInfo) (*APIToken, *User, error) {
+	if strings.HasPrefix(token, APITokenPrefix) == false {
+		return nil, nil, errors.New("Not an API token")
+	}
+	apiToken := new(APIToken)
+	err := dbInfo.Map.SelectOne(apiToken, "select * from "+APITokenTable+" where token_hash=$1", HashToken(token))
+	if err != nil {
+		return nil, nil, errors.New("No such API token")
+	}
+	apiToken.Scopes = strings.Split(apiToken.ScopeList, ",")
+	user := new(User)
+	err = dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where id=$1", apiToken.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only note use once a minute so that scripts do not write to the DB on every request
+	if time.Since(apiToken.LastUsed) > time.Minute {
+		apiToken.LastUsed = time.Now()
+		_, err = dbInfo.Map.Exec("update "+APITokenTable+" set last_used=$1 where id=$2", apiToken.LastUsed, apiToken.Id)
+		if err != nil {
+			logger.Print("Could not update API token use: " + err.Error())
+		}
+	}
+	return apiToken, user, nil
+}
+
+/*
+DeleteAPIToken revokes the User's APIToken with uuid
+*/
+func DeleteAPIToken(userId int64, uuid string, dbInfo *DBInfo) error {
+	result, err := dbInfo.Map.Exec("delete from "+APITokenTable+" where user_id=$1 and u_u_i_d=$2", userId, uuid)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("No such API token")
+	}
+	return nil
+}
+
+/*
+BearerToken returns the token in an "Authorization: Bearer <token>" header, or "" if there is none
+*/
+func BearerToken(header http.Header) string {
+	authorization := header.Get("Authorization")
+	if len(authorization) < 7 || strings.EqualFold(authorization[:7], "Bearer ") == false {
+		return ""
+	}
+	return strings.TrimSpace(authorization[7:])
+}
@@ -0,0 +1,169 @@
+package be
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+var APITokenProperties = []Property{
+	Property{
+		Name:        "uuid",
+		Description: "uuid",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "name",
+		Description: "What the token is for, like the name of a script",
+		DataType:    "string",
+	},
+	Property{
+		Name:        "scopes",
+		Description: "Some of read-only, templates, spaces, and admin",
+		DataType:    "array",
+	},
+	Property{
+		Name:        "hint",
+		Description: "The first characters of the token",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "token",
+		Description: "The token to send as \"Authorization: Bearer <token>\", only in the response that creates it",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "last-used",
+		Description: "When the token was last used, to the minute",
+		DataType:    "date-time",
+		Protected:   true,
+	},
+}
+
+var APITokensProperties = NewAPIListProperties("api-token")
+
+/*
+APITokensResource lists and creates the authenticated User's API tokens
+*/
+type APITokensResource struct{}
+
+func NewAPITokensResource() *APITokensResource {
+	return &APITokensResource{}
+}
+
+func (APITokensResource) Name() string  { return "api-tokens" }
+func (APITokensResource) Path() string  { return "/user/current/token/" }
+func (APITokensResource) Title() string { return "API tokens" }
+func (APITokensResource) Description() string {
+	return "Tokens that scripts send in an Authorization: Bearer header instead of logging in. POST a name and scopes to create one."
+}
+
+func (resource APITokensResource) Properties() []Property {
+	return APITokensProperties
+}
+
+func (resource APITokensResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	apiTokens, err := FindAPITokens(request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	return 200, &APIList{
+		Offset:  0,
+		Limit:   len(apiTokens),
+		Objects: apiTokens,
+	}, responseHeader
+}
+
+func (resource APITokensResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	var data APIToken
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		return 400, APIError{
+			Id:      "name_required",
+			Message: "A name is required",
+		}, responseHeader
+	}
+	err = ValidateAPITokenScopes(data.Scopes)
+	if err != nil {
+		return 400, APIError{
+			Id:      "invalid_scopes",
+			Message: "Scopes must be some of " + strings.Join(APITokenScopes, ", "),
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	// Tokens skip the second factor when used and keep whatever permissions the User is later given, so any token that can write needs it
+	if data.ReadOnly() == false {
+		status, apiError := request.CheckSecondFactor()
+		if apiError != nil {
+			return status, apiError, responseHeader
+		}
+	}
+	apiToken, err := CreateAPIToken(request.User.Id, data.Name, data.Scopes, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the token: " + err.Error(),
+		}, responseHeader
+	}
+	// Copy the token without its plaintext so that the log can not be used to find it
+	summary := *apiToken
+	summary.Token = ""
+	request.Audit(AuditCreate, "api-token", apiToken.UUID, nil, summary)
+	return 200, apiToken, responseHeader
+}
+
+/*
+APITokenResource revokes one of the authenticated User's API tokens
+*/
+type APITokenResource struct{}
+
+func NewAPITokenResource() *APITokenResource {
+	return &APITokenResource{}
+}
+
+func (APITokenResource) Name() string  { return "api-token" }
+func (APITokenResource) Path() string  { return "/user/current/token/{uuid:[0-9,a-z,-]+}" }
+func (APITokenResource) Title() string { return "API token" }
+func (APITokenResource) Description() string {
+	return "DELETE to revoke the token."
+}
+
+func (resource APITokenResource) Properties() []Property {
+	return APITokenProperties
+}
+
+func (resource APITokenResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	uuid, _ := request.PathValues["uuid"]
+	err := DeleteAPIToken(request.User.Id, uuid, request.DBInfo)
+	if err != nil {
+		return 404, APIError{
+			Id:      "no_such_token",
+			Message: "No such token: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditDelete, "api-token", uuid, nil, nil)
+	return 200, "Ok", responseHeader
+}
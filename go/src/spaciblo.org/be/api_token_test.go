@@ -0,0 +1,93 @@
+package be
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestAPITokenScopes(t *testing.T) {
+	readOnly := &APIToken{Scopes: []string{ScopeReadOnly}}
+	AssertTrue(t, readOnly.Allows(GET, "/template/"))
+	AssertFalse(t, readOnly.Allows(POST, "/template/"))
+	templates := &APIToken{Scopes: []string{ScopeTemplates}}
+	AssertTrue(t, templates.Allows(PUT, "/template/{uuid:[0-9,a-z,-]+}/data/"))
+	AssertFalse(t, templates.Allows(PUT, "/space/{uuid:[0-9,a-z,-]+}"))
+	AssertFalse(t, templates.Allows(DELETE, "/user/current/token/{uuid:[0-9,a-z,-]+}"))
+	spaces := &APIToken{Scopes: []string{ScopeTemplates, ScopeSpaces}}
+	AssertTrue(t, spaces.Allows(POST, "/space-bundle/"))
+	AssertTrue(t, spaces.Allows(POST, "/flock/"))
+	admin := &APIToken{Scopes: []string{ScopeAdmin}}
+	AssertTrue(t, admin.Allows(DELETE, "/user/current/token/{uuid:[0-9,a-z,-]+}"))
+	AssertTrue(t, readOnly.ReadOnly())
+	AssertFalse(t, templates.ReadOnly())
+	AssertFalse(t, (&APIToken{Scopes: []string{ScopeReadOnly, ScopeSpaces}}).ReadOnly())
+
+	AssertNil(t, ValidateAPITokenScopes([]string{ScopeReadOnly, ScopeAdmin}))
+	AssertNotNil(t, ValidateAPITokenScopes([]string{}))
+	AssertNotNil(t, ValidateAPITokenScopes([]string{"root"}))
+
+	header := http.Header{}
+	AssertEqual(t, "", BearerToken(header))
+	header.Set("Authorization", "Basic abc")
+	AssertEqual(t, "", BearerToken(header))
+	header.Set("Authorization", "bearer sbt_abc ")
+	AssertEqual(t, "sbt_abc", BearerToken(header))
+}
+
+func TestAPITokenAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+
+	staff, err := CreateUser("staff@example.com", "Staff", "", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = CreatePassword("1234", staff.Id, dbInfo)
+	AssertNil(t, err)
+	client, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, client.Authenticate("staff@example.com", "1234"))
+
+	readToken := new(APIToken)
+	AssertNotNil(t, client.PostAndReceiveJSON("/user/current/token/", APIToken{Name: "bad", Scopes: []string{"root"}}, readToken))
+	AssertNil(t, client.PostAndReceiveJSON("/user/current/token/", APIToken{Name: "reader", Scopes: []string{ScopeReadOnly}}, readToken))
+	AssertEqual(t, "sbt_", readToken.Token[:4])
+	adminToken := new(APIToken)
+	AssertNil(t, client.PostAndReceiveJSON("/user/current/token/", APIToken{Name: "admin", Scopes: []string{ScopeAdmin}}, adminToken))
+	list, err := client.GetList("/user/current/token/")
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(list.Objects.([]interface{})))
+	_, hasToken := list.Objects.([]interface{})[0].(map[string]interface{})["token"]
+	AssertFalse(t, hasToken, "Tokens are only returned when they are created")
+
+	readClient, err := NewTokenClient(testApi.URL(), readToken.Token)
+	AssertNil(t, err)
+	user := new(User)
+	AssertNil(t, readClient.GetJSON("/user/current", user))
+	AssertEqual(t, staff.UUID, user.UUID)
+	AssertFalse(t, user.Staff, "Staff powers need the admin scope")
+	_, err = readClient.GetList("/user/")
+	AssertNotNil(t, err)
+	AssertNotNil(t, readClient.PostAndReceiveJSON("/user/current/token/", APIToken{Name: "escalate", Scopes: []string{ScopeAdmin}}, new(APIToken)))
+
+	adminClient, err := NewTokenClient(testApi.URL(), adminToken.Token)
+	AssertNil(t, err)
+	_, err = adminClient.GetList("/user/")
+	AssertNil(t, err)
+	AssertNil(t, adminClient.Delete("/user/current/token/"+readToken.UUID))
+	AssertNotNil(t, readClient.GetJSON("/user/current", user), "Revoked tokens stop working")
+
+	badClient, err := NewTokenClient(testApi.URL(), "sbt_bogus")
+	AssertNil(t, err)
+	AssertNotNil(t, badClient.GetJSON("/schema", new(Schema)), "Invalid tokens are rejected rather than ignored")
+}
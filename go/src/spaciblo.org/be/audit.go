@@ -0,0 +1,194 @@
+package be
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AuditEventTable = "audit_events"
+
+// Audit actions that most targets share. Others, like "publish", describe more specific changes.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// MaxAuditSummaryLength limits the size of the before and after summaries of an AuditEvent
+const MaxAuditSummaryLength = 4096
+
+/*
+AuditEvent records who changed what, for finding out later how something came to be
+Events are only ever inserted, removed by DeleteAuditEventsBefore once they are older than the retention period,
+and stripped of personal details by AnonymizeAuditEvents when an account is deleted.
+*/
+type AuditEvent struct {
+	Id         int64     `json:"id" db:"id, primarykey, autoincrement"`
+	ActorUUID  string    `json:"actor-uuid" db:"actor_uuid"`   // Empty for changes made by the system
+	ActorEmail string    `json:"actor-email" db:"actor_email"` // As it was at the time, since Users change
+	Action     string    `json:"action" db:"action"`
+	TargetType string    `json:"target-type" db:"target_type"`
+	TargetUUID string    `json:"target-uuid" db:"target_uuid"`
+	Before     string    `json:"before" db:"before_summary"` // JSON, or empty for creation
+	After      string    `json:"after" db:"after_summary"`   // JSON, or empty for deletion
+	IP         string    `json:"ip" db:"ip"`
+	Created    time.Time `json:"created" db:"created"`
+}
+
+/*
+AuditSummary returns value as JSON for an AuditEvent, or an empty string if value is nil
+*/
+func AuditSummary(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if summary, ok := value.(string); ok {
+		return truncateAuditSummary(summary)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "Could not summarize: " + err.Error()
+	}
+	if string(data) == "null" {
+		return ""
+	}
+	return truncateAuditSummary(string(data))
+}
+
+func truncateAuditSummary(summary string) string {
+	if len(summary) <= MaxAuditSummaryLength {
+		return summary
+	}
+	return summary[:MaxAuditSummaryLength-3] + "..."
+}
+
+/*
+RecordAuditEvent saves an AuditEvent, with a nil actor for changes made by the system
+before and after are summarized with AuditSummary.
+*/
+func RecordAuditEvent(actor *User, ip string, action string, targetType string, targetUUID string, before interface{}, after interface{}, dbInfo *DBInfo) (*AuditEvent, error) {
+	event := &AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetUUID: targetUUID,
+		Before:     AuditSummary(before),
+		After:      AuditSummary(after),
+		IP:         ip,
+		Created:    time.Now(),
+	}
+	if actor != nil {
+		event.ActorUUID = actor.UUID
+		event.ActorEmail = actor.Email
+	}
+	err := dbInfo.Map.Insert(event)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+/*
+Audit records an AuditEvent for a change made by the request's User
+Failures are logged rather than returned because the change has already been made.
+*/
+func (request *APIRequest) Audit(action string, targetType string, targetUUID string, before interface{}, after interface{}) {
+	_, err := RecordAuditEvent(request.User, RequestIP(request.Raw), action, targetType, targetUUID, before, after, request.DBInfo)
+	if err != nil {
+		logger.Print("Could not record the audit event: " + err.Error())
+	}
+}
+
+/*
+auditUser records a change that user made to their own account before the request was logged in
+*/
+func auditUser(user *User, action string, before interface{}, after interface{}, request *APIRequest) {
+	_, err := RecordAuditEvent(user, RequestIP(request.Raw), action, "user", user.UUID, before, after, request.DBInfo)
+	if err != nil {
+		logger.Print("Could not record the audit event: " + err.Error())
+	}
+}
+
+/*
+AuditFilter selects AuditEvents, ignoring empty fields
+*/
+type AuditFilter struct {
+	ActorUUID  string
+	Action     string
+	TargetType string
+	TargetUUID string
+	Since      time.Time
+	Until      time.Time
+}
+
+/*
+FindAuditEvents returns the AuditEvents that match filter, newest first
+*/
+func FindAuditEvents(filter AuditFilter, offset int, limit int, dbInfo *DBInfo) ([]*AuditEvent, error) {
+	clauses := []string{}
+	args := []interface{}{}
+	addClause := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		clauses = append(clauses, clause+"$"+strconv.Itoa(len(args)))
+	}
+	if filter.ActorUUID != "" {
+		addClause("actor_uuid=", filter.ActorUUID)
+	}
+	if filter.Action != "" {
+		addClause("action=", filter.Action)
+	}
+	if filter.TargetType != "" {
+		addClause("target_type=", filter.TargetType)
+	}
+	if filter.TargetUUID != "" {
+		addClause("target_uuid=", filter.TargetUUID)
+	}
+	if filter.Since.IsZero() == false {
+		addClause("created>=", filter.Since)
+	}
+	if filter.Until.IsZero() == false {
+		addClause("created<", filter.Until)
+	}
+	query := "select * from " + AuditEventTable
+	if len(clauses) > 0 {
+		query += " where " + strings.Join(clauses, " and ")
+	}
+	args = append(args, limit, offset)
+	query += " order by created desc, id desc limit $" + strconv.Itoa(len(args)-1) + " offset $" + strconv.Itoa(len(args))
+	var events []*AuditEvent
+	_, err := dbInfo.Map.Select(&events, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+/*
+DeleteAuditEventsBefore removes the AuditEvents created before cutoff and returns how many were removed
+*/
+func DeleteAuditEventsBefore(cutoff time.Time, dbInfo *DBInfo) (int64, error) {
+	result, err := dbInfo.Map.Exec("delete from "+AuditEventTable+" where created<$1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+/*
+AnonymizeAuditEvents removes the User's email and the summaries of changes to their account from the AuditEvents
+The events themselves are kept, still marked with the User's UUID, so that what happened can be followed.
+Registration invites sent to the User are summarized with their email, so those summaries are removed, too.
+*/
+func AnonymizeAuditEvents(user *User, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("update "+AuditEventTable+" set actor_email='' where actor_uuid=$1", user.UUID)
+	if err != nil {
+		return err
+	}
+	_, err = dbInfo.Map.Exec("update "+AuditEventTable+" set before_summary='', after_summary='' where target_uuid=$1", user.UUID)
+	if err != nil {
+		return err
+	}
+	_, err = dbInfo.Map.Exec("update "+AuditEventTable+" set before_summary='', after_summary='' where target_type='registration-invite' and target_uuid in (select cast(id as text) from "+RegistrationInviteTable+" where used_by=$1 or lower(email)=lower($2))", user.Id, user.Email)
+	return err
+}
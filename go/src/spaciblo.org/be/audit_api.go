@@ -0,0 +1,127 @@
+package be
+
+import (
+	"net/http"
+	"time"
+)
+
+var AuditEventProperties = []Property{
+	Property{
+		Name:        "actor-uuid",
+		Description: "The User who made the change, or empty for the system",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "actor-email",
+		Description: "The actor's email at the time of the change",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "action",
+		Description: "What was done, like create, update, or delete",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "target-type",
+		Description: "The kind of thing that was changed, like template or user",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "target-uuid",
+		Description: "The UUID of the thing that was changed",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "before",
+		Description: "A JSON summary of the target before the change",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "after",
+		Description: "A JSON summary of the target after the change",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "ip",
+		Description: "The address that requested the change",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "created",
+		Description: "When the change was made",
+		DataType:    "date-time",
+		Protected:   true,
+	},
+}
+
+var AuditEventsProperties = NewAPIListProperties("audit-event")
+
+/*
+AuditEventsResource lets staff read the audit log
+*/
+type AuditEventsResource struct{}
+
+func NewAuditEventsResource() *AuditEventsResource {
+	return &AuditEventsResource{}
+}
+
+func (AuditEventsResource) Name() string  { return "audit-events" }
+func (AuditEventsResource) Path() string  { return "/audit/" }
+func (AuditEventsResource) Title() string { return "Audit log" }
+func (AuditEventsResource) Description() string {
+	return "Who changed what, newest first, for people with the users:manage permission. Filter with the actor, action, target-type, target, since, and until query parameters, where since and until are RFC 3339 times."
+}
+
+func (resource AuditEventsResource) Properties() []Property {
+	return AuditEventsProperties
+}
+
+func (resource AuditEventsResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	query := request.Raw.URL.Query()
+	filter := AuditFilter{
+		ActorUUID:  query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target-type"),
+		TargetUUID: query.Get("target"),
+	}
+	for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			return 400, APIError{
+				Id:      "bad_time",
+				Message: name + " must be an RFC 3339 time like 2017-01-02T15:04:05Z",
+				Error:   err.Error(),
+			}, responseHeader
+		}
+		*value = parsed
+	}
+	offset, limit := GetOffsetAndLimit(query)
+	events, err := FindAuditEvents(filter, offset, limit, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	return 200, &APIList{
+		Offset:  offset,
+		Limit:   limit,
+		Objects: events,
+	}, responseHeader
+}
@@ -0,0 +1,87 @@
+package be
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestAuditSummary(t *testing.T) {
+	AssertEqual(t, "", AuditSummary(nil))
+	var user *User
+	AssertEqual(t, "", AuditSummary(user))
+	AssertEqual(t, "plain", AuditSummary("plain"))
+	AssertEqual(t, `{"image":"a"}`, AuditSummary(map[string]string{"image": "a"}))
+	long := AuditSummary(strings.Repeat("a", MaxAuditSummaryLength*2))
+	AssertEqual(t, MaxAuditSummaryLength, len(long))
+	AssertTrue(t, strings.HasSuffix(long, "..."))
+}
+
+func TestAuditAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	userClient, staffClient, err := CreateTestUserAndStaffWithClients(testApi, dbInfo)
+	AssertNil(t, err)
+
+	_, err = userClient.GetList("/audit/")
+	AssertNotNil(t, err, "Users without users:manage may not read the audit log")
+
+	rolesURL := "/user/" + userClient.User.UUID + "/role/"
+	AssertNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{RoleModerator}}, new(UserRoles)))
+	events, err := FindAuditEvents(AuditFilter{TargetUUID: userClient.User.UUID}, 0, 10, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(events))
+	AssertEqual(t, staffClient.User.UUID, events[0].ActorUUID)
+	AssertEqual(t, staffClient.User.Email, events[0].ActorEmail)
+	AssertEqual(t, AuditUpdate, events[0].Action)
+	AssertEqual(t, "user-roles", events[0].TargetType)
+	AssertEqual(t, "[]", events[0].Before)
+	AssertEqual(t, `["moderator"]`, events[0].After)
+	AssertNotEqual(t, "", events[0].IP)
+
+	_, err = userClient.GetList("/audit/")
+	AssertNotNil(t, err, "Moderators may not read the audit log")
+
+	_, err = RecordAuditEvent(nil, "", AuditDelete, "template", "system-change", nil, nil, dbInfo)
+	AssertNil(t, err)
+	list, err := staffClient.GetList("/audit/")
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(list.Objects.([]interface{})))
+	newest := list.Objects.([]interface{})[0].(map[string]interface{})
+	AssertEqual(t, "system-change", newest["target-uuid"])
+	AssertEqual(t, "", newest["actor-uuid"])
+
+	list, err = staffClient.GetList("/audit/?actor=" + staffClient.User.UUID + "&target-type=user-roles")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+	list, err = staffClient.GetList("/audit/?action=" + AuditCreate)
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(list.Objects.([]interface{})))
+	list, err = staffClient.GetList("/audit/?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	AssertNil(t, err)
+	AssertEqual(t, 0, len(list.Objects.([]interface{})))
+	_, err = staffClient.GetList("/audit/?until=yesterday")
+	AssertNotNil(t, err)
+
+	count, err := DeleteAuditEventsBefore(time.Now().Add(time.Minute), dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, int64(2), count)
+
+	// Admins manage users, so they may read the audit log too
+	AssertNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{RoleAdmin}}, new(UserRoles)))
+	list, err = userClient.GetList("/audit/")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+}
@@ -1,6 +1,8 @@
 package be
 
 import (
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -48,6 +50,22 @@ func UpdatePassword(password *Password, dbInfo *DBInfo) error {
 	return nil
 }
 
+/*
+SetPassword changes the password of a User, creating their Password if they have none
+*/
+func SetPassword(userId int64, plaintext string, dbInfo *DBInfo) error {
+	password, err := FindPasswordByUserId(userId, dbInfo)
+	if err != nil {
+		_, err = CreatePassword(plaintext, userId, dbInfo)
+		return err
+	}
+	err = password.Encode(plaintext)
+	if err != nil {
+		return err
+	}
+	return UpdatePassword(password, dbInfo)
+}
+
 func FindAllPasswords(dbInfo *DBInfo) ([]*Password, error) {
 	var passwords []*Password
 	_, err := dbInfo.Map.Select(&passwords, "select * from "+PasswordTable+" order by id desc")
@@ -66,11 +84,26 @@ func FindPasswordByUserId(userId int64, dbInfo *DBInfo) (*Password, error) {
 func PasswordMatches(userId int64, plaintext string, dbInfo *DBInfo) bool {
 	password, err := FindPasswordByUserId(userId, dbInfo)
 	if err != nil {
+		ComparePasswordToNothing(plaintext)
 		return false
 	}
 	return password.Matches(plaintext)
 }
 
+var nothingHash []byte
+var nothingHashOnce sync.Once
+
+/*
+ComparePasswordToNothing takes as long as checking a password, for when there is no password to check
+Otherwise quick responses would show which emails have no account.
+*/
+func ComparePasswordToNothing(plaintext string) {
+	nothingHashOnce.Do(func() {
+		nothingHash, _ = bcrypt.GenerateFromPassword([]byte("nothing"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(nothingHash, []byte(plaintext))
+}
+
 func DeleteAllPasswords(dbInfo *DBInfo) error {
 	passwords, err := FindAllPasswords(dbInfo)
 	if err != nil {
@@ -24,6 +24,12 @@ func UUID() string {
 	return u4.String()
 }
 
+func init() {
+	// The mime package does not know about glTF on every system
+	mime.AddExtensionType(".gltf", "model/gltf+json")
+	mime.AddExtensionType(".glb", "model/gltf-binary")
+}
+
 func MimeTypeFromFileName(name string) string {
 	lindex := strings.LastIndex(name, ".")
 	if lindex == -1 || lindex == len(name)-1 {
@@ -17,6 +17,7 @@ type Client struct {
 	BaseURL string
 	Schema  Schema
 	Session string
+	Token   string // If set, requests authenticate with this API token instead of the Session
 	User    User
 }
 
@@ -42,17 +43,17 @@ func (client *Client) Authenticate(email string, password string) error {
 		Password: password,
 	}
 	resp, err := client.PostJSON("/user/current", loginData)
+	if resp != nil {
+		// Keep the session even if a second factor is required, because VerifySecondFactor finishes logging it in
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == TestSessionCookie {
+				client.Session = cookie.Value
+			}
+		}
+	}
 	if err != nil {
 		return err
 	}
-
-	// Look for the session cookie
-	cookies := resp.Cookies()
-	for _, cookie := range cookies {
-		if cookie.Name == TestSessionCookie {
-			client.Session = cookie.Value
-		}
-	}
 	if client.Session == "" {
 		return errors.New("No session cookie on the authentication response")
 	}
@@ -66,15 +67,35 @@ func (client *Client) Authenticate(email string, password string) error {
 	return nil
 }
 
+/*
+VerifySecondFactor sends a code from an authenticator app, or a recovery code, after Authenticate returns an error because one is required
+*/
+func (client *Client) VerifySecondFactor(code string) error {
+	return client.PostAndReceiveJSON("/user/current/second-factor", SecondFactorData{Code: code}, &client.User)
+}
+
+/*
+NewTokenClient creates a client that authenticates with an API token, which is how scripts should use the API
+*/
+func NewTokenClient(baseURL string, token string) (*Client, error) {
+	client, err := NewClient(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	client.Token = token
+	return client, nil
+}
+
 func (client *Client) Deauthenticate() error {
 	if client.Session == "" {
 		return nil
 	}
-	client.Session = ""
+	// Send the session so that the service revokes it
 	req, err := client.prepJSONRequest("DELETE", client.BaseURL+"/user/current", nil)
 	if err != nil {
 		return err
 	}
+	client.Session = ""
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
@@ -201,12 +222,7 @@ func (client *Client) SendFile(method string, url string, fieldName string, file
 		return nil, err
 	}
 	req.Header.Add("Accept", AcceptHeaderPrefix+client.Schema.API.Version)
-	if client.Session != "" {
-		req.AddCookie(&http.Cookie{
-			Name:  TestSessionCookie,
-			Value: client.Session,
-		})
-	}
+	client.authorize(req)
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	transport := &http.Transport{
@@ -267,6 +283,12 @@ func (client *Client) SendJSON(method string, url string, data interface{}) (res
 	if err != nil {
 		return
 	}
+	// The service replaces the session cookie when it changes, like after a password change
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == TestSessionCookie && cookie.Value != "" && client.Session != "" {
+			client.Session = cookie.Value
+		}
+	}
 	if resp.StatusCode != 200 {
 		return resp, errors.New("Non-200 error " + strconv.Itoa(resp.StatusCode) + " " + method + "ing JSON to " + url)
 	}
@@ -321,13 +343,19 @@ func (client *Client) prepRequest(method string, url string, reader io.Reader, m
 		req.Header.Add("Content-Type", mimetype)
 	}
 	req.Header.Add("Accept", AcceptHeaderPrefix+client.Schema.API.Version)
-	if client.Session != "" {
+	client.authorize(req)
+	return req, nil
+}
+
+func (client *Client) authorize(req *http.Request) {
+	if client.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
+	} else if client.Session != "" {
 		req.AddCookie(&http.Cookie{
 			Name:  TestSessionCookie,
 			Value: client.Session,
 		})
 	}
-	return req, nil
 }
 
 func (client *Client) fetchSchema() error {
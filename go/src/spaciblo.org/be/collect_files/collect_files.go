@@ -0,0 +1,54 @@
+/*
+Delete stored files that no user, template, or template data references.
+
+	collect_files [grace period, like 48h]
+
+Files that were stored within the grace period (by default 24h) are kept because uploads store files before referencing them.
+A ContentFileStorage locks its RootDir while counting references, so this may run while the API is up.
+*/
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/be"
+	"spaciblo.org/db"
+)
+
+var logger = log.New(os.Stdout, "[collect-files] ", 0)
+
+func main() {
+	gracePeriod := apiDB.DefaultFileGCGracePeriod
+	if len(os.Args) == 2 {
+		parsedPeriod, err := time.ParseDuration(os.Args[1])
+		if err != nil {
+			logger.Println("usage: collect_files [grace period, like 48h]")
+			return
+		}
+		gracePeriod = parsedPeriod
+	} else if len(os.Args) > 2 {
+		logger.Println("usage: collect_files [grace period, like 48h]")
+		return
+	}
+
+	fs, err := be.NewFileStorageFromEnv()
+	if err != nil {
+		logger.Panic("Could not open file storage: " + err.Error())
+		return
+	}
+
+	dbInfo, err := db.InitDB()
+	if err != nil {
+		logger.Panic("DB Initialization Error: " + err.Error())
+		return
+	}
+
+	report, err := apiDB.CollectFileGarbage(fs, gracePeriod, dbInfo)
+	if err != nil {
+		logger.Fatal("Could not collect files: ", err)
+	}
+	logger.Println(report)
+}
@@ -0,0 +1,96 @@
+package be
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GzipDerivative is the FileStorage derivative that holds the gzipped data of a file
+const GzipDerivative = "gzip"
+
+/*
+compressibleExtensions are the text formats that shrink enough to be worth gzipping. Images and binary geometry are already compact.
+*/
+var compressibleExtensions = map[string]bool{
+	".obj":  true,
+	".mtl":  true,
+	".gltf": true,
+	".js":   true,
+	".json": true,
+	".txt":  true,
+	".svg":  true,
+	".xml":  true,
+	".html": true,
+	".css":  true,
+}
+
+/*
+IsCompressibleFileName returns true if the file name has the extension of a text format that should be gzipped
+*/
+func IsCompressibleFileName(name string) bool {
+	return compressibleExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+/*
+AcceptsGzip returns true if the Accept-Encoding header includes gzip without a q value of 0
+*/
+func AcceptsGzip(header http.Header) bool {
+	for _, encoding := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		tokens := strings.Split(encoding, ";")
+		if strings.TrimSpace(tokens[0]) != "gzip" && strings.TrimSpace(tokens[0]) != "*" {
+			continue
+		}
+		if len(tokens) == 1 {
+			return true
+		}
+		param := strings.TrimSpace(tokens[1])
+		if strings.HasPrefix(param, "q=") == false {
+			return true
+		}
+		quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+		return err == nil && quality > 0
+	}
+	return false
+}
+
+/*
+GzipFile gets from or creates in fileStorage a gzipped derivative of the File with Key key
+*/
+func GzipFile(key string, fileStorage FileStorage) (File, error) {
+	gzipFile, err := fileStorage.Get(key, GzipDerivative)
+	if err == nil {
+		return gzipFile, nil
+	}
+	origFile, err := fileStorage.Get(key, "")
+	if err != nil {
+		return nil, err
+	}
+	reader, err := origFile.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// Compress while storing instead of holding the compressed data in memory
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		gzipWriter, _ := gzip.NewWriterLevel(pipeWriter, gzip.BestCompression)
+		_, err := io.Copy(gzipWriter, reader)
+		if err == nil {
+			err = gzipWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	err = fileStorage.PutDerivative(key, GzipDerivative, pipeReader)
+	pipeReader.Close()
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage.Get(key, GzipDerivative)
+}
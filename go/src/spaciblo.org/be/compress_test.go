@@ -0,0 +1,122 @@
+package be
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestETagMatches(t *testing.T) {
+	AssertTrue(t, ETagMatches(`"abc"`, `"abc"`))
+	AssertTrue(t, ETagMatches(`"xyz", W/"abc"`, `"abc"`))
+	AssertTrue(t, ETagMatches(`"abc"`, `W/"abc"`))
+	AssertTrue(t, ETagMatches(`*`, `"abc"`))
+	AssertFalse(t, ETagMatches(`"xyz"`, `"abc"`))
+	AssertFalse(t, ETagMatches(``, `"abc"`))
+	AssertFalse(t, ETagMatches(`"abc"`, ``))
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for acceptEncoding, expected := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, gzip;q=0.5": true,
+		"br, gzip;q=0":        false,
+		"identity":            false,
+		"*":                   true,
+	} {
+		header := http.Header{}
+		header.Set("Accept-Encoding", acceptEncoding)
+		AssertEqual(t, expected, AcceptsGzip(header), acceptEncoding)
+	}
+	AssertTrue(t, IsCompressibleFileName("Model.OBJ"))
+	AssertFalse(t, IsCompressibleFileName("texture.png"))
+}
+
+func TestServeFile(t *testing.T) {
+	fsDir, err := ioutil.TempDir(os.TempDir(), "be-test-fs")
+	AssertNil(t, err, "Could not create fsDir: "+fsDir)
+	defer func() {
+		err = os.RemoveAll(fsDir)
+		AssertNil(t, err, "Could not clean up fsDir: "+fsDir)
+	}()
+	testFS, err := NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+
+	objData := strings.Repeat("v 1.0 2.0 3.0\n", 100)
+	objKey, err := testFS.Put("model.obj", strings.NewReader(objData))
+	AssertNil(t, err)
+	objFile, err := testFS.Get(objKey, "")
+	AssertNil(t, err)
+	serve := func(file File, requestHeader map[string]string) *httptest.ResponseRecorder {
+		raw, err := http.NewRequest("GET", "/model.obj", nil)
+		AssertNil(t, err)
+		for name, value := range requestHeader {
+			raw.Header.Set(name, value)
+		}
+		recorder := httptest.NewRecorder()
+		request := &APIRequest{Raw: raw, Writer: recorder, FS: testFS}
+		header := map[string][]string{
+			"Etag":          []string{FileETag(file)},
+			"Cache-Control": []string{CacheControlRevalidate},
+		}
+		AssertNil(t, request.ServeFile(file, header))
+		return recorder
+	}
+
+	// Clients that do not accept gzip get the whole file
+	recorder := serve(objFile, nil)
+	AssertEqual(t, 200, recorder.Code)
+	AssertEqual(t, objData, recorder.Body.String())
+	AssertEqual(t, "", recorder.Header().Get("Content-Encoding"))
+	AssertEqual(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+	AssertEqual(t, CacheControlRevalidate, recorder.Header().Get("Cache-Control"))
+
+	// Text formats are gzipped with a weak ETag
+	recorder = serve(objFile, map[string]string{"Accept-Encoding": "gzip"})
+	AssertEqual(t, 200, recorder.Code)
+	AssertEqual(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	AssertEqual(t, "W/"+FileETag(objFile), recorder.Header().Get("Etag"))
+	AssertTrue(t, recorder.Body.Len() < len(objData))
+	gzipReader, err := gzip.NewReader(recorder.Body)
+	AssertNil(t, err)
+	unzipped, err := ioutil.ReadAll(gzipReader)
+	AssertNil(t, err)
+	AssertEqual(t, objData, string(unzipped))
+	exists, err := testFS.Exists(objKey, GzipDerivative)
+	AssertNil(t, err)
+	AssertTrue(t, exists, "The gzipped data should be stored for the next request")
+
+	// Ranges are of the uncompressed data
+	recorder = serve(objFile, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=14-27"})
+	AssertEqual(t, 206, recorder.Code)
+	AssertEqual(t, "", recorder.Header().Get("Content-Encoding"))
+	AssertEqual(t, "v 1.0 2.0 3.0\n", recorder.Body.String())
+	AssertEqual(t, "bytes 14-27/1400", recorder.Header().Get("Content-Range"))
+
+	// An If-Range with a different ETag gets the whole file
+	recorder = serve(objFile, map[string]string{"Range": "bytes=14-27", "If-Range": `"stale"`})
+	AssertEqual(t, 200, recorder.Code)
+	AssertEqual(t, len(objData), recorder.Body.Len())
+	recorder = serve(objFile, map[string]string{"Range": "bytes=14-27", "If-Range": FileETag(objFile)})
+	AssertEqual(t, 206, recorder.Code)
+
+	recorder = serve(objFile, map[string]string{"If-None-Match": FileETag(objFile)})
+	AssertEqual(t, 304, recorder.Code)
+
+	// Binary formats are not gzipped
+	pngKey, err := testFS.Put("texture.png", strings.NewReader("not really a png"))
+	AssertNil(t, err)
+	pngFile, err := testFS.Get(pngKey, "")
+	AssertNil(t, err)
+	recorder = serve(pngFile, map[string]string{"Accept-Encoding": "gzip"})
+	AssertEqual(t, 200, recorder.Code)
+	AssertEqual(t, "", recorder.Header().Get("Content-Encoding"))
+	AssertEqual(t, "image/png", recorder.Header().Get("Content-Type"))
+}
@@ -0,0 +1,459 @@
+package be
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	contentFileStorageBlobsName = "b_l_o_b_s"
+	contentFileStorageRefsName  = "r_e_f_s"
+	contentFileStorageLockName  = "l_o_c_k"
+	contentHashLength           = sha256.Size * 2 // Hex encoded
+)
+
+/*
+ContentFileStorage is a FileStorage persisted by the local file system that stores each distinct file once.
+
+Keys are <hex SHA-256 of the data><keySeparator><name>, so identical uploads get the same key and share a blob.
+Each Put of a key adds a reference and each Delete removes one, and the blob and its derivatives are deleted with the last reference to any key for the data.
+Because a key always names the same data, keys can be used as strong ETags.
+
+Keys that were created by a LocalFileStorage in the same RootDir are still readable and deletable, so ContentFileStorage drops in for LocalFileStorage.
+
+Reference counts are protected by a mutex within a process and by an flock of a lock file in the RootDir between processes,
+so that tools like collect_files can run alongside the API.
+*/
+type ContentFileStorage struct {
+	RootDir string
+	legacy  LocalFileStorage
+	mutex   *sync.Mutex
+}
+
+/*
+NewContentFileStorage requires the rootDir exist and be a directory
+*/
+func NewContentFileStorage(rootDir string) (*ContentFileStorage, error) {
+	legacy, err := NewLocalFileStorage(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	fs := &ContentFileStorage{
+		RootDir: rootDir,
+		legacy:  *legacy,
+		mutex:   &sync.Mutex{},
+	}
+	for _, dirName := range []string{contentFileStorageBlobsName, contentFileStorageRefsName} {
+		err = os.MkdirAll(path.Join(rootDir, dirName), os.ModeSticky|0775)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+/*
+Put stores the data from reader, hashing it as it is copied into a temp file, and returns its key.
+If the data is already stored the temp file is discarded and the key gains a reference.
+*/
+func (fs *ContentFileStorage) Put(name string, reader io.Reader) (key string, err error) {
+	tempDir, err := fs.legacy.getOrCreateTempDir()
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := ioutil.TempFile(tempDir, "cfs")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile.Name()) // Fails harmlessly after the temp file is moved into place
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hash), reader)
+	tempFile.Close()
+	if err != nil {
+		return "", err
+	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+	key = contentHash + keySeparator + fs.legacy.clean(strings.Split(name, "/")[0])
+
+	unlock, err := fs.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	blobPath := fs.blobPath(contentHash, "")
+	if _, err := os.Stat(blobPath); err != nil {
+		err = os.Rename(tempFile.Name(), blobPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	count, err := fs.referenceCount(key)
+	if err != nil {
+		return "", err
+	}
+	err = fs.setReferenceCount(key, count+1)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+/*
+PutDerivative stores a derivative of the data for key, which is shared by every key for the same data
+The derivative is moved into place while holding the lock, so that a Delete of the last reference can not leave it behind.
+*/
+func (fs *ContentFileStorage) PutDerivative(key string, derivative string, reader io.Reader) error {
+	if IsContentKey(key) == false {
+		return fs.legacy.PutDerivative(key, derivative, reader)
+	}
+	if derivative == "" {
+		return errors.New("Derivatives require a name")
+	}
+	derivativeDir, err := fs.legacy.derivativeDir(derivative)
+	if err != nil {
+		return err
+	}
+	tempDir, err := fs.legacy.getOrCreateTempDir()
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(tempDir, "cfs")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name()) // Fails harmlessly after the temp file is moved into place
+	_, err = io.Copy(tempFile, reader)
+	tempFile.Close()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := fs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	count, err := fs.referenceCount(key)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("Cannot create a derivative for a non-existant key")
+	}
+	_, err = os.Stat(fs.blobPath(key[:contentHashLength], ""))
+	if err != nil {
+		return errors.New("Cannot create a derivative for a non-existant key")
+	}
+	return os.Rename(tempFile.Name(), path.Join(derivativeDir, key[:contentHashLength]))
+}
+
+func (fs *ContentFileStorage) Get(key string, derivative string) (File, error) {
+	if IsContentKey(key) == false {
+		return fs.legacy.Get(key, derivative)
+	}
+	exists, err := fs.Exists(key, derivative)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("No such File: " + key + " with derivative: " + derivative)
+	}
+	return ContentFile{
+		key:        key,
+		derivative: derivative,
+		path:       fs.blobPath(key[:contentHashLength], derivative),
+	}, nil
+}
+
+/*
+Exists returns true if the key has a reference and its data (or derivative) is stored
+*/
+func (fs *ContentFileStorage) Exists(key string, derivative string) (bool, error) {
+	if IsContentKey(key) == false {
+		return fs.legacy.Exists(key, derivative)
+	}
+	unlock, err := fs.lock()
+	if err != nil {
+		return false, err
+	}
+	count, err := fs.referenceCount(key)
+	unlock()
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+	_, err = os.Stat(fs.blobPath(key[:contentHashLength], derivative))
+	return err == nil, nil
+}
+
+/*
+Delete removes one reference to the key, deleting the data and its derivatives when no key for the data has a reference.
+Deleting a derivative removes only the derivative, which may be recreated from the data.
+Like LocalFileStorage, Delete returns nil for keys that do not exist.
+*/
+func (fs *ContentFileStorage) Delete(key string, derivative string) error {
+	if IsContentKey(key) == false {
+		return fs.legacy.Delete(key, derivative)
+	}
+	contentHash := key[:contentHashLength]
+	if derivative != "" {
+		err := os.Remove(fs.blobPath(contentHash, derivative))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	unlock, err := fs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	count, err := fs.referenceCount(key)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+	err = fs.setReferenceCount(key, count-1)
+	if err != nil {
+		return err
+	}
+	// Other names for the same data keep the blob
+	otherRefs, err := filepath.Glob(path.Join(fs.RootDir, contentFileStorageRefsName, contentHash+keySeparator+"*"))
+	if err != nil {
+		return err
+	}
+	if len(otherRefs) > 0 {
+		return nil
+	}
+	for _, derivativeName := range fs.derivativeDirPaths() {
+		os.Remove(fs.blobPath(contentHash, derivativeName))
+	}
+	err = os.Remove(fs.blobPath(contentHash, ""))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+CollectGarbage drops every reference to unreferenced keys that were last Put before the given time and then deletes the blobs and derivatives of data without references.
+Files in the RootDir from a LocalFileStorage are collected like LocalFileStorage.CollectGarbage does.
+*/
+func (fs *ContentFileStorage) CollectGarbage(liveKeys map[string]bool, before time.Time) (*FileGCReport, error) {
+	unlock, err := fs.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	report := &FileGCReport{}
+
+	refsDir := path.Join(fs.RootDir, contentFileStorageRefsName)
+	infos, err := ioutil.ReadDir(refsDir)
+	if err != nil {
+		return nil, err
+	}
+	referencedHashes := map[string]bool{}
+	for _, info := range infos {
+		if !IsContentKey(info.Name()) {
+			continue
+		}
+		if liveKeys[info.Name()] || !info.ModTime().Before(before) {
+			referencedHashes[info.Name()[:contentHashLength]] = true
+			continue
+		}
+		err = os.Remove(path.Join(refsDir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		report.Originals += 1
+	}
+
+	// Blobs without references include those left by a Put that failed before counting its reference
+	blobsDir := path.Join(fs.RootDir, contentFileStorageBlobsName)
+	infos, err = ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if referencedHashes[info.Name()] {
+			continue
+		}
+		err = os.Remove(path.Join(blobsDir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		report.Bytes += info.Size()
+	}
+
+	for _, derivative := range fs.derivativeDirPaths() {
+		derivativeDir := path.Join(fs.RootDir, derivative)
+		infos, err = ioutil.ReadDir(derivativeDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.IsDir() || !isContentHash(info.Name()) || referencedHashes[info.Name()] {
+				continue
+			}
+			err = os.Remove(path.Join(derivativeDir, info.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			report.Derivatives += 1
+			report.Bytes += info.Size()
+		}
+	}
+
+	err = fs.legacy.collectGarbage(liveKeys, before, fs.derivativeDirPaths(), report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+/*
+IsContentKey returns true if the key was created by a ContentFileStorage
+*/
+func IsContentKey(key string) bool {
+	if len(key) < contentHashLength+len(keySeparator) || key[contentHashLength:contentHashLength+len(keySeparator)] != keySeparator {
+		return false
+	}
+	return isContentHash(key[:contentHashLength])
+}
+
+func isContentHash(name string) bool {
+	if len(name) != contentHashLength {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func (fs *ContentFileStorage) blobPath(contentHash string, derivative string) string {
+	if derivative == "" {
+		return path.Join(fs.RootDir, contentFileStorageBlobsName, contentHash)
+	}
+	return path.Join(fs.RootDir, fs.legacy.clean(derivative), contentHash)
+}
+
+func (fs *ContentFileStorage) derivativeDirPaths() []string {
+	results := []string{}
+	for _, dirName := range fs.legacy.derivativeDirPaths() {
+		if dirName != contentFileStorageBlobsName && dirName != contentFileStorageRefsName {
+			results = append(results, dirName)
+		}
+	}
+	return results
+}
+
+/*
+referenceCount returns the number of Puts minus Deletes of the key, reading a file in the refs dir that holds the count
+The caller must hold the lock.
+*/
+func (fs *ContentFileStorage) referenceCount(key string) (int64, error) {
+	data, err := ioutil.ReadFile(fs.referencePath(key))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+/*
+setReferenceCount writes the count, or removes the refs file if the count is zero. The caller must hold the lock.
+*/
+func (fs *ContentFileStorage) setReferenceCount(key string, count int64) error {
+	if count <= 0 {
+		err := os.Remove(fs.referencePath(key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(fs.referencePath(key), []byte(strconv.FormatInt(count, 10)), 0664)
+}
+
+/*
+lock takes the mutex and then an exclusive flock on the lock file, returning a func that releases both
+*/
+func (fs *ContentFileStorage) lock() (func(), error) {
+	fs.mutex.Lock()
+	lockFile, err := os.OpenFile(path.Join(fs.RootDir, contentFileStorageLockName), os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		fs.mutex.Unlock()
+		return nil, err
+	}
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		lockFile.Close()
+		fs.mutex.Unlock()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+		fs.mutex.Unlock()
+	}, nil
+}
+
+func (fs *ContentFileStorage) referencePath(key string) string {
+	return path.Join(fs.RootDir, contentFileStorageRefsName, fs.legacy.clean(key))
+}
+
+/*
+ContentFile is a be.File backed by a ContentFileStorage
+*/
+type ContentFile struct {
+	key        string
+	derivative string
+	path       string
+}
+
+func (cf ContentFile) Key() string {
+	return cf.key
+}
+
+func (cf ContentFile) Derivative() string {
+	return cf.derivative
+}
+
+/*
+Name is derived from Key which is <content hash><keySeparator><name>
+*/
+func (cf ContentFile) Name() (string, error) {
+	return cf.key[contentHashLength+len(keySeparator):], nil
+}
+
+func (cf ContentFile) Exists() (bool, error) {
+	_, err := os.Stat(cf.path)
+	return err == nil, nil
+}
+
+func (cf ContentFile) Size() (int64, error) {
+	stat, err := os.Stat(cf.path)
+	if err != nil {
+		return -1, err
+	}
+	return stat.Size(), nil
+}
+
+func (cf ContentFile) Reader() (io.Reader, error) {
+	return os.OpenFile(cf.path, os.O_RDONLY, os.ModePerm)
+}
@@ -0,0 +1,137 @@
+package be
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestContentFileStorage(t *testing.T) {
+	_, err := NewContentFileStorage("/bogus/mcboog")
+	AssertNotNil(t, err, "Should return an error if handed a non-existing directory")
+
+	fsDir, err := ioutil.TempDir(os.TempDir(), "be-test-fs")
+	AssertNil(t, err, "Could not create fsDir: "+fsDir)
+	defer func() {
+		err = os.RemoveAll(fsDir)
+		AssertNil(t, err, "Could not clean up fsDir: "+fsDir)
+	}()
+
+	testFS, err := NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+	blobCount := func() int {
+		infos, err := ioutil.ReadDir(path.Join(fsDir, contentFileStorageBlobsName))
+		AssertNil(t, err)
+		return len(infos)
+	}
+
+	exists, err := testFS.Exists("bogus-key", "")
+	AssertNil(t, err)
+	AssertFalse(t, exists)
+
+	// Identical data shares a blob, and identical data and names share a key
+	key1, err := testFS.Put("wood.png", strings.NewReader("grain"))
+	AssertNil(t, err)
+	AssertTrue(t, IsContentKey(key1))
+	key2, err := testFS.Put("wood.png", strings.NewReader("grain"))
+	AssertNil(t, err)
+	AssertEqual(t, key1, key2)
+	key3, err := testFS.Put("oak.png", strings.NewReader("grain"))
+	AssertNil(t, err)
+	AssertNotEqual(t, key1, key3)
+	key4, err := testFS.Put("wood.png", strings.NewReader("knots"))
+	AssertNil(t, err)
+	AssertNotEqual(t, key1, key4)
+	AssertEqual(t, 2, blobCount())
+
+	file, err := testFS.Get(key3, "")
+	AssertNil(t, err)
+	name, err := file.Name()
+	AssertNil(t, err)
+	AssertEqual(t, "oak.png", name)
+	size, err := file.Size()
+	AssertNil(t, err)
+	AssertEqual(t, int64(5), size)
+	reader, err := file.Reader()
+	AssertNil(t, err)
+	AssertTrue(t, CompareReaderData(strings.NewReader("grain"), reader))
+	AssertEqual(t, `"`+key3+`"`, FileETag(file))
+
+	// Derivatives are stored once for the data
+	err = testFS.PutDerivative(key1, "thumb", strings.NewReader("small"))
+	AssertNil(t, err)
+	exists, err = testFS.Exists(key3, "thumb")
+	AssertNil(t, err)
+	AssertTrue(t, exists)
+	derivativeFile, err := testFS.Get(key1, "thumb")
+	AssertNil(t, err)
+	AssertEqual(t, `"`+key1+`/thumb"`, FileETag(derivativeFile))
+
+	// key1 was Put twice so it survives one Delete
+	AssertNil(t, testFS.Delete(key1, ""))
+	exists, err = testFS.Exists(key1, "")
+	AssertNil(t, err)
+	AssertTrue(t, exists)
+	AssertNil(t, testFS.Delete(key1, ""))
+	exists, err = testFS.Exists(key1, "")
+	AssertNil(t, err)
+	AssertFalse(t, exists)
+	AssertNil(t, testFS.Delete(key1, ""), "Deleting non-existant keys should not return an error")
+
+	// The blob and derivative remain until the last key for the data is deleted
+	exists, err = testFS.Exists(key3, "thumb")
+	AssertNil(t, err)
+	AssertTrue(t, exists)
+	AssertEqual(t, 2, blobCount())
+	AssertNil(t, testFS.Delete(key3, ""))
+	AssertEqual(t, 1, blobCount())
+	_, err = os.Stat(path.Join(fsDir, "thumb", key3[:contentHashLength]))
+	AssertTrue(t, os.IsNotExist(err), "Derivatives should be deleted with the data")
+	AssertNotNil(t, testFS.PutDerivative(key3, "thumb", strings.NewReader("small")), "Deleted data can not get new derivatives")
+	_, err = os.Stat(path.Join(fsDir, "thumb", key3[:contentHashLength]))
+	AssertTrue(t, os.IsNotExist(err))
+	AssertNil(t, testFS.Delete(key4, ""))
+	AssertEqual(t, 0, blobCount())
+
+	// Keys from a LocalFileStorage in the same directory still work
+	localFS, err := NewLocalFileStorage(fsDir)
+	AssertNil(t, err)
+	localKey, err := localFS.Put("old.bin", strings.NewReader("legacy"))
+	AssertNil(t, err)
+	AssertFalse(t, IsContentKey(localKey))
+	file, err = testFS.Get(localKey, "")
+	AssertNil(t, err)
+	name, err = file.Name()
+	AssertNil(t, err)
+	AssertEqual(t, "old.bin", name)
+	AssertNil(t, testFS.Delete(localKey, ""))
+	exists, err = localFS.Exists(localKey, "")
+	AssertNil(t, err)
+	AssertFalse(t, exists)
+
+	// Storages in separate processes share the RootDir's lock, like two storages in one process do
+	otherFS, err := NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+	var wait sync.WaitGroup
+	for _, storage := range []*ContentFileStorage{testFS, otherFS} {
+		wait.Add(1)
+		go func(storage *ContentFileStorage) {
+			defer wait.Done()
+			for i := 0; i < 20; i++ {
+				_, err := storage.Put("shared.txt", strings.NewReader("shared"))
+				AssertNil(t, err)
+			}
+		}(storage)
+	}
+	wait.Wait()
+	sharedKey, err := testFS.Put("shared.txt", strings.NewReader("shared"))
+	AssertNil(t, err)
+	count, err := otherFS.referenceCount(sharedKey)
+	AssertNil(t, err)
+	AssertEqual(t, int64(41), count)
+}
@@ -2,6 +2,7 @@ package be
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -59,13 +60,48 @@ func InitDB() (*DBInfo, error) {
 func migrateDB(dbInfo *DBInfo) error {
 	dbInfo.Map.AddTableWithName(User{}, UserTable).SetKeys(true, "Id")
 	dbInfo.Map.AddTableWithName(Password{}, PasswordTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(FileUpload{}, FileUploadTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(EmailVerification{}, EmailVerificationTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(RegistrationInvite{}, RegistrationInviteTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(PasswordReset{}, PasswordResetTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(APIToken{}, APITokenTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(OIDCIdentity{}, OIDCIdentityTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(OIDCLogin{}, OIDCLoginTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(UserSession{}, SessionTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(TOTPSecret{}, TOTPSecretTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(RecoveryCode{}, RecoveryCodeTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(UserRole{}, UserRoleTable).SetKeys(true, "Id")
+	dbInfo.Map.AddTableWithName(AuditEvent{}, AuditEventTable).SetKeys(true, "Id")
 	err := dbInfo.Map.CreateTablesIfNotExists()
 	if err != nil {
 		return err
 	}
+	// CreateTablesIfNotExists does not add columns to existing tables
+	userColumns := [][]string{
+		{"storage_quota", "bigint not null default 0"},
+		// Users from before registration were all created by staff
+		{"verified", "boolean not null default true"},
+		{"session_version", "bigint not null default 0"},
+	}
+	for _, column := range userColumns {
+		err = addColumnIfMissing(UserTable, column[0], column[1], dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	// FindUserByEmail ignores case, so two accounts whose emails differ only in case could not log in
+	_, err = dbInfo.Map.Exec("create unique index if not exists users_lower_email on " + UserTable + " (lower(email))")
+	if err != nil {
+		return errors.New("Could not make emails unique regardless of case, merge accounts whose emails differ only in case: " + err.Error())
+	}
 	return nil
 }
 
+func addColumnIfMissing(table string, column string, definition string, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("alter table " + table + " add column if not exists " + column + " " + definition)
+	return err
+}
+
 func WipeDB(dbInfo *DBInfo) error {
 	err := dbInfo.Map.DropTables()
 	if err != nil {
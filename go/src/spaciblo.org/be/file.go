@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,7 +23,10 @@ A derivate of "" indicates that this is the original file, otherwise it indicate
 For example, a derivative of "fit-crop-200x200" of an image would be a fit-cropped version of maximum size 200x200.
 The key is the same for the original file and its derivatives.
 
-LocalFileStorage implements FileStorage and uses the local file system, but it should also be possible to create FileStorage backed by services like S3.
+LocalFileStorage implements FileStorage and uses the local file system.
+ContentFileStorage also uses the local file system but stores identical data once, with reference counted keys.
+S3FileStorage uses a bucket of an S3 compatible object storage service.
+NewFileStorageFromEnv chooses between them.
 */
 type FileStorage interface {
 	Put(name string, reader io.Reader) (key string, err error)
@@ -49,6 +54,73 @@ type File interface {
 	Reader() (io.Reader, error)
 }
 
+/*
+RedirectableFile is a File that clients can fetch directly from its storage service, like an S3File with a presigned URL.
+RedirectURL returns "" when the File should be served normally.
+*/
+type RedirectableFile interface {
+	File
+	RedirectURL() (string, error)
+}
+
+/*
+NewFileStorageFromEnv returns the FileStorage configured by environment variables.
+
+If FILE_STORAGE_S3_BUCKET is set it returns an S3FileStorage using:
+
+	FILE_STORAGE_S3_ENDPOINT (defaults to AWS: https://s3.<region>.amazonaws.com)
+	FILE_STORAGE_S3_REGION (defaults to us-east-1)
+	FILE_STORAGE_S3_PREFIX (optional, prepended to every object name)
+	FILE_STORAGE_S3_ACCESS_KEY and FILE_STORAGE_S3_SECRET_KEY (defaulting to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)
+	FILE_STORAGE_S3_PRESIGN_SECONDS (optional, serve files by redirecting to presigned URLs that expire after this many seconds)
+
+Otherwise it returns a ContentFileStorage in FILE_STORAGE_DIR.
+*/
+func NewFileStorageFromEnv() (FileStorage, error) {
+	bucket := os.Getenv("FILE_STORAGE_S3_BUCKET")
+	if bucket == "" {
+		fsDir := os.Getenv("FILE_STORAGE_DIR")
+		if fsDir == "" {
+			return nil, errors.New("No FILE_STORAGE_DIR or FILE_STORAGE_S3_BUCKET env variable")
+		}
+		fs, err := NewContentFileStorage(fsDir)
+		if err != nil {
+			return nil, errors.New("Could not open file storage directory: " + fsDir)
+		}
+		return fs, nil
+	}
+
+	region := os.Getenv("FILE_STORAGE_S3_REGION")
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	endpoint := os.Getenv("FILE_STORAGE_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	accessKey := os.Getenv("FILE_STORAGE_S3_ACCESS_KEY")
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := os.Getenv("FILE_STORAGE_S3_SECRET_KEY")
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	fs, err := NewS3FileStorage(endpoint, region, bucket, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	fs.Prefix = os.Getenv("FILE_STORAGE_S3_PREFIX")
+	if presignSeconds := os.Getenv("FILE_STORAGE_S3_PRESIGN_SECONDS"); presignSeconds != "" {
+		seconds, err := strconv.ParseInt(presignSeconds, 10, 64)
+		if err != nil {
+			return nil, errors.New("FILE_STORAGE_S3_PRESIGN_SECONDS must be a number of seconds: " + presignSeconds)
+		}
+		fs.PresignExpiry = time.Duration(seconds) * time.Second
+	}
+	return fs, nil
+}
+
 /*
 LocalFileStorage is a FileStorage persisted by the local file system
 */
@@ -322,3 +394,38 @@ func (lf LocalFile) delete() error {
 func (lf LocalFile) path() string {
 	return path.Join(lf.dir, lf.key)
 }
+
+/*
+CopyFile stores a copy of the original file for key under a new key and returns the new key
+Derivatives are not copied because they can be recreated from the copy.
+*/
+func CopyFile(fileStorage FileStorage, key string) (string, error) {
+	file, err := fileStorage.Get(key, "")
+	if err != nil {
+		return "", err
+	}
+	name, err := file.Name()
+	if err != nil {
+		return "", err
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return fileStorage.Put(name, reader)
+}
+
+/*
+FileETag returns a strong HTTP entity tag for the File
+FileStorage never changes the data stored under a key, so the key and derivative identify the bytes.
+*/
+func FileETag(file File) string {
+	tag := file.Key()
+	if file.Derivative() != "" {
+		tag += "/" + file.Derivative()
+	}
+	return `"` + strings.Replace(tag, `"`, "", -1) + `"`
+}
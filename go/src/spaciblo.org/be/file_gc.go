@@ -0,0 +1,115 @@
+package be
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+GarbageCollectedFileStorage is a FileStorage that can delete the files that nothing references.
+
+CollectGarbage deletes originals whose keys are not in liveKeys and that were stored before the given time, along with their derivatives.
+It also deletes derivatives of missing originals and partial uploads left by crashes.
+The grace period between before and now protects files that were just stored and are not yet referenced.
+*/
+type GarbageCollectedFileStorage interface {
+	FileStorage
+	CollectGarbage(liveKeys map[string]bool, before time.Time) (*FileGCReport, error)
+}
+
+/*
+FileGCReport counts what CollectGarbage deleted and the space it reclaimed
+*/
+type FileGCReport struct {
+	Originals   int   `json:"originals"`
+	Derivatives int   `json:"derivatives"`
+	TempFiles   int   `json:"tempFiles"`
+	Bytes       int64 `json:"bytes"`
+}
+
+func (report *FileGCReport) String() string {
+	return "Deleted " + strconv.Itoa(report.Originals) + " originals, " + strconv.Itoa(report.Derivatives) + " derivatives, and " + strconv.Itoa(report.TempFiles) + " temp files, reclaiming " + strconv.FormatInt(report.Bytes, 10) + " bytes"
+}
+
+func (fs LocalFileStorage) CollectGarbage(liveKeys map[string]bool, before time.Time) (*FileGCReport, error) {
+	report := &FileGCReport{}
+	err := fs.collectGarbage(liveKeys, before, fs.derivativeDirPaths(), report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+/*
+collectGarbage deletes unreferenced originals in the RootDir, derivatives in derivativeDirs whose originals are gone, and old temp files
+Only files named like LocalFileStorage keys are considered so that ContentFileStorage can share the RootDir.
+*/
+func (fs LocalFileStorage) collectGarbage(liveKeys map[string]bool, before time.Time, derivativeDirs []string, report *FileGCReport) error {
+	infos, err := ioutil.ReadDir(fs.RootDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.Contains(info.Name(), keySeparator) || liveKeys[info.Name()] || !info.ModTime().Before(before) {
+			continue
+		}
+		err = os.Remove(path.Join(fs.RootDir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		report.Originals += 1
+		report.Bytes += info.Size()
+	}
+
+	for _, derivative := range derivativeDirs {
+		derivativeDir := path.Join(fs.RootDir, derivative)
+		infos, err := ioutil.ReadDir(derivativeDir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.IsDir() || !strings.Contains(info.Name(), keySeparator) {
+				continue
+			}
+			if _, err := os.Stat(path.Join(fs.RootDir, info.Name())); err == nil {
+				continue
+			}
+			err = os.Remove(path.Join(derivativeDir, info.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			report.Derivatives += 1
+			report.Bytes += info.Size()
+		}
+	}
+	return fs.collectTempFiles(before, report)
+}
+
+/*
+collectTempFiles deletes files in the temp dir that were left before the given time by uploads that crashed before moving them into place
+*/
+func (fs LocalFileStorage) collectTempFiles(before time.Time, report *FileGCReport) error {
+	tempDir := path.Join(fs.RootDir, localFileStorageTempName)
+	infos, err := ioutil.ReadDir(tempDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !info.ModTime().Before(before) {
+			continue
+		}
+		err = os.Remove(path.Join(tempDir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		report.TempFiles += 1
+		report.Bytes += info.Size()
+	}
+	return nil
+}
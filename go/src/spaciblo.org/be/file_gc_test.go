@@ -0,0 +1,96 @@
+package be
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestLocalFileGarbageCollection(t *testing.T) {
+	fsDir, err := ioutil.TempDir(os.TempDir(), "be-test-fs")
+	AssertNil(t, err, "Could not create fsDir: "+fsDir)
+	defer func() {
+		err = os.RemoveAll(fsDir)
+		AssertNil(t, err, "Could not clean up fsDir: "+fsDir)
+	}()
+	testFS, err := NewLocalFileStorage(fsDir)
+	AssertNil(t, err)
+
+	liveKey, err := testFS.Put("live.png", strings.NewReader("live"))
+	AssertNil(t, err)
+	deadKey, err := testFS.Put("dead.png", strings.NewReader("dead"))
+	AssertNil(t, err)
+	AssertNil(t, testFS.PutDerivative(deadKey, "thumb", strings.NewReader("d")))
+	tempDir, err := testFS.getOrCreateTempDir()
+	AssertNil(t, err)
+	AssertNil(t, ioutil.WriteFile(path.Join(tempDir, "crashed"), []byte("partial"), 0664))
+	liveKeys := map[string]bool{liveKey: true}
+
+	// Files newer than the grace period are kept
+	report, err := testFS.CollectGarbage(liveKeys, time.Now().Add(-time.Hour))
+	AssertNil(t, err)
+	AssertEqual(t, FileGCReport{}, *report)
+
+	report, err = testFS.CollectGarbage(liveKeys, time.Now().Add(time.Second))
+	AssertNil(t, err)
+	AssertEqual(t, FileGCReport{Originals: 1, Derivatives: 1, TempFiles: 1, Bytes: 12}, *report)
+	exists, err := testFS.Exists(liveKey, "")
+	AssertNil(t, err)
+	AssertTrue(t, exists)
+	exists, err = testFS.Exists(deadKey, "")
+	AssertNil(t, err)
+	AssertFalse(t, exists)
+	exists, err = testFS.Exists(deadKey, "thumb")
+	AssertNil(t, err)
+	AssertFalse(t, exists)
+}
+
+func TestContentFileGarbageCollection(t *testing.T) {
+	fsDir, err := ioutil.TempDir(os.TempDir(), "be-test-fs")
+	AssertNil(t, err, "Could not create fsDir: "+fsDir)
+	defer func() {
+		err = os.RemoveAll(fsDir)
+		AssertNil(t, err, "Could not clean up fsDir: "+fsDir)
+	}()
+	testFS, err := NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+	localFS, err := NewLocalFileStorage(fsDir)
+	AssertNil(t, err)
+
+	// A dead key for live data only drops the reference
+	liveKey, err := testFS.Put("live.png", strings.NewReader("shared"))
+	AssertNil(t, err)
+	sharedDeadKey, err := testFS.Put("dead.png", strings.NewReader("shared"))
+	AssertNil(t, err)
+	deadKey, err := testFS.Put("dead.png", strings.NewReader("dead"))
+	AssertNil(t, err)
+	AssertNil(t, testFS.PutDerivative(deadKey, "thumb", strings.NewReader("d")))
+	AssertNil(t, testFS.PutDerivative(liveKey, "thumb", strings.NewReader("s")))
+	legacyKey, err := localFS.Put("legacy.png", strings.NewReader("legacy"))
+	AssertNil(t, err)
+	liveKeys := map[string]bool{liveKey: true}
+
+	report, err := testFS.CollectGarbage(liveKeys, time.Now().Add(-time.Hour))
+	AssertNil(t, err)
+	AssertEqual(t, FileGCReport{}, *report)
+
+	report, err = testFS.CollectGarbage(liveKeys, time.Now().Add(time.Second))
+	AssertNil(t, err)
+	AssertEqual(t, FileGCReport{Originals: 3, Derivatives: 1, Bytes: 11}, *report)
+	for _, key := range []string{sharedDeadKey, deadKey, legacyKey} {
+		exists, err := testFS.Exists(key, "")
+		AssertNil(t, err)
+		AssertFalse(t, exists, key)
+	}
+	exists, err := testFS.Exists(liveKey, "thumb")
+	AssertNil(t, err)
+	AssertTrue(t, exists)
+	infos, err := ioutil.ReadDir(path.Join(fsDir, contentFileStorageBlobsName))
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(infos))
+}
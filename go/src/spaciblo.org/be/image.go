@@ -7,6 +7,7 @@ import (
 	"github.com/nfnt/resize"
 	"image"
 	"image/draw"
+	"io"
 	"math"
 
 	_ "image/gif"
@@ -14,6 +15,27 @@ import (
 	_ "image/png"
 )
 
+/*
+MaxImagePixels limits the width times height of images that are decoded for derivatives
+A small compressed file can claim dimensions that would take gigabytes to decode, so the size is checked before decoding.
+*/
+var MaxImagePixels = 4096 * 4096
+
+/*
+DecodeImage decodes the image from reader after checking that its dimensions are within MaxImagePixels
+*/
+func DecodeImage(reader io.Reader) (image.Image, string, error) {
+	header := new(bytes.Buffer)
+	config, _, err := image.DecodeConfig(io.TeeReader(reader, header))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxImagePixels/config.Height {
+		return nil, "", errors.New(fmt.Sprintf("Image dimensions are too large: %dx%d", config.Width, config.Height))
+	}
+	return image.Decode(io.MultiReader(header, reader))
+}
+
 /*
 FitCrop gets from or creates in fileStorage a fit-cropped derivative of the File with Key key
 */
@@ -41,7 +63,7 @@ func FitCrop(maxWidth int, maxHeight int, key string, fileStorage FileStorage) (
 	if err != nil {
 		return nil, err
 	}
-	origImage, _, err := image.Decode(reader)
+	origImage, _, err := DecodeImage(reader)
 	if err != nil {
 		return nil, err
 	}
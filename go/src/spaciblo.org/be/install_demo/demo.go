@@ -12,6 +12,7 @@ import (
 	"strconv"
 
 	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/api/geometry"
 	"spaciblo.org/be"
 	"spaciblo.org/db"
 )
@@ -25,14 +26,9 @@ const DEMO_SPACES_DIR = "spaces"
 const DEMO_SPACE_FILE_NAME = "space.json"
 
 func main() {
-	fsDir := os.Getenv("FILE_STORAGE_DIR")
-	if fsDir == "" {
-		logger.Panic("No FILE_STORAGE_DIR end variable")
-		return
-	}
-	fs, err := be.NewLocalFileStorage(fsDir)
+	fs, err := be.NewFileStorageFromEnv()
 	if err != nil {
-		logger.Panic("Could not open file storage directory: " + fsDir)
+		logger.Panic("Could not open file storage: " + err.Error())
 		return
 	}
 
@@ -255,7 +251,7 @@ func createSpace(directory string, name string, avatarUUID string, dbInfo *be.DB
 	buff := bytes.NewBufferString("")
 	state.Encode(buff)
 
-	record, err := apiDB.CreateSpaceRecord(name, buff.String(), avatarUUID, dbInfo)
+	record, err := apiDB.CreateSpaceRecord(name, buff.String(), avatarUUID, "", dbInfo)
 	if err != nil {
 		logger.Fatal("Could not create space record", err)
 		return nil, err
@@ -264,20 +260,20 @@ func createSpace(directory string, name string, avatarUUID string, dbInfo *be.DB
 	return record, nil
 }
 
-func createTemplate(directory string, name string, dbInfo *be.DBInfo, fs *be.LocalFileStorage) (*apiDB.TemplateRecord, error) {
+func createTemplate(directory string, name string, dbInfo *be.DBInfo, fs be.FileStorage) (*apiDB.TemplateRecord, error) {
 	dataFileInfos, err := ioutil.ReadDir(directory)
 	if err != nil {
 		logger.Fatal("Could not read a template dir %s: %s", directory, err)
 		return nil, err
 	}
 
-	// Find a glTF or obj source file and scripts
+	// Find a glTF, glb, or obj source file and scripts
 	var sourceInfo os.FileInfo
 	var clientScriptName = ""
 	var simScriptName = ""
 	var thumbnailName = ""
 	for _, dataInfo := range dataFileInfos {
-		if dataInfo.Name() == name+".gltf" {
+		if dataInfo.Name() == name+".gltf" || dataInfo.Name() == name+".glb" {
 			sourceInfo = dataInfo
 			continue
 		}
@@ -327,6 +323,10 @@ func createTemplate(directory string, name string, dbInfo *be.DBInfo, fs *be.Loc
 		}
 	}
 
+	if geometry.CanValidate(sourceInfo.Name()) {
+		recordGeometryStats(directory, sourceInfo.Name(), template, fs, dbInfo)
+	}
+
 	for _, dataInfo := range dataFileInfos {
 		if dataInfo.Name() == thumbnailName || dataInfo.Name() == ".DS_Store" {
 			continue
@@ -341,7 +341,7 @@ func createTemplate(directory string, name string, dbInfo *be.DBInfo, fs *be.Loc
 			return nil, err
 		}
 
-		key, err := fs.Put(dataInfo.Name(), dataFile)
+		key, err := apiDB.StoreTemplateDataFile(dataInfo.Name(), dataFile, fs, dbInfo)
 		if err != nil {
 			logger.Fatal("Store a data file %s:", err)
 			return nil, err
@@ -355,6 +355,53 @@ func createTemplate(directory string, name string, dbInfo *be.DBInfo, fs *be.Loc
 	return template, nil
 }
 
+/*
+recordGeometryStats validates a glTF, glb, or OBJ source file against the other files in the template directory and records its stats on the template
+Templates without a thumbnail.jpg get a rendered image.
+*/
+func recordGeometryStats(directory string, sourceName string, template *apiDB.TemplateRecord, fs be.FileStorage, dbInfo *be.DBInfo) {
+	data, err := ioutil.ReadFile(path.Join(directory, sourceName))
+	if err != nil {
+		logger.Println("Could not read the geometry", err)
+		return
+	}
+	resolve := func(uri string) (int64, error) {
+		info, err := os.Stat(path.Join(directory, path.Base(uri)))
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	load := func(name string) ([]byte, error) {
+		return ioutil.ReadFile(path.Join(directory, path.Base(name)))
+	}
+	stats, err := geometry.Validate(sourceName, data, resolve, load)
+	if err != nil {
+		logger.Printf("\t\tInvalid geometry %s: %s", sourceName, err)
+		return
+	}
+	err = apiDB.SetCurrentGeometryStats(template, stats, dbInfo)
+	if err != nil {
+		logger.Println("Could not record the geometry stats", err)
+		return
+	}
+	if template.Image == "" {
+		imageData, err := apiDB.RenderGeometryImage(sourceName, data, load)
+		if err != nil {
+			logger.Printf("\t\tCould not render %s: %s", sourceName, err)
+		} else {
+			template.Image, err = fs.Put("template_image.jpg", bytes.NewReader(imageData))
+			if err != nil {
+				logger.Println("Could not store the rendered image", err)
+			}
+		}
+	}
+	err = apiDB.UpdateTemplateRecord(template, dbInfo)
+	if err != nil {
+		logger.Println("Could not update the template", err)
+	}
+}
+
 func createUser(email string, firstName string, lastName string, staff bool, password string, avatarUUID string, dbInfo *be.DBInfo) (*be.User, error) {
 	user, err := be.CreateUser(email, firstName, lastName, staff, avatarUUID, dbInfo)
 	if err != nil {
@@ -0,0 +1,512 @@
+/*
+Spawn many synthetic WebSocket clients into a space and report how the ws and sim services hold up.
+*/
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"spaciblo.org/sim"
+	"spaciblo.org/ws"
+	wsClient "spaciblo.org/ws/client"
+)
+
+var logger = log.New(os.Stdout, "[load] ", 0)
+
+const RANDOM_PATH = "random"
+const CIRCLE_PATH = "circle"
+
+const LATE_FRAME_FACTOR = 1.5 // A frame is late if it arrives this many tick durations after the previous frame
+const MAX_PENDING_MOTIONS = 100
+
+type Config struct {
+	WSURL          string
+	SpaceUUID      string
+	Session        string
+	Clients        int
+	Avatars        int           // How many of the clients join with an avatar
+	Duration       time.Duration // How long to run after the last client connects
+	Ramp           time.Duration // Time between client connections
+	MotionRate     float64       // Avatar motion messages per second for each client
+	PingRate       float64       // Pings per second for each client
+	Speed          float64       // Meters per second
+	Path           string        // RANDOM_PATH or CIRCLE_PATH
+	ReportInterval time.Duration
+}
+
+func main() {
+	config, err := readConfig()
+	if err != nil {
+		logger.Fatal("Configuration error: ", err)
+		return
+	}
+	stats := NewStats()
+	stopChannel := make(chan bool)
+	interruptChannel := make(chan os.Signal, 1)
+	signal.Notify(interruptChannel, os.Interrupt)
+
+	logger.Printf("Starting %d clients (%d with avatars) in space %s", config.Clients, config.Avatars, config.SpaceUUID)
+	waitGroup := &sync.WaitGroup{}
+	loadClients := []*LoadClient{}
+	startTime := time.Now()
+	for i := 0; i < config.Clients; i++ {
+		loadClient := NewLoadClient(i, config, stats)
+		err = loadClient.Start()
+		if err != nil {
+			logger.Println("Could not start client", i, err)
+			stats.ConnectionFailed()
+		} else {
+			loadClients = append(loadClients, loadClient)
+			waitGroup.Add(1)
+			go loadClient.Run(stopChannel, waitGroup)
+		}
+		if config.Ramp > 0 && i < config.Clients-1 {
+			time.Sleep(config.Ramp)
+		}
+	}
+
+	reportTicker := time.NewTicker(config.ReportInterval)
+	endTimer := time.NewTimer(config.Duration)
+	running := true
+	for running {
+		select {
+		case <-reportTicker.C:
+			stats.Report(loadClients, time.Since(startTime))
+		case <-endTimer.C:
+			running = false
+		case <-interruptChannel:
+			running = false
+		}
+	}
+	reportTicker.Stop()
+	close(stopChannel)
+	waitGroup.Wait()
+	logger.Println("Final report:")
+	stats.Report(loadClients, time.Since(startTime))
+}
+
+func readConfig() (*Config, error) {
+	config := &Config{
+		WSURL:     os.Getenv("LOAD_TEST_WS_URL"),
+		SpaceUUID: os.Getenv("LOAD_TEST_SPACE_UUID"),
+		Session:   os.Getenv("LOAD_TEST_SESSION"),
+		Path:      os.Getenv("LOAD_TEST_PATH"),
+	}
+	if config.WSURL == "" {
+		config.WSURL = "wss://127.0.0.1:9020/ws"
+	}
+	if config.SpaceUUID == "" {
+		return nil, fmt.Errorf("No LOAD_TEST_SPACE_UUID env variable")
+	}
+	if config.Path == "" {
+		config.Path = RANDOM_PATH
+	}
+	if config.Path != RANDOM_PATH && config.Path != CIRCLE_PATH {
+		return nil, fmt.Errorf("Unknown LOAD_TEST_PATH: %s", config.Path)
+	}
+	var err error
+	if config.Clients, err = intEnv("LOAD_TEST_CLIENTS", 10); err != nil {
+		return nil, err
+	}
+	if config.Avatars, err = intEnv("LOAD_TEST_AVATARS", config.Clients); err != nil {
+		return nil, err
+	}
+	if config.Avatars > config.Clients {
+		config.Avatars = config.Clients
+	}
+	seconds, err := floatEnv("LOAD_TEST_DURATION", 60)
+	if err != nil {
+		return nil, err
+	}
+	config.Duration = time.Duration(seconds * float64(time.Second))
+	milliseconds, err := intEnv("LOAD_TEST_RAMP", 100)
+	if err != nil {
+		return nil, err
+	}
+	config.Ramp = time.Duration(milliseconds) * time.Millisecond
+	if config.MotionRate, err = floatEnv("LOAD_TEST_MOTION_RATE", 10); err != nil {
+		return nil, err
+	}
+	if config.PingRate, err = floatEnv("LOAD_TEST_PING_RATE", 1); err != nil {
+		return nil, err
+	}
+	if config.Speed, err = floatEnv("LOAD_TEST_SPEED", 1.5); err != nil {
+		return nil, err
+	}
+	seconds, err = floatEnv("LOAD_TEST_REPORT_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+	config.ReportInterval = time.Duration(seconds * float64(time.Second))
+	if config.Clients <= 0 || config.MotionRate <= 0 || config.PingRate <= 0 || config.Duration <= 0 || config.ReportInterval <= 0 {
+		return nil, fmt.Errorf("Client count, rates, duration, and report interval must be greater than zero")
+	}
+	return config, nil
+}
+
+func intEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse %s: %s", name, value)
+	}
+	return result, nil
+}
+
+func floatEnv(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse %s: %s", name, value)
+	}
+	return result, nil
+}
+
+/*
+LoadClient is one synthetic user which connects, joins the space, and then walks a path while pinging the ws service
+*/
+type LoadClient struct {
+	Index    int
+	Config   *Config
+	Stats    *Stats
+	Avatar   bool
+	WSClient *wsClient.Client
+
+	position []float64
+	heading  float64 // Radians
+
+	lastFrame      int64
+	lastFrameTime  time.Time
+	pendingMotions map[string]time.Time // <position key, time sent>
+	pendingLock    sync.Mutex
+	closing        int32 // Set to 1 before the load tester closes the connection, so that OnClose does not count it as a disconnection
+}
+
+func NewLoadClient(index int, config *Config, stats *Stats) *LoadClient {
+	loadClient := &LoadClient{
+		Index:          index,
+		Config:         config,
+		Stats:          stats,
+		Avatar:         index < config.Avatars,
+		WSClient:       wsClient.NewClient(config.WSURL, config.Session),
+		position:       []float64{rand.Float64()*10 - 5, 0, rand.Float64()*10 - 5},
+		heading:        rand.Float64() * 2 * math.Pi,
+		lastFrame:      -1,
+		pendingMotions: make(map[string]time.Time),
+	}
+	loadClient.WSClient.OnSpaceUpdate = loadClient.handleSpaceUpdate
+	loadClient.WSClient.OnAck = loadClient.handleAck
+	loadClient.WSClient.OnClose = func(err error) {
+		if atomic.LoadInt32(&loadClient.closing) == 0 {
+			stats.Disconnected()
+		}
+	}
+	return loadClient
+}
+
+func (loadClient *LoadClient) Start() error {
+	err := loadClient.WSClient.Connect()
+	if err != nil {
+		return err
+	}
+	err = loadClient.WSClient.WaitForConnected(10 * time.Second)
+	if err != nil {
+		loadClient.Close()
+		return err
+	}
+	return loadClient.WSClient.JoinSpace(loadClient.Config.SpaceUUID, loadClient.Avatar)
+}
+
+func (loadClient *LoadClient) Run(stopChannel chan bool, waitGroup *sync.WaitGroup) {
+	defer waitGroup.Done()
+	defer loadClient.Close()
+
+	motionTicker := time.NewTicker(time.Duration(float64(time.Second) / loadClient.Config.MotionRate))
+	defer motionTicker.Stop()
+	pingTicker := time.NewTicker(time.Duration(float64(time.Second) / loadClient.Config.PingRate))
+	defer pingTicker.Stop()
+	for {
+		select {
+		case <-stopChannel:
+			return
+		case <-pingTicker.C:
+			err := loadClient.WSClient.Ping(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err != nil {
+				loadClient.Stats.SendFailed()
+			}
+		case <-motionTicker.C:
+			if loadClient.Avatar == false {
+				continue
+			}
+			loadClient.step(1 / loadClient.Config.MotionRate)
+			sentAt := time.Now()
+			loadClient.addPendingMotion(loadClient.position, sentAt)
+			err := loadClient.WSClient.SendAvatarMotion(
+				loadClient.position,
+				[]float64{0, math.Sin(loadClient.heading / 2), 0, math.Cos(loadClient.heading / 2)},
+				[]float64{0, 0, -loadClient.Config.Speed},
+				[]float64{0, 0, 0},
+				[]float64{1, 1, 1},
+				nil,
+			)
+			if err != nil {
+				loadClient.Stats.SendFailed()
+			}
+		}
+	}
+}
+
+// step moves the client along its path
+/*
+Close ends the connection without counting it as a disconnection
+*/
+func (loadClient *LoadClient) Close() error {
+	atomic.StoreInt32(&loadClient.closing, 1)
+	return loadClient.WSClient.Close()
+}
+
+func (loadClient *LoadClient) step(seconds float64) {
+	distance := loadClient.Config.Speed * seconds
+	if loadClient.Config.Path == CIRCLE_PATH {
+		// Walk a circle with a five meter radius, so the turn rate depends on the speed
+		loadClient.heading += distance / 5
+	} else {
+		loadClient.heading += (rand.Float64() - 0.5) * 0.5
+	}
+	loadClient.position = []float64{
+		loadClient.position[0] - math.Sin(loadClient.heading)*distance,
+		loadClient.position[1],
+		loadClient.position[2] - math.Cos(loadClient.heading)*distance,
+	}
+}
+
+func positionKey(position []float64) string {
+	return fmt.Sprintf("%.6f,%.6f,%.6f", position[0], position[1], position[2])
+}
+
+func (loadClient *LoadClient) addPendingMotion(position []float64, sentAt time.Time) {
+	loadClient.pendingLock.Lock()
+	defer loadClient.pendingLock.Unlock()
+	if len(loadClient.pendingMotions) >= MAX_PENDING_MOTIONS {
+		// The sim only replicates the latest position each tick, so forget the old ones
+		for key, pendingAt := range loadClient.pendingMotions {
+			if sentAt.Sub(pendingAt) > time.Second {
+				delete(loadClient.pendingMotions, key)
+			}
+		}
+	}
+	loadClient.pendingMotions[positionKey(position)] = sentAt
+}
+
+func (loadClient *LoadClient) takePendingMotion(position []float64) (time.Time, bool) {
+	loadClient.pendingLock.Lock()
+	defer loadClient.pendingLock.Unlock()
+	key := positionKey(position)
+	sentAt, ok := loadClient.pendingMotions[key]
+	if ok {
+		delete(loadClient.pendingMotions, key)
+	}
+	return sentAt, ok
+}
+
+func (loadClient *LoadClient) handleAck(message string) {
+	sentNanos, err := strconv.ParseInt(message, 10, 64)
+	if err != nil {
+		return // The Ack for Join-Space
+	}
+	loadClient.Stats.AddPingLatency(time.Since(time.Unix(0, sentNanos)))
+}
+
+func (loadClient *LoadClient) handleSpaceUpdate(update *ws.SpaceUpdateMessage) {
+	now := time.Now()
+	if loadClient.lastFrame >= 0 {
+		if update.Frame <= loadClient.lastFrame {
+			// The initial additions for a joining client share the frame of the regular update
+			if update.Frame < loadClient.lastFrame {
+				loadClient.Stats.AddOutOfOrderFrame()
+			}
+		} else {
+			interval := now.Sub(loadClient.lastFrameTime)
+			late := interval > time.Duration(LATE_FRAME_FACTOR*float64(sim.TICK_DURATION))
+			loadClient.Stats.AddFrame(update.Frame-loadClient.lastFrame-1, interval, late)
+		}
+	}
+	if update.Frame >= loadClient.lastFrame {
+		loadClient.lastFrame = update.Frame
+		loadClient.lastFrameTime = now
+	}
+
+	if loadClient.Avatar == false {
+		return
+	}
+	avatarNode := loadClient.WSClient.AvatarNode()
+	if avatarNode == nil {
+		return
+	}
+	for _, nodeUpdate := range update.NodeUpdates {
+		if nodeUpdate.Id != avatarNode.Id || len(nodeUpdate.Position) != 3 {
+			continue
+		}
+		sentAt, ok := loadClient.takePendingMotion(nodeUpdate.Position)
+		if ok {
+			loadClient.Stats.AddMotionLatency(now.Sub(sentAt))
+		}
+	}
+}
+
+/*
+Stats collects the measurements from all of the LoadClients
+*/
+type Stats struct {
+	lock sync.Mutex
+
+	connectionFailures int
+	disconnections     int
+	sendFailures       int
+
+	pingLatencies   []time.Duration
+	motionLatencies []time.Duration
+	frameIntervals  []time.Duration
+	frames          int64
+	droppedFrames   int64
+	lateFrames      int64
+	outOfOrder      int64
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		pingLatencies:   []time.Duration{},
+		motionLatencies: []time.Duration{},
+		frameIntervals:  []time.Duration{},
+	}
+}
+
+func (stats *Stats) ConnectionFailed() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.connectionFailures += 1
+}
+
+func (stats *Stats) Disconnected() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.disconnections += 1
+}
+
+func (stats *Stats) SendFailed() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.sendFailures += 1
+}
+
+func (stats *Stats) AddPingLatency(latency time.Duration) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.pingLatencies = append(stats.pingLatencies, latency)
+}
+
+func (stats *Stats) AddMotionLatency(latency time.Duration) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.motionLatencies = append(stats.motionLatencies, latency)
+}
+
+func (stats *Stats) AddFrame(skipped int64, interval time.Duration, late bool) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.frames += 1
+	stats.droppedFrames += skipped
+	stats.frameIntervals = append(stats.frameIntervals, interval)
+	if late {
+		stats.lateFrames += 1
+	}
+}
+
+func (stats *Stats) AddOutOfOrderFrame() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stats.outOfOrder += 1
+}
+
+/*
+Report logs the stats gathered so far and then resets the latency and frame samples for the next interval
+*/
+func (stats *Stats) Report(loadClients []*LoadClient, elapsed time.Duration) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	var sent int64 = 0
+	var received int64 = 0
+	for _, loadClient := range loadClients {
+		sent += loadClient.WSClient.BytesSent()
+		received += loadClient.WSClient.BytesReceived()
+	}
+	seconds := elapsed.Seconds()
+	clientCount := float64(len(loadClients))
+	if clientCount == 0 {
+		clientCount = 1
+	}
+
+	logger.Printf("%.0fs: %d clients, %d connection failures, %d disconnections, %d send failures", seconds, len(loadClients), stats.connectionFailures, stats.disconnections, stats.sendFailures)
+	logger.Printf("  bandwidth per client: %.1f KB/s down, %.1f KB/s up", float64(received)/1024/seconds/clientCount, float64(sent)/1024/seconds/clientCount)
+	logger.Printf("  ping latency: %s", summarize(stats.pingLatencies))
+	logger.Printf("  motion latency: %s", summarize(stats.motionLatencies))
+	logger.Printf("  frames: %d received, %d dropped, %d late, %d out of order", stats.frames, stats.droppedFrames, stats.lateFrames, stats.outOfOrder)
+	logger.Printf("  server tick interval (expected %s): %s", sim.TICK_DURATION, summarize(stats.frameIntervals))
+
+	stats.pingLatencies = []time.Duration{}
+	stats.motionLatencies = []time.Duration{}
+	stats.frameIntervals = []time.Duration{}
+}
+
+func summarize(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "no samples"
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Sort(durationSlice(sorted))
+	var total time.Duration = 0
+	for _, duration := range sorted {
+		total += duration
+	}
+	return fmt.Sprintf("n=%d mean=%s p50=%s p95=%s p99=%s max=%s",
+		len(sorted),
+		total/time.Duration(len(sorted)),
+		percentile(sorted, 0.5),
+		percentile(sorted, 0.95),
+		percentile(sorted, 0.99),
+		sorted[len(sorted)-1],
+	)
+}
+
+type durationSlice []time.Duration
+
+func (slice durationSlice) Len() int           { return len(slice) }
+func (slice durationSlice) Less(i, j int) bool { return slice[i] < slice[j] }
+func (slice durationSlice) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+// percentile expects the durations to be sorted
+func percentile(sorted []time.Duration, fraction float64) time.Duration {
+	index := int(math.Ceil(fraction*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
@@ -0,0 +1,144 @@
+package be
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+MailMessage is a plain text email
+*/
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+/*
+Mailer sends MailMessages, like verification links, to users
+*/
+type Mailer interface {
+	Send(message *MailMessage) error
+}
+
+/*
+NewMailerFromEnv returns an SMTPMailer if the SMTP_HOST env variable is set and otherwise a LogMailer
+SMTPMailer also reads SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, and MAIL_FROM.
+*/
+func NewMailerFromEnv() (Mailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &LogMailer{}, nil
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		return nil, errors.New("No MAIL_FROM env variable")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
+
+/*
+SMTPMailer sends MailMessages through an SMTP server, using STARTTLS when the server offers it
+*/
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string // If empty, messages are sent without authentication
+	Password string
+	From     string
+}
+
+func (mailer *SMTPMailer) Send(message *MailMessage) error {
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+	data, err := FormatMailMessage(mailer.From, message, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(net.JoinHostPort(mailer.Host, mailer.Port), auth, mailer.From, []string{message.To}, data)
+}
+
+/*
+FormatMailMessage returns the RFC 5322 headers and body of message
+*/
+func FormatMailMessage(from string, message *MailMessage, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, message.To, message.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("Mail headers may not contain line breaks")
+		}
+	}
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("From: " + from + "\r\n")
+	buffer.WriteString("To: " + message.To + "\r\n")
+	buffer.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Subject) + "\r\n")
+	buffer.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(strings.Replace(message.Body, "\n", "\r\n", -1))
+	return buffer.Bytes(), nil
+}
+
+/*
+LogMailer writes MailMessages to the log instead of sending them, which is handy in development
+*/
+type LogMailer struct{}
+
+func (mailer *LogMailer) Send(message *MailMessage) error {
+	logger.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+/*
+MemoryMailer keeps sent MailMessages so that tests can read them
+*/
+type MemoryMailer struct {
+	Messages []*MailMessage
+	mutex    sync.Mutex
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{
+		Messages: []*MailMessage{},
+	}
+}
+
+func (mailer *MemoryMailer) Send(message *MailMessage) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.Messages = append(mailer.Messages, message)
+	return nil
+}
+
+/*
+LastMessageTo returns the most recent MailMessage sent to the address to, or nil if none were sent
+*/
+func (mailer *MemoryMailer) LastMessageTo(to string) *MailMessage {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	for i := len(mailer.Messages) - 1; i >= 0; i-- {
+		if mailer.Messages[i].To == to {
+			return mailer.Messages[i]
+		}
+	}
+	return nil
+}
@@ -50,6 +50,45 @@ func main() {
 		if err != nil {
 			logger.Println("Error:", err)
 		}
+	} else if os.Args[1] == "invite" {
+		// Leave the email empty to create an invite that anyone can use
+		invite, err := be.CreateRegistrationInvite(promptFor("email"), 0, dbInfo)
+		if err != nil {
+			logger.Println("Error:", err)
+			return
+		}
+		logger.Println("Invite code:", invite.Code)
+	} else if os.Args[1] == "reset_totp" {
+		// For people who have lost their authenticator app and their recovery codes
+		err := resetTOTP(promptFor("email"), dbInfo)
+		if err != nil {
+			logger.Println("Error:", err)
+		}
+	} else if os.Args[1] == "roles" {
+		for _, role := range be.Roles {
+			logger.Println(role.Name+":", strings.Join(role.Permissions, ", "))
+		}
+		// Leave the email empty to only list the roles
+		email := promptFor("email")
+		if email == "" {
+			return
+		}
+		roles, err := userRoles(email, dbInfo)
+		if err != nil {
+			logger.Println("Error:", err)
+			return
+		}
+		logger.Println(email+":", strings.Join(roles, ", "))
+	} else if os.Args[1] == "add_role" {
+		err := changeRole(promptFor("email"), promptFor("role"), true, dbInfo)
+		if err != nil {
+			logger.Println("Error:", err)
+		}
+	} else if os.Args[1] == "remove_role" {
+		err := changeRole(promptFor("email"), promptFor("role"), false, dbInfo)
+		if err != nil {
+			logger.Println("Error:", err)
+		}
 	} else {
 		logger.Println("unknown command:", os.Args[1])
 	}
@@ -84,15 +123,42 @@ func changePassword(email string, password string, dbInfo *be.DBInfo) error {
 	if err != nil {
 		return err
 	}
-	passwordRecord, err := be.FindPasswordByUserId(user.Id, dbInfo)
+	err = be.SetPassword(user.Id, password, dbInfo)
+	if err != nil {
+		return err
+	}
+	return be.InvalidateSessions(user, nil, dbInfo)
+}
+
+func resetTOTP(email string, dbInfo *be.DBInfo) error {
+	user, err := be.FindUserByEmail(email, dbInfo)
+	if err != nil {
+		return err
+	}
+	err = be.DeleteTOTP(user.Id, dbInfo)
 	if err != nil {
 		return err
 	}
-	err = passwordRecord.Encode(password)
+	return be.InvalidateSessions(user, nil, dbInfo)
+}
+
+func userRoles(email string, dbInfo *be.DBInfo) ([]string, error) {
+	user, err := be.FindUserByEmail(email, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return be.FindUserRoles(user.Id, dbInfo)
+}
+
+func changeRole(email string, role string, add bool, dbInfo *be.DBInfo) error {
+	user, err := be.FindUserByEmail(email, dbInfo)
 	if err != nil {
 		return err
 	}
-	return be.UpdatePassword(passwordRecord, dbInfo)
+	if add {
+		return be.AddUserRole(user.Id, role, dbInfo)
+	}
+	return be.RemoveUserRole(user.Id, role, dbInfo)
 }
 
 func createUser(email string, firstName string, lastName string, staff bool, password string, avatarUUID string, dbInfo *be.DBInfo) (*be.User, error) {
@@ -0,0 +1,645 @@
+package be
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OIDCIdentityTable = "oidc_identities"
+	OIDCLoginTable    = "oidc_logins"
+)
+
+// OIDCLoginLifetime is how long someone has to finish logging in at the provider
+var OIDCLoginLifetime = 10 * time.Minute
+
+// oidcClockSkew is how far the provider's clock may be ahead of ours when checking ID token expiration
+const oidcClockSkew = time.Minute
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+/*
+OIDCConfig holds the OpenID Connect providers that people may log in with
+*/
+type OIDCConfig struct {
+	Providers   []*OIDCProvider
+	LoggedInURL string // Where browsers are redirected after logging in
+}
+
+/*
+Provider returns the OIDCProvider with name, or nil if there is none
+*/
+func (config OIDCConfig) Provider(name string) *OIDCProvider {
+	for _, provider := range config.Providers {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+/*
+OIDCConfigFromEnv reads the comma separated provider names in OIDC_PROVIDERS and for each name, like corp, reads:
+
+	OIDC_CORP_ISSUER, like https://accounts.example.com
+	OIDC_CORP_CLIENT_ID
+	OIDC_CORP_CLIENT_SECRET (optional for public clients)
+	OIDC_CORP_DISPLAY_NAME (optional)
+	OIDC_CORP_SCOPES (optional, space separated, defaults to "openid email profile")
+	OIDC_CORP_CREATE_USERS (optional, true to create accounts for new people)
+
+OIDC_LOGGED_IN_URL is where browsers go after logging in, by default /
+*/
+func OIDCConfigFromEnv() (OIDCConfig, error) {
+	config := OIDCConfig{
+		LoggedInURL: os.Getenv("OIDC_LOGGED_IN_URL"),
+	}
+	if config.LoggedInURL == "" {
+		config.LoggedInURL = "/"
+	}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+		provider := &OIDCProvider{
+			Name:         name,
+			DisplayName:  os.Getenv(prefix + "DISPLAY_NAME"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return config, errors.New("OIDC provider " + name + " needs " + prefix + "ISSUER and " + prefix + "CLIENT_ID")
+		}
+		if value := os.Getenv(prefix + "CREATE_USERS"); value != "" {
+			createUsers, err := strconv.ParseBool(value)
+			if err != nil {
+				return config, errors.New(prefix + "CREATE_USERS must be true or false: " + value)
+			}
+			provider.CreateUsers = createUsers
+		}
+		config.Providers = append(config.Providers, provider)
+	}
+	return config, nil
+}
+
+/*
+OIDCProvider logs people in with the OpenID Connect authorization code flow and PKCE
+The provider's endpoints and signing keys are discovered from the Issuer and cached.
+*/
+type OIDCProvider struct {
+	Name         string // Used in URLs, so it should be lower case letters, numbers, and dashes
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // If empty, "openid email profile"
+	CreateUsers  bool     // If true, people whose verified email has no User get a new one
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+/*
+OIDCClaims are the parts of an ID token that login uses
+*/
+type OIDCClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        oidcAudience `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expires         int64        `json:"exp"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   oidcBool     `json:"email_verified"`
+	GivenName       string       `json:"given_name"`
+	FamilyName      string       `json:"family_name"`
+}
+
+// The aud claim may be a string or an array of strings
+type oidcAudience []string
+
+func (audience *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*audience = oidcAudience{single}
+		return nil
+	}
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	*audience = oidcAudience(multiple)
+	return err
+}
+
+// Some providers send email_verified as the string "true"
+type oidcBool bool
+
+func (value *oidcBool) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	*value = oidcBool(parsed)
+	return err
+}
+
+func (provider *OIDCProvider) Discover() (*oidcDiscovery, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+	discovery := new(oidcDiscovery)
+	err := getJSON(strings.TrimSuffix(provider.Issuer, "/")+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(provider.Issuer, "/") {
+		return nil, errors.New("The discovered issuer does not match: " + discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("The provider's discovery document is missing endpoints")
+	}
+	provider.discovery = discovery
+	return discovery, nil
+}
+
+/*
+AuthCodeURL returns the provider's URL where people log in, which then redirects to redirectURI with a code
+*/
+func (provider *OIDCProvider) AuthCodeURL(redirectURI string, state string, nonce string, verifier string) (string, error) {
+	discovery, err := provider.Discover()
+	if err != nil {
+		return "", err
+	}
+	scopes := provider.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+/*
+Exchange trades the code from the provider's redirect for the verified claims of the person who logged in
+*/
+func (provider *OIDCProvider) Exchange(code string, redirectURI string, verifier string, nonce string) (*OIDCClaims, error) {
+	discovery, err := provider.Discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {provider.ClientID},
+		"code_verifier": {verifier},
+	}
+	request, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if provider.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+	}
+	response, err := oidcHTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var tokens struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 || tokens.IDToken == "" {
+		return nil, errors.New(fmt.Sprintf("The token request failed with status %d: %s", response.StatusCode, tokens.Error))
+	}
+	claims, err := provider.VerifyIDToken(tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	// Some providers only return the email from the userinfo endpoint
+	if claims.Email == "" && discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		err = provider.fillFromUserinfo(claims, discovery.UserinfoEndpoint, tokens.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+func (provider *OIDCProvider) fillFromUserinfo(claims *OIDCClaims, endpoint string, accessToken string) error {
+	request, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Accept", "application/json")
+	response, err := oidcHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return errors.New("The userinfo request failed with status " + strconv.Itoa(response.StatusCode))
+	}
+	userinfo := new(OIDCClaims)
+	err = json.NewDecoder(response.Body).Decode(userinfo)
+	if err != nil {
+		return err
+	}
+	if userinfo.Subject != claims.Subject {
+		return errors.New("The userinfo subject does not match the ID token")
+	}
+	claims.Email = userinfo.Email
+	claims.EmailVerified = userinfo.EmailVerified
+	if claims.GivenName == "" {
+		claims.GivenName = userinfo.GivenName
+	}
+	if claims.FamilyName == "" {
+		claims.FamilyName = userinfo.FamilyName
+	}
+	return nil
+}
+
+/*
+VerifyIDToken checks the signature, issuer, audience, expiration, and nonce of an ID token and returns its claims
+RS256 and ES256 signatures are supported.
+*/
+func (provider *OIDCProvider) VerifyIDToken(rawToken string, nonce string) (*OIDCClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeJWTSegment(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	key, err := provider.publicKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	err = verifyJWTSignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+	claims := new(OIDCClaims)
+	err = decodeJWTSegment(parts[1], claims)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := provider.Discover()
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != discovery.Issuer {
+		return nil, errors.New("The ID token is from another issuer: " + claims.Issuer)
+	}
+	audienceMatches := false
+	for _, audience := range claims.Audience {
+		if audience == provider.ClientID {
+			audienceMatches = true
+		}
+	}
+	if audienceMatches == false || (claims.AuthorizedParty != "" && claims.AuthorizedParty != provider.ClientID) {
+		return nil, errors.New("The ID token is for another client")
+	}
+	if time.Unix(claims.Expires, 0).Add(oidcClockSkew).Before(time.Now()) {
+		return nil, errors.New("The ID token has expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("The ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("The ID token has no subject")
+	}
+	return claims, nil
+}
+
+/*
+publicKey returns the provider's signing key with keyID, fetching the keys again if the provider has rotated them
+*/
+func (provider *OIDCProvider) publicKey(keyID string) (crypto.PublicKey, error) {
+	discovery, err := provider.Discover()
+	if err != nil {
+		return nil, err
+	}
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if key := findJWK(provider.keys, keyID); key != nil {
+		return key, nil
+	}
+	keys, err := fetchJWKS(discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	provider.keys = keys
+	if key := findJWK(provider.keys, keyID); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("No provider key with id: " + keyID)
+}
+
+func findJWK(keys map[string]crypto.PublicKey, keyID string) crypto.PublicKey {
+	if keyID == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[keyID]
+}
+
+func fetchJWKS(jwksURI string) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	err := getJSON(jwksURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.KeyType {
+		case "RSA":
+			n, err := decodeJWKInt(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeJWKInt(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[jwk.KeyID] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if jwk.Curve != "P-256" {
+				continue
+			}
+			x, err := decodeJWKInt(jwk.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeJWKInt(jwk.Y)
+			if err != nil {
+				return nil, err
+			}
+			keys[jwk.KeyID] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func verifyJWTSignature(algorithm string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch algorithm {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if ok == false {
+			return errors.New("The RS256 ID token was signed with a non-RSA key")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if ok == false || len(signature) != 64 {
+			return errors.New("Malformed ES256 ID token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if ecdsa.Verify(ecKey, digest[:], r, s) == false {
+			return errors.New("Invalid ID token signature")
+		}
+		return nil
+	}
+	return errors.New("Unsupported ID token algorithm: " + algorithm)
+}
+
+func decodeJWTSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func getJSON(url string, target interface{}) error {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := oidcHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return errors.New("Non-200 status " + strconv.Itoa(response.StatusCode) + " getting " + url)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+/*
+PKCEChallenge returns the S256 code challenge for a PKCE code verifier
+*/
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+/*
+OIDCIdentity links a User to the subject that a provider identifies them by, so that changing their email at the provider keeps the link
+*/
+type OIDCIdentity struct {
+	Id       int64     `db:"id, primarykey, autoincrement"`
+	UserId   int64     `db:"user_id"`
+	Provider string    `db:"provider"`
+	Subject  string    `db:"subject"`
+	Created  time.Time `db:"created"`
+}
+
+/*
+OIDCLogin holds the PKCE verifier and nonce for a login that has gone to the provider and not yet come back
+The state in the provider's redirect finds the OIDCLogin, and the session must hold the same state.
+*/
+type OIDCLogin struct {
+	Id        int64     `db:"id, primarykey, autoincrement"`
+	StateHash string    `db:"state_hash"`
+	Provider  string    `db:"provider"`
+	Verifier  string    `db:"verifier"`
+	Nonce     string    `db:"nonce"`
+	Expires   time.Time `db:"expires"`
+}
+
+/*
+CreateOIDCLogin stores a new OIDCLogin and returns it with the state to send to the provider
+*/
+func CreateOIDCLogin(provider string, dbInfo *DBInfo) (*OIDCLogin, string, error) {
+	state, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+OIDCLoginTable+" where expires < $1", time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	login := &OIDCLogin{
+		StateHash: HashToken(state),
+		Provider:  provider,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		Expires:   time.Now().Add(OIDCLoginLifetime),
+	}
+	err = dbInfo.Map.Insert(login)
+	if err != nil {
+		return nil, "", err
+	}
+	return login, state, nil
+}
+
+/*
+RedeemOIDCLogin deletes and returns the unexpired OIDCLogin for state so that each login can only finish once
+*/
+func RedeemOIDCLogin(provider string, state string, dbInfo *DBInfo) (*OIDCLogin, error) {
+	login := new(OIDCLogin)
+	err := dbInfo.Map.SelectOne(login, "select * from "+OIDCLoginTable+" where state_hash=$1 and provider=$2", HashToken(state), provider)
+	if err != nil {
+		return nil, errors.New("No such login")
+	}
+	count, err := dbInfo.Map.Delete(login)
+	if err != nil {
+		return nil, err
+	}
+	if count != 1 {
+		return nil, errors.New("The login has already finished")
+	}
+	if time.Now().After(login.Expires) {
+		return nil, errors.New("The login has expired")
+	}
+	return login, nil
+}
+
+/*
+FindOrCreateOIDCUser returns the User linked to the claims' subject, linking or creating one by verified email if there is none
+*/
+func FindOrCreateOIDCUser(provider *OIDCProvider, claims *OIDCClaims, dbInfo *DBInfo) (*User, error) {
+	identity := new(OIDCIdentity)
+	err := dbInfo.Map.SelectOne(identity, "select * from "+OIDCIdentityTable+" where provider=$1 and subject=$2", provider.Name, claims.Subject)
+	if err == nil {
+		user := new(User)
+		err = dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where id=$1", identity.UserId)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if claims.Email == "" || bool(claims.EmailVerified) == false {
+		return nil, errors.New("The provider did not share a verified email")
+	}
+	user := new(User)
+	err = dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where lower(email)=lower($1)", claims.Email)
+	if err != nil {
+		if provider.CreateUsers == false {
+			return nil, errors.New("No account uses the email " + claims.Email)
+		}
+		user, err = CreateUser(claims.Email, claims.GivenName, claims.FamilyName, false, "", dbInfo)
+		if err != nil {
+			return nil, err
+		}
+	} else if user.Verified == false {
+		// The provider verified the email, which is all that registration was waiting for
+		user.Verified = true
+		err = UpdateUser(user, dbInfo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = dbInfo.Map.Insert(&OIDCIdentity{
+		UserId:   user.Id,
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Created:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,236 @@
+package be
+
+import (
+	"net/http"
+)
+
+// OIDCStateKey holds the state of a login that has gone to the provider, which the callback must match
+const OIDCStateKey string = "oidc-state"
+
+var OIDCProviderProperties = []Property{
+	Property{
+		Name:        "name",
+		Description: "The name used in login URLs",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "display-name",
+		Description: "The name to show on login buttons",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "login-url",
+		Description: "The URL that browsers should visit to log in with the provider",
+		DataType:    "string",
+		Protected:   true,
+	},
+}
+
+var OIDCProvidersProperties = NewAPIListProperties("oidc-provider")
+
+var OIDCLoginProperties = []Property{}
+
+type OIDCProviderData struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display-name"`
+	LoginURL    string `json:"login-url"`
+}
+
+/*
+OIDCProvidersResource lists the OpenID Connect providers that people can log in with
+*/
+type OIDCProvidersResource struct {
+	api *API
+}
+
+func NewOIDCProvidersResource(api *API) *OIDCProvidersResource {
+	return &OIDCProvidersResource{
+		api: api,
+	}
+}
+
+func (OIDCProvidersResource) Name() string  { return "oidc-providers" }
+func (OIDCProvidersResource) Path() string  { return "/user/oidc/" }
+func (OIDCProvidersResource) Title() string { return "Login providers" }
+func (OIDCProvidersResource) Description() string {
+	return "The OpenID Connect providers that people can log in with."
+}
+
+func (resource OIDCProvidersResource) Properties() []Property {
+	return OIDCProvidersProperties
+}
+
+func (resource OIDCProvidersResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	providers := []OIDCProviderData{}
+	for _, provider := range resource.api.OIDC.Providers {
+		displayName := provider.DisplayName
+		if displayName == "" {
+			displayName = provider.Name
+		}
+		providers = append(providers, OIDCProviderData{
+			Name:        provider.Name,
+			DisplayName: displayName,
+			LoginURL:    resource.api.Path + "/user/oidc/" + provider.Name + "/login",
+		})
+	}
+	return 200, &APIList{
+		Offset:  0,
+		Limit:   len(providers),
+		Objects: providers,
+	}, responseHeader
+}
+
+/*
+OIDCLoginResource starts a login by redirecting the browser to the provider
+*/
+type OIDCLoginResource struct {
+	api *API
+}
+
+func NewOIDCLoginResource(api *API) *OIDCLoginResource {
+	return &OIDCLoginResource{
+		api: api,
+	}
+}
+
+func (OIDCLoginResource) Name() string  { return "oidc-login" }
+func (OIDCLoginResource) Path() string  { return "/user/oidc/{provider:[0-9a-z-]+}/login" }
+func (OIDCLoginResource) Title() string { return "Provider login" }
+func (OIDCLoginResource) Description() string {
+	return "GET in a browser to log in with an OpenID Connect provider, which then redirects to the callback."
+}
+
+func (resource OIDCLoginResource) Properties() []Property {
+	return OIDCLoginProperties
+}
+
+func (resource OIDCLoginResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	provider := resource.api.OIDC.Provider(request.PathValues["provider"])
+	if provider == nil {
+		return 404, UnknownOIDCProviderError, responseHeader
+	}
+	if resource.api.Registration.PublicURL == "" {
+		return 500, PublicURLRequiredError, responseHeader
+	}
+	if request.Session == nil {
+		return 500, APIError{
+			Id:      "no_session",
+			Message: "Provider login requires sessions",
+		}, responseHeader
+	}
+	login, state, err := CreateOIDCLogin(provider.Name, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not start the login: " + err.Error(),
+		}, responseHeader
+	}
+	authURL, err := provider.AuthCodeURL(oidcRedirectURI(resource.api, provider), state, login.Nonce, login.Verifier)
+	if err != nil {
+		return 502, APIError{
+			Id:      "provider_error",
+			Message: "Could not reach the login provider",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	request.Session.Set(OIDCStateKey, state)
+	responseHeader["Location"] = []string{authURL}
+	return http.StatusFound, nil, responseHeader
+}
+
+/*
+OIDCCallbackResource finishes a login when the provider redirects the browser back
+*/
+type OIDCCallbackResource struct {
+	api *API
+}
+
+func NewOIDCCallbackResource(api *API) *OIDCCallbackResource {
+	return &OIDCCallbackResource{
+		api: api,
+	}
+}
+
+func (OIDCCallbackResource) Name() string  { return "oidc-callback" }
+func (OIDCCallbackResource) Path() string  { return "/user/oidc/{provider:[0-9a-z-]+}/callback" }
+func (OIDCCallbackResource) Title() string { return "Provider login callback" }
+func (OIDCCallbackResource) Description() string {
+	return "The provider redirects here with a code, which logs in the user with the same verified email and redirects to the site."
+}
+
+func (resource OIDCCallbackResource) Properties() []Property {
+	return OIDCLoginProperties
+}
+
+func (resource OIDCCallbackResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	provider := resource.api.OIDC.Provider(request.PathValues["provider"])
+	if provider == nil {
+		return 404, UnknownOIDCProviderError, responseHeader
+	}
+	if resource.api.Registration.PublicURL == "" {
+		return 500, PublicURLRequiredError, responseHeader
+	}
+	query := request.Raw.URL.Query()
+	if query.Get("error") != "" {
+		return 400, APIError{
+			Id:      "provider_error",
+			Message: "The login provider did not log you in",
+			Error:   query.Get("error") + ": " + query.Get("error_description"),
+		}, responseHeader
+	}
+	// The session's state ties the callback to the browser that started the login
+	state := query.Get("state")
+	if request.Session == nil || state == "" {
+		return 400, OIDCStateError, responseHeader
+	}
+	sessionState, _ := request.Session.Get(OIDCStateKey).(string)
+	request.Session.Delete(OIDCStateKey)
+	if sessionState != state {
+		return 400, OIDCStateError, responseHeader
+	}
+	login, err := RedeemOIDCLogin(provider.Name, state, request.DBInfo)
+	if err != nil {
+		return 400, OIDCStateError, responseHeader
+	}
+	claims, err := provider.Exchange(query.Get("code"), oidcRedirectURI(resource.api, provider), login.Verifier, login.Nonce)
+	if err != nil {
+		return 502, APIError{
+			Id:      "provider_error",
+			Message: "Could not verify the login with the provider",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	user, err := FindOrCreateOIDCUser(provider, claims, request.DBInfo)
+	if err != nil {
+		return 403, APIError{
+			Id:      "no_account",
+			Message: "There is no account for this login",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	loggedIn, err := BeginLogIn(request.Session, user, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	// If the user has a second factor, the site finds a pending login at SecondFactorResource and asks for a code
+	responseHeader["Location"] = []string{resource.api.OIDC.LoggedInURL}
+	if loggedIn == false {
+		return http.StatusSeeOther, SecondFactorRequiredError, responseHeader
+	}
+	return http.StatusSeeOther, user, responseHeader
+}
+
+/*
+oidcRedirectURI returns the callback URL, which must be registered with the provider
+*/
+func oidcRedirectURI(api *API, provider *OIDCProvider) string {
+	return api.Registration.PublicURL + api.Path + "/user/oidc/" + provider.Name + "/callback"
+}
@@ -0,0 +1,349 @@
+package be
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// From RFC 7636, Appendix B
+	AssertEqual(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestOIDCProvider(t *testing.T) {
+	mock, err := newMockOIDCProvider()
+	AssertNil(t, err)
+	defer mock.Close()
+	mock.Login("subject-1", "alice@example.com", true)
+	provider := mock.Provider("mock")
+
+	redirectURI := "https://example.com/callback"
+	code, state := mock.Authorize(t, provider, redirectURI, "state-1", "nonce-1", "verifier-1")
+	AssertEqual(t, "state-1", state)
+	_, err = provider.Exchange(code, redirectURI, "verifier-2", "nonce-1")
+	AssertNotNil(t, err, "The PKCE verifier must match the challenge")
+	_, err = provider.Exchange(code, redirectURI, "verifier-1", "nonce-2")
+	AssertNotNil(t, err, "The nonce must match")
+	claims, err := provider.Exchange(code, redirectURI, "verifier-1", "nonce-1")
+	AssertNil(t, err)
+	AssertEqual(t, "subject-1", claims.Subject)
+	AssertEqual(t, "alice@example.com", claims.Email)
+	AssertTrue(t, bool(claims.EmailVerified))
+
+	valid := mock.Claims("client", "nonce-1")
+	token, err := mock.Sign(valid)
+	AssertNil(t, err)
+	_, err = provider.VerifyIDToken(token, "nonce-1")
+	AssertNil(t, err)
+	parts := strings.Split(token, ".")
+	tampered := valid
+	tampered["sub"] = "subject-2"
+	tamperedToken, err := mock.Sign(tampered)
+	AssertNil(t, err)
+	_, err = provider.VerifyIDToken(strings.Split(tamperedToken, ".")[0]+"."+strings.Split(tamperedToken, ".")[1]+"."+parts[2], "nonce-1")
+	AssertNotNil(t, err, "A changed payload should not verify")
+
+	expired := mock.Claims("client", "nonce-1")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	token, err = mock.Sign(expired)
+	AssertNil(t, err)
+	_, err = provider.VerifyIDToken(token, "nonce-1")
+	AssertNotNil(t, err, "Expired tokens should not verify")
+
+	otherClient := mock.Claims("other-client", "nonce-1")
+	token, err = mock.Sign(otherClient)
+	AssertNil(t, err)
+	_, err = provider.VerifyIDToken(token, "nonce-1")
+	AssertNotNil(t, err, "Tokens for other clients should not verify")
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	_, err = provider.VerifyIDToken(unsigned, "nonce-1")
+	AssertNotNil(t, err, "Unsigned tokens should not verify")
+}
+
+func TestOIDCAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	mock, err := newMockOIDCProvider()
+	AssertNil(t, err)
+	defer mock.Close()
+	provider := mock.Provider("mock")
+	testApi.API.OIDC.Providers = []*OIDCProvider{provider}
+
+	client, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	list, err := client.GetList("/user/oidc/")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+
+	alice, err := CreateUser("alice@example.com", "Alice", "", false, "", dbInfo)
+	AssertNil(t, err)
+
+	// Unverified emails are not linked to accounts
+	mock.Login("subject-1", "alice@example.com", false)
+	status, user := oidcBrowserLogin(t, testApi, "mock", nil)
+	AssertEqual(t, 403, status)
+
+	mock.Login("subject-1", "Alice@example.com", true)
+	status, user = oidcBrowserLogin(t, testApi, "mock", nil)
+	AssertEqual(t, 303, status)
+	AssertEqual(t, alice.UUID, user.UUID)
+
+	// The link follows the provider's subject, not the email
+	mock.Login("subject-1", "alice@example.org", true)
+	status, user = oidcBrowserLogin(t, testApi, "mock", nil)
+	AssertEqual(t, 303, status)
+	AssertEqual(t, alice.UUID, user.UUID)
+
+	// New people only get accounts if the provider allows it
+	mock.Login("subject-2", "bob@example.com", true)
+	status, _ = oidcBrowserLogin(t, testApi, "mock", nil)
+	AssertEqual(t, 403, status)
+	provider.CreateUsers = true
+	status, user = oidcBrowserLogin(t, testApi, "mock", nil)
+	AssertEqual(t, 303, status)
+	AssertEqual(t, "bob@example.com", user.Email)
+	AssertTrue(t, user.Verified)
+
+	// A callback that was not started in the same browser fails
+	status, _ = oidcBrowserLogin(t, testApi, "mock", func(callback *url.URL) {
+		query := callback.Query()
+		query.Set("state", "not-the-state")
+		callback.RawQuery = query.Encode()
+	})
+	AssertEqual(t, 400, status)
+
+	AssertStatus(t, 404, "GET", testApi.URL()+"/user/oidc/unknown/login")
+}
+
+/*
+oidcBrowserLogin follows the login redirects like a browser would, calling changeCallback (if not nil) on the callback URL,
+and returns the callback's status and the User that the session then authenticates
+*/
+func oidcBrowserLogin(t *testing.T, testApi *TestAPI, providerName string, changeCallback func(*url.URL)) (int, *User) {
+	jar, err := cookiejar.New(nil)
+	AssertNil(t, err)
+	browser := &http.Client{
+		Jar:       jar,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if strings.HasSuffix(request.URL.Path, "/callback") && changeCallback != nil {
+				changeCallback(request.URL)
+			}
+			// Stop after the callback instead of following its redirect to the site
+			if strings.HasSuffix(via[len(via)-1].URL.Path, "/callback") {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	resp, err := browser.Get(testApi.URL() + "/user/oidc/" + providerName + "/login")
+	AssertNil(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		return resp.StatusCode, nil
+	}
+	request, err := http.NewRequest("GET", testApi.URL()+"/user/current", nil)
+	AssertNil(t, err)
+	request.Header.Set("Accept", AcceptHeaderPrefix+TestVersion)
+	resp, err = browser.Do(request)
+	AssertNil(t, err)
+	defer resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+	user := new(User)
+	AssertNil(t, json.NewDecoder(resp.Body).Decode(user))
+	return resp.StatusCode, user
+}
+
+/*
+mockOIDCProvider is a minimal OpenID Connect provider that immediately logs in whoever was passed to Login
+*/
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mutex         sync.Mutex
+	subject       string
+	email         string
+	emailVerified bool
+	codes         map[string]url.Values // The authorization request for each code
+}
+
+func newMockOIDCProvider() (*mockOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	mock := &mockOIDCProvider{
+		key:   key,
+		codes: map[string]url.Values{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", mock.serveDiscovery)
+	mux.HandleFunc("/authorize", mock.serveAuthorize)
+	mux.HandleFunc("/token", mock.serveToken)
+	mux.HandleFunc("/jwks", mock.serveJWKS)
+	mock.server = httptest.NewServer(mux)
+	return mock, nil
+}
+
+func (mock *mockOIDCProvider) Close() {
+	mock.server.Close()
+}
+
+func (mock *mockOIDCProvider) Provider(name string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       mock.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}
+}
+
+func (mock *mockOIDCProvider) Login(subject string, email string, emailVerified bool) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.subject = subject
+	mock.email = email
+	mock.emailVerified = emailVerified
+}
+
+/*
+Authorize visits the provider's authorization URL and returns the code and state from its redirect
+*/
+func (mock *mockOIDCProvider) Authorize(t *testing.T, provider *OIDCProvider, redirectURI string, state string, nonce string, verifier string) (string, string) {
+	authURL, err := provider.AuthCodeURL(redirectURI, state, nonce, verifier)
+	AssertNil(t, err)
+	client := &http.Client{
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(authURL)
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	AssertNil(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (mock *mockOIDCProvider) Claims(audience string, nonce string) map[string]interface{} {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	return map[string]interface{}{
+		"iss":            mock.server.URL,
+		"sub":            mock.subject,
+		"aud":            audience,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          mock.email,
+		"email_verified": mock.emailVerified,
+	}
+}
+
+func (mock *mockOIDCProvider) Sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "key-1"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, mock.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (mock *mockOIDCProvider) serveDiscovery(writer http.ResponseWriter, request *http.Request) {
+	json.NewEncoder(writer).Encode(map[string]string{
+		"issuer":                 mock.server.URL,
+		"authorization_endpoint": mock.server.URL + "/authorize",
+		"token_endpoint":         mock.server.URL + "/token",
+		"jwks_uri":               mock.server.URL + "/jwks",
+	})
+}
+
+func (mock *mockOIDCProvider) serveAuthorize(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	if query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(writer, "invalid_request", 400)
+		return
+	}
+	code, _ := RandomToken()
+	mock.mutex.Lock()
+	mock.codes[code] = query
+	mock.mutex.Unlock()
+	http.Redirect(writer, request, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (mock *mockOIDCProvider) serveToken(writer http.ResponseWriter, request *http.Request) {
+	clientID, secret, ok := request.BasicAuth()
+	if ok == false || clientID != "client" || secret != "secret" {
+		http.Error(writer, `{"error":"invalid_client"}`, 401)
+		return
+	}
+	mock.mutex.Lock()
+	authorization, ok := mock.codes[request.PostFormValue("code")]
+	mock.mutex.Unlock()
+	if ok == false || authorization.Get("redirect_uri") != request.PostFormValue("redirect_uri") || authorization.Get("code_challenge") != PKCEChallenge(request.PostFormValue("code_verifier")) {
+		http.Error(writer, `{"error":"invalid_grant"}`, 400)
+		return
+	}
+	idToken, err := mock.Sign(mock.Claims("client", authorization.Get("nonce")))
+	if err != nil {
+		http.Error(writer, `{"error":"server_error"}`, 500)
+		return
+	}
+	json.NewEncoder(writer).Encode(map[string]string{
+		"id_token":     idToken,
+		"access_token": "access",
+		"token_type":   "Bearer",
+	})
+}
+
+func (mock *mockOIDCProvider) serveJWKS(writer http.ResponseWriter, request *http.Request) {
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(mock.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(mock.key.E)).Bytes()),
+			},
+		},
+	})
+}
@@ -0,0 +1,212 @@
+package be
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var PasswordChangeProperties = []Property{
+	Property{
+		Name:        "current-password",
+		Description: "The password the user logged in with",
+		DataType:    "string",
+	},
+	Property{
+		Name:        "new-password",
+		Description: "The password to change to",
+		DataType:    "string",
+	},
+}
+
+var PasswordResetProperties = []Property{
+	Property{
+		Name:        "email",
+		Description: "POST the email of an account to send it a password reset token",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "token",
+		Description: "PUT the emailed token with a new-password to set the password",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "new-password",
+		Description: "The password to change to",
+		DataType:    "string",
+		Optional:    true,
+	},
+}
+
+type PasswordChangeData struct {
+	CurrentPassword string `json:"current-password"`
+	NewPassword     string `json:"new-password"`
+}
+
+type PasswordResetData struct {
+	Email       string `json:"email"`
+	Token       string `json:"token"`
+	NewPassword string `json:"new-password"`
+}
+
+/*
+CurrentUserPasswordResource changes the password of the authenticated User and logs out their other sessions
+*/
+type CurrentUserPasswordResource struct{}
+
+func NewCurrentUserPasswordResource() *CurrentUserPasswordResource {
+	return &CurrentUserPasswordResource{}
+}
+
+func (CurrentUserPasswordResource) Name() string  { return "current-user-password" }
+func (CurrentUserPasswordResource) Path() string  { return "/user/current/password" }
+func (CurrentUserPasswordResource) Title() string { return "Change password" }
+func (CurrentUserPasswordResource) Description() string {
+	return "PUT the current and new passwords to change the password. Other sessions are logged out."
+}
+
+func (resource CurrentUserPasswordResource) Properties() []Property {
+	return PasswordChangeProperties
+}
+
+func (resource CurrentUserPasswordResource) Put(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	var data PasswordChangeData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	if PasswordMatches(request.User.Id, data.CurrentPassword, request.DBInfo) == false {
+		return 400, APIError{
+			Id:      "incorrect_password",
+			Message: "Incorrect password",
+		}, responseHeader
+	}
+	if len(data.NewPassword) < MinPasswordLength {
+		return 400, ShortPasswordError, responseHeader
+	}
+	err = SetPassword(request.User.Id, data.NewPassword, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not change the password: " + err.Error(),
+		}, responseHeader
+	}
+	err = InvalidateSessions(request.User, request.Session, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not log out other sessions: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit("password-change", "user", request.User.UUID, nil, nil)
+	return 200, "Ok", responseHeader
+}
+
+/*
+PasswordResetResource emails single use tokens to people who forgot their password and sets a new password when one is redeemed
+*/
+type PasswordResetResource struct {
+	api   *API
+	limit *RateLimit
+}
+
+func NewPasswordResetResource(api *API) *PasswordResetResource {
+	return &PasswordResetResource{
+		api: api,
+		// Each POST sends an email
+		limit: NewRateLimit(20, time.Hour),
+	}
+}
+
+func (PasswordResetResource) Name() string  { return "password-reset" }
+func (PasswordResetResource) Path() string  { return "/user/password-reset" }
+func (PasswordResetResource) Title() string { return "Password reset" }
+func (PasswordResetResource) Description() string {
+	return "POST an email to send that account a reset token. PUT the token and a new password to set the password and log out every session."
+}
+
+func (resource PasswordResetResource) Properties() []Property {
+	return PasswordResetProperties
+}
+
+func (resource PasswordResetResource) RateLimit(method string) *RateLimit {
+	if method == POST {
+		return resource.limit
+	}
+	return nil
+}
+
+func (resource PasswordResetResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	var data PasswordResetData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	if data.Email == "" {
+		return 400, UnprocessableError, responseHeader
+	}
+	if resource.api.Registration.PublicURL == "" {
+		// Links built from the Host header would send reset tokens wherever the requester points them
+		return 500, PublicURLRequiredError, responseHeader
+	}
+	// Respond the same whether or not there is an account so that this can not be used to find accounts
+	user, err := FindUserByEmail(strings.TrimSpace(data.Email), request.DBInfo)
+	if err != nil {
+		return 200, "Ok", responseHeader
+	}
+	token, err := CreatePasswordReset(user.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the password reset: " + err.Error(),
+		}, responseHeader
+	}
+	link := resource.api.Registration.PublicURL + resource.api.Registration.ResetURL + "?password-reset-token=" + url.QueryEscape(token)
+	err = resource.api.Mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "Reset your Spaciblō password",
+		Body:    "Follow this link within the hour to choose a new password:\n\n" + link + "\n\nIf you did not ask to reset your password, you can ignore this email.\n",
+	})
+	if err != nil {
+		return 500, APIError{
+			Id:      "mail_error",
+			Message: "Could not send the password reset email",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	return 200, "Ok", responseHeader
+}
+
+func (resource PasswordResetResource) Put(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	var data PasswordResetData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	if data.Token == "" {
+		return 400, UnprocessableError, responseHeader
+	}
+	if len(data.NewPassword) < MinPasswordLength {
+		return 400, ShortPasswordError, responseHeader
+	}
+	user, err := RedeemPasswordReset(data.Token, data.NewPassword, request.DBInfo)
+	if err != nil {
+		return 400, APIError{
+			Id:      "invalid_token",
+			Message: "The password reset token is invalid or has expired",
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	auditUser(user, "password-reset", nil, nil, request)
+	return 200, "Ok", responseHeader
+}
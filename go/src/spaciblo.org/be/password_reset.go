@@ -0,0 +1,89 @@
+package be
+
+import (
+	"errors"
+	"time"
+)
+
+const PasswordResetTable = "password_resets"
+
+// PasswordResetLifetime is how long an emailed password reset token works
+var PasswordResetLifetime = time.Hour
+
+/*
+PasswordReset holds the hash of a single use token that lets a User who forgot their password set a new one
+*/
+type PasswordReset struct {
+	Id        int64     `db:"id, primarykey, autoincrement"`
+	UserId    int64     `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	Expires   time.Time `db:"expires"`
+}
+
+/*
+CreatePasswordReset returns a token to email to the User
+Earlier tokens keep working until they expire, in case emails arrive out of order.
+*/
+func CreatePasswordReset(userId int64, dbInfo *DBInfo) (string, error) {
+	token, err := RandomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+PasswordResetTable+" where expires < $1", time.Now())
+	if err != nil {
+		return "", err
+	}
+	err = dbInfo.Map.Insert(&PasswordReset{
+		UserId:    userId,
+		TokenHash: HashToken(token),
+		Expires:   time.Now().Add(PasswordResetLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+/*
+RedeemPasswordReset sets the password of the User with an unexpired reset for token, deletes all of their resets, and logs out their sessions
+*/
+func RedeemPasswordReset(token string, plaintext string, dbInfo *DBInfo) (*User, error) {
+	reset := new(PasswordReset)
+	err := dbInfo.Map.SelectOne(reset, "select * from "+PasswordResetTable+" where token_hash=$1", HashToken(token))
+	if err != nil {
+		return nil, errors.New("No such password reset")
+	}
+	// Deleting before setting the password means that a token can only be used once, even by concurrent requests
+	result, err := dbInfo.Map.Exec("delete from "+PasswordResetTable+" where id=$1", reset.Id)
+	if err != nil {
+		return nil, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if count != 1 {
+		return nil, errors.New("The password reset has already been used")
+	}
+	if time.Now().After(reset.Expires) {
+		return nil, errors.New("The password reset has expired")
+	}
+	user := new(User)
+	err = dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where id=$1", reset.UserId)
+	if err != nil {
+		return nil, err
+	}
+	err = SetPassword(user.Id, plaintext, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+PasswordResetTable+" where user_id=$1", user.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = InvalidateSessions(user, nil, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,95 @@
+package be
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestPasswordAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	mailer := NewMemoryMailer()
+	testApi.API.Mailer = mailer
+
+	user, err := CreateUser("alice@example.com", "Alice", "", false, "", dbInfo)
+	AssertNil(t, err)
+	_, err = CreatePassword("12345678", user.Id, dbInfo)
+	AssertNil(t, err)
+	client, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, client.Authenticate("alice@example.com", "12345678"))
+	otherClient, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, otherClient.Authenticate("alice@example.com", "12345678"))
+	currentUser := new(User)
+
+	putStatus := func(client *Client, url string, data interface{}) int {
+		// PutJSON returns an error along with the response for non-200 statuses
+		resp, _ := client.PutJSON(url, data)
+		AssertNotNil(t, resp)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	AssertEqual(t, 400, putStatus(client, "/user/current/password", PasswordChangeData{CurrentPassword: "wrong", NewPassword: "abcdefgh"}))
+	AssertEqual(t, 400, putStatus(client, "/user/current/password", PasswordChangeData{CurrentPassword: "12345678", NewPassword: "abc"}))
+	AssertEqual(t, 200, putStatus(client, "/user/current/password", PasswordChangeData{CurrentPassword: "12345678", NewPassword: "abcdefgh"}))
+
+	// The session that changed the password stays logged in and the others are logged out
+	AssertNil(t, client.GetJSON("/user/current", currentUser))
+	AssertNotNil(t, otherClient.GetJSON("/user/current", currentUser))
+	AssertNotNil(t, otherClient.Authenticate("alice@example.com", "12345678"))
+	AssertNil(t, otherClient.Authenticate("alice@example.com", "abcdefgh"))
+
+	// Asking to reset an unknown email looks the same as a known one
+	resp, err := otherClient.PostJSON("/user/password-reset", PasswordResetData{Email: "nobody@example.com"})
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+	AssertTrue(t, mailer.LastMessageTo("nobody@example.com") == nil)
+
+	// Without PUBLIC_URL no links are sent, since the Host header is the requester's to choose
+	publicURL := testApi.API.Registration.PublicURL
+	testApi.API.Registration.PublicURL = ""
+	resp, _ = otherClient.PostJSON("/user/password-reset", PasswordResetData{Email: "alice@example.com"})
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 500, resp.StatusCode)
+	AssertTrue(t, mailer.LastMessageTo("alice@example.com") == nil)
+	testApi.API.Registration.PublicURL = publicURL
+
+	resp, err = otherClient.PostJSON("/user/password-reset", PasswordResetData{Email: "alice@example.com"})
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+	message := mailer.LastMessageTo("alice@example.com")
+	AssertNotNil(t, message)
+	start := strings.Index(message.Body, publicURL+"/")
+	AssertTrue(t, start != -1, message.Body)
+	link, err := url.Parse(strings.Fields(message.Body[start:])[0])
+	AssertNil(t, err)
+	token := link.Query().Get("password-reset-token")
+	AssertTrue(t, token != "")
+
+	AssertEqual(t, 400, putStatus(otherClient, "/user/password-reset", PasswordResetData{Token: "bogus", NewPassword: "qwertyui"}))
+	AssertEqual(t, 200, putStatus(otherClient, "/user/password-reset", PasswordResetData{Token: token, NewPassword: "qwertyui"}))
+	AssertEqual(t, 400, putStatus(otherClient, "/user/password-reset", PasswordResetData{Token: token, NewPassword: "zxcvbnm,"}), "Tokens are single use")
+
+	// A reset logs out every session
+	AssertNotNil(t, client.GetJSON("/user/current", currentUser))
+	AssertNotNil(t, otherClient.GetJSON("/user/current", currentUser))
+	AssertNil(t, client.Authenticate("alice@example.com", "qwertyui"))
+	AssertNil(t, client.GetJSON("/user/current", currentUser))
+}
@@ -0,0 +1,261 @@
+package be
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const FileUploadTable = "file_uploads"
+
+/*
+UploadLimits bound how much data a request may send and how much FileStorage each user may fill
+A limit of zero or less means no limit.
+*/
+type UploadLimits struct {
+	MaxRequestBytes   int64 `json:"max-request-bytes"`
+	MaxFileBytes      int64 `json:"max-file-bytes"`
+	DefaultQuotaBytes int64 `json:"default-quota-bytes"`
+}
+
+var DefaultUploadLimits = UploadLimits{
+	MaxRequestBytes:   256 * 1024 * 1024,
+	MaxFileBytes:      128 * 1024 * 1024,
+	DefaultQuotaBytes: 1024 * 1024 * 1024,
+}
+
+/*
+UploadLimitsFromEnv returns DefaultUploadLimits overridden by the UPLOAD_MAX_REQUEST_BYTES, UPLOAD_MAX_FILE_BYTES, and STORAGE_QUOTA_BYTES env variables
+*/
+func UploadLimitsFromEnv() (UploadLimits, error) {
+	limits := DefaultUploadLimits
+	for name, limit := range map[string]*int64{
+		"UPLOAD_MAX_REQUEST_BYTES": &limits.MaxRequestBytes,
+		"UPLOAD_MAX_FILE_BYTES":    &limits.MaxFileBytes,
+		"STORAGE_QUOTA_BYTES":      &limits.DefaultQuotaBytes,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return limits, errors.New(name + " must be a number of bytes: " + value)
+		}
+		*limit = parsed
+	}
+	return limits, nil
+}
+
+/*
+QuotaFor returns the number of bytes that user may store, or zero if there is no limit
+A User.StorageQuota of zero uses the default quota and a negative User.StorageQuota means unlimited.
+*/
+func (limits UploadLimits) QuotaFor(user *User) int64 {
+	if user.StorageQuota < 0 {
+		return 0
+	}
+	if user.StorageQuota > 0 {
+		return user.StorageQuota
+	}
+	if limits.DefaultQuotaBytes < 0 {
+		return 0
+	}
+	return limits.DefaultQuotaBytes
+}
+
+/*
+Check returns nil if a file of size bytes may be stored by a user who already uses used bytes of their quota
+*/
+func (limits UploadLimits) Check(size int64, used int64, quota int64) *APIError {
+	if limits.MaxFileBytes > 0 && size > limits.MaxFileBytes {
+		return &APIError{
+			Id:      FileTooLargeError.Id,
+			Message: fmt.Sprintf("%s: %d bytes is over the limit of %d bytes", FileTooLargeError.Message, size, limits.MaxFileBytes),
+		}
+	}
+	if quota > 0 && used+size > quota {
+		return &APIError{
+			Id:      StorageQuotaExceededError.Id,
+			Message: fmt.Sprintf("%s: %d bytes would bring usage to %d of %d bytes", StorageQuotaExceededError.Message, size, used+size, quota),
+		}
+	}
+	return nil
+}
+
+/*
+CheckUpload returns a status code and an APIError if the request's User may not store a file of size bytes, or 0 and nil if they may
+A negative size means the client did not say how much it will send.
+*/
+func (request *APIRequest) CheckUpload(size int64) (int, *APIError) {
+	return request.checkUpload(size, request.UploadLimits)
+}
+
+/*
+CheckUploads is CheckUpload for several files stored by one request, like the files of a space bundle
+Each file must be within the file size limit and together they must fit in the User's quota.
+*/
+func (request *APIRequest) CheckUploads(sizes []int64) (int, *APIError) {
+	var total int64
+	for _, size := range sizes {
+		if size < 0 {
+			return 411, &LengthRequiredError
+		}
+		apiError := request.UploadLimits.Check(size, 0, 0)
+		if apiError != nil {
+			return 413, apiError
+		}
+		total += size
+		if total < 0 {
+			return 413, &FileTooLargeError
+		}
+	}
+	limits := request.UploadLimits
+	limits.MaxFileBytes = 0 // Already checked for each file
+	return request.checkUpload(total, limits)
+}
+
+func (request *APIRequest) checkUpload(size int64, limits UploadLimits) (int, *APIError) {
+	if size < 0 {
+		return 411, &LengthRequiredError
+	}
+	var used, quota int64
+	if request.User != nil {
+		quota = limits.QuotaFor(request.User)
+		if quota > 0 {
+			var err error
+			used, err = FindStorageUsage(request.User.Id, request.DBInfo)
+			if err != nil {
+				return 500, &APIError{
+					Id:      "database_error",
+					Message: "Could not find storage usage: " + err.Error(),
+				}
+			}
+		}
+	}
+	apiError := limits.Check(size, used, quota)
+	if apiError != nil {
+		return 413, apiError
+	}
+	return 0, nil
+}
+
+/*
+RecordUpload counts the stored size of the File with Key key against the request's User's quota
+*/
+func (request *APIRequest) RecordUpload(key string) {
+	if request.User == nil {
+		return
+	}
+	err := RecordFileUpload(request.User.Id, key, request.FS, request.DBInfo)
+	if err != nil {
+		logger.Print("Could not record the upload of " + key + ": " + err.Error())
+	}
+}
+
+/*
+isRequestTooLarge returns true if err came from reading past the http.MaxBytesReader limit on a request's body
+*/
+func isRequestTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+/*
+FileUpload records that a User stored a File so that its size counts against their storage quota
+*/
+type FileUpload struct {
+	Id      int64     `json:"id" db:"id, primarykey, autoincrement"`
+	UserId  int64     `json:"user-id" db:"user_id"`
+	Key     string    `json:"key" db:"key"`
+	Size    int64     `json:"size" db:"size"`
+	Created time.Time `json:"created" db:"created"`
+}
+
+/*
+RecordFileUpload stores a FileUpload with the size that fileStorage reports for the File with Key key
+Uploading the same key twice only counts once.
+*/
+func RecordFileUpload(userId int64, key string, fileStorage FileStorage, dbInfo *DBInfo) error {
+	file, err := fileStorage.Get(key, "")
+	if err != nil {
+		return err
+	}
+	size, err := file.Size()
+	if err != nil {
+		return err
+	}
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+FileUploadTable+" where user_id=$1 and key=$2", userId, key)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return dbInfo.Map.Insert(&FileUpload{
+		UserId:  userId,
+		Key:     key,
+		Size:    size,
+		Created: time.Now(),
+	})
+}
+
+/*
+FindStorageUsage returns the total bytes of the Files that a User has uploaded and that are still stored
+*/
+func FindStorageUsage(userId int64, dbInfo *DBInfo) (int64, error) {
+	return dbInfo.Map.SelectInt("select coalesce(sum(size), 0) from "+FileUploadTable+" where user_id=$1", userId)
+}
+
+/*
+DeleteFileUpload stops counting the File with Key key against a User's quota
+*/
+func DeleteFileUpload(userId int64, key string, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+FileUploadTable+" where user_id=$1 and key=$2", userId, key)
+	return err
+}
+
+/*
+DeleteKeyFileUploads stops counting the File with Key key against the quota of every User who uploaded it
+Call it when the File is deleted from FileStorage.
+*/
+func DeleteKeyFileUploads(key string, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+FileUploadTable+" where key=$1", key)
+	return err
+}
+
+/*
+DeleteUserFileUploads stops counting every File a User has uploaded against their quota
+*/
+func DeleteUserFileUploads(userId int64, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+FileUploadTable+" where user_id=$1", userId)
+	return err
+}
+
+/*
+DeleteDeadFileUploads deletes the FileUploads created before before whose keys are not in liveKeys
+The files themselves are deleted by file garbage collection, so they no longer count against quotas.
+Files that are deleted directly have their FileUploads removed by DeleteKeyFileUploads, so this catches the rest.
+*/
+func DeleteDeadFileUploads(liveKeys map[string]bool, before time.Time, dbInfo *DBInfo) (int, error) {
+	var uploads []*FileUpload
+	_, err := dbInfo.Map.Select(&uploads, "select * from "+FileUploadTable+" where created < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, upload := range uploads {
+		if liveKeys[upload.Key] {
+			continue
+		}
+		_, err = dbInfo.Map.Delete(upload)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += 1
+	}
+	return deleted, nil
+}
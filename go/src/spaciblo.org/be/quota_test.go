@@ -0,0 +1,47 @@
+package be
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestUploadLimits(t *testing.T) {
+	limits := UploadLimits{MaxFileBytes: 100, DefaultQuotaBytes: 1000}
+	AssertEqual(t, int64(1000), limits.QuotaFor(&User{}))
+	AssertEqual(t, int64(50), limits.QuotaFor(&User{StorageQuota: 50}))
+	AssertEqual(t, int64(0), limits.QuotaFor(&User{StorageQuota: -1}), "Negative quotas are unlimited")
+
+	AssertTrue(t, limits.Check(100, 900, 1000) == nil)
+	apiError := limits.Check(101, 0, 1000)
+	AssertNotNil(t, apiError)
+	AssertEqual(t, FileTooLargeError.Id, apiError.Id)
+	apiError = limits.Check(100, 901, 1000)
+	AssertNotNil(t, apiError)
+	AssertEqual(t, StorageQuotaExceededError.Id, apiError.Id)
+	AssertTrue(t, limits.Check(100, 5000, 0) == nil, "A zero quota is unlimited")
+
+	unlimited := UploadLimits{DefaultQuotaBytes: -1}
+	AssertEqual(t, int64(0), unlimited.QuotaFor(&User{}))
+	AssertTrue(t, unlimited.Check(1<<40, 0, 0) == nil)
+
+	// Multipart bodies that run past the request limit are told apart from malformed ones
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "big.bin")
+	AssertNil(t, err)
+	part.Write(bytes.Repeat([]byte("a"), 4096))
+	writer.Close()
+	request := httptest.NewRequest("POST", "/", body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Body = http.MaxBytesReader(httptest.NewRecorder(), request.Body, 1024)
+	err = request.ParseMultipartForm(1024)
+	AssertNotNil(t, err)
+	AssertTrue(t, isRequestTooLarge(err))
+	AssertFalse(t, isRequestTooLarge(errors.New("request body too large")))
+}
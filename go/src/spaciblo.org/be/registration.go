@@ -0,0 +1,261 @@
+package be
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	EmailVerificationTable  = "email_verifications"
+	RegistrationInviteTable = "registration_invites"
+)
+
+const (
+	RegistrationClosed     = "closed"
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite"
+)
+
+// EmailVerificationLifetime is how long a verification link works after it is sent
+var EmailVerificationLifetime = 48 * time.Hour
+
+// MinPasswordLength is the shortest password that registration and password changes accept
+const MinPasswordLength = 8
+
+/*
+RegistrationConfig controls who may create an account through the registration API
+*/
+type RegistrationConfig struct {
+	Mode           string   // RegistrationClosed, RegistrationOpen, or RegistrationInviteOnly
+	AllowedDomains []string // If not empty, only emails at these domains may register
+	PublicURL      string   // Like https://example.com, used in emailed links and OIDC callbacks. Never taken from the request's Host header, so emails are refused if it is empty
+	VerifiedURL    string   // Where browsers are redirected after following a verification link
+	ResetURL       string   // The page that emailed password reset links open, with the token in a query parameter
+}
+
+/*
+RegistrationConfigFromEnv reads REGISTRATION_MODE, REGISTRATION_DOMAINS (comma separated), PUBLIC_URL, REGISTRATION_VERIFIED_URL, and PASSWORD_RESET_URL
+Registration is closed unless REGISTRATION_MODE is open or invite, which also require PUBLIC_URL for the verification links.
+*/
+func RegistrationConfigFromEnv() (RegistrationConfig, error) {
+	config := RegistrationConfig{
+		Mode:        os.Getenv("REGISTRATION_MODE"),
+		PublicURL:   strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+		VerifiedURL: os.Getenv("REGISTRATION_VERIFIED_URL"),
+		ResetURL:    os.Getenv("PASSWORD_RESET_URL"),
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = RegistrationClosed
+	case RegistrationClosed, RegistrationOpen, RegistrationInviteOnly:
+	default:
+		return config, errors.New("REGISTRATION_MODE must be closed, open, or invite: " + config.Mode)
+	}
+	for _, domain := range strings.Split(os.Getenv("REGISTRATION_DOMAINS"), ",") {
+		domain = strings.TrimSpace(domain)
+		if domain != "" {
+			config.AllowedDomains = append(config.AllowedDomains, domain)
+		}
+	}
+	if config.Mode != RegistrationClosed && config.PublicURL == "" {
+		return config, errors.New("PUBLIC_URL is required when REGISTRATION_MODE is " + config.Mode)
+	}
+	if config.VerifiedURL == "" {
+		config.VerifiedURL = "/"
+	}
+	if config.ResetURL == "" {
+		config.ResetURL = "/"
+	}
+	return config, nil
+}
+
+/*
+AllowsEmail returns true if the email address is in one of the AllowedDomains, or if there are no AllowedDomains
+*/
+func (config RegistrationConfig) AllowsEmail(email string) bool {
+	if len(config.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+	emailDomain := strings.ToLower(email[at+1:])
+	for _, domain := range config.AllowedDomains {
+		if emailDomain == strings.ToLower(strings.TrimPrefix(domain, "@")) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+NormalizeEmail returns the bare, trimmed, lowercased address from an email like "Alice <Alice@example.com>" or an error if it is not an address
+Lowercasing keeps people from registering the same mailbox twice with different capitals.
+*/
+func NormalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+/*
+EmailVerification holds the hash of the token in a verification link so that the DB alone can not be used to verify an account
+*/
+type EmailVerification struct {
+	Id        int64     `db:"id, primarykey, autoincrement"`
+	UserId    int64     `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	Expires   time.Time `db:"expires"`
+}
+
+/*
+CreateEmailVerification replaces any earlier verifications for a User and returns the token to send to them
+*/
+func CreateEmailVerification(userId int64, dbInfo *DBInfo) (string, error) {
+	token, err := RandomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+EmailVerificationTable+" where user_id=$1", userId)
+	if err != nil {
+		return "", err
+	}
+	err = dbInfo.Map.Insert(&EmailVerification{
+		UserId:    userId,
+		TokenHash: HashToken(token),
+		Expires:   time.Now().Add(EmailVerificationLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+/*
+VerifyEmail marks the User with an unexpired verification for token as verified and returns them
+*/
+func VerifyEmail(token string, dbInfo *DBInfo) (*User, error) {
+	verification := new(EmailVerification)
+	err := dbInfo.Map.SelectOne(verification, "select * from "+EmailVerificationTable+" where token_hash=$1", HashToken(token))
+	if err != nil {
+		return nil, errors.New("No such verification")
+	}
+	_, err = dbInfo.Map.Delete(verification)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(verification.Expires) {
+		return nil, errors.New("The verification has expired")
+	}
+	user := new(User)
+	err = dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where id=$1", verification.UserId)
+	if err != nil {
+		return nil, err
+	}
+	user.Verified = true
+	err = UpdateUser(user, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+/*
+RegistrationInvite lets one person register when registration is invite only
+If Email is not empty then only that address may use the invite.
+*/
+type RegistrationInvite struct {
+	Id        int64     `json:"id" db:"id, primarykey, autoincrement"`
+	Code      string    `json:"code" db:"code"`
+	Email     string    `json:"email" db:"email"`
+	CreatedBy int64     `json:"created-by" db:"created_by"`
+	UsedBy    int64     `json:"used-by" db:"used_by"` // 0 until someone registers with the invite
+	Created   time.Time `json:"created" db:"created"`
+}
+
+func CreateRegistrationInvite(email string, createdBy int64, dbInfo *DBInfo) (*RegistrationInvite, error) {
+	code, err := RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	invite := &RegistrationInvite{
+		Code:      code,
+		Email:     email,
+		CreatedBy: createdBy,
+		Created:   time.Now(),
+	}
+	err = dbInfo.Map.Insert(invite)
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func FindRegistrationInvites(offset int, limit int, dbInfo *DBInfo) ([]RegistrationInvite, error) {
+	var invites []RegistrationInvite
+	_, err := dbInfo.Map.Select(&invites, "select * from "+RegistrationInviteTable+" order by id desc limit $1 offset $2", limit, offset)
+	return invites, err
+}
+
+/*
+FindUsableRegistrationInvite returns the unused invite with code if email may use it
+*/
+func FindUsableRegistrationInvite(code string, email string, dbInfo *DBInfo) (*RegistrationInvite, error) {
+	invite := new(RegistrationInvite)
+	err := dbInfo.Map.SelectOne(invite, "select * from "+RegistrationInviteTable+" where code=$1 and used_by=0", code)
+	if err != nil {
+		return nil, errors.New("No such invite")
+	}
+	if invite.Email != "" && strings.EqualFold(invite.Email, email) == false {
+		return nil, errors.New("The invite is for a different email")
+	}
+	return invite, nil
+}
+
+/*
+UseRegistrationInvite marks the invite as used by a User, failing if someone else used it first
+*/
+func UseRegistrationInvite(invite *RegistrationInvite, userId int64, dbInfo *DBInfo) error {
+	result, err := dbInfo.Map.Exec("update "+RegistrationInviteTable+" set used_by=$1 where id=$2 and used_by=0", userId, invite.Id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("The invite has already been used")
+	}
+	invite.UsedBy = userId
+	return nil
+}
+
+/*
+RandomToken returns 32 random bytes, hex encoded, for use in links and invites
+*/
+func RandomToken() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+/*
+HashToken returns the hex encoded SHA-256 of a token from RandomToken, which is what the DB stores
+*/
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,404 @@
+package be
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var RegistrationProperties = []Property{
+	Property{
+		Name:        "email",
+		Description: "email",
+		DataType:    "string",
+	},
+	Property{
+		Name:        "password",
+		Description: "password",
+		DataType:    "string",
+	},
+	Property{
+		Name:        "first-name",
+		Description: "first name",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "last-name",
+		Description: "last name",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "invite",
+		Description: "The code of a registration invite, required when registration is invite only",
+		DataType:    "string",
+		Optional:    true,
+	},
+}
+
+var EmailVerificationProperties = []Property{
+	Property{
+		Name:        "token",
+		Description: "The token from a verification link",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "email",
+		Description: "POST only an email to send a new verification link",
+		DataType:    "string",
+		Optional:    true,
+	},
+}
+
+var RegistrationInviteProperties = []Property{
+	Property{
+		Name:        "code",
+		Description: "The code to pass as the invite when registering",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "email",
+		Description: "If set, only this email may register with the invite",
+		DataType:    "string",
+		Optional:    true,
+	},
+	Property{
+		Name:        "used-by",
+		Description: "The id of the user who registered with the invite, or 0",
+		DataType:    "int",
+		Protected:   true,
+	},
+}
+
+var RegistrationInvitesProperties = NewAPIListProperties("registration-invite")
+
+type RegistrationData struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"first-name"`
+	LastName  string `json:"last-name"`
+	Invite    string `json:"invite"`
+}
+
+type EmailVerificationData struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+}
+
+/*
+RegistrationResource creates unverified Users and emails them a link to EmailVerificationResource
+*/
+type RegistrationResource struct {
+	api   *API
+	limit *RateLimit
+}
+
+func NewRegistrationResource(api *API) *RegistrationResource {
+	return &RegistrationResource{
+		api: api,
+		// Each POST sends an email
+		limit: NewRateLimit(20, time.Hour),
+	}
+}
+
+func (RegistrationResource) Name() string  { return "registration" }
+func (RegistrationResource) Path() string  { return "/user/register" }
+func (RegistrationResource) Title() string { return "Registration" }
+func (RegistrationResource) Description() string {
+	return "POST to create an account. The account can log in once the link in the verification email is followed."
+}
+
+func (resource RegistrationResource) Properties() []Property {
+	return RegistrationProperties
+}
+
+func (resource RegistrationResource) RateLimit(method string) *RateLimit {
+	if method == POST {
+		return resource.limit
+	}
+	return nil
+}
+
+func (resource RegistrationResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	config := resource.api.Registration
+	if config.Mode != RegistrationOpen && config.Mode != RegistrationInviteOnly {
+		return 403, RegistrationClosedError, responseHeader
+	}
+	if config.PublicURL == "" {
+		return 500, PublicURLRequiredError, responseHeader
+	}
+	var data RegistrationData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	email, err := NormalizeEmail(data.Email)
+	if err != nil {
+		return 400, APIError{
+			Id:      "invalid_email",
+			Message: "Invalid email: " + data.Email,
+		}, responseHeader
+	}
+	if len(data.Password) < MinPasswordLength {
+		return 400, ShortPasswordError, responseHeader
+	}
+	if config.AllowsEmail(email) == false {
+		return 403, APIError{
+			Id:      "domain_not_allowed",
+			Message: "Registration is limited to emails at " + strings.Join(config.AllowedDomains, ", "),
+		}, responseHeader
+	}
+	var invite *RegistrationInvite
+	if config.Mode == RegistrationInviteOnly {
+		invite, err = FindUsableRegistrationInvite(data.Invite, email, request.DBInfo)
+		if err != nil {
+			return 403, APIError{
+				Id:      "invalid_invite",
+				Message: "Registration requires an unused invite",
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+	_, err = FindUserByEmail(email, request.DBInfo)
+	if err == nil {
+		return 400, APIError{
+			Id:      "email_taken",
+			Message: "An account already uses that email",
+		}, responseHeader
+	}
+
+	user, err := CreateUnverifiedUser(email, data.FirstName, data.LastName, request.DBInfo)
+	if err != nil {
+		if _, findErr := FindUserByEmail(email, request.DBInfo); findErr == nil {
+			// A concurrent registration took the email after the check above
+			return 400, APIError{
+				Id:      "email_taken",
+				Message: "An account already uses that email",
+			}, responseHeader
+		}
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the user: " + err.Error(),
+		}, responseHeader
+	}
+	_, err = CreatePassword(data.Password, user.Id, request.DBInfo)
+	if err != nil {
+		request.DBInfo.Map.Delete(user)
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the password: " + err.Error(),
+		}, responseHeader
+	}
+	if invite != nil {
+		err = UseRegistrationInvite(invite, user.Id, request.DBInfo)
+		if err != nil {
+			request.DBInfo.Map.Exec("delete from "+PasswordTable+" where user_id=$1", user.Id)
+			request.DBInfo.Map.Delete(user)
+			return 403, APIError{
+				Id:      "invalid_invite",
+				Message: "Registration requires an unused invite",
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+	auditUser(user, AuditCreate, nil, user, request)
+	apiError := sendVerificationEmail(user, resource.api, request)
+	if apiError != nil {
+		return 500, apiError, responseHeader
+	}
+	return 200, user, responseHeader
+}
+
+/*
+EmailVerificationResource activates Users who follow the link in their verification email
+*/
+type EmailVerificationResource struct {
+	api *API
+}
+
+func NewEmailVerificationResource(api *API) *EmailVerificationResource {
+	return &EmailVerificationResource{
+		api: api,
+	}
+}
+
+func (EmailVerificationResource) Name() string  { return "email-verification" }
+func (EmailVerificationResource) Path() string  { return "/user/verify" }
+func (EmailVerificationResource) Title() string { return "Email verification" }
+func (EmailVerificationResource) Description() string {
+	return "GET with a token, as in the emailed link, to verify and log in then redirect to the site. POST a token to do the same and receive the user, or POST an email to send a new link."
+}
+
+func (resource EmailVerificationResource) Properties() []Property {
+	return EmailVerificationProperties
+}
+
+func (resource EmailVerificationResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	user, apiError := verifyAndLogIn(request.Raw.URL.Query().Get("token"), request)
+	if apiError != nil {
+		return 400, apiError, responseHeader
+	}
+	responseHeader["Location"] = []string{resource.api.Registration.VerifiedURL}
+	return http.StatusSeeOther, user, responseHeader
+}
+
+func (resource EmailVerificationResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	var data EmailVerificationData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	if data.Token != "" {
+		user, apiError := verifyAndLogIn(data.Token, request)
+		if apiError != nil {
+			return 400, apiError, responseHeader
+		}
+		auditUser(user, "verify", nil, nil, request)
+		return 200, user, responseHeader
+	}
+	if data.Email == "" {
+		return 400, UnprocessableError, responseHeader
+	}
+	if resource.api.Registration.PublicURL == "" {
+		return 500, PublicURLRequiredError, responseHeader
+	}
+	// Respond the same whether or not there is an unverified user so that this can not be used to find accounts
+	email, err := NormalizeEmail(data.Email)
+	if err != nil {
+		return 200, "Ok", responseHeader
+	}
+	user, err := FindUserByEmail(email, request.DBInfo)
+	if err == nil && user.Verified == false {
+		apiError := sendVerificationEmail(user, resource.api, request)
+		if apiError != nil {
+			return 500, apiError, responseHeader
+		}
+	}
+	return 200, "Ok", responseHeader
+}
+
+func verifyAndLogIn(token string, request *APIRequest) (*User, *APIError) {
+	if token == "" {
+		return nil, &APIError{
+			Id:      "missing_token",
+			Message: "A verification token is required",
+		}
+	}
+	user, err := VerifyEmail(token, request.DBInfo)
+	if err != nil {
+		return nil, &APIError{
+			Id:      "invalid_token",
+			Message: "The verification link is invalid or has expired",
+			Error:   err.Error(),
+		}
+	}
+	if request.Session != nil {
+		LogIn(request.Session, user)
+	}
+	return user, nil
+}
+
+func sendVerificationEmail(user *User, api *API, request *APIRequest) *APIError {
+	if api.Registration.PublicURL == "" {
+		return &PublicURLRequiredError
+	}
+	token, err := CreateEmailVerification(user.Id, request.DBInfo)
+	if err != nil {
+		return &APIError{
+			Id:      "database_error",
+			Message: "Could not create the verification: " + err.Error(),
+		}
+	}
+	link := api.Registration.PublicURL + api.Path + NewEmailVerificationResource(api).Path() + "?token=" + url.QueryEscape(token)
+	err = api.Mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "Verify your Spaciblō account",
+		Body:    "Follow this link to finish creating your account:\n\n" + link + "\n\nIf you did not create an account, you can ignore this email.\n",
+	})
+	if err != nil {
+		return &APIError{
+			Id:      "mail_error",
+			Message: "Could not send the verification email",
+			Error:   err.Error(),
+		}
+	}
+	return nil
+}
+
+/*
+RegistrationInvitesResource lets people with users:manage create the invites required when registration is invite only
+*/
+type RegistrationInvitesResource struct {
+}
+
+func NewRegistrationInvitesResource() *RegistrationInvitesResource {
+	return &RegistrationInvitesResource{}
+}
+
+func (RegistrationInvitesResource) Name() string  { return "registration-invites" }
+func (RegistrationInvitesResource) Path() string  { return "/user/invite/" }
+func (RegistrationInvitesResource) Title() string { return "Registration invites" }
+func (RegistrationInvitesResource) Description() string {
+	return "A list of registration invites, for people with the users:manage permission. POST to create one."
+}
+
+func (resource RegistrationInvitesResource) Properties() []Property {
+	return RegistrationInvitesProperties
+}
+
+func (resource RegistrationInvitesResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	offset, limit := GetOffsetAndLimit(request.Raw.URL.Query())
+	invites, err := FindRegistrationInvites(offset, limit, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	return 200, &APIList{
+		Offset:  offset,
+		Limit:   limit,
+		Objects: invites,
+	}, responseHeader
+}
+
+func (resource RegistrationInvitesResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status, apiError := request.CheckPermission(PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	var data RegistrationInvite
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	invite, err := CreateRegistrationInvite(strings.TrimSpace(data.Email), request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the invite: " + err.Error(),
+		}, responseHeader
+	}
+	// Leave out the code, which is as good as the invite itself
+	request.Audit(AuditCreate, "registration-invite", strconv.FormatInt(invite.Id, 10), nil, map[string]string{"email": invite.Email})
+	return 200, invite, responseHeader
+}
@@ -0,0 +1,155 @@
+package be
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestRegistrationConfig(t *testing.T) {
+	config := RegistrationConfig{}
+	AssertTrue(t, config.AllowsEmail("alice@example.com"))
+	config.AllowedDomains = []string{"example.com", "@Example.org"}
+	AssertTrue(t, config.AllowsEmail("alice@EXAMPLE.com"))
+	AssertTrue(t, config.AllowsEmail("alice@example.org"))
+	AssertFalse(t, config.AllowsEmail("alice@example.com.evil.net"))
+	AssertFalse(t, config.AllowsEmail("alice"))
+
+	email, err := NormalizeEmail(" Alice <alice@example.com> ")
+	AssertNil(t, err)
+	AssertEqual(t, "alice@example.com", email)
+	email, err = NormalizeEmail("Alice@Example.COM")
+	AssertNil(t, err)
+	AssertEqual(t, "alice@example.com", email)
+	_, err = NormalizeEmail("alice")
+	AssertNotNil(t, err)
+}
+
+func TestFormatMailMessage(t *testing.T) {
+	date := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+	data, err := FormatMailMessage("site@example.com", &MailMessage{
+		To:      "alice@example.com",
+		Subject: "Hi",
+		Body:    "One\nTwo",
+	}, date)
+	AssertNil(t, err)
+	AssertEqual(t, "From: site@example.com\r\nTo: alice@example.com\r\nSubject: Hi\r\nDate: Sat, 04 Mar 2017 05:06:07 +0000\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nOne\r\nTwo", string(data))
+	_, err = FormatMailMessage("site@example.com", &MailMessage{To: "alice@example.com\r\nBcc: eve@example.com"}, date)
+	AssertNotNil(t, err, "Header injection should fail")
+}
+
+func TestRegistrationAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	mailer := NewMemoryMailer()
+	testApi.API.Mailer = mailer
+
+	client, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	register := func(data RegistrationData) (int, APIError) {
+		// PostJSON returns an error along with the response for non-200 statuses
+		resp, _ := client.PostJSON("/user/register", data)
+		AssertNotNil(t, resp)
+		defer resp.Body.Close()
+		var apiError APIError
+		if resp.StatusCode != 200 {
+			AssertNil(t, json.NewDecoder(resp.Body).Decode(&apiError))
+		}
+		return resp.StatusCode, apiError
+	}
+	verificationToken := func(email string) string {
+		message := mailer.LastMessageTo(email)
+		AssertNotNil(t, message)
+		start := strings.Index(message.Body, "https://")
+		AssertTrue(t, start != -1, message.Body)
+		link, err := url.Parse(strings.Fields(message.Body[start:])[0])
+		AssertNil(t, err)
+		return link.Query().Get("token")
+	}
+
+	// Registration is closed by default
+	status, apiError := register(RegistrationData{Email: "alice@example.com", Password: "12345678"})
+	AssertEqual(t, 403, status)
+	AssertEqual(t, RegistrationClosedError.Id, apiError.Id)
+
+	testApi.API.Registration.Mode = RegistrationOpen
+	testApi.API.Registration.AllowedDomains = []string{"example.com"}
+	status, apiError = register(RegistrationData{Email: "alice@example.org", Password: "12345678"})
+	AssertEqual(t, 403, status)
+	AssertEqual(t, "domain_not_allowed", apiError.Id)
+	status, apiError = register(RegistrationData{Email: "alice@example.com", Password: "1234"})
+	AssertEqual(t, 400, status)
+	AssertEqual(t, "short_password", apiError.Id)
+	status, _ = register(RegistrationData{Email: "alice@example.com", Password: "12345678", FirstName: "Alice"})
+	AssertEqual(t, 200, status)
+	status, apiError = register(RegistrationData{Email: "alice@example.com", Password: "12345678"})
+	AssertEqual(t, 400, status)
+	AssertEqual(t, "email_taken", apiError.Id)
+	status, apiError = register(RegistrationData{Email: "Alice@Example.com", Password: "12345678"})
+	AssertEqual(t, 400, status)
+	AssertEqual(t, "email_taken", apiError.Id, "Emails that differ only in case are the same account")
+	_, err = CreateUser("ALICE@example.com", "Alice", "", false, "", dbInfo)
+	AssertNotNil(t, err, "The database also keeps emails unique regardless of case")
+
+	// Unverified users can not log in
+	AssertNotNil(t, client.Authenticate("alice@example.com", "12345678"))
+	// Asking for another link invalidates the first
+	firstToken := verificationToken("alice@example.com")
+	resp, err := client.PostJSON("/user/verify", EmailVerificationData{Email: " ALICE@example.com"})
+	AssertNil(t, err)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode)
+	token := verificationToken("alice@example.com")
+	AssertTrue(t, firstToken != token)
+	user := new(User)
+	AssertNotNil(t, client.PostAndReceiveJSON("/user/verify", EmailVerificationData{Token: firstToken}, user))
+	AssertNil(t, client.PostAndReceiveJSON("/user/verify", EmailVerificationData{Token: token}, user))
+	AssertTrue(t, user.Verified)
+	AssertEqual(t, "Alice", user.FirstName)
+	AssertNil(t, client.Authenticate("alice@example.com", "12345678"))
+	AssertFalse(t, client.User.Staff)
+
+	// Invites are staff only and each may be used once
+	_, err = client.GetList("/user/invite/")
+	AssertNotNil(t, err)
+	staff, err := CreateUser("staff@example.com", "Staff", "", true, "", dbInfo)
+	AssertNil(t, err)
+	_, err = CreatePassword("1234", staff.Id, dbInfo)
+	AssertNil(t, err)
+	staffClient, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, staffClient.Authenticate("staff@example.com", "1234"))
+	invite := new(RegistrationInvite)
+	AssertNil(t, staffClient.PostAndReceiveJSON("/user/invite/", RegistrationInvite{Email: "bob@example.com"}, invite))
+	AssertTrue(t, invite.Code != "")
+
+	testApi.API.Registration.Mode = RegistrationInviteOnly
+	status, apiError = register(RegistrationData{Email: "bob@example.com", Password: "12345678"})
+	AssertEqual(t, 403, status)
+	AssertEqual(t, "invalid_invite", apiError.Id)
+	status, apiError = register(RegistrationData{Email: "carol@example.com", Password: "12345678", Invite: invite.Code})
+	AssertEqual(t, 403, status, "The invite is for bob")
+	status, _ = register(RegistrationData{Email: "bob@example.com", Password: "12345678", Invite: invite.Code})
+	AssertEqual(t, 200, status)
+	list, err := staffClient.GetList("/user/invite/")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+	bob, err := FindUserByEmail("bob@example.com", dbInfo)
+	AssertNil(t, err)
+	AssertFalse(t, bob.Verified)
+	AssertEqual(t, float64(bob.Id), list.Objects.([]interface{})[0].(map[string]interface{})["used-by"])
+}
@@ -0,0 +1,211 @@
+package be
+
+import (
+	"errors"
+)
+
+const UserRoleTable = "user_roles"
+
+// Permissions are granted by Roles. Staff have every permission.
+const (
+	PermissionTemplatesWrite = "templates:write" // Create, change, publish, and delete templates and their data
+	PermissionAvatarsWrite   = "avatars:write"   // Create, change, and delete avatars and their parts
+	PermissionSpacesCreate   = "spaces:create"   // Create spaces, including by cloning and importing bundles
+	PermissionSpacesWrite    = "spaces:write"    // Change spaces and export them as bundles
+	PermissionUsersModerate  = "users:moderate"  // List users and log out their sessions
+	PermissionUsersManage    = "users:manage"    // Change users, their storage and roles, create registration invites, and read the audit log
+)
+
+var Permissions = []string{
+	PermissionTemplatesWrite,
+	PermissionAvatarsWrite,
+	PermissionSpacesCreate,
+	PermissionSpacesWrite,
+	PermissionUsersModerate,
+	PermissionUsersManage,
+}
+
+const (
+	RoleAdmin          = "admin"
+	RoleContentManager = "content-manager"
+	RoleSpaceBuilder   = "space-builder"
+	RoleModerator      = "moderator"
+)
+
+/*
+Role is a named set of Permissions that can be given to Users
+*/
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+var Roles = []Role{
+	Role{
+		Name:        RoleAdmin,
+		Description: "Can do everything that staff can, except make other users staff",
+		Permissions: Permissions,
+	},
+	Role{
+		Name:        RoleContentManager,
+		Description: "Manages templates and avatars",
+		Permissions: []string{PermissionTemplatesWrite, PermissionAvatarsWrite},
+	},
+	Role{
+		Name:        RoleSpaceBuilder,
+		Description: "Creates and changes spaces",
+		Permissions: []string{PermissionSpacesCreate, PermissionSpacesWrite},
+	},
+	Role{
+		Name:        RoleModerator,
+		Description: "Looks after users and can log them out",
+		Permissions: []string{PermissionUsersModerate},
+	},
+}
+
+/*
+FindRole returns the Role named name, or an error if there is no such Role
+*/
+func FindRole(name string) (*Role, error) {
+	for i := range Roles {
+		if Roles[i].Name == name {
+			return &Roles[i], nil
+		}
+	}
+	return nil, errors.New("Unknown role: " + name)
+}
+
+/*
+HasPermission returns true if permissions includes permission
+*/
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+HasRole returns true if roles includes role
+*/
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+RolePermissions returns the Permissions granted by any of the named Roles, ignoring unknown names
+*/
+func RolePermissions(roles []string) []string {
+	permissions := []string{}
+	for _, name := range roles {
+		role, err := FindRole(name)
+		if err != nil {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			if HasPermission(permissions, permission) == false {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+/*
+UserRole records that a User has a Role
+*/
+type UserRole struct {
+	Id     int64  `db:"id, primarykey, autoincrement"`
+	UserId int64  `db:"user_id"`
+	Role   string `db:"role"`
+}
+
+/*
+FindUserRoles returns the names of the User's Roles in alphabetical order
+*/
+func FindUserRoles(userId int64, dbInfo *DBInfo) ([]string, error) {
+	var userRoles []UserRole
+	_, err := dbInfo.Map.Select(&userRoles, "select * from "+UserRoleTable+" where user_id=$1 order by role", userId)
+	if err != nil {
+		return nil, err
+	}
+	roles := []string{}
+	for _, userRole := range userRoles {
+		roles = append(roles, userRole.Role)
+	}
+	return roles, nil
+}
+
+/*
+FindUserPermissions returns every Permission of the User, which is all of them for staff
+*/
+func FindUserPermissions(user *User, dbInfo *DBInfo) ([]string, error) {
+	if user.Staff {
+		return append([]string{}, Permissions...), nil
+	}
+	roles, err := FindUserRoles(user.Id, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	return RolePermissions(roles), nil
+}
+
+/*
+AddUserRole gives the User the Role named role, if they do not already have it
+*/
+func AddUserRole(userId int64, role string, dbInfo *DBInfo) error {
+	_, err := FindRole(role)
+	if err != nil {
+		return err
+	}
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+UserRoleTable+" where user_id=$1 and role=$2", userId, role)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return dbInfo.Map.Insert(&UserRole{
+		UserId: userId,
+		Role:   role,
+	})
+}
+
+/*
+RemoveUserRole takes the Role named role from the User
+*/
+func RemoveUserRole(userId int64, role string, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+UserRoleTable+" where user_id=$1 and role=$2", userId, role)
+	return err
+}
+
+/*
+SetUserRoles replaces the User's Roles, returning an error without changing them if a name is unknown
+*/
+func SetUserRoles(userId int64, roles []string, dbInfo *DBInfo) error {
+	for _, role := range roles {
+		_, err := FindRole(role)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := dbInfo.Map.Exec("delete from "+UserRoleTable+" where user_id=$1", userId)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		err = AddUserRole(userId, role, dbInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package be
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+var RoleProperties = []Property{
+	Property{
+		Name:        "name",
+		Description: "name",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "description",
+		Description: "What people with the role do",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "permissions",
+		Description: "The permissions that the role grants",
+		DataType:    "array",
+		Protected:   true,
+	},
+}
+
+var RolesProperties = NewAPIListProperties("role")
+
+var UserRolesProperties = []Property{
+	Property{
+		Name:        "roles",
+		Description: "The names of the user's roles",
+		DataType:    "array",
+	},
+	Property{
+		Name:        "permissions",
+		Description: "Everything the roles allow, which is every permission for staff",
+		DataType:    "array",
+		Protected:   true,
+	},
+}
+
+type UserRoles struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+/*
+Permissions returns what the request's User may do, limited by the scopes of an API token
+*/
+func (request *APIRequest) Permissions() ([]string, error) {
+	if request.User == nil {
+		return []string{}, nil
+	}
+	if request.permissions == nil {
+		permissions, err := FindUserPermissions(request.User, request.DBInfo)
+		if err != nil {
+			return nil, err
+		}
+		request.permissions = permissions
+	}
+	return request.permissions, nil
+}
+
+/*
+HasPermission returns true if the request's User has permission, logging and returning false if it can not be found
+*/
+func (request *APIRequest) HasPermission(permission string) bool {
+	permissions, err := request.Permissions()
+	if err != nil {
+		logger.Print("Could not find permissions: " + err.Error())
+		return false
+	}
+	return HasPermission(permissions, permission)
+}
+
+/*
+CheckPermission returns an error unless the request's User has permission
+*/
+func (request *APIRequest) CheckPermission(permission string) (int, *APIError) {
+	if request.User == nil {
+		return 401, &NotLoggedInError
+	}
+	if request.HasPermission(permission) == false {
+		return 403, &APIError{
+			Id:      PermissionDeniedError.Id,
+			Message: PermissionDeniedError.Message,
+			Error:   "Requires " + permission,
+		}
+	}
+	return 0, nil
+}
+
+/*
+isPrivileged returns true if the request's User is staff or has any permission from a role
+*/
+func (request *APIRequest) isPrivileged() bool {
+	if request.User == nil {
+		return false
+	}
+	if request.User.Staff {
+		return true
+	}
+	permissions, err := request.Permissions()
+	return err != nil || len(permissions) > 0
+}
+
+/*
+RolesResource lists the Roles that can be given to Users
+*/
+type RolesResource struct{}
+
+func NewRolesResource() *RolesResource {
+	return &RolesResource{}
+}
+
+func (RolesResource) Name() string  { return "roles" }
+func (RolesResource) Path() string  { return "/role/" }
+func (RolesResource) Title() string { return "Roles" }
+func (RolesResource) Description() string {
+	return "The roles that can be given to users and the permissions that each grants."
+}
+
+func (resource RolesResource) Properties() []Property {
+	return RolesProperties
+}
+
+func (resource RolesResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	return 200, &APIList{
+		Offset:  0,
+		Limit:   len(Roles),
+		Objects: Roles,
+	}, responseHeader
+}
+
+/*
+UserRolesResource shows a User's Roles and lets people with users:manage change them
+*/
+type UserRolesResource struct{}
+
+func NewUserRolesResource() *UserRolesResource {
+	return &UserRolesResource{}
+}
+
+func (UserRolesResource) Name() string  { return "user-roles" }
+func (UserRolesResource) Path() string  { return "/user/{uuid:[0-9,a-z,-]+}/role/" }
+func (UserRolesResource) Title() string { return "User roles" }
+func (UserRolesResource) Description() string {
+	return "The user's roles and permissions. People may read their own, and those with the users:manage permission may PUT a new list of roles."
+}
+
+func (resource UserRolesResource) Properties() []Property {
+	return UserRolesProperties
+}
+
+func (resource UserRolesResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	user := request.User
+	if request.PathValues["uuid"] != user.UUID {
+		var status int
+		var apiError *APIError
+		user, status, apiError = findManagedUser(request, PermissionUsersManage)
+		if apiError != nil {
+			return status, apiError, responseHeader
+		}
+	}
+	return userRolesResponse(user, request, responseHeader)
+}
+
+func (resource UserRolesResource) Put(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	user, status, apiError := findManagedUser(request, PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	var data UserRoles
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	current, err := FindUserRoles(user.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	// People may only give or take away roles whose permissions they already have
+	for _, name := range append(current, data.Roles...) {
+		if HasRole(current, name) && HasRole(data.Roles, name) {
+			continue
+		}
+		role, err := FindRole(name)
+		if err != nil {
+			return 400, APIError{
+				Id:      "unknown_role",
+				Message: err.Error(),
+			}, responseHeader
+		}
+		for _, permission := range role.Permissions {
+			if request.HasPermission(permission) == false {
+				return 403, APIError{
+					Id:      PermissionDeniedError.Id,
+					Message: "Only people with every permission of the " + name + " role may change who has it",
+				}, responseHeader
+			}
+		}
+	}
+	err = SetUserRoles(user.Id, data.Roles, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not set the roles: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditUpdate, "user-roles", user.UUID, current, data.Roles)
+	return userRolesResponse(user, request, responseHeader)
+}
+
+func userRolesResponse(user *User, request *APIRequest, responseHeader http.Header) (int, interface{}, http.Header) {
+	roles, err := FindUserRoles(user.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	permissions, err := FindUserPermissions(user, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	return 200, UserRoles{
+		Roles:       roles,
+		Permissions: permissions,
+	}, responseHeader
+}
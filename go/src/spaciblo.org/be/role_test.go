@@ -0,0 +1,88 @@
+package be
+
+import (
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestRolePermissions(t *testing.T) {
+	for _, role := range Roles {
+		for _, permission := range role.Permissions {
+			AssertTrue(t, HasPermission(Permissions, permission), role.Name+" grants an unknown permission")
+		}
+	}
+	permissions := RolePermissions([]string{RoleContentManager, RoleSpaceBuilder, "unknown"})
+	AssertEqual(t, 4, len(permissions))
+	AssertFalse(t, HasPermission(permissions, PermissionUsersManage))
+	AssertEqual(t, len(Permissions), len(RolePermissions([]string{RoleAdmin, RoleModerator})))
+
+	token := &APIToken{Scopes: []string{ScopeTemplates}}
+	limited := token.LimitPermissions(Permissions)
+	AssertEqual(t, []string{PermissionTemplatesWrite, PermissionAvatarsWrite}, limited)
+	token.Scopes = []string{ScopeReadOnly}
+	AssertEqual(t, 0, len(token.LimitPermissions(Permissions)))
+	token.Scopes = []string{ScopeSpaces, ScopeAdmin}
+	AssertEqual(t, len(Permissions), len(token.LimitPermissions(Permissions)))
+}
+
+func TestRolesAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	userClient, staffClient, err := CreateTestUserAndStaffWithClients(testApi, dbInfo)
+	AssertNil(t, err)
+
+	list, err := userClient.GetList("/role/")
+	AssertNil(t, err)
+	AssertEqual(t, len(Roles), len(list.Objects.([]interface{})))
+
+	rolesURL := "/user/" + userClient.User.UUID + "/role/"
+	userRoles := new(UserRoles)
+	AssertNil(t, userClient.GetJSON(rolesURL, userRoles))
+	AssertEqual(t, 0, len(userRoles.Roles))
+	AssertEqual(t, 0, len(userRoles.Permissions))
+	_, err = userClient.GetList("/user/")
+	AssertNotNil(t, err)
+	AssertNotNil(t, userClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{RoleAdmin}}, userRoles), "People can not give themselves roles")
+
+	// Moderators can list users and log them out, but not staff
+	AssertNotNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{"unknown"}}, userRoles))
+	AssertNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{RoleModerator}}, userRoles))
+	AssertEqual(t, []string{RoleModerator}, userRoles.Roles)
+	AssertEqual(t, []string{PermissionUsersModerate}, userRoles.Permissions)
+	_, err = userClient.GetList("/user/")
+	AssertNil(t, err)
+	_, err = userClient.GetList("/user/" + staffClient.User.UUID + "/session/")
+	AssertNotNil(t, err)
+	invite := new(RegistrationInvite)
+	AssertNotNil(t, userClient.PostAndReceiveJSON("/user/invite/", RegistrationInvite{}, invite))
+
+	// Admins manage users, but may not give roles with permissions they lack or change staff
+	AssertNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{RoleAdmin}}, userRoles))
+	AssertNil(t, userClient.PostAndReceiveJSON("/user/invite/", RegistrationInvite{}, invite))
+	other, err := CreateUser("other@example.com", "Other", "", false, "", dbInfo)
+	AssertNil(t, err)
+	otherRolesURL := "/user/" + other.UUID + "/role/"
+	AssertNil(t, userClient.PutAndReceiveJSON(otherRolesURL, UserRoles{Roles: []string{RoleSpaceBuilder}}, userRoles))
+	AssertEqual(t, []string{RoleSpaceBuilder}, userRoles.Roles)
+	AssertNotNil(t, userClient.GetJSON("/user/"+staffClient.User.UUID+"/role/", userRoles))
+	other.Staff = true
+	AssertNil(t, userClient.PutAndReceiveJSON("/user/"+other.UUID, other, new(User)))
+	updated, err := FindUser(other.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertFalse(t, updated.Staff)
+
+	AssertNil(t, staffClient.PutAndReceiveJSON(rolesURL, UserRoles{Roles: []string{}}, userRoles))
+	_, err = userClient.GetList("/user/")
+	AssertNotNil(t, err)
+}
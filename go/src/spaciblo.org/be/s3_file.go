@@ -0,0 +1,682 @@
+package be
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3DerivativesName   = "d_e_r_i_v_a_t_i_v_e_s"
+	s3Algorithm         = "AWS4-HMAC-SHA256"
+	s3Service           = "s3"
+	s3DateFormat        = "20060102T150405Z"
+	s3UnsignedPayload   = "UNSIGNED-PAYLOAD"
+	s3DefaultRegion     = "us-east-1"
+	s3MinimumPartSize   = 5 * 1024 * 1024 // S3 rejects multipart uploads with smaller parts, except for the last part
+	s3MaximumPresignAge = 7 * 24 * time.Hour
+)
+
+/*
+S3FileStorage is a FileStorage persisted by a bucket of an S3 compatible object storage service like AWS S3 or MinIO.
+
+Originals are stored as objects named <Prefix><key> and derivatives as <Prefix>d_e_r_i_v_a_t_i_v_e_s/<key>/<derivative>.
+Keys have the same form as LocalFileStorage keys.
+
+Requests are signed with AWS Signature Version 4 and use path style URLs (<Endpoint>/<Bucket>/<object>) so any endpoint will work.
+Put streams data in multipart uploads of PartSize so large files are never held in memory.
+If PresignExpiry is not zero then S3Files are RedirectableFiles and APIRequest.ServeFile redirects clients to presigned URLs instead of proxying the data.
+*/
+type S3FileStorage struct {
+	Endpoint      *url.URL
+	Region        string
+	Bucket        string
+	Prefix        string
+	AccessKey     string
+	SecretKey     string
+	PartSize      int
+	PresignExpiry time.Duration
+	Client        *http.Client
+}
+
+/*
+NewS3FileStorage requires a bucket and an endpoint URL like https://s3.us-east-1.amazonaws.com
+*/
+func NewS3FileStorage(endpoint string, region string, bucket string, accessKey string, secretKey string) (*S3FileStorage, error) {
+	if bucket == "" {
+		return nil, errors.New("S3 file storage requires a bucket")
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpointURL.Scheme == "" || endpointURL.Host == "" {
+		return nil, errors.New("S3 file storage requires an absolute endpoint URL: " + endpoint)
+	}
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	return &S3FileStorage{
+		Endpoint:  endpointURL,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PartSize:  s3MinimumPartSize,
+		Client:    http.DefaultClient,
+	}, nil
+}
+
+/*
+Put streams the data from reader into a new object and returns its key
+*/
+func (fs *S3FileStorage) Put(name string, reader io.Reader) (key string, err error) {
+	key = LocalFileStorage{}.generateKey(name)
+	err = fs.upload(fs.objectName(key, ""), MimeTypeFromFileName(name), reader)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (fs *S3FileStorage) PutDerivative(key string, derivative string, reader io.Reader) error {
+	if derivative == "" {
+		return errors.New("Derivatives require a name")
+	}
+	exists, err := fs.Exists(key, "")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("Cannot create a derivative for a non-existant key")
+	}
+	return fs.upload(fs.objectName(key, derivative), MimeTypeFromFileName(key), reader)
+}
+
+func (fs *S3FileStorage) Get(key string, derivative string) (File, error) {
+	key = LocalFileStorage{}.clean(key)
+	size, err := fs.head(fs.objectName(key, derivative))
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.New("No such File: " + key + " with derivative: " + derivative)
+	}
+	return S3File{
+		fs:         fs,
+		key:        key,
+		derivative: derivative,
+		size:       size,
+	}, nil
+}
+
+func (fs *S3FileStorage) Exists(key string, derivative string) (bool, error) {
+	key = LocalFileStorage{}.clean(key)
+	if key == "" {
+		return false, errors.New("Empty file key")
+	}
+	size, err := fs.head(fs.objectName(key, derivative))
+	if err != nil {
+		return false, err
+	}
+	return size >= 0, nil
+}
+
+/*
+Delete removes the object for the key or derivative. Deleting the original also deletes all of its derivatives.
+Like LocalFileStorage, Delete returns nil for keys that do not exist.
+*/
+func (fs *S3FileStorage) Delete(key string, derivative string) error {
+	key = LocalFileStorage{}.clean(key)
+	if key == "" {
+		return errors.New("Empty file key")
+	}
+	if derivative == "" {
+		derivatives, err := fs.list(fs.derivativesPrefix(key))
+		if err != nil {
+			return err
+		}
+		for _, derivative := range derivatives {
+			err = fs.deleteObject(derivative.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return fs.deleteObject(fs.objectName(key, derivative))
+}
+
+/*
+CollectGarbage deletes unreferenced originals last modified before the given time and the derivatives of missing originals.
+Incomplete multipart uploads are aborted by S3FileStorage.Put, so remaining ones should be expired by a bucket lifecycle rule.
+*/
+func (fs *S3FileStorage) CollectGarbage(liveKeys map[string]bool, before time.Time) (*FileGCReport, error) {
+	objects, err := fs.list(fs.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	report := &FileGCReport{}
+	derivativesPrefix := fs.Prefix + s3DerivativesName + "/"
+	storedKeys := map[string]bool{}
+	for _, object := range objects {
+		key := strings.TrimPrefix(object.Key, fs.Prefix)
+		if strings.HasPrefix(object.Key, derivativesPrefix) || strings.Contains(key, "/") {
+			continue
+		}
+		if liveKeys[key] || !object.LastModified.Before(before) {
+			storedKeys[key] = true
+			continue
+		}
+		err = fs.deleteObject(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		report.Originals += 1
+		report.Bytes += object.Size
+	}
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, derivativesPrefix) {
+			continue
+		}
+		key := strings.Split(strings.TrimPrefix(object.Key, derivativesPrefix), "/")[0]
+		if storedKeys[key] {
+			continue
+		}
+		err = fs.deleteObject(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		report.Derivatives += 1
+		report.Bytes += object.Size
+	}
+	return report, nil
+}
+
+/*
+PresignedURL returns a URL for a GET of the key or derivative that needs no other authorization until expires passes
+*/
+func (fs *S3FileStorage) PresignedURL(key string, derivative string, expires time.Duration) string {
+	return presignS3URL(fs.objectURL(fs.objectName(key, derivative), nil), fs.AccessKey, fs.SecretKey, fs.Region, expires, time.Now())
+}
+
+func (fs *S3FileStorage) objectName(key string, derivative string) string {
+	if derivative == "" {
+		return fs.Prefix + key
+	}
+	return fs.derivativesPrefix(key) + LocalFileStorage{}.clean(derivative)
+}
+
+func (fs *S3FileStorage) derivativesPrefix(key string) string {
+	return fs.Prefix + s3DerivativesName + "/" + key + "/"
+}
+
+/*
+upload sends the data from reader in one PUT if it fits in a single part, otherwise it uses a multipart upload
+*/
+func (fs *S3FileStorage) upload(objectName string, contentType string, reader io.Reader) error {
+	partSize := fs.PartSize
+	if partSize <= 0 {
+		partSize = s3MinimumPartSize
+	}
+	buffer := make([]byte, partSize)
+	count, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		header := http.Header{}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		response, err := fs.do("PUT", objectName, nil, header, buffer[:count])
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	uploadId, err := fs.createMultipartUpload(objectName, contentType)
+	if err != nil {
+		return err
+	}
+	completion := s3CompleteMultipartUpload{}
+	for partNumber := 1; count > 0; partNumber++ {
+		etag, err := fs.uploadPart(objectName, uploadId, partNumber, buffer[:count])
+		if err != nil {
+			fs.abortMultipartUpload(objectName, uploadId)
+			return err
+		}
+		completion.Parts = append(completion.Parts, s3CompletedPart{
+			PartNumber: partNumber,
+			ETag:       etag,
+		})
+		count, err = io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			fs.abortMultipartUpload(objectName, uploadId)
+			return err
+		}
+	}
+	err = fs.completeMultipartUpload(objectName, uploadId, completion)
+	if err != nil {
+		fs.abortMultipartUpload(objectName, uploadId)
+		return err
+	}
+	return nil
+}
+
+func (fs *S3FileStorage) createMultipartUpload(objectName string, contentType string) (string, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	result := s3InitiateMultipartUploadResult{}
+	err := fs.doXML("POST", objectName, url.Values{"uploads": []string{""}}, header, nil, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.UploadId == "" {
+		return "", errors.New("S3 did not return an upload ID")
+	}
+	return result.UploadId, nil
+}
+
+func (fs *S3FileStorage) uploadPart(objectName string, uploadId string, partNumber int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": []string{strconv.Itoa(partNumber)},
+		"uploadId":   []string{uploadId},
+	}
+	response, err := fs.do("PUT", objectName, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	etag := response.Header.Get("ETag")
+	if etag == "" {
+		return "", errors.New("S3 did not return an ETag for part " + strconv.Itoa(partNumber))
+	}
+	return etag, nil
+}
+
+func (fs *S3FileStorage) completeMultipartUpload(objectName string, uploadId string, completion s3CompleteMultipartUpload) error {
+	data, err := xml.Marshal(completion)
+	if err != nil {
+		return err
+	}
+	return fs.doXML("POST", objectName, url.Values{"uploadId": []string{uploadId}}, nil, data, &s3CompleteMultipartUploadResult{})
+}
+
+// abortMultipartUpload is a best try to release the stored parts of a failed upload
+func (fs *S3FileStorage) abortMultipartUpload(objectName string, uploadId string) {
+	response, err := fs.do("DELETE", objectName, url.Values{"uploadId": []string{uploadId}}, nil, nil)
+	if err != nil {
+		logger.Println("Could not abort the S3 multipart upload", objectName, err)
+		return
+	}
+	response.Body.Close()
+}
+
+/*
+head returns the size of the object or -1 if it does not exist
+*/
+func (fs *S3FileStorage) head(objectName string) (int64, error) {
+	response, err := fs.do("HEAD", objectName, nil, nil, nil)
+	if err != nil {
+		if responseErr, ok := err.(*s3ResponseError); ok && responseErr.StatusCode == http.StatusNotFound {
+			return -1, nil
+		}
+		return -1, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+func (fs *S3FileStorage) deleteObject(objectName string) error {
+	response, err := fs.do("DELETE", objectName, nil, nil, nil)
+	if err != nil {
+		if responseErr, ok := err.(*s3ResponseError); ok && responseErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+/*
+list returns every object in the bucket with a name that starts with prefix
+*/
+func (fs *S3FileStorage) list(prefix string) ([]s3ListedObject, error) {
+	results := []s3ListedObject{}
+	continuationToken := ""
+	for {
+		query := url.Values{
+			"list-type": []string{"2"},
+			"prefix":    []string{prefix},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		result := s3ListBucketResult{}
+		err := fs.doXML("GET", "", query, nil, nil, &result)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result.Contents...)
+		if result.IsTruncated == false || result.NextContinuationToken == "" {
+			return results, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+/*
+objectURL returns the path style URL of an object, or of the bucket if objectName is ""
+*/
+func (fs *S3FileStorage) objectURL(objectName string, query url.Values) *url.URL {
+	escapedPath := strings.TrimRight(fs.Endpoint.EscapedPath(), "/") + "/" + s3Escape(fs.Bucket, true)
+	if objectName != "" {
+		escapedPath += "/" + s3Escape(objectName, false)
+	}
+	objectURL := &url.URL{
+		Scheme:   fs.Endpoint.Scheme,
+		Host:     fs.Endpoint.Host,
+		RawQuery: canonicalS3Query(query),
+	}
+	objectURL.Path, _ = url.PathUnescape(escapedPath)
+	objectURL.RawPath = escapedPath
+	return objectURL
+}
+
+/*
+do sends a signed request and returns the response if its status is 2xx, otherwise it returns an *s3ResponseError
+*/
+func (fs *S3FileStorage) do(method string, objectName string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	request, err := http.NewRequest(method, fs.objectURL(objectName, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	payloadHash := sha256.Sum256(body)
+	signS3Request(request, hex.EncodeToString(payloadHash[:]), fs.AccessKey, fs.SecretKey, fs.Region, time.Now())
+
+	client := fs.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		return nil, newS3ResponseError(response.StatusCode, response.Body)
+	}
+	return response, nil
+}
+
+/*
+doXML sends a signed request and unmarshals the XML response into result
+S3 may return a 200 status with an error document for long running requests like completing a multipart upload, so those are checked, too.
+*/
+func (fs *S3FileStorage) doXML(method string, objectName string, query url.Values, header http.Header, body []byte, result interface{}) error {
+	response, err := fs.do(method, objectName, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return newS3ResponseError(response.StatusCode, bytes.NewReader(data))
+	}
+	return xml.Unmarshal(data, result)
+}
+
+/*
+S3File is a be.File backed by an S3FileStorage
+*/
+type S3File struct {
+	fs         *S3FileStorage
+	key        string
+	derivative string
+	size       int64
+}
+
+func (sf S3File) Key() string {
+	return sf.key
+}
+
+func (sf S3File) Derivative() string {
+	return sf.derivative
+}
+
+/*
+Name is derived from Key which is <UUID><keySeparator><name>
+*/
+func (sf S3File) Name() (string, error) {
+	return sf.key[strings.Index(sf.key, keySeparator)+len(keySeparator):], nil
+}
+
+func (sf S3File) Exists() (bool, error) {
+	return sf.fs.Exists(sf.key, sf.derivative)
+}
+
+/*
+Size is read when the S3File is returned by S3FileStorage.Get
+*/
+func (sf S3File) Size() (int64, error) {
+	return sf.size, nil
+}
+
+/*
+Reader returns the body of a GET for the object, which the caller should close
+*/
+func (sf S3File) Reader() (io.Reader, error) {
+	response, err := sf.fs.do("GET", sf.fs.objectName(sf.key, sf.derivative), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+/*
+RedirectURL returns a presigned URL if the S3FileStorage has a PresignExpiry, otherwise ""
+*/
+func (sf S3File) RedirectURL() (string, error) {
+	if sf.fs.PresignExpiry <= 0 {
+		return "", nil
+	}
+	return sf.fs.PresignedURL(sf.key, sf.derivative, sf.fs.PresignExpiry), nil
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	Key  string `xml:"Key"`
+	ETag string `xml:"ETag"`
+}
+
+type s3ListedObject struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type s3ListBucketResult struct {
+	Contents              []s3ListedObject `xml:"Contents"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	NextContinuationToken string           `xml:"NextContinuationToken"`
+}
+
+type s3ResponseError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func newS3ResponseError(statusCode int, body io.Reader) *s3ResponseError {
+	responseErr := &s3ResponseError{}
+	xml.NewDecoder(body).Decode(responseErr) // HEAD responses and some proxies return no error document
+	responseErr.StatusCode = statusCode
+	return responseErr
+}
+
+func (err *s3ResponseError) Error() string {
+	return "S3 error " + strconv.Itoa(err.StatusCode) + " " + err.Code + ": " + err.Message
+}
+
+/*
+signS3Request adds the AWS Signature Version 4 Authorization header for the request's URL and headers
+All headers already set on the request are signed, so set them before calling.
+*/
+func signS3Request(request *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format(s3DateFormat)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headerNames := []string{"host"}
+	headerValues := map[string]string{"host": request.URL.Host}
+	if request.Host != "" {
+		headerValues["host"] = request.Host
+	}
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headerNames = append(headerNames, lowerName)
+		headerValues[lowerName] = strings.Join(trimmed, ",")
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := ""
+	for _, name := range headerNames {
+		canonicalHeaders += name + ":" + headerValues[name] + "\n"
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		canonicalS3Query(request.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := s3Scope(now, region)
+	signature := s3Signature(canonicalRequest, amzDate, scope, secretKey, now, region)
+	request.Header.Set("Authorization", s3Algorithm+" Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+/*
+presignS3URL returns objectURL with query parameters that authorize a GET until expires passes
+*/
+func presignS3URL(objectURL *url.URL, accessKey string, secretKey string, region string, expires time.Duration, now time.Time) string {
+	if expires > s3MaximumPresignAge {
+		expires = s3MaximumPresignAge
+	}
+	amzDate := now.UTC().Format(s3DateFormat)
+	scope := s3Scope(now, region)
+	query := objectURL.Query()
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		objectURL.EscapedPath(),
+		canonicalS3Query(query),
+		"host:" + objectURL.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	signature := s3Signature(canonicalRequest, amzDate, scope, secretKey, now, region)
+
+	presignedURL := *objectURL
+	presignedURL.RawQuery = canonicalS3Query(query) + "&X-Amz-Signature=" + signature
+	return presignedURL.String()
+}
+
+func s3Scope(now time.Time, region string) string {
+	return now.UTC().Format("20060102") + "/" + region + "/" + s3Service + "/aws4_request"
+}
+
+func s3Signature(canonicalRequest string, amzDate string, scope string, secretKey string, now time.Time, region string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := s3Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signingKey := s3HMAC([]byte("AWS4"+secretKey), now.UTC().Format("20060102"))
+	signingKey = s3HMAC(signingKey, region)
+	signingKey = s3HMAC(signingKey, s3Service)
+	signingKey = s3HMAC(signingKey, "aws4_request")
+	return hex.EncodeToString(s3HMAC(signingKey, stringToSign))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/*
+canonicalS3Query returns the query sorted by name with names and values escaped as S3 requires
+*/
+func canonicalS3Query(query url.Values) string {
+	names := []string{}
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+/*
+s3Escape percent encodes everything except unreserved characters, and slashes unless escapeSlash is true
+*/
+func s3Escape(value string, escapeSlash bool) string {
+	var buffer bytes.Buffer
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !escapeSlash) {
+			buffer.WriteByte(b)
+		} else {
+			buffer.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return buffer.String()
+}
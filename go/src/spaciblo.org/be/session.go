@@ -0,0 +1,161 @@
+package be
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/goincremental/negroni-sessions"
+)
+
+/*
+SessionVersionKey holds the User.SessionVersion at login so that changing it logs out every other session
+*/
+const SessionVersionKey string = "session-version"
+
+// Second factor session keys, which hold unix times as strings
+const (
+	SecondFactorKey         string = "second-factor-at"    // When the session last verified a second factor
+	PendingLogInKey         string = "pending-user-uuid"   // The User who entered their password but not yet their second factor
+	PendingLogInAtKey       string = "pending-login-at"    // When they entered their password
+	SecondFactorAttemptsKey string = "second-factor-tries" // Incorrect codes since the password was entered
+)
+
+// PendingLogInLifetime is how long someone has to enter their second factor after their password
+var PendingLogInLifetime = 5 * time.Minute
+
+// MaxSecondFactorAttempts incorrect codes end a pending login, so that codes can not be guessed
+const MaxSecondFactorAttempts = 5
+
+/*
+LogIn sets the session to authenticate as user
+*/
+func LogIn(session sessions.Session, user *User) {
+	session.Set(UserUUIDKey, user.UUID)
+	session.Set(SessionVersionKey, strconv.FormatInt(user.SessionVersion, 10))
+	session.Delete(PendingLogInKey)
+	session.Delete(PendingLogInAtKey)
+	session.Delete(SecondFactorAttemptsKey)
+}
+
+/*
+BeginLogIn logs the session in as user if they have no second factor, and returns true
+Otherwise it leaves the session logged out and waiting for FindPendingLogInUser and a second factor, and returns false.
+*/
+func BeginLogIn(session sessions.Session, user *User, dbInfo *DBInfo) (bool, error) {
+	hasTOTP, err := HasTOTP(user.Id, dbInfo)
+	if err != nil {
+		return false, err
+	}
+	if hasTOTP == false {
+		LogIn(session, user)
+		return true, nil
+	}
+	session.Delete(UserUUIDKey)
+	session.Delete(SecondFactorKey)
+	session.Set(PendingLogInKey, user.UUID)
+	session.Set(PendingLogInAtKey, strconv.FormatInt(time.Now().Unix(), 10))
+	session.Set(SecondFactorAttemptsKey, "0")
+	return false, nil
+}
+
+/*
+FindPendingLogInUser returns the User who entered their password in this session and must now enter a second factor
+*/
+func FindPendingLogInUser(session sessions.Session, dbInfo *DBInfo) (*User, error) {
+	uuid, _ := session.Get(PendingLogInKey).(string)
+	if uuid == "" {
+		return nil, errors.New("No pending login")
+	}
+	if sessionTime(session, PendingLogInAtKey).Add(PendingLogInLifetime).Before(time.Now()) {
+		return nil, errors.New("The pending login has expired")
+	}
+	return FindUser(uuid, dbInfo)
+}
+
+/*
+FailSecondFactor counts an incorrect code and returns true if there have been too many, in which case the session is logged out
+*/
+func FailSecondFactor(session sessions.Session) bool {
+	value, _ := session.Get(SecondFactorAttemptsKey).(string)
+	attempts, _ := strconv.Atoi(value)
+	attempts += 1
+	if attempts >= MaxSecondFactorAttempts {
+		session.Delete(UserUUIDKey)
+		session.Delete(SecondFactorKey)
+		session.Delete(PendingLogInKey)
+		session.Delete(PendingLogInAtKey)
+		session.Delete(SecondFactorAttemptsKey)
+		return true
+	}
+	session.Set(SecondFactorAttemptsKey, strconv.Itoa(attempts))
+	return false
+}
+
+/*
+MarkSecondFactor notes that the session has just verified a second factor
+*/
+func MarkSecondFactor(session sessions.Session) {
+	session.Set(SecondFactorKey, strconv.FormatInt(time.Now().Unix(), 10))
+	session.Set(SecondFactorAttemptsKey, "0")
+}
+
+/*
+HasRecentSecondFactor returns true if the session verified a second factor within SecondFactorLifetime
+*/
+func HasRecentSecondFactor(session sessions.Session) bool {
+	return sessionTime(session, SecondFactorKey).Add(SecondFactorLifetime).After(time.Now())
+}
+
+func sessionTime(session sessions.Session, key string) time.Time {
+	value, _ := session.Get(key).(string)
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+/*
+FindSessionUser returns the User that the session authenticates, or an error if the session is not logged in or has been invalidated
+*/
+func FindSessionUser(session sessions.Session, dbInfo *DBInfo) (*User, error) {
+	if session == nil {
+		return nil, errors.New("No session")
+	}
+	uuid, _ := session.Get(UserUUIDKey).(string)
+	if uuid == "" {
+		return nil, errors.New("Not logged in")
+	}
+	user, err := FindUser(uuid, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	// Sessions from before versioning have no version and are treated as version 0
+	version, _ := session.Get(SessionVersionKey).(string)
+	if version == "" {
+		version = "0"
+	}
+	if version != strconv.FormatInt(user.SessionVersion, 10) {
+		return nil, errors.New("The session has been invalidated")
+	}
+	return user, nil
+}
+
+/*
+InvalidateSessions logs out every session of user, except for keep if it is not nil
+*/
+func InvalidateSessions(user *User, keep sessions.Session, dbInfo *DBInfo) error {
+	user.SessionVersion += 1
+	err := UpdateUser(user, dbInfo)
+	if err != nil {
+		return err
+	}
+	keepUUID := ""
+	if keep != nil {
+		LogIn(keep, user)
+		keepUUID, _ = keep.Get(SessionUUIDKey).(string)
+	}
+	// Sessions in a DBSessionStore can be removed outright instead of waiting to be rejected
+	return RevokeUserSessions(user.UUID, keepUUID, dbInfo)
+}
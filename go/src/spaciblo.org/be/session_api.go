@@ -0,0 +1,202 @@
+package be
+
+import (
+	"net/http"
+)
+
+var UserSessionProperties = []Property{
+	Property{
+		Name:        "uuid",
+		Description: "uuid",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "user-agent",
+		Description: "The browser or device that logged in",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "ip",
+		Description: "The address that last used the session",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "last-seen",
+		Description: "When the session was last used, to the minute",
+		DataType:    "date-time",
+		Protected:   true,
+	},
+	Property{
+		Name:        "current",
+		Description: "True for the session that made the request",
+		DataType:    "bool",
+		Protected:   true,
+	},
+}
+
+var UserSessionsProperties = NewAPIListProperties("user-session")
+
+/*
+CurrentUserSessionsResource lists the authenticated User's sessions
+*/
+type CurrentUserSessionsResource struct{}
+
+func NewCurrentUserSessionsResource() *CurrentUserSessionsResource {
+	return &CurrentUserSessionsResource{}
+}
+
+func (CurrentUserSessionsResource) Name() string  { return "current-user-sessions" }
+func (CurrentUserSessionsResource) Path() string  { return "/user/current/session/" }
+func (CurrentUserSessionsResource) Title() string { return "Sessions" }
+func (CurrentUserSessionsResource) Description() string {
+	return "The browsers and devices that are logged in. DELETE to log out all of them except this one."
+}
+
+func (resource CurrentUserSessionsResource) Properties() []Property {
+	return UserSessionsProperties
+}
+
+func (resource CurrentUserSessionsResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	return userSessionsResponse(request.User, currentSessionUUID(request), request)
+}
+
+func (resource CurrentUserSessionsResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	err := RevokeUserSessions(request.User.UUID, currentSessionUUID(request), request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not revoke the sessions: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditDelete, "user-sessions", request.User.UUID, nil, nil)
+	return 200, "Ok", responseHeader
+}
+
+/*
+CurrentUserSessionResource revokes one of the authenticated User's sessions
+*/
+type CurrentUserSessionResource struct{}
+
+func NewCurrentUserSessionResource() *CurrentUserSessionResource {
+	return &CurrentUserSessionResource{}
+}
+
+func (CurrentUserSessionResource) Name() string  { return "current-user-session" }
+func (CurrentUserSessionResource) Path() string  { return "/user/current/session/{uuid:[0-9,a-z,-]+}" }
+func (CurrentUserSessionResource) Title() string { return "Session" }
+func (CurrentUserSessionResource) Description() string {
+	return "DELETE to log out the session."
+}
+
+func (resource CurrentUserSessionResource) Properties() []Property {
+	return UserSessionProperties
+}
+
+func (resource CurrentUserSessionResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	uuid, _ := request.PathValues["uuid"]
+	err := RevokeUserSession(request.User.UUID, uuid, request.DBInfo)
+	if err != nil {
+		return 404, APIError{
+			Id:      "no_such_session",
+			Message: "No such session: " + uuid,
+			Error:   err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditDelete, "session", uuid, nil, nil)
+	return 200, "Ok", responseHeader
+}
+
+/*
+UserSessionsResource lets moderators see and log out the sessions of any User
+*/
+type UserSessionsResource struct{}
+
+func NewUserSessionsResource() *UserSessionsResource {
+	return &UserSessionsResource{}
+}
+
+func (UserSessionsResource) Name() string  { return "user-sessions" }
+func (UserSessionsResource) Path() string  { return "/user/{uuid:[0-9,a-z,-]+}/session/" }
+func (UserSessionsResource) Title() string { return "User sessions" }
+func (UserSessionsResource) Description() string {
+	return "The user's sessions, for people with the users:moderate permission. DELETE to log out all of them."
+}
+
+func (resource UserSessionsResource) Properties() []Property {
+	return UserSessionsProperties
+}
+
+func (resource UserSessionsResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	user, status, apiError := findManagedUser(request, PermissionUsersModerate)
+	if apiError != nil {
+		return status, apiError, map[string][]string{}
+	}
+	return userSessionsResponse(user, currentSessionUUID(request), request)
+}
+
+func (resource UserSessionsResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	user, status, apiError := findManagedUser(request, PermissionUsersModerate)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	// InvalidateSessions also bumps the session version, which rejects copies of cookie store sessions
+	err := InvalidateSessions(user, nil, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not revoke the sessions: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditDelete, "user-sessions", user.UUID, nil, nil)
+	return 200, "Ok", responseHeader
+}
+
+func userSessionsResponse(user *User, currentUUID string, request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	userSessions, err := FindUserSessions(user.UUID, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}, responseHeader
+	}
+	for _, userSession := range userSessions {
+		userSession.Current = userSession.UUID == currentUUID
+	}
+	return 200, &APIList{
+		Offset:  0,
+		Limit:   len(userSessions),
+		Objects: userSessions,
+	}, responseHeader
+}
+
+/*
+currentSessionUUID returns the uuid of the request's DBSessionStore session, or "" if it has none
+*/
+func currentSessionUUID(request *APIRequest) string {
+	if request.Session == nil {
+		return ""
+	}
+	uuid, _ := request.Session.Get(SessionUUIDKey).(string)
+	return uuid
+}
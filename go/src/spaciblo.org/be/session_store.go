@@ -0,0 +1,288 @@
+package be
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
+	gSessions "github.com/gorilla/sessions"
+)
+
+const SessionTable = "sessions"
+
+// SessionUUIDKey holds the public id of a DBSessionStore session, which people use to list and revoke their sessions
+const SessionUUIDKey string = "session-uuid"
+
+// SessionLifetime is how long an unused session lasts. Each use extends it.
+var SessionLifetime = 30 * 24 * time.Hour
+
+// sessionSeenInterval limits how often loading a session writes its LastSeen, so that browsing does not write to the DB on every request
+const sessionSeenInterval = time.Minute
+
+/*
+UserSession is the DB record of a browser session
+The cookie holds a signed random key, of which only the hash is stored, so the DB alone can not be used to take over a session.
+*/
+type UserSession struct {
+	Id        int64     `json:"-" db:"id, primarykey, autoincrement"`
+	UUID      string    `json:"uuid" db:"u_u_i_d"`
+	KeyHash   string    `json:"-" db:"key_hash"`
+	UserUUID  string    `json:"-" db:"user_uuid"` // Empty until the session logs in
+	Data      []byte    `json:"-" db:"data"`      // The gob encoded session values
+	UserAgent string    `json:"user-agent" db:"user_agent"`
+	IP        string    `json:"ip" db:"ip"`
+	Created   time.Time `json:"created" db:"created"`
+	LastSeen  time.Time `json:"last-seen" db:"last_seen"`
+	Expires   time.Time `json:"expires" db:"expires"`
+
+	Current bool `json:"current" db:"-"` // True if this is the session of the request that listed it
+}
+
+/*
+DBSessionStore keeps session values in the DB so that sessions can be listed and revoked
+It implements negroni-sessions.Store and is shared by the api and ws services, which must use the same secret.
+*/
+type DBSessionStore struct {
+	Codecs  []securecookie.Codec
+	Token   nSessions.TokenGetSetter
+	DBInfo  *DBInfo
+	options *gSessions.Options
+}
+
+func NewDBSessionStore(dbInfo *DBInfo, keyPairs ...[]byte) *DBSessionStore {
+	return &DBSessionStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Token:  nSessions.NewCookieToken(),
+		DBInfo: dbInfo,
+		// The same defaults as the cookie store. The front end reads the cookie, so it is not HttpOnly.
+		options: &gSessions.Options{
+			Path:   "/",
+			MaxAge: int(SessionLifetime.Seconds()),
+		},
+	}
+}
+
+func (store *DBSessionStore) Options(options nSessions.Options) {
+	store.options = &gSessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HTTPOnly,
+	}
+}
+
+/*
+Get returns the session for name from the request's registry, loading it if necessary
+*/
+func (store *DBSessionStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(store, name)
+}
+
+/*
+New returns the session named in the request's cookie, or a new and empty session if there is none or it was revoked
+*/
+func (store *DBSessionStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(store, name)
+	options := *store.options
+	session.Options = &options
+	session.IsNew = true
+	cookie, err := store.Token.GetToken(r, name)
+	if err != nil {
+		return session, nil
+	}
+	var key string
+	err = securecookie.DecodeMulti(name, cookie, &key, store.Codecs...)
+	if err != nil {
+		// Probably a cookie from before sessions were stored in the DB, so start a new session
+		return session, nil
+	}
+	record, err := findSessionRecord(key, store.DBInfo)
+	if err != nil {
+		return session, nil
+	}
+	values, err := decodeSessionValues(record.Data)
+	if err != nil {
+		return session, err
+	}
+	session.ID = key
+	session.Values = values
+	session.IsNew = false
+	if time.Since(record.LastSeen) > sessionSeenInterval {
+		_, err = store.DBInfo.Map.Exec("update "+SessionTable+" set last_seen=$1, expires=$2, ip=$3 where id=$4", time.Now(), time.Now().Add(SessionLifetime), RequestIP(r), record.Id)
+		if err != nil {
+			logger.Print("Could not update session use: " + err.Error())
+		}
+	}
+	return session, nil
+}
+
+/*
+Save writes the session values to the DB and the signed session key to the cookie
+A session that logs in gets a new key so that a key planted before login can not be used afterward.
+*/
+func (store *DBSessionStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			_, err := store.DBInfo.Map.Exec("delete from "+SessionTable+" where key_hash=$1", HashToken(session.ID))
+			if err != nil {
+				return err
+			}
+		}
+		store.Token.SetToken(w, session.Name(), "", session.Options)
+		return nil
+	}
+	userUUID, _ := session.Values[UserUUIDKey].(string)
+	var record *UserSession
+	if session.ID != "" {
+		record, _ = findSessionRecord(session.ID, store.DBInfo)
+	}
+	if record != nil && record.UserUUID != userUUID {
+		_, err := store.DBInfo.Map.Delete(record)
+		if err != nil {
+			return err
+		}
+		record = nil
+	}
+	if record == nil {
+		key, err := RandomToken()
+		if err != nil {
+			return err
+		}
+		err = DeleteExpiredSessions(store.DBInfo)
+		if err != nil {
+			return err
+		}
+		session.ID = key
+		session.Values[SessionUUIDKey] = UUID()
+		record = &UserSession{
+			KeyHash: HashToken(key),
+			Created: time.Now(),
+		}
+	}
+	data, err := encodeSessionValues(session.Values)
+	if err != nil {
+		return err
+	}
+	record.UUID, _ = session.Values[SessionUUIDKey].(string)
+	record.UserUUID = userUUID
+	record.Data = data
+	record.UserAgent = r.UserAgent()
+	record.IP = RequestIP(r)
+	record.LastSeen = time.Now()
+	record.Expires = time.Now().Add(SessionLifetime)
+	if record.Id == 0 {
+		err = store.DBInfo.Map.Insert(record)
+	} else {
+		_, err = store.DBInfo.Map.Update(record)
+	}
+	if err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, store.Codecs...)
+	if err != nil {
+		return err
+	}
+	store.Token.SetToken(w, session.Name(), encoded, session.Options)
+	return nil
+}
+
+func findSessionRecord(key string, dbInfo *DBInfo) (*UserSession, error) {
+	record := new(UserSession)
+	err := dbInfo.Map.SelectOne(record, "select * from "+SessionTable+" where key_hash=$1 and expires>$2", HashToken(key), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func encodeSessionValues(values map[interface{}]interface{}) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := gob.NewEncoder(buffer).Encode(values)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeSessionValues(data []byte) (map[interface{}]interface{}, error) {
+	values := map[interface{}]interface{}{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+/*
+RequestIP returns the address of the client that sent the request, without the port
+*/
+func RequestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+FindUserSessions returns the unexpired sessions that are logged in as the User with userUUID, most recently used first
+*/
+func FindUserSessions(userUUID string, dbInfo *DBInfo) ([]*UserSession, error) {
+	var userSessions []*UserSession
+	_, err := dbInfo.Map.Select(&userSessions, "select * from "+SessionTable+" where user_uuid=$1 and expires>$2 order by last_seen desc", userUUID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return userSessions, nil
+}
+
+/*
+SessionIsActive returns true if the session with uuid has not been revoked or expired
+*/
+func SessionIsActive(uuid string, dbInfo *DBInfo) (bool, error) {
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+SessionTable+" where u_u_i_d=$1 and expires>$2", uuid, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+/*
+RevokeUserSession deletes the session with uuid if it belongs to the User with userUUID
+*/
+func RevokeUserSession(userUUID string, uuid string, dbInfo *DBInfo) error {
+	result, err := dbInfo.Map.Exec("delete from "+SessionTable+" where user_uuid=$1 and u_u_i_d=$2", userUUID, uuid)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("No such session")
+	}
+	return nil
+}
+
+/*
+RevokeUserSessions deletes every session of the User with userUUID except the one with keepUUID, if it is not empty
+*/
+func RevokeUserSessions(userUUID string, keepUUID string, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+SessionTable+" where user_uuid=$1 and u_u_i_d<>$2", userUUID, keepUUID)
+	return err
+}
+
+/*
+DeleteExpiredSessions removes the sessions that have not been used within SessionLifetime
+*/
+func DeleteExpiredSessions(dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+SessionTable+" where expires<$1", time.Now())
+	return err
+}
@@ -0,0 +1,94 @@
+package be
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestSessionValues(t *testing.T) {
+	values := map[interface{}]interface{}{
+		UserUUIDKey:       "user-1",
+		SessionVersionKey: "2",
+	}
+	data, err := encodeSessionValues(values)
+	AssertNil(t, err)
+	decoded, err := decodeSessionValues(data)
+	AssertNil(t, err)
+	AssertEqual(t, "user-1", decoded[UserUUIDKey])
+	AssertEqual(t, "2", decoded[SessionVersionKey])
+
+	AssertEqual(t, "10.0.0.1", RequestIP(&http.Request{RemoteAddr: "10.0.0.1:4321"}))
+	AssertEqual(t, "::1", RequestIP(&http.Request{RemoteAddr: "[::1]:4321"}))
+}
+
+func TestSessionsAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	userClient, staffClient, err := CreateTestUserAndStaffWithClients(testApi, dbInfo)
+	AssertNil(t, err)
+
+	list, err := userClient.GetList("/user/current/session/")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+	first := list.Objects.([]interface{})[0].(map[string]interface{})
+	AssertEqual(t, true, first["current"])
+	AssertEqual(t, "127.0.0.1", first["ip"])
+
+	// A second login is listed, and is not current for the first client
+	otherClient, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	AssertNil(t, otherClient.Authenticate(userClient.User.Email, "1234"))
+	AssertTrue(t, otherClient.Session != userClient.Session)
+	list, err = userClient.GetList("/user/current/session/")
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(list.Objects.([]interface{})))
+	var otherUUID string
+	for _, object := range list.Objects.([]interface{}) {
+		if object.(map[string]interface{})["current"] == false {
+			otherUUID = object.(map[string]interface{})["uuid"].(string)
+		}
+	}
+	AssertTrue(t, otherUUID != "")
+
+	// Revoking a session logs it out even though its cookie is still valid
+	AssertNil(t, userClient.Delete("/user/current/session/"+otherUUID))
+	user := new(User)
+	AssertNotNil(t, otherClient.GetJSON("/user/current", user))
+	AssertNotNil(t, staffClient.Delete("/user/current/session/"+first["uuid"].(string)), "Staff can not revoke it as their own")
+
+	// Logging out revokes the session on the server
+	cookie := userClient.Session
+	AssertNil(t, userClient.Deauthenticate())
+	userClient.Session = cookie
+	AssertNotNil(t, userClient.GetJSON("/user/current", user))
+
+	// Staff can log out everyone
+	AssertNil(t, userClient.Authenticate(userClient.User.Email, "1234"))
+	_, err = userClient.GetList("/user/" + userClient.User.UUID + "/session/")
+	AssertNotNil(t, err, "Only staff can list other sessions")
+	list, err = staffClient.GetList("/user/" + userClient.User.UUID + "/session/")
+	AssertNil(t, err)
+	AssertEqual(t, 1, len(list.Objects.([]interface{})))
+	sessionUUID := list.Objects.([]interface{})[0].(map[string]interface{})["uuid"].(string)
+	active, err := SessionIsActive(sessionUUID, dbInfo)
+	AssertNil(t, err)
+	AssertTrue(t, active)
+	AssertNil(t, staffClient.Delete("/user/"+userClient.User.UUID+"/session/"))
+	AssertNotNil(t, userClient.GetJSON("/user/current", user))
+	active, err = SessionIsActive(sessionUUID, dbInfo)
+	AssertNil(t, err)
+	AssertFalse(t, active)
+	AssertNil(t, staffClient.GetJSON("/user/current", user))
+}
@@ -0,0 +1,103 @@
+/*
+Export spaces to and import spaces from space bundle zip archives.
+
+	space_bundle export <space uuid> <bundle path>
+	space_bundle import <bundle path> [owner email]
+*/
+package main
+
+import (
+	"log"
+	"os"
+
+	apiDB "spaciblo.org/api/db"
+	"spaciblo.org/be"
+	"spaciblo.org/db"
+)
+
+var logger = log.New(os.Stdout, "[bundle] ", 0)
+
+func main() {
+	if len(os.Args) <= 1 {
+		logger.Println("usage: space_bundle export <space uuid> <bundle path> | import <bundle path> [owner email]")
+		return
+	}
+
+	fs, err := be.NewFileStorageFromEnv()
+	if err != nil {
+		logger.Panic("Could not open file storage: " + err.Error())
+		return
+	}
+
+	dbInfo, err := db.InitDB()
+	if err != nil {
+		logger.Panic("DB Initialization Error: " + err.Error())
+		return
+	}
+
+	if os.Args[1] == "export" && len(os.Args) == 4 {
+		err = exportSpace(os.Args[2], os.Args[3], fs, dbInfo)
+		if err != nil {
+			logger.Fatal("Could not export the space: ", err)
+		}
+	} else if os.Args[1] == "import" && (len(os.Args) == 3 || len(os.Args) == 4) {
+		ownerEmail := ""
+		if len(os.Args) == 4 {
+			ownerEmail = os.Args[3]
+		}
+		err = importSpace(os.Args[2], ownerEmail, fs, dbInfo)
+		if err != nil {
+			logger.Fatal("Could not import the space: ", err)
+		}
+	} else {
+		logger.Println("unknown command:", os.Args[1:])
+	}
+}
+
+func exportSpace(spaceUUID string, bundlePath string, fs be.FileStorage, dbInfo *be.DBInfo) error {
+	spaceRecord, err := apiDB.FindSpaceRecord(spaceUUID, dbInfo)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	err = apiDB.WriteSpaceBundle(spaceRecord, file, fs, dbInfo)
+	closeErr := file.Close()
+	if err != nil {
+		os.Remove(bundlePath)
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	logger.Println("Exported space", spaceRecord.Name+":", bundlePath)
+	return nil
+}
+
+func importSpace(bundlePath string, ownerEmail string, fs be.FileStorage, dbInfo *be.DBInfo) error {
+	ownerUUID := ""
+	if ownerEmail != "" {
+		user, err := be.FindUserByEmail(ownerEmail, dbInfo)
+		if err != nil {
+			return err
+		}
+		ownerUUID = user.UUID
+	}
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	spaceRecord, _, err := apiDB.ImportSpaceBundle(file, stat.Size(), ownerUUID, fs, dbInfo)
+	if err != nil {
+		return err
+	}
+	logger.Println("Imported space", spaceRecord.Name+":", spaceRecord.UUID)
+	return nil
+}
@@ -17,7 +17,6 @@ import (
 	"testing"
 
 	"github.com/goincremental/negroni-sessions"
-	"github.com/goincremental/negroni-sessions/cookiestore"
 	"github.com/urfave/negroni"
 )
 
@@ -103,13 +102,11 @@ Create and cleanup (synchronously) like so:
 func NewTestAPI() (*TestAPI, error) {
 	// Set up the usual API + Negroni
 	negServer := negroni.New() // add negroni.NewLogger() to see all requests
-	store := cookiestore.New([]byte(TestSessionSecret))
-	negServer.Use(sessions.Sessions(TestSessionCookie, store))
 	tempDir, err := ioutil.TempDir(os.TempDir(), "test-api-fs")
 	if err != nil {
 		return nil, err
 	}
-	fs, err := NewLocalFileStorage(tempDir)
+	fs, err := NewContentFileStorage(tempDir)
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +118,10 @@ func NewTestAPI() (*TestAPI, error) {
 	if err != nil {
 		return nil, err
 	}
+	store := NewDBSessionStore(dbInfo, []byte(TestSessionSecret))
+	negServer.Use(sessions.Sessions(TestSessionCookie, store))
 	api := NewAPI("/api/"+TestVersion, TestVersion, fs, dbInfo)
+	api.Registration.PublicURL = "https://127.0.0.1:" + strconv.Itoa(TestPort)
 	negServer.UseHandler(api.Mux)
 
 	// Set up a stoppable listener so we can clean up afterwards
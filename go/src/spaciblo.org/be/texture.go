@@ -0,0 +1,190 @@
+package be
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+/*
+TextureTierSizes are the maximum dimensions of the texture tier derivatives, largest first so each tier is resized from the one before it
+*/
+var TextureTierSizes = []int{2048, 1024, 512, 256}
+
+/*
+TexturePlaceholderDerivative is a tiny version of a texture that clients can show while a full tier loads
+*/
+const (
+	TexturePlaceholderDerivative = "texture-placeholder"
+	texturePlaceholderSize       = 32
+	textureJPEGQuality           = 90
+)
+
+/*
+TextureTierDerivative returns the name of the derivative for a tier size, like texture-1024
+*/
+func TextureTierDerivative(size int) string {
+	return fmt.Sprintf("texture-%d", size)
+}
+
+/*
+IsTextureDerivative returns true if derivative is a tier or the placeholder
+*/
+func IsTextureDerivative(derivative string) bool {
+	_, err := textureDerivativeSize(derivative)
+	return err == nil
+}
+
+/*
+IsTextureFileName returns true if the file name has the extension of an image format that the texture pipeline resizes
+*/
+func IsTextureFileName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+/*
+TextureTier gets from or creates in fileStorage a texture tier or placeholder derivative of the texture File with Key key
+*/
+func TextureTier(key string, derivative string, fileStorage FileStorage) (File, error) {
+	size, err := textureDerivativeSize(derivative)
+	if err != nil {
+		return nil, err
+	}
+	dFile, err := fileStorage.Get(key, derivative)
+	if err == nil {
+		return dFile, nil
+	}
+	origImage, name, err := readTexture(key, fileStorage)
+	if err != nil {
+		return nil, err
+	}
+	err = putTextureDerivative(key, derivative, name, resizeTexture(origImage, size), fileStorage)
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage.Get(key, derivative)
+}
+
+/*
+GenerateTextureTiers creates every tier and the placeholder for the texture File with Key key
+Each tier is resized from the next larger one, like a mipmap chain, which is much faster than resizing the original every time.
+*/
+func GenerateTextureTiers(key string, fileStorage FileStorage) error {
+	tierImage, name, err := readTexture(key, fileStorage)
+	if err != nil {
+		return err
+	}
+	for _, size := range TextureTierSizes {
+		tierImage = resizeTexture(tierImage, size)
+		err = putTextureDerivative(key, TextureTierDerivative(size), name, tierImage, fileStorage)
+		if err != nil {
+			return err
+		}
+	}
+	return putTextureDerivative(key, TexturePlaceholderDerivative, name, resizeTexture(tierImage, texturePlaceholderSize), fileStorage)
+}
+
+/*
+PowerOfTwoTextureSize returns power of two dimensions that keep the aspect ratio of width x height as closely as possible without exceeding maxSize
+Textures with power of two dimensions can be mipmapped by every WebGL implementation.
+*/
+func PowerOfTwoTextureSize(width int, height int, maxSize int) (int, int) {
+	scale := math.Min(1, float64(maxSize)/float64(maxInt(width, height)))
+	return nearestPowerOfTwo(float64(width)*scale, maxSize), nearestPowerOfTwo(float64(height)*scale, maxSize)
+}
+
+func textureDerivativeSize(derivative string) (int, error) {
+	if derivative == TexturePlaceholderDerivative {
+		return texturePlaceholderSize, nil
+	}
+	for _, size := range TextureTierSizes {
+		if derivative == TextureTierDerivative(size) {
+			return size, nil
+		}
+	}
+	return 0, errors.New("Unknown texture derivative: " + derivative)
+}
+
+func readTexture(key string, fileStorage FileStorage) (image.Image, string, error) {
+	origFile, err := fileStorage.Get(key, "")
+	if err != nil {
+		return nil, "", err
+	}
+	name, err := origFile.Name()
+	if err != nil {
+		return nil, "", err
+	}
+	if IsTextureFileName(name) == false {
+		return nil, "", errors.New("Not a texture: " + name)
+	}
+	reader, err := origFile.Reader()
+	if err != nil {
+		return nil, "", err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	origImage, _, err := DecodeImage(reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return origImage, name, nil
+}
+
+func resizeTexture(source image.Image, maxSize int) image.Image {
+	bounds := source.Bounds()
+	width, height := PowerOfTwoTextureSize(bounds.Dx(), bounds.Dy(), maxSize)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return source
+	}
+	return resize.Resize(uint(width), uint(height), source, resize.Lanczos3)
+}
+
+/*
+putTextureDerivative encodes the image in the format of the original so that derivatives are served with the original's Content-Type
+PNG keeps any alpha channel.
+*/
+func putTextureDerivative(key string, derivative string, name string, texture image.Image, fileStorage FileStorage) error {
+	buffer := new(bytes.Buffer)
+	var err error
+	if strings.ToLower(filepath.Ext(name)) == ".png" {
+		err = png.Encode(buffer, texture)
+	} else {
+		err = jpeg.Encode(buffer, texture, &jpeg.Options{Quality: textureJPEGQuality})
+	}
+	if err != nil {
+		return err
+	}
+	return fileStorage.PutDerivative(key, derivative, buffer)
+}
+
+func nearestPowerOfTwo(value float64, maxValue int) int {
+	if value <= 1 {
+		return 1
+	}
+	result := int(math.Pow(2, math.Floor(math.Log2(value)+0.5)))
+	if result > maxValue {
+		return maxValue
+	}
+	return result
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package be
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestPowerOfTwoTextureSize(t *testing.T) {
+	width, height := PowerOfTwoTextureSize(4096, 4096, 1024)
+	AssertEqual(t, 1024, width)
+	AssertEqual(t, 1024, height)
+	width, height = PowerOfTwoTextureSize(1000, 300, 2048)
+	AssertEqual(t, 1024, width)
+	AssertEqual(t, 256, height)
+	width, height = PowerOfTwoTextureSize(1000, 300, 512)
+	AssertEqual(t, 512, width)
+	AssertEqual(t, 128, height)
+	width, height = PowerOfTwoTextureSize(3000, 10, 32)
+	AssertEqual(t, 32, width)
+	AssertEqual(t, 1, height)
+}
+
+func TestTextureTiers(t *testing.T) {
+	fsDir, err := ioutil.TempDir(os.TempDir(), "be-test-fs")
+	AssertNil(t, err, "Could not create fsDir: "+fsDir)
+	defer func() {
+		err = os.RemoveAll(fsDir)
+		AssertNil(t, err, "Could not clean up fsDir: "+fsDir)
+	}()
+	testFS, err := NewContentFileStorage(fsDir)
+	AssertNil(t, err)
+
+	AssertTrue(t, IsTextureDerivative("texture-512"))
+	AssertTrue(t, IsTextureDerivative(TexturePlaceholderDerivative))
+	AssertFalse(t, IsTextureDerivative("texture-500"))
+	AssertFalse(t, IsTextureDerivative("fit-crop-128x128"))
+
+	texture := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	for x := 0; x < 600; x++ {
+		for y := 0; y < 300; y++ {
+			texture.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0, 128})
+		}
+	}
+	textureData := new(bytes.Buffer)
+	AssertNil(t, png.Encode(textureData, texture))
+	key, err := testFS.Put("wall.png", textureData)
+	AssertNil(t, err)
+	AssertNil(t, GenerateTextureTiers(key, testFS))
+
+	expectedSizes := map[string][]int{
+		TextureTierDerivative(2048):  []int{512, 256},
+		TextureTierDerivative(1024):  []int{512, 256},
+		TextureTierDerivative(512):   []int{512, 256},
+		TextureTierDerivative(256):   []int{256, 128},
+		TexturePlaceholderDerivative: []int{32, 16},
+	}
+	for derivative, size := range expectedSizes {
+		exists, err := testFS.Exists(key, derivative)
+		AssertNil(t, err)
+		AssertTrue(t, exists, derivative)
+		file, err := TextureTier(key, derivative, testFS)
+		AssertNil(t, err)
+		reader, err := file.Reader()
+		AssertNil(t, err)
+		tierImage, format, err := image.Decode(reader)
+		reader.(*os.File).Close()
+		AssertNil(t, err)
+		AssertEqual(t, "png", format, "Tiers should keep the format of the original")
+		AssertEqual(t, size[0], tierImage.Bounds().Dx(), derivative)
+		AssertEqual(t, size[1], tierImage.Bounds().Dy(), derivative)
+	}
+
+	// Tiers are created on demand if they were not generated on upload
+	AssertNil(t, testFS.Delete(key, TextureTierDerivative(256)))
+	file, err := TextureTier(key, TextureTierDerivative(256), testFS)
+	AssertNil(t, err)
+	AssertEqual(t, TextureTierDerivative(256), file.Derivative())
+
+	_, err = TextureTier(key, "texture-500", testFS)
+	AssertNotNil(t, err)
+	objKey, err := testFS.Put("model.obj", bytes.NewBufferString("v 0 0 0\n"))
+	AssertNil(t, err)
+	_, err = TextureTier(objKey, TextureTierDerivative(256), testFS)
+	AssertNotNil(t, err, "Only images have texture tiers")
+
+	// A tiny file that claims huge dimensions is refused before it is decoded
+	buffer := new(bytes.Buffer)
+	AssertNil(t, png.Encode(buffer, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buffer.Bytes()
+	binary.BigEndian.PutUint32(data[16:20], 100000) // The IHDR width
+	binary.BigEndian.PutUint32(data[20:24], 100000) // and height
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	bombKey, err := testFS.Put("bomb.png", bytes.NewReader(data))
+	AssertNil(t, err)
+	_, err = TextureTier(bombKey, TextureTierDerivative(256), testFS)
+	AssertNotNil(t, err)
+	AssertTrue(t, strings.Contains(err.Error(), "too large"), err.Error())
+}
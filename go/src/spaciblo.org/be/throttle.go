@@ -0,0 +1,242 @@
+package be
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Throttle slows repeated failures, like wrong passwords, with exponential backoff for each key
+Failures are kept in memory, so they are forgotten when the process restarts and are not shared between processes.
+*/
+type Throttle struct {
+	FreeFailures int           // Failures in a row that are not delayed
+	BaseDelay    time.Duration // The delay after the first failure past FreeFailures, which doubles with each further failure
+	MaxDelay     time.Duration
+	Forget       time.Duration // A key without failures for this long starts over
+
+	mutex     sync.Mutex
+	failures  map[string]*throttleRecord
+	lastSweep time.Time
+}
+
+type throttleRecord struct {
+	count int
+	last  time.Time
+}
+
+func NewThrottle(freeFailures int, baseDelay time.Duration, maxDelay time.Duration, forget time.Duration) *Throttle {
+	return &Throttle{
+		FreeFailures: freeFailures,
+		BaseDelay:    baseDelay,
+		MaxDelay:     maxDelay,
+		Forget:       forget,
+		failures:     map[string]*throttleRecord{},
+	}
+}
+
+/*
+Wait returns how long key must wait before trying again, or 0 if it may try now
+*/
+func (throttle *Throttle) Wait(key string, now time.Time) time.Duration {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	record, ok := throttle.failures[key]
+	if ok == false {
+		return 0
+	}
+	wait := record.last.Add(throttle.delay(record.count)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+/*
+Fail records a failure for key and returns how many failures in a row it has had
+*/
+func (throttle *Throttle) Fail(key string, now time.Time) int {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	if now.Sub(throttle.lastSweep) > throttle.Forget {
+		for k, record := range throttle.failures {
+			if now.Sub(record.last) > throttle.Forget {
+				delete(throttle.failures, k)
+			}
+		}
+		throttle.lastSweep = now
+	}
+	record, ok := throttle.failures[key]
+	if ok == false || now.Sub(record.last) > throttle.Forget {
+		record = &throttleRecord{}
+		throttle.failures[key] = record
+	}
+	record.count += 1
+	record.last = now
+	return record.count
+}
+
+/*
+Succeed forgets the failures of key
+*/
+func (throttle *Throttle) Succeed(key string) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	delete(throttle.failures, key)
+}
+
+func (throttle *Throttle) delay(count int) time.Duration {
+	if count <= throttle.FreeFailures {
+		return 0
+	}
+	delay := throttle.BaseDelay
+	for i := throttle.FreeFailures + 1; i < count && delay < throttle.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > throttle.MaxDelay {
+		return throttle.MaxDelay
+	}
+	return delay
+}
+
+/*
+LoginThrottle slows password guessing from each IP address and against each account, and second factor guessing against each account
+*/
+type LoginThrottle struct {
+	IP      *Throttle
+	Account *Throttle
+	// Keyed by User.Id and kept apart from Account so that knowing the password does not reset code guessing
+	SecondFactor *Throttle
+	// The account owner is emailed when this many passwords in a row are wrong
+	NotifyAt int
+}
+
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		// Allow more from each address, since many people can share one
+		IP:           NewThrottle(20, time.Second, time.Hour, 24*time.Hour),
+		Account:      NewThrottle(5, time.Second, 15*time.Minute, 24*time.Hour),
+		SecondFactor: NewThrottle(MaxSecondFactorAttempts, time.Second, 15*time.Minute, 24*time.Hour),
+		NotifyAt:     10,
+	}
+}
+
+/*
+Wait returns how long logging in to email from ip must wait, or 0 if it may try now
+*/
+func (throttle *LoginThrottle) Wait(ip string, email string, now time.Time) time.Duration {
+	wait := throttle.IP.Wait(ip, now)
+	if accountWait := throttle.Account.Wait(loginAccountKey(email), now); accountWait > wait {
+		wait = accountWait
+	}
+	return wait
+}
+
+/*
+Fail records a wrong password and returns true if the account owner should be notified
+*/
+func (throttle *LoginThrottle) Fail(ip string, email string, now time.Time) bool {
+	throttle.IP.Fail(ip, now)
+	return throttle.Account.Fail(loginAccountKey(email), now) == throttle.NotifyAt
+}
+
+/*
+Succeed forgets the account's failures, but not the address's, so that logging in to one account does not reset guessing at others
+*/
+func (throttle *LoginThrottle) Succeed(email string) {
+	throttle.Account.Succeed(loginAccountKey(email))
+}
+
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+/*
+RateLimit allows each key a number of requests per period, refilling evenly over the period
+*/
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+
+	mutex     sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimit(requests int, period time.Duration) *RateLimit {
+	return &RateLimit{
+		Requests: requests,
+		Period:   period,
+		buckets:  map[string]*rateBucket{},
+	}
+}
+
+/*
+Allow uses one request for key, returning false and how long to wait if there are none left
+*/
+func (limit *RateLimit) Allow(key string, now time.Time) (bool, time.Duration) {
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+	perToken := limit.Period / time.Duration(limit.Requests)
+	if now.Sub(limit.lastSweep) > limit.Period {
+		// Full buckets are the same as missing buckets
+		for k, bucket := range limit.buckets {
+			if now.Sub(bucket.last) > limit.Period {
+				delete(limit.buckets, k)
+			}
+		}
+		limit.lastSweep = now
+	}
+	bucket, ok := limit.buckets[key]
+	if ok == false {
+		bucket = &rateBucket{
+			tokens: float64(limit.Requests),
+			last:   now,
+		}
+		limit.buckets[key] = bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.last)) / float64(perToken)
+	if bucket.tokens > float64(limit.Requests) {
+		bucket.tokens = float64(limit.Requests)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(perToken))
+	}
+	bucket.tokens -= 1
+	return true, 0
+}
+
+/*
+RateLimited is implemented by Resources that limit how often each client may call a method
+RateLimit returns nil for methods without a limit. Requests are counted per User when logged in and otherwise per IP address.
+*/
+type RateLimited interface {
+	RateLimit(method string) *RateLimit
+}
+
+/*
+RetryAfter returns the value of a Retry-After header for wait, in whole seconds
+*/
+func RetryAfter(wait time.Duration) string {
+	seconds := int64(wait / time.Second)
+	if wait%time.Second != 0 {
+		seconds += 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+func rateLimitKey(request *http.Request, user *User) string {
+	if user != nil {
+		return "user:" + user.UUID
+	}
+	return "ip:" + RequestIP(request)
+}
@@ -0,0 +1,126 @@
+package be
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestThrottle(t *testing.T) {
+	now := time.Now()
+	throttle := NewThrottle(2, time.Second, 5*time.Second, time.Hour)
+	AssertEqual(t, time.Duration(0), throttle.Wait("a", now))
+	AssertEqual(t, 1, throttle.Fail("a", now))
+	AssertEqual(t, 2, throttle.Fail("a", now))
+	AssertEqual(t, time.Duration(0), throttle.Wait("a", now), "The first failures are free")
+	throttle.Fail("a", now)
+	AssertEqual(t, time.Second, throttle.Wait("a", now))
+	throttle.Fail("a", now)
+	AssertEqual(t, 2*time.Second, throttle.Wait("a", now))
+	AssertEqual(t, time.Second, throttle.Wait("a", now.Add(time.Second)))
+	for i := 0; i < 10; i++ {
+		throttle.Fail("a", now)
+	}
+	AssertEqual(t, 5*time.Second, throttle.Wait("a", now), "Delays stop growing at MaxDelay")
+	AssertEqual(t, time.Duration(0), throttle.Wait("b", now))
+
+	AssertEqual(t, 1, throttle.Fail("a", now.Add(2*time.Hour)), "Old failures are forgotten")
+	throttle.Succeed("a")
+	AssertEqual(t, 1, throttle.Fail("a", now))
+
+	login := NewLoginThrottle()
+	for i := 1; i < login.NotifyAt; i++ {
+		AssertFalse(t, login.Fail("10.0.0.1", "Alice@example.com ", now))
+	}
+	AssertTrue(t, login.Fail("10.0.0.2", "alice@example.com", now))
+	AssertTrue(t, login.Wait("10.0.0.3", "ALICE@example.com", now) > 0, "Accounts are throttled from any address")
+	AssertEqual(t, time.Duration(0), login.Wait("10.0.0.3", "bob@example.com", now))
+	login.Succeed("alice@example.com")
+	AssertEqual(t, time.Duration(0), login.Wait("10.0.0.3", "alice@example.com", now))
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+	limit := NewRateLimit(3, 3*time.Second)
+	for i := 0; i < 3; i++ {
+		allowed, _ := limit.Allow("a", now)
+		AssertTrue(t, allowed)
+	}
+	allowed, wait := limit.Allow("a", now)
+	AssertFalse(t, allowed)
+	AssertEqual(t, time.Second, wait)
+	allowed, _ = limit.Allow("b", now)
+	AssertTrue(t, allowed, "Each key has its own limit")
+	allowed, _ = limit.Allow("a", now.Add(time.Second))
+	AssertTrue(t, allowed, "Requests refill over the period")
+	allowed, _ = limit.Allow("a", now.Add(time.Second))
+	AssertFalse(t, allowed)
+
+	AssertEqual(t, "1", RetryAfter(time.Second))
+	AssertEqual(t, "2", RetryAfter(1500*time.Millisecond))
+}
+
+func TestLoginThrottling(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	mailer := NewMemoryMailer()
+	testApi.API.Mailer = mailer
+	testApi.API.LoginThrottle.Account.BaseDelay = time.Hour
+	testApi.API.LoginThrottle.NotifyAt = testApi.API.LoginThrottle.Account.FreeFailures
+
+	user, err := CreateUser("alice@example.com", "Alice", "", false, "", dbInfo)
+	AssertNil(t, err)
+	_, err = CreatePassword("1234", user.Id, dbInfo)
+	AssertNil(t, err)
+	client, err := NewClient(testApi.URL())
+	AssertNil(t, err)
+	login := func(email string, password string) (int, string) {
+		resp, _ := client.PostJSON("/user/current", LoginData{Email: email, Password: password})
+		AssertNotNil(t, resp)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		AssertNil(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// Unknown emails and wrong passwords can not be told apart
+	status, unknownBody := login("nobody@example.com", "1234")
+	AssertEqual(t, 400, status)
+	status, wrongBody := login(user.Email, "4321")
+	AssertEqual(t, 400, status)
+	AssertEqual(t, unknownBody, wrongBody)
+	apiError := APIError{}
+	AssertNil(t, json.Unmarshal([]byte(wrongBody), &apiError))
+	AssertEqual(t, IncorrectLoginError.Id, apiError.Id)
+
+	for i := 1; i < testApi.API.LoginThrottle.Account.FreeFailures; i++ {
+		status, _ = login(user.Email, "4321")
+		AssertEqual(t, 400, status)
+	}
+	time.Sleep(100 * time.Millisecond) // The notification is sent in the background
+	AssertTrue(t, mailer.LastMessageTo(user.Email) != nil, "The owner hears about the failed logins")
+
+	status, _ = login(user.Email, "4321")
+	AssertEqual(t, 400, status)
+	status, _ = login(user.Email, "1234")
+	AssertEqual(t, 429, status, "Even the right password waits")
+	status, _ = login("nobody@example.com", "1234")
+	AssertEqual(t, 400, status)
+
+	testApi.API.LoginThrottle.Account.Succeed(user.Email)
+	status, _ = login(user.Email, "1234")
+	AssertEqual(t, 200, status)
+}
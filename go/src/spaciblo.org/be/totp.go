@@ -0,0 +1,288 @@
+package be
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TOTPSecretTable   = "totp_secrets"
+	RecoveryCodeTable = "recovery_codes"
+)
+
+// TOTP parameters, which are the defaults of most authenticator apps
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 // seconds
+	TOTPIssuer = "Spaciblo"
+)
+
+// RecoveryCodeCount is how many recovery codes are generated at a time
+const RecoveryCodeCount = 10
+
+// SecondFactorLifetime is how long after verifying a second factor a session may use sensitive staff endpoints
+var SecondFactorLifetime = 15 * time.Minute
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+/*
+TOTPSecret is the shared secret of a User's authenticator app
+It is not Confirmed, and so not required at login, until the User has entered a code from the app.
+*/
+type TOTPSecret struct {
+	Id          int64     `db:"id, primarykey, autoincrement"`
+	UserId      int64     `db:"user_id"`
+	Secret      string    `db:"secret"` // Base32, as shown to authenticator apps
+	Confirmed   bool      `db:"confirmed"`
+	LastCounter int64     `db:"last_counter"` // The time step of the last accepted code, so that codes can not be replayed
+	Created     time.Time `db:"created"`
+}
+
+/*
+URI returns the otpauth URI that authenticator apps read from a QR code
+*/
+func (secret *TOTPSecret) URI(email string) string {
+	query := url.Values{
+		"secret": {secret.Secret},
+		"issuer": {TOTPIssuer},
+		"digits": {strconv.Itoa(TOTPDigits)},
+		"period": {strconv.Itoa(TOTPPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+email) + "?" + query.Encode()
+}
+
+/*
+Validate returns the time step of code if it is correct at now, allowing one step of clock drift, and newer than LastCounter
+*/
+func (secret *TOTPSecret) Validate(code string, now time.Time) (int64, bool) {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	counter := now.Unix() / TOTPPeriod
+	for _, step := range []int64{counter - 1, counter, counter + 1} {
+		if step <= secret.LastCounter {
+			continue
+		}
+		expected, err := TOTPCode(secret.Secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+/*
+TOTPCode returns the RFC 6238 code for a base32 secret at a time step
+*/
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+/*
+CreateTOTPSecret replaces any unconfirmed secret of the User with a new one
+*/
+func CreateTOTPSecret(userId int64, dbInfo *DBInfo) (*TOTPSecret, error) {
+	data := make([]byte, 20)
+	_, err := rand.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+TOTPSecretTable+" where user_id=$1 and confirmed=false", userId)
+	if err != nil {
+		return nil, err
+	}
+	secret := &TOTPSecret{
+		UserId:  userId,
+		Secret:  totpEncoding.EncodeToString(data),
+		Created: time.Now(),
+	}
+	err = dbInfo.Map.Insert(secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+/*
+FindTOTPSecret returns the User's confirmed or unconfirmed secret
+*/
+func FindTOTPSecret(userId int64, confirmed bool, dbInfo *DBInfo) (*TOTPSecret, error) {
+	secret := new(TOTPSecret)
+	err := dbInfo.Map.SelectOne(secret, "select * from "+TOTPSecretTable+" where user_id=$1 and confirmed=$2 order by id desc limit 1", userId, confirmed)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+/*
+HasTOTP returns true if the User has a confirmed TOTP secret and so must use it to log in
+*/
+func HasTOTP(userId int64, dbInfo *DBInfo) (bool, error) {
+	count, err := dbInfo.Map.SelectInt("select count(*) from "+TOTPSecretTable+" where user_id=$1 and confirmed=true", userId)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+/*
+UseTOTPCode validates code against secret and records its time step so that it can not be used again
+*/
+func UseTOTPCode(secret *TOTPSecret, code string, dbInfo *DBInfo) error {
+	counter, ok := secret.Validate(code, time.Now())
+	if ok == false {
+		return errors.New("Incorrect code")
+	}
+	// Only one request may move LastCounter past counter, so a code is accepted once even if it is sent twice at the same time
+	result, err := dbInfo.Map.Exec("update "+TOTPSecretTable+" set last_counter=$1 where id=$2 and last_counter<$1", counter, secret.Id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("The code has already been used")
+	}
+	secret.LastCounter = counter
+	return nil
+}
+
+/*
+ConfirmTOTPSecret enables the secret, replacing any earlier confirmed secret, and returns new recovery codes
+*/
+func ConfirmTOTPSecret(secret *TOTPSecret, code string, dbInfo *DBInfo) ([]string, error) {
+	err := UseTOTPCode(secret, code, dbInfo)
+	if err != nil {
+		return nil, err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+TOTPSecretTable+" where user_id=$1 and id<>$2", secret.UserId, secret.Id)
+	if err != nil {
+		return nil, err
+	}
+	secret.Confirmed = true
+	_, err = dbInfo.Map.Exec("update "+TOTPSecretTable+" set confirmed=true where id=$1", secret.Id)
+	if err != nil {
+		return nil, err
+	}
+	return CreateRecoveryCodes(secret.UserId, dbInfo)
+}
+
+/*
+DeleteTOTP removes the User's TOTP secrets and recovery codes, so they log in with only a password
+*/
+func DeleteTOTP(userId int64, dbInfo *DBInfo) error {
+	_, err := dbInfo.Map.Exec("delete from "+TOTPSecretTable+" where user_id=$1", userId)
+	if err != nil {
+		return err
+	}
+	_, err = dbInfo.Map.Exec("delete from "+RecoveryCodeTable+" where user_id=$1", userId)
+	return err
+}
+
+/*
+RecoveryCode lets a User log in once without their authenticator app
+*/
+type RecoveryCode struct {
+	Id       int64  `db:"id, primarykey, autoincrement"`
+	UserId   int64  `db:"user_id"`
+	CodeHash string `db:"code_hash"`
+}
+
+/*
+CreateRecoveryCodes replaces the User's recovery codes and returns the new ones, which are not available again
+*/
+func CreateRecoveryCodes(userId int64, dbInfo *DBInfo) ([]string, error) {
+	_, err := dbInfo.Map.Exec("delete from "+RecoveryCodeTable+" where user_id=$1", userId)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		token, err := RandomToken()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = token[:5] + "-" + token[5:10] + "-" + token[10:15]
+		err = dbInfo.Map.Insert(&RecoveryCode{
+			UserId:   userId,
+			CodeHash: HashToken(normalizeRecoveryCode(codes[i])),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func CountRecoveryCodes(userId int64, dbInfo *DBInfo) (int64, error) {
+	return dbInfo.Map.SelectInt("select count(*) from "+RecoveryCodeTable+" where user_id=$1", userId)
+}
+
+/*
+UseRecoveryCode deletes the User's recovery code, returning an error if there is no such code
+*/
+func UseRecoveryCode(userId int64, code string, dbInfo *DBInfo) error {
+	result, err := dbInfo.Map.Exec("delete from "+RecoveryCodeTable+" where user_id=$1 and code_hash=$2", userId, HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return errors.New("Incorrect recovery code")
+	}
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+/*
+VerifySecondFactor checks a code from the User's authenticator app or one of their recovery codes
+*/
+func VerifySecondFactor(userId int64, code string, dbInfo *DBInfo) error {
+	code = strings.TrimSpace(code)
+	if len(code) == TOTPDigits {
+		secret, err := FindTOTPSecret(userId, true, dbInfo)
+		if err != nil {
+			return errors.New("No second factor is enabled")
+		}
+		return UseTOTPCode(secret, code, dbInfo)
+	}
+	return UseRecoveryCode(userId, code, dbInfo)
+}
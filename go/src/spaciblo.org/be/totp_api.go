@@ -0,0 +1,373 @@
+package be
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var SecondFactorProperties = []Property{
+	Property{
+		Name:        "code",
+		Description: "A code from the authenticator app or a recovery code",
+		DataType:    "string",
+	},
+}
+
+var TOTPProperties = []Property{
+	Property{
+		Name:        "enabled",
+		Description: "True if logging in requires a code from an authenticator app",
+		DataType:    "bool",
+		Protected:   true,
+	},
+	Property{
+		Name:        "recovery-codes-left",
+		Description: "How many unused recovery codes remain",
+		DataType:    "int",
+		Protected:   true,
+	},
+	Property{
+		Name:        "secret",
+		Description: "Only in the POST response that starts enrollment: the secret to enter in the authenticator app",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "uri",
+		Description: "Only in the POST response that starts enrollment: the otpauth URI to show as a QR code",
+		DataType:    "string",
+		Protected:   true,
+	},
+	Property{
+		Name:        "recovery-codes",
+		Description: "Only in the response that creates them: codes that each log in once without the authenticator app",
+		DataType:    "array",
+		Protected:   true,
+	},
+	Property{
+		Name:        "code",
+		Description: "PUT a code from the authenticator app to finish enrollment",
+		DataType:    "string",
+		Optional:    true,
+	},
+}
+
+type SecondFactorData struct {
+	Code string `json:"code"`
+}
+
+type SecondFactorStatus struct {
+	Pending bool `json:"pending"` // A password was entered and the second factor is needed to finish logging in
+	Recent  bool `json:"recent"`  // The session verified a second factor within SecondFactorLifetime
+}
+
+type TOTPData struct {
+	Enabled           bool     `json:"enabled"`
+	RecoveryCodesLeft int64    `json:"recovery-codes-left"`
+	Secret            string   `json:"secret,omitempty"`
+	URI               string   `json:"uri,omitempty"`
+	RecoveryCodes     []string `json:"recovery-codes,omitempty"`
+}
+
+/*
+CheckSecondFactor returns an error unless the request's session verified a second factor within SecondFactorLifetime
+Users without TOTP pass, unless they are staff or have a role and RequireStaffSecondFactor is set. Requests with API tokens pass
+because tokens with admin scope or that keep any role permissions can only be created after verifying a second factor.
+*/
+func (request *APIRequest) CheckSecondFactor() (int, *APIError) {
+	if request.User == nil {
+		return 401, &NotLoggedInError
+	}
+	if request.Token != nil {
+		return 0, nil
+	}
+	hasTOTP, err := HasTOTP(request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, &APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}
+	}
+	if hasTOTP == false {
+		if request.RequireStaffSecondFactor && request.isPrivileged() {
+			return 403, &SecondFactorEnrollmentError
+		}
+		return 0, nil
+	}
+	if request.Session == nil || HasRecentSecondFactor(request.Session) == false {
+		return 403, &SecondFactorRequiredError
+	}
+	return 0, nil
+}
+
+/*
+SecondFactorResource finishes logging in after a password, and lets logged in sessions verify again before sensitive actions
+*/
+type SecondFactorResource struct {
+	api *API
+}
+
+func NewSecondFactorResource(api *API) *SecondFactorResource {
+	return &SecondFactorResource{
+		api: api,
+	}
+}
+
+func (SecondFactorResource) Name() string  { return "second-factor" }
+func (SecondFactorResource) Path() string  { return "/user/current/second-factor" }
+func (SecondFactorResource) Title() string { return "Second factor" }
+func (SecondFactorResource) Description() string {
+	return "POST a code from the authenticator app, or a recovery code, to finish logging in or before sensitive staff actions."
+}
+
+func (resource SecondFactorResource) Properties() []Property {
+	return SecondFactorProperties
+}
+
+func (resource SecondFactorResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	status := SecondFactorStatus{}
+	if request.Session != nil {
+		_, err := FindPendingLogInUser(request.Session, request.DBInfo)
+		status.Pending = err == nil
+		status.Recent = request.User != nil && HasRecentSecondFactor(request.Session)
+	}
+	return 200, status, responseHeader
+}
+
+func (resource SecondFactorResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.Session == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	var data SecondFactorData
+	err := json.NewDecoder(request.Raw.Body).Decode(&data)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	user := request.User
+	if user == nil {
+		user, err = FindPendingLogInUser(request.Session, request.DBInfo)
+		if err != nil {
+			return 401, APIError{
+				Id:      "no_pending_login",
+				Message: "Log in with a password first",
+				Error:   err.Error(),
+			}, responseHeader
+		}
+	}
+	// Failures count against the account as well as the session, because logging in again starts a new session
+	throttle := resource.api.LoginThrottle.SecondFactor
+	throttleKey := strconv.FormatInt(user.Id, 10)
+	if wait := throttle.Wait(throttleKey, time.Now()); wait > 0 {
+		responseHeader["Retry-After"] = []string{RetryAfter(wait)}
+		return 429, SecondFactorThrottledError, responseHeader
+	}
+	err = VerifySecondFactor(user.Id, data.Code, request.DBInfo)
+	if err != nil {
+		throttle.Fail(throttleKey, time.Now())
+		if FailSecondFactor(request.Session) {
+			return 401, APIError{
+				Id:      "too_many_attempts",
+				Message: "Too many incorrect codes, log in again",
+			}, responseHeader
+		}
+		return 400, IncorrectSecondFactorError, responseHeader
+	}
+	throttle.Succeed(throttleKey)
+	if request.User == nil {
+		LogIn(request.Session, user)
+	}
+	MarkSecondFactor(request.Session)
+	return 200, user, responseHeader
+}
+
+/*
+TOTPResource turns on and off the requirement for a code from an authenticator app when logging in
+*/
+type TOTPResource struct{}
+
+func NewTOTPResource() *TOTPResource {
+	return &TOTPResource{}
+}
+
+func (TOTPResource) Name() string  { return "totp" }
+func (TOTPResource) Path() string  { return "/user/current/totp" }
+func (TOTPResource) Title() string { return "Two-factor authentication" }
+func (TOTPResource) Description() string {
+	return "POST to start enrollment and receive a secret for the authenticator app, then PUT a code from the app to turn it on and receive recovery codes. DELETE to turn it off."
+}
+
+func (resource TOTPResource) Properties() []Property {
+	return TOTPProperties
+}
+
+func (resource TOTPResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	data, apiError := totpStatus(request.User, request)
+	if apiError != nil {
+		return 500, apiError, responseHeader
+	}
+	return 200, data, responseHeader
+}
+
+func (resource TOTPResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	data, apiError := totpStatus(request.User, request)
+	if apiError != nil {
+		return 500, apiError, responseHeader
+	}
+	if data.Enabled {
+		return 400, APIError{
+			Id:      "totp_enabled",
+			Message: "Two-factor authentication is already on. Turn it off first to use a new authenticator app.",
+		}, responseHeader
+	}
+	secret, err := CreateTOTPSecret(request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create the secret: " + err.Error(),
+		}, responseHeader
+	}
+	data.Secret = secret.Secret
+	data.URI = secret.URI(request.User.Email)
+	return 200, data, responseHeader
+}
+
+func (resource TOTPResource) Put(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	var codeData SecondFactorData
+	err := json.NewDecoder(request.Raw.Body).Decode(&codeData)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	secret, err := FindTOTPSecret(request.User.Id, false, request.DBInfo)
+	if err != nil {
+		return 400, APIError{
+			Id:      "no_enrollment",
+			Message: "POST to start enrollment first",
+		}, responseHeader
+	}
+	recoveryCodes, err := ConfirmTOTPSecret(secret, codeData.Code, request.DBInfo)
+	if err != nil {
+		return 400, IncorrectSecondFactorError, responseHeader
+	}
+	if request.Session != nil {
+		MarkSecondFactor(request.Session)
+	}
+	data, apiError := totpStatus(request.User, request)
+	if apiError != nil {
+		return 500, apiError, responseHeader
+	}
+	data.RecoveryCodes = recoveryCodes
+	request.Audit("totp-enable", "user", request.User.UUID, nil, nil)
+	return 200, data, responseHeader
+}
+
+func (resource TOTPResource) Delete(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	if request.RequireStaffSecondFactor && request.isPrivileged() {
+		return 403, SecondFactorEnrollmentError, responseHeader
+	}
+	status, apiError := request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	err := DeleteTOTP(request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not turn off two-factor authentication: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit("totp-disable", "user", request.User.UUID, nil, nil)
+	return 200, "Ok", responseHeader
+}
+
+/*
+RecoveryCodesResource replaces the authenticated User's recovery codes
+*/
+type RecoveryCodesResource struct{}
+
+func NewRecoveryCodesResource() *RecoveryCodesResource {
+	return &RecoveryCodesResource{}
+}
+
+func (RecoveryCodesResource) Name() string  { return "recovery-codes" }
+func (RecoveryCodesResource) Path() string  { return "/user/current/totp/recovery-codes" }
+func (RecoveryCodesResource) Title() string { return "Recovery codes" }
+func (RecoveryCodesResource) Description() string {
+	return "POST to replace the recovery codes, which requires a recent second factor."
+}
+
+func (resource RecoveryCodesResource) Properties() []Property {
+	return TOTPProperties
+}
+
+func (resource RecoveryCodesResource) Post(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	if request.User == nil {
+		return 401, NotLoggedInError, responseHeader
+	}
+	data, apiError := totpStatus(request.User, request)
+	if apiError != nil {
+		return 500, apiError, responseHeader
+	}
+	if data.Enabled == false {
+		return 400, APIError{
+			Id:      "totp_disabled",
+			Message: "Two-factor authentication is off",
+		}, responseHeader
+	}
+	status, apiError := request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	recoveryCodes, err := CreateRecoveryCodes(request.User.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not create recovery codes: " + err.Error(),
+		}, responseHeader
+	}
+	data.RecoveryCodesLeft = int64(len(recoveryCodes))
+	data.RecoveryCodes = recoveryCodes
+	request.Audit("recovery-codes", "user", request.User.UUID, nil, nil)
+	return 200, data, responseHeader
+}
+
+func totpStatus(user *User, request *APIRequest) (*TOTPData, *APIError) {
+	enabled, err := HasTOTP(user.Id, request.DBInfo)
+	if err != nil {
+		return nil, &APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}
+	}
+	count, err := CountRecoveryCodes(user.Id, request.DBInfo)
+	if err != nil {
+		return nil, &APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
+		}
+	}
+	return &TOTPData{
+		Enabled:           enabled,
+		RecoveryCodesLeft: count,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package be
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// From RFC 6238, Appendix B, truncated to six digits
+	secret := &TOTPSecret{Secret: totpEncoding.EncodeToString([]byte("12345678901234567890"))}
+	for seconds, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TOTPCode(secret.Secret, seconds/TOTPPeriod)
+		AssertNil(t, err)
+		AssertEqual(t, expected, code)
+	}
+
+	now := time.Unix(1111111109, 0)
+	counter, ok := secret.Validate("081804", now)
+	AssertTrue(t, ok)
+	AssertEqual(t, int64(37037036), counter)
+	_, ok = secret.Validate("081804", now.Add(TOTPPeriod*time.Second))
+	AssertTrue(t, ok, "One step of clock drift is allowed")
+	_, ok = secret.Validate("081804", now.Add(3*TOTPPeriod*time.Second))
+	AssertFalse(t, ok)
+	_, ok = secret.Validate("081805", now)
+	AssertFalse(t, ok)
+	secret.LastCounter = counter
+	_, ok = secret.Validate("081804", now)
+	AssertFalse(t, ok, "Codes can not be replayed")
+
+	AssertTrue(t, strings.HasPrefix(secret.URI("alice@example.com"), "otpauth://totp/Spaciblo:alice@example.com?"))
+}
+
+func TestTOTPAPI(t *testing.T) {
+	err := CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := InitDB()
+	AssertNil(t, err)
+	defer func() {
+		WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	testApi, err := NewTestAPI()
+	AssertNil(t, err)
+	defer testApi.Stop()
+	userClient, staffClient, err := CreateTestUserAndStaffWithClients(testApi, dbInfo)
+	AssertNil(t, err)
+	currentCode := func(secret string, steps int64) string {
+		code, err := TOTPCode(secret, time.Now().Unix()/TOTPPeriod+steps)
+		AssertNil(t, err)
+		return code
+	}
+
+	// Enroll
+	totp := new(TOTPData)
+	AssertNil(t, userClient.GetJSON("/user/current/totp", totp))
+	AssertFalse(t, totp.Enabled)
+	AssertNil(t, userClient.PostAndReceiveJSON("/user/current/totp", nil, totp))
+	AssertTrue(t, totp.Secret != "")
+	secret := totp.Secret
+	AssertNotNil(t, userClient.PutAndReceiveJSON("/user/current/totp", SecondFactorData{Code: "000000"}, totp))
+	AssertNil(t, userClient.PutAndReceiveJSON("/user/current/totp", SecondFactorData{Code: currentCode(secret, 0)}, totp))
+	AssertTrue(t, totp.Enabled)
+	AssertEqual(t, RecoveryCodeCount, len(totp.RecoveryCodes))
+	recoveryCodes := totp.RecoveryCodes
+
+	// Logging in now takes two steps
+	email := userClient.User.Email
+	AssertNil(t, userClient.Deauthenticate())
+	AssertNotNil(t, userClient.Authenticate(email, "1234"))
+	user := new(User)
+	AssertNotNil(t, userClient.GetJSON("/user/current", user), "The password alone does not log in")
+	AssertNotNil(t, userClient.VerifySecondFactor("000000"))
+	AssertNil(t, userClient.VerifySecondFactor(currentCode(secret, 1)))
+	AssertNil(t, userClient.GetJSON("/user/current", user))
+	AssertEqual(t, email, user.Email)
+
+	// Recovery codes work once
+	AssertNil(t, userClient.Deauthenticate())
+	AssertNotNil(t, userClient.Authenticate(email, "1234"))
+	AssertNil(t, userClient.VerifySecondFactor(strings.ToUpper(recoveryCodes[0])))
+	AssertNil(t, userClient.GetJSON("/user/current/totp", totp))
+	AssertEqual(t, int64(RecoveryCodeCount-1), totp.RecoveryCodesLeft)
+	AssertNil(t, userClient.Deauthenticate())
+	AssertNotNil(t, userClient.Authenticate(email, "1234"))
+	AssertNotNil(t, userClient.VerifySecondFactor(recoveryCodes[0]))
+
+	// Too many incorrect codes end the pending login
+	for i := 1; i < MaxSecondFactorAttempts; i++ {
+		AssertNotNil(t, userClient.VerifySecondFactor("000000"))
+	}
+	AssertNotNil(t, userClient.VerifySecondFactor(recoveryCodes[1]))
+
+	// Logging in again with the right password does not reset the account's count of incorrect codes
+	testApi.API.LoginThrottle.SecondFactor.BaseDelay = time.Hour
+	AssertNotNil(t, userClient.Authenticate(email, "1234"))
+	AssertNotNil(t, userClient.VerifySecondFactor("000000"))
+	resp, _ := userClient.PostJSON("/user/current/second-factor", SecondFactorData{Code: recoveryCodes[1]})
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 429, resp.StatusCode, "Even the right code waits")
+	testApi.API.LoginThrottle.SecondFactor.Succeed(strconv.FormatInt(user.Id, 10))
+	AssertNil(t, userClient.VerifySecondFactor(recoveryCodes[1]))
+
+	// Staff must enroll before sensitive actions when the installation requires it
+	invite := new(RegistrationInvite)
+	AssertNil(t, staffClient.PostAndReceiveJSON("/user/invite/", RegistrationInvite{}, invite))
+	testApi.API.RequireStaffSecondFactor = true
+	resp, _ = staffClient.PostJSON("/user/invite/", RegistrationInvite{})
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 403, resp.StatusCode)
+	resp, _ = staffClient.PostJSON("/user/current/token/", APIToken{Name: "content", Scopes: []string{ScopeTemplates}})
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 403, resp.StatusCode, "Tokens that can write need a second factor")
+	resp, _ = staffClient.PostJSON("/user/current/token/", APIToken{Name: "reader", Scopes: []string{ScopeReadOnly}})
+	AssertNotNil(t, resp)
+	resp.Body.Close()
+	AssertEqual(t, 200, resp.StatusCode, "Read only tokens do not")
+	AssertNil(t, staffClient.PostAndReceiveJSON("/user/current/totp", nil, totp))
+	AssertNil(t, staffClient.PutAndReceiveJSON("/user/current/totp", SecondFactorData{Code: currentCode(totp.Secret, 0)}, totp))
+	AssertNil(t, staffClient.PostAndReceiveJSON("/user/invite/", RegistrationInvite{}, invite))
+	AssertNotNil(t, staffClient.Delete("/user/current/totp"), "Required second factors can not be turned off")
+}
@@ -1,6 +1,7 @@
 package be
 
 import (
+	"strings"
 	"time"
 )
 
@@ -17,6 +18,12 @@ type User struct {
 	AvatarUUID string    `json:"avatarUUID" db:"avatar"`
 	Created    time.Time `json:"created" db:"created"`
 	Updated    time.Time `json:"updated" db:"updated"`
+	// Bytes of FileStorage the user may fill, with 0 for the default quota and -1 for unlimited
+	StorageQuota int64 `json:"storage-quota" db:"storage_quota"`
+	// False for registered users until they follow the link in their verification email
+	Verified bool `json:"verified" db:"verified"`
+	// Incremented to log out every session, like when the password changes
+	SessionVersion int64 `json:"-" db:"session_version"`
 }
 
 func (user *User) DisplayName() string {
@@ -33,6 +40,17 @@ func (user *User) DisplayName() string {
 }
 
 func CreateUser(email string, firstName string, lastName string, staff bool, avatarUUID string, dbInfo *DBInfo) (*User, error) {
+	return createUser(email, firstName, lastName, staff, avatarUUID, true, dbInfo)
+}
+
+/*
+CreateUnverifiedUser creates a User who may not log in until they follow the link sent by CreateEmailVerification
+*/
+func CreateUnverifiedUser(email string, firstName string, lastName string, dbInfo *DBInfo) (*User, error) {
+	return createUser(email, firstName, lastName, false, "", false, dbInfo)
+}
+
+func createUser(email string, firstName string, lastName string, staff bool, avatarUUID string, verified bool, dbInfo *DBInfo) (*User, error) {
 	user := new(User)
 	user.UUID = UUID()
 	user.Email = email
@@ -40,6 +58,7 @@ func CreateUser(email string, firstName string, lastName string, staff bool, ava
 	user.LastName = lastName
 	user.Staff = staff
 	user.AvatarUUID = avatarUUID
+	user.Verified = verified
 	err := dbInfo.Map.Insert(user)
 	if err != nil {
 		return nil, err
@@ -76,8 +95,16 @@ func FindUser(uuid string, dbInfo *DBInfo) (*User, error) {
 	return findUserByField("u_u_i_d", uuid, dbInfo)
 }
 
+/*
+FindUserByEmail ignores case, since NormalizeEmail lowercases addresses but older accounts may have been created with capitals
+*/
 func FindUserByEmail(email string, dbInfo *DBInfo) (*User, error) {
-	return findUserByField("email", email, dbInfo)
+	user := new(User)
+	err := dbInfo.Map.SelectOne(user, "select * from "+UserTable+" where lower(email)=lower($1)", strings.TrimSpace(email))
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 func findUserByField(fieldName string, value string, dbInfo *DBInfo) (*User, error) {
@@ -5,6 +5,8 @@ import (
 
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var UserProperties = []Property{
@@ -95,6 +97,11 @@ func (resource CurrentUserImageResource) Get(request *APIRequest) (int, interfac
 			Message: "Error reading user image: " + request.User.Image + ": " + err.Error(),
 		}, responseHeader
 	}
+	responseHeader["Etag"] = []string{FileETag(imageFile)}
+	responseHeader["Cache-Control"] = []string{CacheControlPrivate}
+	if request.NotModified(responseHeader["Etag"][0]) {
+		return 200, nil, responseHeader
+	}
 	err = request.ServeFile(imageFile, responseHeader)
 	if err != nil {
 		return 500, &APIError{
@@ -120,6 +127,10 @@ func (resource CurrentUserImageResource) PutForm(request *APIRequest) (int, inte
 			Message: "An `image` field is required up update your user image",
 		}, responseHeader
 	}
+	status, apiError := request.CheckUpload(fileHeader.Size)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
 	fileKey, err := request.FS.Put(fileHeader.Filename, file)
 	if err != nil {
 		return http.StatusInternalServerError, &APIError{
@@ -127,6 +138,7 @@ func (resource CurrentUserImageResource) PutForm(request *APIRequest) (int, inte
 			Message: "Could not store the file: " + err.Error(),
 		}, responseHeader
 	}
+	request.RecordUpload(fileKey)
 
 	oldFileKey := request.User.Image
 	request.User.Image = fileKey
@@ -137,7 +149,12 @@ func (resource CurrentUserImageResource) PutForm(request *APIRequest) (int, inte
 			Message: "Could not update the user: " + err.Error(),
 		}, responseHeader
 	}
-	if oldFileKey != "" {
+	request.Audit(AuditUpdate, "user", request.User.UUID, map[string]string{"image": oldFileKey}, map[string]string{"image": fileKey})
+	if oldFileKey != "" && oldFileKey != fileKey {
+		err = DeleteFileUpload(request.User.Id, oldFileKey, request.DBInfo)
+		if err != nil {
+			logger.Print("Could not delete old image upload: " + err.Error())
+		}
 		err = request.FS.Delete(oldFileKey, "")
 		if err != nil {
 			logger.Print("Could not delete old image: " + err.Error())
@@ -150,10 +167,15 @@ func (resource CurrentUserImageResource) PutForm(request *APIRequest) (int, inte
 CurrentUserResource returns a user if the GET request is authenticated, otherwise a 404 NotLoggedInError
 */
 type CurrentUserResource struct {
+	api        *API
+	loginLimit *RateLimit
 }
 
-func NewCurrentUserResource() *CurrentUserResource {
-	return &CurrentUserResource{}
+func NewCurrentUserResource(api *API) *CurrentUserResource {
+	return &CurrentUserResource{
+		api:        api,
+		loginLimit: NewRateLimit(20, time.Minute),
+	}
 }
 
 func (CurrentUserResource) Name() string  { return "current-user" }
@@ -167,6 +189,13 @@ func (resource CurrentUserResource) Properties() []Property {
 	return UserProperties
 }
 
+func (resource CurrentUserResource) RateLimit(method string) *RateLimit {
+	if method == POST {
+		return resource.loginLimit
+	}
+	return nil
+}
+
 func etagForUser(user *User, version string) []string {
 	return []string{"user-" + version + "-" + fmt.Sprintf("%d", user.Updated.UnixNano())}
 }
@@ -185,15 +214,29 @@ func (resource CurrentUserResource) Delete(request *APIRequest) (int, interface{
 	if request.User == nil {
 		return 200, "Ok", responseHeader
 	}
-	// Instead of clearing the session, which leaves behind a cookie, we delete the entire cookie
-	// Since the cookie is opaque to the client, deleting it makes it easy for the client to decide whether it is authenticated.
+	// Revoke the session so that a copy of the cookie can not be used after logging out
+	if sessionUUID := currentSessionUUID(request); sessionUUID != "" {
+		err := RevokeUserSession(request.User.UUID, sessionUUID, request.DBInfo)
+		if err != nil {
+			logger.Print("Could not revoke the session: " + err.Error())
+		}
+	}
+	deleteAuthCookie(request)
+	return 200, "Ok", responseHeader
+}
+
+/*
+deleteAuthCookie logs out the request's browser
+Instead of clearing the session, which leaves behind a cookie, we delete the entire cookie
+Since the cookie is opaque to the client, deleting it makes it easy for the client to decide whether it is authenticated.
+*/
+func deleteAuthCookie(request *APIRequest) {
 	http.SetCookie(request.Writer, &http.Cookie{
 		Name:   AuthCookieName,
 		Value:  "",
 		Path:   "/",
 		MaxAge: -1,
 	})
-	return 200, "Ok", responseHeader
 }
 
 func (resource CurrentUserResource) Post(request *APIRequest) (int, interface{}, http.Header) {
@@ -206,24 +249,56 @@ func (resource CurrentUserResource) Post(request *APIRequest) (int, interface{},
 	if loginData.Email == "" || loginData.Password == "" {
 		return 400, UnprocessableError, responseHeader
 	}
+	ip := RequestIP(request.Raw)
+	throttle := resource.api.LoginThrottle
+	if wait := throttle.Wait(ip, loginData.Email, time.Now()); wait > 0 {
+		responseHeader["Retry-After"] = []string{RetryAfter(wait)}
+		return 429, LoginThrottledError, responseHeader
+	}
+	// Unknown emails and wrong passwords get the same response, after the same bcrypt work, so that accounts can not be discovered
 	user, err := FindUserByEmail(loginData.Email, request.DBInfo)
 	if err != nil {
-		return 400, APIError{
-			Id:      "no_such_user",
-			Message: "No such user",
-			Error:   err.Error(),
-		}, responseHeader
+		ComparePasswordToNothing(loginData.Password)
 	}
-	if PasswordMatches(user.Id, loginData.Password, request.DBInfo) == false {
-		return 400, APIError{
-			Id:      "incorrect_password",
-			Message: "Incorrect password",
+	if err != nil || PasswordMatches(user.Id, loginData.Password, request.DBInfo) == false {
+		if throttle.Fail(ip, loginData.Email, time.Now()) && user != nil {
+			// Mail in the background so that the response time does not show that the account exists
+			go notifyLoginFailures(user, ip, resource.api)
+		}
+		return 400, IncorrectLoginError, responseHeader
+	}
+	throttle.Succeed(loginData.Email)
+	if user.Verified == false {
+		return 403, UnverifiedEmailError, responseHeader
+	}
+	loggedIn, err := BeginLogIn(request.Session, user, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Database error: " + err.Error(),
 		}, responseHeader
 	}
-	request.Session.Set(UserUUIDKey, user.UUID)
+	if loggedIn == false {
+		// The session finishes logging in when SecondFactorResource receives a code
+		return 401, SecondFactorRequiredError, responseHeader
+	}
 	return 200, user, responseHeader
 }
 
+/*
+notifyLoginFailures tells the User that their account is being slowed down by wrong passwords
+*/
+func notifyLoginFailures(user *User, ip string, api *API) {
+	err := api.Mailer.Send(&MailMessage{
+		To:      user.Email,
+		Subject: "Failed logins to your Spaciblō account",
+		Body:    "There have been " + strconv.Itoa(api.LoginThrottle.NotifyAt) + " attempts in a row to log in to your account with the wrong password, most recently from " + ip + ". Logging in will be slower for a while.\n\nIf this was not you, your password is still safe, but you may want to change it and turn on two-factor authentication.\n",
+	})
+	if err != nil {
+		logger.Print("Could not send the failed login email: " + err.Error())
+	}
+}
+
 type UserResource struct {
 }
 
@@ -244,11 +319,9 @@ func (resource UserResource) Properties() []Property {
 
 func (resource UserResource) Get(request *APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 403, ForbiddenError, responseHeader
+	status, apiError := request.CheckPermission(PermissionUsersModerate)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	uuid, _ := request.PathValues["uuid"]
@@ -278,8 +351,15 @@ func (resource UserResource) Put(request *APIRequest) (int, interface{}, http.He
 			Error:   err.Error(),
 		}, responseHeader
 	}
-	if request.User.Staff != true && request.User.UUID != user.UUID {
-		return 403, ForbiddenError, responseHeader
+	if request.User.UUID != user.UUID {
+		_, status, apiError := findManagedUser(request, PermissionUsersManage)
+		if apiError != nil {
+			return status, apiError, responseHeader
+		}
+		status, apiError = request.CheckSecondFactor()
+		if apiError != nil {
+			return status, apiError, responseHeader
+		}
 	}
 
 	var updatedUser User
@@ -296,18 +376,172 @@ func (resource UserResource) Put(request *APIRequest) (int, interface{}, http.He
 	// Some fields cannot be updated via this API endpoint
 	updatedUser.Image = user.Image
 	updatedUser.Created = user.Created
-	// Some fields can only be updated by staff
+	updatedUser.SessionVersion = user.SessionVersion
+	// Some fields can only be updated by people who manage users, and only staff can make staff
+	if request.HasPermission(PermissionUsersManage) == false {
+		updatedUser.Email = user.Email
+		updatedUser.StorageQuota = user.StorageQuota
+		updatedUser.Verified = user.Verified
+	}
 	if request.User.Staff == false {
 		updatedUser.Staff = user.Staff
-		updatedUser.Email = user.Email
 	}
 	err = UpdateUser(&updatedUser, request.DBInfo)
 	if err != nil {
 		return 400, BadRequestError, responseHeader
 	}
+	request.Audit(AuditUpdate, "user", user.UUID, user, updatedUser)
 	return 200, updatedUser, responseHeader
 }
 
+var UserStorageProperties = []Property{
+	Property{
+		Name:        "used",
+		Description: "Bytes of stored files that count against the quota",
+		DataType:    "int",
+		Protected:   true,
+	},
+	Property{
+		Name:        "quota",
+		Description: "Bytes the user may store, with 0 for the default quota and -1 for unlimited",
+		DataType:    "int",
+	},
+	Property{
+		Name:        "effective-quota",
+		Description: "Bytes the user may store after applying the default, with 0 for unlimited",
+		DataType:    "int",
+		Protected:   true,
+	},
+	Property{
+		Name:        "reset",
+		Description: "Set to true in a PUT to stop counting all existing uploads against the quota",
+		DataType:    "bool",
+		Optional:    true,
+	},
+}
+
+/*
+UserStorage is how much FileStorage a User fills and how much they may fill
+*/
+type UserStorage struct {
+	Used           int64 `json:"used"`
+	Quota          int64 `json:"quota"`
+	EffectiveQuota int64 `json:"effective-quota"`
+	Reset          bool  `json:"reset,omitempty"`
+}
+
+/*
+UserStorageResource lets people with users:manage view and adjust a User's storage quota and usage
+*/
+type UserStorageResource struct {
+}
+
+func NewUserStorageResource() *UserStorageResource {
+	return &UserStorageResource{}
+}
+
+func (UserStorageResource) Name() string  { return "user-storage" }
+func (UserStorageResource) Path() string  { return "/user/{uuid:[0-9,a-z,-]+}/storage" }
+func (UserStorageResource) Title() string { return "User storage" }
+func (UserStorageResource) Description() string {
+	return "The bytes a user has uploaded and their storage quota, for people with the users:manage permission."
+}
+
+func (resource UserStorageResource) Properties() []Property {
+	return UserStorageProperties
+}
+
+func (resource UserStorageResource) Get(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	user, status, apiError := findManagedUser(request, PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	return userStorageResponse(user, request, responseHeader)
+}
+
+func (resource UserStorageResource) Put(request *APIRequest) (int, interface{}, http.Header) {
+	responseHeader := map[string][]string{}
+	user, status, apiError := findManagedUser(request, PermissionUsersManage)
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	status, apiError = request.CheckSecondFactor()
+	if apiError != nil {
+		return status, apiError, responseHeader
+	}
+	var updatedStorage UserStorage
+	err := json.NewDecoder(request.Raw.Body).Decode(&updatedStorage)
+	if err != nil {
+		return 400, JSONParseError, responseHeader
+	}
+	if updatedStorage.Quota < -1 {
+		return 400, APIError{
+			Id:      BadRequestError.Id,
+			Message: "The quota must be a number of bytes, 0 for the default, or -1 for unlimited",
+		}, responseHeader
+	}
+	if updatedStorage.Reset {
+		err = DeleteUserFileUploads(user.Id, request.DBInfo)
+		if err != nil {
+			return 500, APIError{
+				Id:      "database_error",
+				Message: "Could not reset storage usage: " + err.Error(),
+			}, responseHeader
+		}
+	}
+	before := map[string]int64{"storage-quota": user.StorageQuota}
+	user.StorageQuota = updatedStorage.Quota
+	err = UpdateUser(user, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not update the user: " + err.Error(),
+		}, responseHeader
+	}
+	request.Audit(AuditUpdate, "user-storage", user.UUID, before, map[string]interface{}{"storage-quota": user.StorageQuota, "reset": updatedStorage.Reset})
+	return userStorageResponse(user, request, responseHeader)
+}
+
+/*
+findManagedUser returns the User in the request path if the request's User has permission over them
+Only staff may act on other staff.
+*/
+func findManagedUser(request *APIRequest, permission string) (*User, int, *APIError) {
+	status, apiError := request.CheckPermission(permission)
+	if apiError != nil {
+		return nil, status, apiError
+	}
+	uuid, _ := request.PathValues["uuid"]
+	user, err := FindUser(uuid, request.DBInfo)
+	if err != nil {
+		return nil, 404, &APIError{
+			Id:      "no_such_user",
+			Message: "No such user: " + uuid,
+			Error:   err.Error(),
+		}
+	}
+	if user.Staff && request.User.Staff == false {
+		return nil, 403, &StaffOnlyError
+	}
+	return user, 0, nil
+}
+
+func userStorageResponse(user *User, request *APIRequest, responseHeader http.Header) (int, interface{}, http.Header) {
+	used, err := FindStorageUsage(user.Id, request.DBInfo)
+	if err != nil {
+		return 500, APIError{
+			Id:      "database_error",
+			Message: "Could not find storage usage: " + err.Error(),
+		}, responseHeader
+	}
+	return 200, UserStorage{
+		Used:           used,
+		Quota:          user.StorageQuota,
+		EffectiveQuota: request.UploadLimits.QuotaFor(user),
+	}, responseHeader
+}
+
 type UsersResource struct {
 }
 
@@ -328,11 +562,9 @@ func (resource UsersResource) Properties() []Property {
 
 func (resource UsersResource) Get(request *APIRequest) (int, interface{}, http.Header) {
 	responseHeader := map[string][]string{}
-	if request.User == nil {
-		return 401, NotLoggedInError, responseHeader
-	}
-	if request.User.Staff != true {
-		return 403, ForbiddenError, responseHeader
+	status, apiError := request.CheckPermission(PermissionUsersModerate)
+	if apiError != nil {
+		return status, apiError, responseHeader
 	}
 
 	offset, limit := GetOffsetAndLimit(request.Raw.Form)
@@ -50,3 +50,10 @@ func (client *SimRPCClient) StartSimulator(spaceUUID string) (*simRPC.Ack, error
 	}
 	return client.HostClient.HandleStartSimulatorRequest(context.Background(), request)
 }
+
+func (client *SimRPCClient) SaveSpace(spaceUUID string) (*simRPC.Ack, error) {
+	request := &simRPC.SaveSpaceRequest{
+		SpaceUUID: spaceUUID,
+	}
+	return client.HostClient.HandleSaveSpaceRequest(context.Background(), request)
+}
@@ -23,6 +23,7 @@ It has these top-level messages:
 	AddNodeRequest
 	RemoveNodeRequest
 	StartSimulatorRequest
+	SaveSpaceRequest
 */
 package simRPC
 
@@ -566,6 +567,22 @@ func (m *StartSimulatorRequest) GetSpaceUUID() string {
 	return ""
 }
 
+type SaveSpaceRequest struct {
+	SpaceUUID string `protobuf:"bytes,1,opt,name=spaceUUID" json:"spaceUUID,omitempty"`
+}
+
+func (m *SaveSpaceRequest) Reset()                    { *m = SaveSpaceRequest{} }
+func (m *SaveSpaceRequest) String() string            { return proto.CompactTextString(m) }
+func (*SaveSpaceRequest) ProtoMessage()               {}
+func (*SaveSpaceRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *SaveSpaceRequest) GetSpaceUUID() string {
+	if m != nil {
+		return m.SpaceUUID
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Ping)(nil), "simRPC.Ping")
 	proto.RegisterType((*Ack)(nil), "simRPC.Ack")
@@ -581,6 +598,7 @@ func init() {
 	proto.RegisterType((*AddNodeRequest)(nil), "simRPC.AddNodeRequest")
 	proto.RegisterType((*RemoveNodeRequest)(nil), "simRPC.RemoveNodeRequest")
 	proto.RegisterType((*StartSimulatorRequest)(nil), "simRPC.StartSimulatorRequest")
+	proto.RegisterType((*SaveSpaceRequest)(nil), "simRPC.SaveSpaceRequest")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -610,6 +628,8 @@ type SimHostClient interface {
 	HandleRemoveNodeRequest(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*Ack, error)
 	// Request that a space's simulator be started, if it hasn't already
 	HandleStartSimulatorRequest(ctx context.Context, in *StartSimulatorRequest, opts ...grpc.CallOption) (*Ack, error)
+	// Request that a running simulator save its state to the DB before returning
+	HandleSaveSpaceRequest(ctx context.Context, in *SaveSpaceRequest, opts ...grpc.CallOption) (*Ack, error)
 }
 
 type simHostClient struct {
@@ -692,6 +712,15 @@ func (c *simHostClient) HandleStartSimulatorRequest(ctx context.Context, in *Sta
 	return out, nil
 }
 
+func (c *simHostClient) HandleSaveSpaceRequest(ctx context.Context, in *SaveSpaceRequest, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := grpc.Invoke(ctx, "/simRPC.SimHost/HandleSaveSpaceRequest", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for SimHost service
 
 type SimHostServer interface {
@@ -711,6 +740,8 @@ type SimHostServer interface {
 	HandleRemoveNodeRequest(context.Context, *RemoveNodeRequest) (*Ack, error)
 	// Request that a space's simulator be started, if it hasn't already
 	HandleStartSimulatorRequest(context.Context, *StartSimulatorRequest) (*Ack, error)
+	// Request that a running simulator save its state to the DB before returning
+	HandleSaveSpaceRequest(context.Context, *SaveSpaceRequest) (*Ack, error)
 }
 
 func RegisterSimHostServer(s *grpc.Server, srv SimHostServer) {
@@ -861,6 +892,24 @@ func _SimHost_HandleStartSimulatorRequest_Handler(srv interface{}, ctx context.C
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SimHost_HandleSaveSpaceRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SaveSpaceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimHostServer).HandleSaveSpaceRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/simRPC.SimHost/HandleSaveSpaceRequest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimHostServer).HandleSaveSpaceRequest(ctx, req.(*SaveSpaceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _SimHost_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "simRPC.SimHost",
 	HandlerType: (*SimHostServer)(nil),
@@ -897,6 +946,10 @@ var _SimHost_serviceDesc = grpc.ServiceDesc{
 			MethodName: "HandleStartSimulatorRequest",
 			Handler:    _SimHost_HandleStartSimulatorRequest_Handler,
 		},
+		{
+			MethodName: "HandleSaveSpaceRequest",
+			Handler:    _SimHost_HandleSaveSpaceRequest_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "sim.proto",
@@ -905,51 +958,52 @@ var _SimHost_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("sim.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 723 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xcc, 0x56, 0xcb, 0x6e, 0xd3, 0x40,
-	0x14, 0xc5, 0x76, 0x1e, 0xce, 0x4d, 0x28, 0x30, 0x4d, 0x83, 0x09, 0xaf, 0x68, 0x24, 0xa4, 0x20,
-	0xa4, 0x4a, 0xb4, 0x65, 0x81, 0x10, 0x8b, 0x50, 0x16, 0x8d, 0xa0, 0xa8, 0x72, 0xd5, 0x0f, 0x98,
-	0xc6, 0x43, 0xb1, 0x6a, 0x7b, 0x8c, 0x67, 0x52, 0x89, 0x75, 0x7f, 0x01, 0x89, 0x0d, 0xbf, 0xc2,
-	0xbf, 0xa1, 0x79, 0xd8, 0xb1, 0x9d, 0x34, 0x05, 0xd1, 0x05, 0xbb, 0xdc, 0x73, 0x1f, 0x73, 0xef,
-	0x39, 0x33, 0xbe, 0x81, 0x0e, 0x0f, 0xe3, 0xed, 0x34, 0x63, 0x82, 0xa1, 0x16, 0x0f, 0x63, 0xff,
-	0x68, 0x1f, 0x0f, 0xa1, 0x71, 0x14, 0x26, 0x67, 0x08, 0x41, 0x23, 0x21, 0x31, 0xf5, 0xac, 0x91,
-	0x35, 0xee, 0xf8, 0xea, 0x37, 0x7e, 0x0a, 0xce, 0x64, 0x76, 0x8e, 0x3c, 0x68, 0xc7, 0x94, 0x73,
-	0x72, 0x96, 0x7b, 0x73, 0x13, 0xf7, 0x01, 0x7d, 0x0c, 0xb9, 0x38, 0x0e, 0xe3, 0x69, 0xf2, 0x99,
-	0xf1, 0x23, 0x92, 0x91, 0x98, 0xe3, 0x97, 0xd0, 0x36, 0xc8, 0xaa, 0xaa, 0x12, 0x9b, 0xcf, 0xc3,
-	0xc0, 0xb3, 0x35, 0x26, 0x7f, 0xe3, 0x3d, 0xe8, 0x9a, 0x14, 0x59, 0x0f, 0x3d, 0x83, 0x66, 0x28,
-	0x0b, 0x7a, 0xd6, 0xc8, 0x19, 0x77, 0x77, 0xee, 0x6c, 0xeb, 0x66, 0xb7, 0x4d, 0x8c, 0xaf, 0xbd,
-	0xf8, 0xa7, 0x05, 0x77, 0xf7, 0xa3, 0x90, 0x26, 0xe2, 0x90, 0xc6, 0xa7, 0x34, 0xe3, 0x5f, 0xc2,
-	0x14, 0x3d, 0x01, 0x98, 0x29, 0xec, 0xe4, 0x64, 0xfa, 0xde, 0x1c, 0x5c, 0x42, 0xd0, 0x10, 0xdc,
-	0x39, 0xa7, 0x99, 0xf2, 0xea, 0x16, 0x0a, 0x1b, 0x3d, 0x82, 0x0e, 0x4f, 0xc9, 0x8c, 0x2a, 0xa7,
-	0xa3, 0x9c, 0x0b, 0x00, 0x0d, 0xa0, 0x15, 0xab, 0x73, 0xbc, 0xc6, 0xc8, 0x1a, 0xbb, 0xbe, 0xb1,
-	0x24, 0x4e, 0x2e, 0x88, 0x20, 0x99, 0xd7, 0xd4, 0xb8, 0xb6, 0x64, 0x7b, 0xf0, 0x8e, 0x05, 0xdf,
-	0x4e, 0xd2, 0x80, 0x08, 0xba, 0x92, 0x8b, 0x21, 0xb8, 0x29, 0xe3, 0xa1, 0x08, 0x59, 0xe2, 0xd9,
-	0x23, 0x67, 0x6c, 0xf9, 0x85, 0x8d, 0x46, 0xd0, 0x65, 0x99, 0x6c, 0x9b, 0x28, 0xb7, 0xa3, 0xdc,
-	0x65, 0x48, 0x46, 0x88, 0x8c, 0x24, 0x3c, 0xd2, 0x11, 0x0d, 0x1d, 0x51, 0x82, 0x64, 0xfd, 0x8c,
-	0x99, 0x02, 0x4d, 0x5d, 0x3f, 0xb7, 0xf1, 0x77, 0x1b, 0x7a, 0x13, 0xd5, 0xe9, 0x21, 0x53, 0xc1,
-	0x95, 0xe9, 0xad, 0xfa, 0xf4, 0x55, 0x5e, 0xed, 0x55, 0xbc, 0x16, 0xa3, 0x38, 0xeb, 0x47, 0x69,
-	0x5c, 0x3b, 0x4a, 0x73, 0xfd, 0x28, 0xad, 0xea, 0x28, 0xa8, 0x0f, 0x4d, 0x3e, 0x23, 0x11, 0xf5,
-	0xda, 0xca, 0xa1, 0x0d, 0xb4, 0x07, 0xdd, 0xd3, 0x82, 0x7e, 0xee, 0xb9, 0xea, 0x2e, 0xa1, 0xfc,
-	0x2e, 0x2d, 0x94, 0xf1, 0xcb, 0x61, 0x78, 0x17, 0xda, 0xc7, 0x54, 0x88, 0x2b, 0xde, 0x84, 0x3c,
-	0xea, 0x82, 0x44, 0x73, 0x6a, 0x18, 0xd0, 0x06, 0xfe, 0x61, 0x03, 0x7c, 0x62, 0x01, 0x35, 0x52,
-	0x6f, 0x80, 0x3d, 0x0d, 0x54, 0x9a, 0xe3, 0xdb, 0xd3, 0x00, 0xbd, 0x00, 0x97, 0xeb, 0x9a, 0x5c,
-	0xc9, 0x5c, 0xbe, 0xd2, 0x1a, 0xf7, 0x8b, 0x80, 0xff, 0x90, 0x48, 0x0c, 0x3d, 0x41, 0xe3, 0x34,
-	0x22, 0x42, 0xdf, 0x0d, 0x57, 0x8d, 0x5e, 0xc1, 0xe4, 0x23, 0x88, 0x28, 0x09, 0x68, 0xe6, 0x75,
-	0xd4, 0xd8, 0xc6, 0xc2, 0x97, 0x16, 0xdc, 0x36, 0x34, 0xd3, 0xaf, 0x73, 0xca, 0xc5, 0x3f, 0x5e,
-	0xb3, 0x3d, 0xe8, 0x26, 0x05, 0xd1, 0x5c, 0x11, 0x54, 0x12, 0x75, 0xa1, 0x81, 0x5f, 0x0e, 0xc3,
-	0xbf, 0x6c, 0xd8, 0x98, 0x04, 0x81, 0x74, 0xdf, 0x4c, 0x1b, 0x03, 0x68, 0xa5, 0x24, 0xa3, 0x89,
-	0x50, 0x9f, 0x09, 0xc7, 0x37, 0x56, 0x45, 0xe9, 0xc6, 0xdf, 0x28, 0xdd, 0x5c, 0xaf, 0x74, 0xeb,
-	0x5a, 0xa5, 0xdb, 0xeb, 0x95, 0x76, 0xaf, 0x52, 0xba, 0x53, 0x56, 0x7a, 0xa1, 0x22, 0x54, 0x54,
-	0x24, 0x70, 0xcf, 0xa7, 0x31, 0xbb, 0xa0, 0x37, 0xc7, 0xe0, 0x06, 0xd8, 0x61, 0x60, 0xd8, 0xb3,
-	0xc3, 0x00, 0xbf, 0x82, 0xad, 0x63, 0x41, 0x32, 0xb9, 0x4c, 0xe6, 0x11, 0x11, 0x2c, 0xfb, 0xa3,
-	0x63, 0x76, 0x2e, 0x1b, 0x6a, 0xdb, 0x1c, 0x30, 0x2e, 0xd0, 0x73, 0x80, 0x03, 0x92, 0x04, 0x11,
-	0x55, 0x1b, 0xad, 0x97, 0x13, 0x2f, 0xad, 0x61, 0x37, 0xb7, 0x26, 0xb3, 0x73, 0x7c, 0x0b, 0x4d,
-	0xa0, 0x57, 0xde, 0x5c, 0x68, 0x98, 0xbb, 0x97, 0xf7, 0xd9, 0x70, 0xb3, 0xb6, 0x7e, 0x64, 0x88,
-	0x2a, 0x31, 0xd0, 0xa7, 0x2d, 0xad, 0x20, 0x2f, 0x4f, 0xa8, 0x7b, 0xea, 0x5d, 0xbc, 0x06, 0xa4,
-	0x4b, 0x54, 0xbe, 0xc3, 0xfd, 0x22, 0xa8, 0x84, 0xd6, 0x53, 0xdf, 0xc0, 0xa6, 0x4e, 0xad, 0x3e,
-	0xae, 0xad, 0x3c, 0xaa, 0x02, 0xd7, 0x93, 0xdf, 0x42, 0xdf, 0x9c, 0x5b, 0x7d, 0x13, 0x83, 0x22,
-	0xac, 0x82, 0xd7, 0xd3, 0xf7, 0xe1, 0xbe, 0x4e, 0x5f, 0xbe, 0x13, 0x0f, 0xf2, 0xc8, 0x25, 0x57,
-	0xbd, 0xc8, 0x07, 0x78, 0xa8, 0x8b, 0xac, 0x56, 0xfd, 0x71, 0x41, 0xfa, 0x2a, 0x77, 0xad, 0xd8,
-	0x69, 0x4b, 0xfd, 0xa9, 0xd9, 0xfd, 0x1d, 0x00, 0x00, 0xff, 0xff, 0x1e, 0xb0, 0x05, 0x12, 0xe1,
-	0x08, 0x00, 0x00,
+	// 746 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0xcb, 0x6e, 0x13, 0x4b,
+	0x10, 0xbd, 0xf3, 0xb0, 0x3d, 0x2e, 0xfb, 0xe6, 0xe6, 0x76, 0x1c, 0xdf, 0xb9, 0xe6, 0x65, 0xb5,
+	0x84, 0x64, 0x84, 0x14, 0x41, 0x12, 0x16, 0x08, 0xb1, 0x30, 0x61, 0x11, 0x0b, 0x82, 0xa2, 0xb1,
+	0xf2, 0x01, 0x1d, 0x4f, 0x13, 0x46, 0x99, 0x17, 0xd3, 0x6d, 0x4b, 0xac, 0xf9, 0x05, 0x24, 0x36,
+	0xfc, 0x0a, 0x5b, 0xbe, 0x0b, 0xf5, 0x63, 0xc6, 0x33, 0x63, 0xc7, 0x09, 0x22, 0x0b, 0x76, 0xae,
+	0x73, 0xaa, 0xaa, 0xbb, 0xea, 0x54, 0x4f, 0x19, 0xda, 0x2c, 0x88, 0xf6, 0xd2, 0x2c, 0xe1, 0x09,
+	0x6a, 0xb2, 0x20, 0xf2, 0x4e, 0x8f, 0xf0, 0x00, 0xec, 0xd3, 0x20, 0xbe, 0x40, 0x08, 0xec, 0x98,
+	0x44, 0xd4, 0x35, 0x86, 0xc6, 0xa8, 0xed, 0xc9, 0xdf, 0xf8, 0x01, 0x58, 0xe3, 0xd9, 0x25, 0x72,
+	0xa1, 0x15, 0x51, 0xc6, 0xc8, 0x45, 0xce, 0xe6, 0x26, 0xee, 0x01, 0x7a, 0x1b, 0x30, 0x3e, 0x0d,
+	0xa2, 0x49, 0xfc, 0x3e, 0x61, 0xa7, 0x24, 0x23, 0x11, 0xc3, 0x4f, 0xa1, 0xa5, 0x91, 0x75, 0x59,
+	0x05, 0x36, 0x9f, 0x07, 0xbe, 0x6b, 0x2a, 0x4c, 0xfc, 0xc6, 0x87, 0xd0, 0xd1, 0x21, 0x22, 0x1f,
+	0x7a, 0x08, 0x8d, 0x40, 0x24, 0x74, 0x8d, 0xa1, 0x35, 0xea, 0xec, 0xff, 0xb3, 0xa7, 0x2e, 0xbb,
+	0xa7, 0x7d, 0x3c, 0xc5, 0xe2, 0x6f, 0x06, 0x6c, 0x1f, 0x85, 0x01, 0x8d, 0xf9, 0x09, 0x8d, 0xce,
+	0x69, 0xc6, 0x3e, 0x04, 0x29, 0xba, 0x0f, 0x30, 0x93, 0xd8, 0xd9, 0xd9, 0xe4, 0xb5, 0x3e, 0xb8,
+	0x84, 0xa0, 0x01, 0x38, 0x73, 0x46, 0x33, 0xc9, 0xaa, 0x2b, 0x14, 0x36, 0xba, 0x0b, 0x6d, 0x96,
+	0x92, 0x19, 0x95, 0xa4, 0x25, 0xc9, 0x25, 0x80, 0xfa, 0xd0, 0x8c, 0xe4, 0x39, 0xae, 0x3d, 0x34,
+	0x46, 0x8e, 0xa7, 0x2d, 0x81, 0x93, 0x05, 0xe1, 0x24, 0x73, 0x1b, 0x0a, 0x57, 0x96, 0xb8, 0x1e,
+	0xbc, 0x4a, 0xfc, 0x4f, 0x67, 0xa9, 0x4f, 0x38, 0x5d, 0xdb, 0x8b, 0x01, 0x38, 0x69, 0xc2, 0x02,
+	0x1e, 0x24, 0xb1, 0x6b, 0x0e, 0xad, 0x91, 0xe1, 0x15, 0x36, 0x1a, 0x42, 0x27, 0xc9, 0xc4, 0xb5,
+	0x89, 0xa4, 0x2d, 0x49, 0x97, 0x21, 0xe1, 0xc1, 0x33, 0x12, 0xb3, 0x50, 0x79, 0xd8, 0xca, 0xa3,
+	0x04, 0x89, 0xfc, 0x59, 0xa2, 0x13, 0x34, 0x54, 0xfe, 0xdc, 0xc6, 0x5f, 0x4c, 0xe8, 0x8e, 0xe5,
+	0x4d, 0x4f, 0x12, 0xe9, 0x5c, 0xa9, 0xde, 0xa8, 0x57, 0x5f, 0xed, 0xab, 0xb9, 0xae, 0xaf, 0x45,
+	0x29, 0xd6, 0xe6, 0x52, 0xec, 0x6b, 0x4b, 0x69, 0x6c, 0x2e, 0xa5, 0x59, 0x2d, 0x05, 0xf5, 0xa0,
+	0xc1, 0x66, 0x24, 0xa4, 0x6e, 0x4b, 0x12, 0xca, 0x40, 0x87, 0xd0, 0x39, 0x2f, 0xda, 0xcf, 0x5c,
+	0x47, 0xce, 0x12, 0xca, 0x67, 0x69, 0xa9, 0x8c, 0x57, 0x76, 0xc3, 0x07, 0xd0, 0x9a, 0x52, 0xce,
+	0xaf, 0x78, 0x13, 0xe2, 0xa8, 0x05, 0x09, 0xe7, 0x54, 0x77, 0x40, 0x19, 0xf8, 0xab, 0x09, 0xf0,
+	0x2e, 0xf1, 0xa9, 0x96, 0x7a, 0x0b, 0xcc, 0x89, 0x2f, 0xc3, 0x2c, 0xcf, 0x9c, 0xf8, 0xe8, 0x31,
+	0x38, 0x4c, 0xe5, 0x64, 0xae, 0x59, 0x1b, 0x69, 0x85, 0x7b, 0x85, 0xc3, 0x1f, 0xd8, 0x48, 0x0c,
+	0x5d, 0x4e, 0xa3, 0x34, 0x24, 0x5c, 0xcd, 0x86, 0x23, 0x4b, 0xaf, 0x60, 0xe2, 0x11, 0x84, 0x94,
+	0xf8, 0x34, 0x73, 0xdb, 0xb2, 0x6c, 0x6d, 0xe1, 0xcf, 0x06, 0xfc, 0xad, 0xdb, 0x4c, 0x3f, 0xce,
+	0x29, 0xe3, 0xbf, 0x39, 0x66, 0x87, 0xd0, 0x89, 0x8b, 0x46, 0x33, 0xd7, 0xaa, 0x8a, 0xba, 0xd4,
+	0xc0, 0x2b, 0xbb, 0xe1, 0xef, 0x26, 0x6c, 0x8d, 0x7d, 0x5f, 0xd0, 0xb7, 0x73, 0x8d, 0x3e, 0x34,
+	0x53, 0x92, 0xd1, 0x98, 0xcb, 0xcf, 0x84, 0xe5, 0x69, 0xab, 0xa2, 0xb4, 0xfd, 0x2b, 0x4a, 0x37,
+	0x36, 0x2b, 0xdd, 0xbc, 0x56, 0xe9, 0xd6, 0x66, 0xa5, 0x9d, 0xab, 0x94, 0x6e, 0x97, 0x95, 0x5e,
+	0xaa, 0x08, 0x15, 0x15, 0x09, 0xfc, 0xeb, 0xd1, 0x28, 0x59, 0xd0, 0xdb, 0xeb, 0xe0, 0x16, 0x98,
+	0x81, 0xaf, 0xbb, 0x67, 0x06, 0x3e, 0x7e, 0x06, 0xbb, 0x53, 0x4e, 0x32, 0xb1, 0x4c, 0xe6, 0x21,
+	0xe1, 0x49, 0x76, 0xa3, 0x63, 0xf0, 0x13, 0xd8, 0x9e, 0x92, 0x05, 0x9d, 0x0a, 0xe0, 0x46, 0x11,
+	0xfb, 0x3f, 0x6c, 0xb9, 0x9f, 0x8e, 0x13, 0xc6, 0xd1, 0x23, 0x80, 0x63, 0x12, 0xfb, 0x21, 0x95,
+	0x3b, 0xb0, 0x9b, 0x4b, 0x25, 0xac, 0x41, 0x27, 0xb7, 0xc6, 0xb3, 0x4b, 0xfc, 0x17, 0x1a, 0x43,
+	0xb7, 0xbc, 0xeb, 0xd0, 0x20, 0xa7, 0x57, 0x37, 0xe0, 0x60, 0xa7, 0xb6, 0xb0, 0x84, 0x8b, 0x4c,
+	0xd1, 0x57, 0xa7, 0xad, 0x2c, 0x2d, 0x37, 0x0f, 0xa8, 0x33, 0xf5, 0x5b, 0x3c, 0x07, 0xa4, 0x52,
+	0x54, 0xbe, 0xdc, 0xbd, 0xc2, 0xa9, 0x84, 0xd6, 0x43, 0x5f, 0xc0, 0x8e, 0x0a, 0xad, 0x3e, 0xc7,
+	0xdd, 0xdc, 0xab, 0x02, 0xd7, 0x83, 0x5f, 0x42, 0x4f, 0x9f, 0x5b, 0x7d, 0x45, 0xfd, 0xc2, 0xad,
+	0x82, 0xd7, 0xc3, 0x8f, 0xe0, 0x3f, 0x15, 0xbe, 0x3a, 0x45, 0xff, 0xe7, 0x9e, 0x2b, 0x54, 0x3d,
+	0xc9, 0x1b, 0xb8, 0xa3, 0x92, 0xac, 0x9f, 0x93, 0x7b, 0x45, 0xd3, 0xd7, 0xd1, 0xab, 0x72, 0x6a,
+	0x2d, 0x56, 0xa6, 0xa7, 0xd0, 0xa2, 0xce, 0xd4, 0x52, 0x9c, 0x37, 0xe5, 0x3f, 0xa9, 0x83, 0x9f,
+	0x03, 0x00, 0xb5, 0xac, 0x34, 0x99, 0x56, 0x09, 0x00, 0x00,
 }
@@ -159,6 +159,22 @@ func (server *SimHostServer) HandleStartSimulatorRequest(ctx context.Context, st
 	return &simRPC.Ack{Message: "OK"}, nil
 }
 
+/*
+HandleSaveSpaceRequest saves the state of a running simulator so that the space's records are current
+It is not an error if the space has no running simulator because then the records are already current.
+*/
+func (server *SimHostServer) HandleSaveSpaceRequest(ctx context.Context, saveSpaceRequest *simRPC.SaveSpaceRequest) (*simRPC.Ack, error) {
+	spaceSim, ok := server.SpaceSimulators[saveSpaceRequest.SpaceUUID]
+	if ok == false {
+		return &simRPC.Ack{Message: "Not running"}, nil
+	}
+	err := spaceSim.RequestSave(SAVE_REQUEST_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &simRPC.Ack{Message: "OK"}, nil
+}
+
 func (server *SimHostServer) HandleAddNodeRequest(ctx context.Context, addNodeRequest *simRPC.AddNodeRequest) (*simRPC.Ack, error) {
 	spaceSim, ok := server.SpaceSimulators[addNodeRequest.SpaceUUID]
 	if ok == false {
@@ -72,6 +72,85 @@ func TestHostStartup(t *testing.T) {
 	AssertNil(t, err)
 }
 
+func TestIncrementalSave(t *testing.T) {
+	err := be.CreateDB()
+	AssertNil(t, err)
+	dbInfo, err := db.InitDB()
+	AssertNil(t, err)
+	defer func() {
+		be.WipeDB(dbInfo)
+		dbInfo.Connection.Close()
+	}()
+
+	avatarRecord, err := apiDB.CreateAvatarRecord("Default Avatar", dbInfo)
+	AssertNil(t, err)
+	spaceRecord, err := createSpace(avatarRecord.UUID, dbInfo)
+	AssertNil(t, err)
+
+	// The first save writes every node
+	spaceSim, err := NewSpaceSimulator(spaceRecord.UUID, nil, dbInfo)
+	AssertNil(t, err)
+	err = spaceSim.SaveState()
+	AssertNil(t, err)
+	nodeRecords, err := apiDB.FindSpaceNodeRecords(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 3, len(nodeRecords))
+	groupNode := spaceSim.RootNode.Nodes[0]
+	AssertTrue(t, groupNode.RecordId != 0)
+
+	// Change, add, and remove nodes and then save again
+	groupNode.SetOrCreateSetting("name", "Moved Group")
+	groupNode.Position.Set([]float64{4, 5, 6})
+	newNode, err := NewSceneNode(apiDB.NewEmptySpaceStateNode(), 0, dbInfo)
+	AssertNil(t, err)
+	newNode.SetOrCreateSetting("name", "New Node")
+	spaceSim.RootNode.Add(newNode)
+	removedNode := groupNode.Nodes[0]
+	spaceSim.UnsavedDeletions = append(spaceSim.UnsavedDeletions, removedNode.getRecordIds()...)
+	groupNode.Remove(removedNode)
+	spaceSim.RootNode.getNodeUpdates() // Marks the changed nodes as unsaved, as happens during a tick
+	AssertTrue(t, groupNode.Unsaved)
+	AssertEqual(t, false, spaceSim.RootNode.Unsaved)
+	err = spaceSim.SaveState()
+	AssertNil(t, err)
+	AssertEqual(t, false, groupNode.Unsaved)
+	AssertEqual(t, 0, len(spaceSim.UnsavedDeletions))
+
+	// A new simulator loads the saved nodes
+	spaceSim2, err := NewSpaceSimulator(spaceRecord.UUID, nil, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(spaceSim2.RootNode.Nodes))
+	AssertEqual(t, "Moved Group", spaceSim2.RootNode.Nodes[0].SettingValue("name"))
+	AssertEqual(t, []float64{4, 5, 6}, spaceSim2.RootNode.Nodes[0].Position.Data)
+	AssertEqual(t, 0, len(spaceSim2.RootNode.Nodes[0].Nodes))
+	AssertEqual(t, "New Node", spaceSim2.RootNode.Nodes[1].SettingValue("name"))
+	AssertEqual(t, groupNode.RecordId, spaceSim2.RootNode.Nodes[0].RecordId)
+
+	// Replacing the stored state makes the next save fail so that the simulator reloads instead of writing orphaned records
+	replacement := apiDB.NewEmptySpaceStateNode()
+	replacement.Settings["name"] = "Replaced Space"
+	replacement.Nodes = append(replacement.Nodes, apiDB.NewEmptySpaceStateNode())
+	AssertNil(t, apiDB.UpdateSpaceState(spaceRecord.UUID, replacement.ToString(), dbInfo))
+	groupNode.Position.Set([]float64{7, 8, 9})
+	spaceSim.RootNode.getNodeUpdates()
+	AssertEqual(t, apiDB.ErrSpaceStateReplaced, spaceSim.SaveState())
+	AssertNil(t, spaceSim.reloadState())
+	AssertEqual(t, 1, len(spaceSim.RootNode.Nodes))
+	AssertEqual(t, "Replaced Space", spaceSim.RootNode.SettingValue("name"))
+	AssertNil(t, spaceSim.SaveState())
+	nodeRecords, err = apiDB.FindSpaceNodeRecords(spaceRecord.UUID, dbInfo)
+	AssertNil(t, err)
+	AssertEqual(t, 2, len(nodeRecords))
+
+	// Before its first save, a simulator notices the State blob changing
+	AssertNil(t, apiDB.UpdateSpaceState(spaceRecord.UUID, replacement.ToString(), dbInfo))
+	spaceSim3, err := NewSpaceSimulator(spaceRecord.UUID, nil, dbInfo)
+	AssertNil(t, err)
+	replacement.Settings["name"] = "Replaced Again"
+	AssertNil(t, apiDB.UpdateSpaceState(spaceRecord.UUID, replacement.ToString(), dbInfo))
+	AssertEqual(t, apiDB.ErrSpaceStateReplaced, spaceSim3.SaveState())
+}
+
 func createSpace(avatarUUID string, dbInfo *be.DBInfo) (*apiDB.SpaceRecord, error) {
 	templateRecord0, _ := apiDB.CreateTemplateRecord("Template 0", "bogus0.obj", "", "", "", "", dbInfo)
 	templateRecord1, _ := apiDB.CreateTemplateRecord("Template 1", "bogus1.obj", "", "", "", "", dbInfo)
@@ -85,7 +164,7 @@ func createSpace(avatarUUID string, dbInfo *be.DBInfo) (*apiDB.SpaceRecord, erro
 	groupNode := apiDB.NewSpaceStateNode(position, orientation, translation, rotation, scale, "")
 	rootNode.Nodes = append(rootNode.Nodes, groupNode)
 	groupNode.Nodes = append(groupNode.Nodes, apiDB.NewSpaceStateNode(position, orientation, translation, rotation, scale, templateRecord1.UUID))
-	return apiDB.CreateSpaceRecord("Space 0", rootNode.ToString(), avatarUUID, dbInfo)
+	return apiDB.CreateSpaceRecord("Space 0", rootNode.ToString(), avatarUUID, "", dbInfo)
 }
 
 func createTestCluster(dbInfo *be.DBInfo) (*ws.WSService, *SimHostService, error) {
@@ -6,14 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strconv"
+	"sync"
 	"time"
 
 	apiDB "spaciblo.org/api/db"
 	"spaciblo.org/be"
 )
 
-const TICK_DURATION = time.Millisecond * 100 // 10 ticks per second
-const TICKS_BETWEEN_SAVES = 30 * 10          // Save space state to the DB after this many ticks
+const TICK_DURATION = time.Millisecond * 100  // 10 ticks per second
+const TICKS_BETWEEN_SAVES = 30 * 10           // Save space state to the DB after this many ticks
+const SAVE_REQUEST_TIMEOUT = time.Second * 10 // How long RequestSave waits for the simulator to save
 
 const REMOVE_KEY_INDICATOR = "_r_e_m_o_v_e_"
 
@@ -40,13 +43,17 @@ type SpaceSimulator struct {
 	DefaultAvatarUUID string
 	SimHostServer     *SimHostServer
 	DBInfo            *be.DBInfo
-	TicksSinceSaved   int64 // The number of ticks since the state was last saved to the SpaceRecord
+	TicksSinceSaved   int64      // The number of ticks since the state was last saved to the SpaceRecord
+	UnsavedDeletions  []int64    // SpaceNodeRecord Ids of nodes removed since the last save
+	loadedStateBlob   string     // The SpaceRecord.State that was loaded, to notice if it is replaced before the first save
+	tickLock          sync.Mutex // Held during each Tick and by RequestSave when it saves without ticking, so the scene is not saved while it changes
 
 	ClientMembershipChannel chan *ClientMembershipNotice
 	AvatarMotionChannel     chan *AvatarMotionNotice
 	AddNodeChannel          chan *AddNodeNotice
 	RemoveNodeChannel       chan *RemoveNodeNotice
 	NodeUpdateChannel       chan *NodeUpdateNotice
+	SaveRequestChannel      chan chan error // Each requester receives the result of the save on its channel
 }
 
 func NewSpaceSimulator(spaceUUID string, simHostServer *SimHostServer, dbInfo *be.DBInfo) (*SpaceSimulator, error) {
@@ -54,7 +61,7 @@ func NewSpaceSimulator(spaceUUID string, simHostServer *SimHostServer, dbInfo *b
 	if err != nil {
 		return nil, err
 	}
-	state, err := spaceRecord.DecodeState()
+	state, err := spaceRecord.LoadState(dbInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +88,8 @@ func NewSpaceSimulator(spaceUUID string, simHostServer *SimHostServer, dbInfo *b
 		Clients:           make(map[string]*ClientInfo),
 		Additions:         []*SceneAddition{},
 		Deletions:         []int64{},
+		UnsavedDeletions:  []int64{},
+		loadedStateBlob:   spaceRecord.State,
 		DefaultAvatarUUID: avatarRecord.UUID,
 		SimHostServer:     simHostServer,
 		DBInfo:            dbInfo,
@@ -90,6 +99,7 @@ func NewSpaceSimulator(spaceUUID string, simHostServer *SimHostServer, dbInfo *b
 		AddNodeChannel:          make(chan *AddNodeNotice, 1024),
 		RemoveNodeChannel:       make(chan *RemoveNodeNotice, 1024),
 		NodeUpdateChannel:       make(chan *NodeUpdateNotice, 1024),
+		SaveRequestChannel:      make(chan chan error, 1024),
 	}, nil
 }
 
@@ -97,6 +107,8 @@ func NewSpaceSimulator(spaceUUID string, simHostServer *SimHostServer, dbInfo *b
 StartTime starts a go routine that loops until SpaceSimulator.Running is false, ticking every TICK_DURATION
 */
 func (spaceSim *SpaceSimulator) StartTime() {
+	spaceSim.tickLock.Lock()
+	defer spaceSim.tickLock.Unlock()
 	if spaceSim.Running {
 		return
 	}
@@ -118,6 +130,8 @@ Tick is where the SpaceSimulator actually simulates time passing by:
 - calculates physical interaction (TODO)
 */
 func (spaceSim *SpaceSimulator) Tick(delta time.Duration) {
+	spaceSim.tickLock.Lock()
+	defer spaceSim.tickLock.Unlock()
 	membershipNotices := spaceSim.collectMembershipNotices()
 	for _, notice := range membershipNotices {
 		// TODO compress duplicate membership notices
@@ -203,6 +217,9 @@ func (spaceSim *SpaceSimulator) Tick(delta time.Duration) {
 		node.Rotation.Set(notice.Rotation)
 		node.Scale.Set(notice.Scale)
 		if notice.TemplateUUID != "" && notice.TemplateUUID != node.TemplateUUID.Value {
+			if nodeClientUUID == "" {
+				spaceSim.audit(clientInfo.User, "node-template", map[string]interface{}{"id": node.Id, "templateUUID": node.TemplateUUID.Value}, map[string]interface{}{"id": node.Id, "templateUUID": notice.TemplateUUID})
+			}
 			node.TemplateUUID.Value = notice.TemplateUUID // May be REMOVE_KEY_INDICATOR
 			node.TemplateUUID.Dirty = true
 		}
@@ -248,6 +265,7 @@ func (spaceSim *SpaceSimulator) Tick(delta time.Duration) {
 		}
 		parentNode.Add(childNode)
 		spaceSim.Additions = append(spaceSim.Additions, &SceneAddition{childNode, parentNode.Id})
+		spaceSim.audit(clientInfo.User, "node-add", nil, map[string]interface{}{"id": childNode.Id, "parent": parentNode.Id, "settings": notice.Settings})
 	}
 
 	removeNodeNotices := spaceSim.collectRemoveNodeNotices()
@@ -271,7 +289,9 @@ func (spaceSim *SpaceSimulator) Tick(delta time.Duration) {
 			continue
 		}
 		spaceSim.Deletions = append(spaceSim.Deletions, node.Id)
+		spaceSim.UnsavedDeletions = append(spaceSim.UnsavedDeletions, node.getRecordIds()...)
 		parent.Remove(node)
+		spaceSim.audit(clientInfo.User, "node-remove", map[string]interface{}{"id": node.Id, "parent": parent.Id, "templateUUID": node.TemplateUUID.Value}, nil)
 	}
 
 	// Send new client clients full initialization updates
@@ -300,17 +320,158 @@ func (spaceSim *SpaceSimulator) Tick(delta time.Duration) {
 	spaceSim.Frame = (spaceSim.Frame + 1) % math.MaxInt64
 
 	spaceSim.TicksSinceSaved += 1
-	if spaceSim.TicksSinceSaved > TICKS_BETWEEN_SAVES {
+	saveRequests := spaceSim.collectSaveRequests()
+	if spaceSim.TicksSinceSaved > TICKS_BETWEEN_SAVES || len(saveRequests) > 0 {
 		spaceSim.TicksSinceSaved = 0
 		err = spaceSim.SaveState()
+		if err == apiDB.ErrSpaceStateReplaced {
+			logger.Println("The stored state was replaced, reloading it", spaceSim.UUID)
+			err = spaceSim.reloadState()
+		}
 		if err != nil {
 			logger.Println("Could not save state", err)
 		}
+		for _, resultChannel := range saveRequests {
+			resultChannel <- err
+		}
 	}
 }
 
+/*
+audit records a change to the space in the background so that the tick is not slowed by the database
+*/
+func (spaceSim *SpaceSimulator) audit(user *be.User, action string, before interface{}, after interface{}) {
+	go func() {
+		_, err := be.RecordAuditEvent(user, "", action, "space", spaceSim.UUID, before, after, spaceSim.DBInfo)
+		if err != nil {
+			logger.Println("Could not record the audit event", err)
+		}
+	}()
+}
+
+/*
+RequestSave asks the simulator to save its state during the next tick and waits until that save is done
+If the simulator is not ticking it saves immediately, holding the tick lock in case ticking starts during the save.
+*/
+func (spaceSim *SpaceSimulator) RequestSave(timeout time.Duration) error {
+	spaceSim.tickLock.Lock()
+	if spaceSim.Running == false {
+		defer spaceSim.tickLock.Unlock()
+		return spaceSim.SaveState()
+	}
+	spaceSim.tickLock.Unlock()
+	resultChannel := make(chan error, 1)
+	spaceSim.SaveRequestChannel <- resultChannel
+	select {
+	case err := <-resultChannel:
+		return err
+	case <-time.After(timeout):
+		return errors.New("Timed out waiting for the simulator to save")
+	}
+}
+
+/*
+SaveState writes the nodes that were added, changed, or removed since the last save to the space's SpaceNodeRecords
+Nodes that are unchanged since the last save are not written.
+Returns apiDB.ErrSpaceStateReplaced without saving if the stored state was replaced since it was loaded, in which case call reloadState.
+*/
 func (spaceSim *SpaceSimulator) SaveState() error {
-	return apiDB.UpdateSpaceState(spaceSim.UUID, spaceSim.RootNode.toSpaceStateNode().ToString(), spaceSim.DBInfo)
+	changes, err := apiDB.BeginSpaceNodeChanges(spaceSim.UUID, spaceSim.DBInfo)
+	if err != nil {
+		return err
+	}
+	inserted := []*SceneNode{}
+	err = spaceSim.checkStateNotReplaced(changes)
+	if err == nil {
+		err = spaceSim.RootNode.saveChanges(0, changes, &inserted)
+	}
+	if err == nil {
+		for _, recordId := range spaceSim.UnsavedDeletions {
+			err = changes.Delete(recordId)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = changes.Commit()
+	} else {
+		changes.Rollback()
+	}
+	if err != nil {
+		// The inserts were rolled back so those nodes need to be inserted during the next save
+		for _, node := range inserted {
+			node.RecordId = 0
+		}
+		return err
+	}
+	spaceSim.UnsavedDeletions = []int64{}
+	spaceSim.RootNode.setSaved()
+	return nil
+}
+
+/*
+checkStateNotReplaced returns apiDB.ErrSpaceStateReplaced if the root node's record is gone or, before the first save, the State blob changed
+changes holds the space's row lock, so the answer stays true until changes is committed.
+*/
+func (spaceSim *SpaceSimulator) checkStateNotReplaced(changes *apiDB.SpaceNodeChanges) error {
+	if spaceSim.RootNode.RecordId != 0 {
+		exists, err := changes.HasRecord(spaceSim.RootNode.RecordId)
+		if err != nil {
+			return err
+		}
+		if exists == false {
+			return apiDB.ErrSpaceStateReplaced
+		}
+		return nil
+	}
+	stateBlob, err := changes.StateBlob()
+	if err != nil {
+		return err
+	}
+	if stateBlob != spaceSim.loadedStateBlob {
+		return apiDB.ErrSpaceStateReplaced
+	}
+	return nil
+}
+
+/*
+reloadState replaces the simulated scene with the space's stored state, keeping the avatars
+Clients are sent the deletion of the old nodes and the addition of the new ones during the next tick.
+Changes that were not saved before the state was replaced are dropped.
+*/
+func (spaceSim *SpaceSimulator) reloadState() error {
+	spaceRecord, err := apiDB.FindSpaceRecord(spaceSim.UUID, spaceSim.DBInfo)
+	if err != nil {
+		return err
+	}
+	state, err := spaceRecord.LoadState(spaceSim.DBInfo)
+	if err != nil {
+		return err
+	}
+	loadedRoot, err := NewRootNode(state, spaceSim.DBInfo)
+	if err != nil {
+		return err
+	}
+	for _, child := range append([]*SceneNode{}, spaceSim.RootNode.Nodes...) {
+		if child.Transient {
+			continue
+		}
+		spaceSim.Deletions = append(spaceSim.Deletions, child.Id)
+		spaceSim.RootNode.Remove(child)
+	}
+	for key, value := range state.Settings {
+		spaceSim.RootNode.SetOrCreateSetting(key, value)
+	}
+	spaceSim.RootNode.RecordId = loadedRoot.RecordId
+	for _, child := range loadedRoot.Nodes {
+		child.SetClean(true)
+		spaceSim.RootNode.Add(child)
+		spaceSim.Additions = append(spaceSim.Additions, spaceSim.additionsForSceneNode(child, spaceSim.RootNode)...)
+	}
+	spaceSim.UnsavedDeletions = []int64{}
+	spaceSim.loadedStateBlob = spaceRecord.State
+	return nil
 }
 
 func (spaceSim *SpaceSimulator) GetClientUUIDs() []string {
@@ -369,6 +530,18 @@ func (spaceSim *SpaceSimulator) collectRemoveNodeNotices() []*RemoveNodeNotice {
 	}
 }
 
+func (spaceSim *SpaceSimulator) collectSaveRequests() []chan error {
+	results := []chan error{}
+	for {
+		select {
+		case item := <-spaceSim.SaveRequestChannel:
+			results = append(results, item)
+		default:
+			return results
+		}
+	}
+}
+
 func (spaceSim *SpaceSimulator) collectNodeUpdateNotices() []*NodeUpdateNotice {
 	results := []*NodeUpdateNotice{}
 	for {
@@ -580,6 +753,10 @@ func (spaceSim *SpaceSimulator) createClientInfo(clientUUID string, userUUID str
 				scale = []float64{1, 1, 1}
 			}
 			partNode := NewBodyPartSceneNode(partRecord.Part, templateUUID, position, orientation, scale)
+			if templateUUID != "" && partRecord.TemplateRevision != 0 {
+				// Clients load the pinned revision instead of the template's current revision
+				partNode.Settings[apiDB.TemplateRevisionSetting] = NewStringTuple(apiDB.TemplateRevisionSetting, strconv.FormatInt(partRecord.TemplateRevision, 10))
+			}
 			if partRecord.Parent != "" {
 				parentNode, ok := partMap[partRecord.Parent]
 				if ok == false {
@@ -617,6 +794,7 @@ func (spaceSim *SpaceSimulator) removeClient(clientUUID string) {
 func NewRootNode(initialState *apiDB.SpaceStateNode, dbInfo *be.DBInfo) (*SceneNode, error) {
 	rootNode := &SceneNode{
 		Id:           nextSceneId(),
+		RecordId:     initialState.RecordId,
 		Settings:     make(map[string]*StringTuple),
 		TemplateUUID: NewStringField(""),
 		Position:     NewVector3([]float64{0, 0, 0}),
@@ -667,7 +845,9 @@ type SceneNode struct {
 	TemplateUUID *StringField
 	Leader       *Int64Field
 	Nodes        []*SceneNode
-	Transient    bool // True if ignored when serializing to a SpaceStateNode (e.g. this is an Avatar node)
+	Transient    bool  // True if ignored when serializing to a SpaceStateNode (e.g. this is an Avatar node)
+	RecordId     int64 // The Id of the SpaceNodeRecord that stores this node, or 0 if it has not been saved
+	Unsaved      bool  // True if the node has changed since it was last saved
 }
 
 func NewBodyPartSceneNode(name string, templateUUID string, position []float64, orientation []float64, scale []float64) *SceneNode {
@@ -704,6 +884,7 @@ func NewSceneNode(stateNode *apiDB.SpaceStateNode, leader int64, dbInfo *be.DBIn
 	}
 	sceneNode := &SceneNode{
 		Id:           nextSceneId(),
+		RecordId:     stateNode.RecordId,
 		Settings:     make(map[string]*StringTuple),
 		TemplateUUID: NewStringField(""),
 		Position:     NewVector3(stateNode.Position),
@@ -754,26 +935,102 @@ func (node *SceneNode) getClientUUID() string {
 }
 
 func (node *SceneNode) toSpaceStateNode() *apiDB.SpaceStateNode {
-	stateNode := apiDB.NewSpaceStateNode(node.Position.Data, node.Orientation.Data, node.Translation.Data, node.Rotation.Data, node.Scale.Data, node.TemplateUUID.Value)
+	stateNode := node.toChildlessSpaceStateNode()
+	for _, child := range node.Nodes {
+		if child.Transient {
+			continue
+		}
+		stateNode.Nodes = append(stateNode.Nodes, child.toSpaceStateNode())
+	}
+	return stateNode
+}
+
+/*
+toChildlessSpaceStateNode returns a SpaceStateNode with this node's values but none of its children
+*/
+func (node *SceneNode) toChildlessSpaceStateNode() *apiDB.SpaceStateNode {
+	templateUUID := node.TemplateUUID.Value
+	if templateUUID == REMOVE_KEY_INDICATOR {
+		templateUUID = ""
+	}
+	stateNode := apiDB.NewSpaceStateNode(node.Position.Data, node.Orientation.Data, node.Translation.Data, node.Rotation.Data, node.Scale.Data, templateUUID)
+	stateNode.RecordId = node.RecordId
 	for _, setting := range node.Settings {
+		if setting.Value == REMOVE_KEY_INDICATOR {
+			continue
+		}
 		stateNode.Settings[setting.Key] = setting.Value
 	}
+	return stateNode
+}
+
+/*
+saveChanges inserts or updates the SpaceNodeRecords for this node and its non-transient descendants if they are new or unsaved
+Parents are saved before their children so that the children's records can refer to the parent record's Id.
+Newly inserted nodes are appended to inserted so that their RecordIds can be cleared if the changes are rolled back.
+*/
+func (node *SceneNode) saveChanges(parentRecordId int64, changes *apiDB.SpaceNodeChanges, inserted *[]*SceneNode) error {
+	if node.RecordId == 0 {
+		record := apiDB.NewSpaceNodeRecord(changes.SpaceUUID, parentRecordId, node.toChildlessSpaceStateNode())
+		err := changes.Insert(record)
+		if err != nil {
+			return err
+		}
+		node.RecordId = record.Id
+		*inserted = append(*inserted, node)
+	} else if node.Unsaved {
+		record := apiDB.NewSpaceNodeRecord(changes.SpaceUUID, parentRecordId, node.toChildlessSpaceStateNode())
+		err := changes.Update(record)
+		if err != nil {
+			return err
+		}
+	}
 	for _, child := range node.Nodes {
 		if child.Transient {
 			continue
 		}
-		stateNode.Nodes = append(stateNode.Nodes, child.toSpaceStateNode())
+		err := child.saveChanges(node.RecordId, changes, inserted)
+		if err != nil {
+			return err
+		}
 	}
-	return stateNode
+	return nil
+}
+
+// setSaved clears the Unsaved flag on this node and its non-transient descendants
+func (node *SceneNode) setSaved() {
+	node.Unsaved = false
+	for _, child := range node.Nodes {
+		if child.Transient {
+			continue
+		}
+		child.setSaved()
+	}
+}
+
+// getRecordIds returns the SpaceNodeRecord Ids of this node and its descendants that have been saved
+func (node *SceneNode) getRecordIds() []int64 {
+	results := []int64{}
+	if node.Transient {
+		return results
+	}
+	if node.RecordId != 0 {
+		results = append(results, node.RecordId)
+	}
+	for _, child := range node.Nodes {
+		results = append(results, child.getRecordIds()...)
+	}
+	return results
 }
 
 /*
 getNodeUpdates lists each NodeUpdate in the hierarchy starting at this SceneNode iff they are dirty
-This has the side effects of setting them clean and removing each Settings tuple with a Value of REMOVE_KEY_INDICATOR
+This has the side effects of setting them clean, marking them Unsaved, and removing each Settings tuple with a Value of REMOVE_KEY_INDICATOR
 */
 func (node *SceneNode) getNodeUpdates() []*NodeUpdate {
 	result := []*NodeUpdate{}
 	if node.isDirty() {
+		node.Unsaved = true
 		update := &NodeUpdate{
 			Id:           node.Id,
 			Settings:     []*StringTuple{},
@@ -0,0 +1,257 @@
+/*
+Package client is a headless Go client for the ws service.
+It connects over WebSocket, joins a space, keeps a local mirror of the space's scene graph, and sends avatar motion and node update requests.
+It is used by tools like the load tester and by integration tests that need to act like a browser.
+*/
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"spaciblo.org/be"
+	"spaciblo.org/ws"
+)
+
+var logger = log.New(os.Stdout, "[ws-client] ", 0)
+
+/*
+Client connects to the ws service and mirrors the scene of the space it joins
+Set the On* callbacks before calling Connect. They are called from the read go routine, so they should not block for long.
+*/
+type Client struct {
+	URL               string // A fully qualified WebSocket URL like wss://127.0.0.1:9020/ws
+	Session           string // Optional session cookie value, as set by be.Client.Authenticate
+	SessionCookieName string
+	SpaceUUID         string // Set by JoinSpace
+	Scene             *Scene
+
+	OnConnected   func(clientUUID string)
+	OnSpaceUpdate func(update *ws.SpaceUpdateMessage)
+	OnAck         func(message string)
+	OnMessage     func(message ws.ClientMessage) // Called for every parsed message, after the more specific callbacks
+	OnClose       func(err error)
+
+	bytesSent     int64 // Accessed atomically
+	bytesReceived int64 // Accessed atomically
+	conn          *websocket.Conn
+	writeLock     sync.Mutex
+	connected     chan bool // Closed when the Connected message arrives
+	connectedOnce sync.Once
+	stateLock     sync.Mutex // Guards clientUUID, which the read go routine sets
+	clientUUID    string     // Set when the Connected message arrives
+}
+
+/*
+NewClient creates a client for the ws service
+url: a fully qualified WebSocket URL like wss://127.0.0.1:9020/ws
+session: the value of a be session cookie, or "" to connect anonymously
+*/
+func NewClient(url string, session string) *Client {
+	return &Client{
+		URL:               url,
+		Session:           session,
+		SessionCookieName: be.AuthCookieName,
+		Scene:             NewScene(),
+		connected:         make(chan bool),
+	}
+}
+
+/*
+Connect opens the WebSocket and starts reading messages in a separate go routine
+*/
+func (client *Client) Connect() error {
+	if client.conn != nil {
+		return errors.New("Client is already connected")
+	}
+	dialer := &websocket.Dialer{
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+		HandshakeTimeout: 10 * time.Second,
+	}
+	header := http.Header{}
+	if client.Session != "" {
+		cookie := &http.Cookie{
+			Name:  client.SessionCookieName,
+			Value: client.Session,
+		}
+		header.Add("Cookie", cookie.String())
+	}
+	conn, _, err := dialer.Dial(client.URL, header)
+	if err != nil {
+		return err
+	}
+	client.conn = conn
+	go client.readLoop()
+	return nil
+}
+
+/*
+WaitForConnected blocks until the ws service sends the Connected message or the timeout passes
+*/
+func (client *Client) WaitForConnected(timeout time.Duration) error {
+	select {
+	case <-client.connected:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("Timed out waiting for the Connected message")
+	}
+}
+
+func (client *Client) readLoop() {
+	for {
+		_, data, err := client.conn.ReadMessage()
+		if err != nil {
+			if client.OnClose != nil {
+				client.OnClose(err)
+			}
+			return
+		}
+		atomic.AddInt64(&client.bytesReceived, int64(len(data)))
+		message, err := ws.ParseMessageJson(string(data))
+		if err != nil {
+			logger.Println("Could not parse message", err, string(data))
+			continue
+		}
+		client.handleMessage(message)
+	}
+}
+
+func (client *Client) handleMessage(message ws.ClientMessage) {
+	switch typedMessage := message.(type) {
+	case *ws.ConnectedMessage:
+		client.stateLock.Lock()
+		client.clientUUID = typedMessage.ClientUUID
+		client.stateLock.Unlock()
+		client.connectedOnce.Do(func() {
+			close(client.connected)
+		})
+		if client.OnConnected != nil {
+			client.OnConnected(typedMessage.ClientUUID)
+		}
+	case *ws.SpaceUpdateMessage:
+		client.Scene.Apply(typedMessage)
+		if client.OnSpaceUpdate != nil {
+			client.OnSpaceUpdate(typedMessage)
+		}
+	case *ws.AckMessage:
+		if client.OnAck != nil {
+			client.OnAck(typedMessage.Message)
+		}
+	case *ws.UnknownMessageTypeMessage:
+		logger.Println("The ws service did not recognize a message type", typedMessage.UnknownType)
+	}
+	if client.OnMessage != nil {
+		client.OnMessage(message)
+	}
+}
+
+/*
+Send marshals a message to JSON and writes it to the WebSocket
+*/
+func (client *Client) Send(message ws.ClientMessage) error {
+	if client.conn == nil {
+		return errors.New("Client is not connected")
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	err = client.conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&client.bytesSent, int64(len(data)))
+	return nil
+}
+
+// BytesSent returns the total size of the message payloads written to the WebSocket
+func (client *Client) BytesSent() int64 {
+	return atomic.LoadInt64(&client.bytesSent)
+}
+
+// BytesReceived returns the total size of the message payloads read from the WebSocket
+func (client *Client) BytesReceived() int64 {
+	return atomic.LoadInt64(&client.bytesReceived)
+}
+
+func (client *Client) Ping(message string) error {
+	return client.Send(ws.NewPingMessage(message))
+}
+
+/*
+JoinSpace asks the ws service to route the space's updates to this client
+If avatar is true the sim will add an avatar node for this client
+*/
+func (client *Client) JoinSpace(spaceUUID string, avatar bool) error {
+	client.SpaceUUID = spaceUUID
+	return client.Send(ws.NewJoinSpaceMessage(spaceUUID, avatar))
+}
+
+/*
+SendAvatarMotion sends the position and motion of this client's avatar and (optionally) its body parts
+*/
+func (client *Client) SendAvatarMotion(position []float64, orientation []float64, translation []float64, rotation []float64, scale []float64, bodyUpdates []ws.BodyUpdateMessage) error {
+	if client.SpaceUUID == "" {
+		return errors.New("Can not send avatar motion before joining a space")
+	}
+	message := ws.NewAvatarMotionMessage(client.SpaceUUID, position, orientation, translation, rotation, scale)
+	if bodyUpdates != nil {
+		message.BodyUpdates = bodyUpdates
+	}
+	return client.Send(message)
+}
+
+/*
+SendUpdateRequest asks the sim to change nodes in the joined space
+*/
+func (client *Client) SendUpdateRequest(nodeUpdates []*ws.NodeUpdateMessage) error {
+	if client.SpaceUUID == "" {
+		return errors.New("Can not send an update request before joining a space")
+	}
+	return client.Send(&ws.UpdateRequestMessage{
+		TypedMessage:       ws.TypedMessage{Type: ws.UpdateRequestType},
+		SpaceUUID:          client.SpaceUUID,
+		NodeUpdateMessages: nodeUpdates,
+	})
+}
+
+/*
+ClientUUID returns the UUID that the ws service assigned to this client, or "" until the Connected message arrives
+*/
+func (client *Client) ClientUUID() string {
+	client.stateLock.Lock()
+	defer client.stateLock.Unlock()
+	return client.clientUUID
+}
+
+/*
+AvatarNode returns the scene node of this client's avatar, or nil if the sim has not yet added it
+*/
+func (client *Client) AvatarNode() *Node {
+	clientUUID := client.ClientUUID()
+	if clientUUID == "" {
+		return nil
+	}
+	return client.Scene.FindBySetting("clientUUID", clientUUID)
+}
+
+func (client *Client) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return client.conn.Close()
+}
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"spaciblo.org/ws"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestClientUUID(t *testing.T) {
+	client := NewClient("wss://127.0.0.1:9020/ws", "")
+	AssertEqual(t, "", client.ClientUUID())
+	AssertTrue(t, client.AvatarNode() == nil)
+
+	// The read go routine sets the UUID while others read it
+	done := make(chan bool)
+	go func() {
+		client.handleMessage(&ws.ConnectedMessage{ClientUUID: "client-1"})
+		close(done)
+	}()
+	client.AvatarNode()
+	<-done
+	AssertNil(t, client.WaitForConnected(time.Second))
+	AssertEqual(t, "client-1", client.ClientUUID())
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"sync"
+
+	"spaciblo.org/sim"
+	"spaciblo.org/ws"
+)
+
+/*
+Node is the client side mirror of a sim SceneNode, built from the additions and updates in Space-Update messages
+*/
+type Node struct {
+	Id           int64
+	Parent       *Node
+	Settings     map[string]string
+	Position     []float64
+	Orientation  []float64
+	Translation  []float64
+	Rotation     []float64
+	Scale        []float64
+	TemplateUUID string
+	Leader       int64
+	Nodes        []*Node
+}
+
+func (node *Node) SettingValue(name string) string {
+	value, ok := node.Settings[name]
+	if ok == false {
+		return ""
+	}
+	return value
+}
+
+func (node *Node) add(childNode *Node) {
+	node.Nodes = append(node.Nodes, childNode)
+	childNode.Parent = node
+}
+
+func (node *Node) remove(childNode *Node) {
+	results := []*Node{}
+	for _, n := range node.Nodes {
+		if n.Id == childNode.Id {
+			n.Parent = nil
+		} else {
+			results = append(results, n)
+		}
+	}
+	node.Nodes = results
+}
+
+/*
+Scene holds a local copy of a space's scene graph
+It is safe to use from multiple go routines, but the Nodes it returns should be treated as read-only
+*/
+type Scene struct {
+	root      *Node           // nil until the addition with no parent arrives
+	frame     int64           // The frame of the last applied update
+	nodes     map[int64]*Node // <node id, node>
+	nodesLock sync.RWMutex
+}
+
+func NewScene() *Scene {
+	return &Scene{
+		root:  nil,
+		frame: -1,
+		nodes: make(map[int64]*Node),
+	}
+}
+
+/*
+Apply updates the scene graph using the additions, deletions, and node updates in a Space-Update message.
+Additions are applied first, then deletions, then updates, which is the order in which the sim produces them.
+*/
+func (scene *Scene) Apply(update *ws.SpaceUpdateMessage) {
+	scene.nodesLock.Lock()
+	defer scene.nodesLock.Unlock()
+
+	for _, addition := range update.Additions {
+		scene.applyAddition(addition)
+	}
+	for _, id := range update.Deletions {
+		scene.applyDeletion(id)
+	}
+	for _, nodeUpdate := range update.NodeUpdates {
+		scene.applyNodeUpdate(nodeUpdate)
+	}
+	scene.frame = update.Frame
+}
+
+func (scene *Scene) applyAddition(addition *ws.AdditionMessage) {
+	node, ok := scene.nodes[addition.Id]
+	if ok == false {
+		node = &Node{
+			Id:       addition.Id,
+			Settings: make(map[string]string),
+			Nodes:    []*Node{},
+		}
+	} else if node.Parent != nil {
+		// The initial additions sent when joining may repeat nodes we already know about
+		node.Parent.remove(node)
+	}
+	for key, value := range addition.Settings {
+		node.Settings[key] = value
+	}
+	node.Position = addition.Position
+	node.Orientation = addition.Orientation
+	node.Translation = addition.Translation
+	node.Rotation = addition.Rotation
+	node.Scale = addition.Scale
+	node.TemplateUUID = addition.TemplateUUID
+	node.Leader = addition.Leader
+	scene.nodes[node.Id] = node
+
+	if addition.Parent == -1 {
+		scene.root = node
+		return
+	}
+	parent, ok := scene.nodes[addition.Parent]
+	if ok == false {
+		logger.Println("Received an addition for an unknown parent", addition.Id, addition.Parent)
+		return
+	}
+	parent.add(node)
+}
+
+func (scene *Scene) applyDeletion(id int64) {
+	node, ok := scene.nodes[id]
+	if ok == false {
+		return
+	}
+	if node.Parent != nil {
+		node.Parent.remove(node)
+	}
+	if scene.root == node {
+		scene.root = nil
+	}
+	scene.forget(node)
+}
+
+// forget removes the node and its descendants from the id map
+func (scene *Scene) forget(node *Node) {
+	delete(scene.nodes, node.Id)
+	for _, child := range node.Nodes {
+		scene.forget(child)
+	}
+}
+
+func (scene *Scene) applyNodeUpdate(update *ws.NodeUpdateMessage) {
+	node, ok := scene.nodes[update.Id]
+	if ok == false {
+		logger.Println("Received an update for an unknown node", update.Id)
+		return
+	}
+	for key, value := range update.Settings {
+		if value == sim.REMOVE_KEY_INDICATOR {
+			delete(node.Settings, key)
+		} else {
+			node.Settings[key] = value
+		}
+	}
+	// The sim sends empty arrays for values that have not changed
+	if len(update.Position) == 3 {
+		node.Position = update.Position
+	}
+	if len(update.Orientation) == 4 {
+		node.Orientation = update.Orientation
+	}
+	if len(update.Translation) == 3 {
+		node.Translation = update.Translation
+	}
+	if len(update.Rotation) == 3 {
+		node.Rotation = update.Rotation
+	}
+	if len(update.Scale) == 3 {
+		node.Scale = update.Scale
+	}
+	if update.TemplateUUID == sim.REMOVE_KEY_INDICATOR {
+		node.TemplateUUID = ""
+	} else if update.TemplateUUID != "" {
+		node.TemplateUUID = update.TemplateUUID
+	}
+	node.Leader = update.Leader
+}
+
+/*
+Root returns the root node of the space, or nil if the initial additions have not yet arrived
+*/
+func (scene *Scene) Root() *Node {
+	scene.nodesLock.RLock()
+	defer scene.nodesLock.RUnlock()
+	return scene.root
+}
+
+/*
+Frame returns the sim frame of the last applied Space-Update, or -1 if none have been applied
+*/
+func (scene *Scene) Frame() int64 {
+	scene.nodesLock.RLock()
+	defer scene.nodesLock.RUnlock()
+	return scene.frame
+}
+
+/*
+Find returns the node with the given id, or nil if it is not in the scene
+*/
+func (scene *Scene) Find(id int64) *Node {
+	scene.nodesLock.RLock()
+	defer scene.nodesLock.RUnlock()
+	node, ok := scene.nodes[id]
+	if ok == false {
+		return nil
+	}
+	return node
+}
+
+/*
+FindBySetting returns the first node with a setting that matches name and value, or nil if there is none
+*/
+func (scene *Scene) FindBySetting(name string, value string) *Node {
+	scene.nodesLock.RLock()
+	defer scene.nodesLock.RUnlock()
+	for _, node := range scene.nodes {
+		if node.SettingValue(name) == value {
+			return node
+		}
+	}
+	return nil
+}
+
+/*
+Count returns the number of nodes in the scene, including the root node
+*/
+func (scene *Scene) Count() int {
+	scene.nodesLock.RLock()
+	defer scene.nodesLock.RUnlock()
+	return len(scene.nodes)
+}
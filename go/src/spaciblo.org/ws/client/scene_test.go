@@ -0,0 +1,97 @@
+package client
+
+import (
+	"testing"
+
+	"spaciblo.org/sim"
+	"spaciblo.org/ws"
+
+	. "github.com/chai2010/assert"
+)
+
+func TestSceneApply(t *testing.T) {
+	scene := NewScene()
+	AssertTrue(t, scene.Root() == nil)
+	AssertEqual(t, int64(-1), scene.Frame())
+
+	update := ws.NewSpaceUpdateMessage("space-1", 1)
+	update.Additions = append(update.Additions, &ws.AdditionMessage{
+		Id:       1,
+		Parent:   -1,
+		Settings: map[string]string{"name": "root"},
+		Position: []float64{0, 0, 0},
+	})
+	update.Additions = append(update.Additions, &ws.AdditionMessage{
+		Id:           2,
+		Parent:       1,
+		Settings:     map[string]string{"clientUUID": "client-1"},
+		Position:     []float64{1, 2, 3},
+		TemplateUUID: "template-1",
+	})
+	update.Additions = append(update.Additions, &ws.AdditionMessage{
+		Id:       3,
+		Parent:   2,
+		Settings: map[string]string{"name": "head"},
+	})
+	scene.Apply(update)
+	AssertEqual(t, int64(1), scene.Frame())
+	AssertEqual(t, 3, scene.Count())
+	AssertTrue(t, scene.Root() != nil)
+	AssertEqual(t, int64(1), scene.Root().Id)
+	AssertEqual(t, 1, len(scene.Root().Nodes))
+	avatar := scene.FindBySetting("clientUUID", "client-1")
+	AssertTrue(t, avatar != nil)
+	AssertEqual(t, int64(2), avatar.Id)
+	AssertEqual(t, scene.Root(), avatar.Parent)
+	AssertEqual(t, "template-1", avatar.TemplateUUID)
+
+	// Empty vectors and template UUIDs in updates mean that the values did not change
+	update = ws.NewSpaceUpdateMessage("space-1", 2)
+	update.NodeUpdates = append(update.NodeUpdates, &ws.NodeUpdateMessage{
+		Id:          2,
+		Settings:    map[string]string{"clientUUID": sim.REMOVE_KEY_INDICATOR, "name": "avatar"},
+		Position:    []float64{},
+		Orientation: []float64{0, 1, 0, 0},
+	})
+	scene.Apply(update)
+	avatar = scene.Find(2)
+	AssertEqual(t, 3.0, avatar.Position[2])
+	AssertEqual(t, 1.0, avatar.Orientation[1])
+	AssertEqual(t, "", avatar.SettingValue("clientUUID"))
+	AssertEqual(t, "avatar", avatar.SettingValue("name"))
+	AssertEqual(t, "template-1", avatar.TemplateUUID)
+
+	update = ws.NewSpaceUpdateMessage("space-1", 3)
+	update.NodeUpdates = append(update.NodeUpdates, &ws.NodeUpdateMessage{
+		Id:           2,
+		TemplateUUID: sim.REMOVE_KEY_INDICATOR,
+	})
+	scene.Apply(update)
+	AssertEqual(t, "", scene.Find(2).TemplateUUID)
+
+	// Deleting a node also forgets its descendants
+	update = ws.NewSpaceUpdateMessage("space-1", 4)
+	update.Deletions = append(update.Deletions, 2)
+	scene.Apply(update)
+	AssertEqual(t, 1, scene.Count())
+	AssertTrue(t, scene.Find(2) == nil)
+	AssertTrue(t, scene.Find(3) == nil)
+	AssertEqual(t, 0, len(scene.Root().Nodes))
+}
+
+func TestParseServerMessages(t *testing.T) {
+	message, err := ws.ParseMessageJson(`{"type":"Connected","clientUUID":"client-1"}`)
+	AssertNil(t, err)
+	connected, ok := message.(*ws.ConnectedMessage)
+	AssertTrue(t, ok)
+	AssertEqual(t, "client-1", connected.ClientUUID)
+
+	message, err = ws.ParseMessageJson(`{"type":"Space-Update","spaceUUID":"space-1","frame":12,"additions":[{"id":1,"parent":-1}],"deletions":[4],"nodeUpdates":[]}`)
+	AssertNil(t, err)
+	spaceUpdate, ok := message.(*ws.SpaceUpdateMessage)
+	AssertTrue(t, ok)
+	AssertEqual(t, int64(12), spaceUpdate.Frame)
+	AssertEqual(t, 1, len(spaceUpdate.Additions))
+	AssertEqual(t, int64(-1), spaceUpdate.Additions[0].Parent)
+	AssertEqual(t, int64(4), spaceUpdate.Deletions[0])
+}
@@ -3,6 +3,7 @@ package ws
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/goincremental/negroni-sessions"
 	"github.com/gorilla/websocket"
@@ -17,6 +18,7 @@ WebSocketConnection holds state for an incoming connection from a browser client
 type WebSocketConnection struct {
 	ClientUUID string             // Assigned when the connection comes in
 	UserUUID   string             // Assigned if the new incoming connection has a valid session
+	SessionID  string             // The UUID of that session, which is checked until the connection closes
 	SpaceUUID  string             // Empty until the first space update message comes in
 	Conn       *websocket.Conn    // The connection back to the client
 	Outgoing   chan ClientMessage // A buffer for outgoing ClientMessages
@@ -41,6 +43,9 @@ func (wsConn *WebSocketConnection) HandleOutgoing() {
 	}
 }
 
+// SessionCheckInterval is how often connections with a session check that it has not been revoked
+var SessionCheckInterval = 30 * time.Second
+
 /*
 WebSocketHandler holds the state and logic for all WebSocket handling.
 Includes a list of active connections and the client back to a sim host.
@@ -130,18 +135,19 @@ func (handler WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 
 	session := sessions.GetSession(r)
 	userUUID := ""
+	sessionID := ""
 	if session != nil {
-		sUUID := session.Get(be.UserUUIDKey)
-		uuid, _ := sUUID.(string)
-		_, err := be.FindUser(uuid, handler.DBInfo)
+		user, err := be.FindSessionUser(session, handler.DBInfo)
 		if err == nil {
-			userUUID = uuid
+			userUUID = user.UUID
+			sessionID, _ = session.Get(be.SessionUUIDKey).(string)
 		}
 	}
 
 	wsConnection := &WebSocketConnection{
 		ClientUUID: UUID(),
 		UserUUID:   userUUID,
+		SessionID:  sessionID,
 		SpaceUUID:  "",
 		Conn:       conn,
 		Outgoing:   make(chan ClientMessage, 2048),
@@ -150,7 +156,12 @@ func (handler WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	handler.AddWebSocketConnection(wsConnection)
 
 	go wsConnection.HandleOutgoing() // Sends outgoing messages from the wsConnection.Outgoing channel
+	sessionDone := make(chan bool)
+	if wsConnection.SessionID != "" {
+		go handler.WatchSession(wsConnection, sessionDone)
+	}
 	defer func() {
+		close(sessionDone)
 		handler.RemoveWebSocketConnection(wsConnection)
 		conn.Close()
 		wsConnection.Stop <- true // Stops HandleOutgoing go routine
@@ -191,3 +202,29 @@ func (handler WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		}
 	}
 }
+
+/*
+WatchSession closes the connection once its session has been revoked or has expired, which ends the ServeHTTP loop
+It returns when done is closed.
+*/
+func (handler WebSocketHandler) WatchSession(wsConn *WebSocketConnection, done chan bool) {
+	ticker := time.NewTicker(SessionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			active, err := be.SessionIsActive(wsConn.SessionID, handler.DBInfo)
+			if err != nil {
+				logger.Println("Could not check the session", err)
+				continue
+			}
+			if active == false {
+				logger.Println("Closing the connection of a revoked session", wsConn.ClientUUID)
+				wsConn.Conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
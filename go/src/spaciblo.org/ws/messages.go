@@ -290,6 +290,14 @@ func ParseMessageJson(rawMessage string) (ClientMessage, error) {
 	switch typedMessage.Type {
 	case PingType:
 		parsedMessage = new(PingMessage)
+	case AckType:
+		parsedMessage = new(AckMessage)
+	case ConnectedType:
+		parsedMessage = new(ConnectedMessage)
+	case SpaceUpdateType:
+		parsedMessage = new(SpaceUpdateMessage)
+	case UnknownMessageType:
+		parsedMessage = new(UnknownMessageTypeMessage)
 	case JoinSpaceType:
 		parsedMessage = new(JoinSpaceMessage)
 	case AvatarMotionType:
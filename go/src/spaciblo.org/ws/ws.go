@@ -13,7 +13,6 @@ import (
 	"strconv"
 
 	"github.com/goincremental/negroni-sessions"
-	"github.com/goincremental/negroni-sessions/cookiestore"
 	"github.com/nu7hatch/gouuid"
 	"github.com/urfave/negroni"
 
@@ -93,7 +92,7 @@ func (wsService *WSService) Start() {
 		wsService.WSListener = stoppableListener
 
 		server := negroni.New()
-		store := cookiestore.New([]byte(wsService.SessionSecret))
+		store := be.NewDBSessionStore(wsService.DBInfo, []byte(wsService.SessionSecret))
 		server.Use(sessions.Sessions(be.AuthCookieName, store))
 
 		mux := http.NewServeMux()